@@ -0,0 +1,108 @@
+package api
+
+import (
+	"blockbook/bchain"
+	"blockbook/db"
+	"fmt"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// Degraded mode
+// if the backend is not reachable, the worker serves only the data stored in db:
+// balances, utxos, txids and TxHistoryLight of addresses, transactions from the transactions cache
+// or reconstructed from txAddresses and stored block info
+// the responses assembled this way are marked by the backendUnavailable flag
+
+// isBackendUnavailableError returns true if the error means that the backend is not reachable
+// in that case the worker switches to the degraded mode until the sync worker reconnects to the backend
+func (w *Worker) isBackendUnavailableError(err error) bool {
+	if !bchain.IsConnectionError(err) {
+		return false
+	}
+	if w.is.SetBackendAvailable(false) {
+		glog.Warning("api: backend is unavailable, serving only the data stored in db, ", err)
+	}
+	return true
+}
+
+// getTransactionFromDB returns the transaction using only the data stored in db
+// the transaction is taken from the transactions cache or for bitcoin type coins reconstructed from txAddresses
+func (w *Worker) getTransactionFromDB(txid string, spendingTxs bool) (*Tx, error) {
+	bchainTx, height, err := w.txCache.GetCachedTransaction(txid)
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetCachedTransaction %v", txid)
+	}
+	var tx *Tx
+	if bchainTx != nil {
+		if tx, err = w.GetTransactionFromBchainTx(bchainTx, height, spendingTxs, false); err != nil {
+			return nil, err
+		}
+	} else {
+		var ta *db.TxAddresses
+		if w.chainType == bchain.ChainBitcoinType {
+			if ta, err = w.db.GetTxAddresses(txid); err != nil {
+				return nil, errors.Annotatef(err, "GetTxAddresses %v", txid)
+			}
+		}
+		if ta == nil {
+			return nil, NewAPIError(fmt.Sprintf("Transaction '%v' not found, backend unavailable", txid), true)
+		}
+		bi, err := w.db.GetBlockInfo(ta.Height)
+		if err != nil {
+			return nil, errors.Annotatef(err, "GetBlockInfo %v", ta.Height)
+		}
+		if bi == nil {
+			glog.Warning("DB inconsistency:  block height ", ta.Height, ": not found in db")
+			bi = &db.BlockInfo{}
+		}
+		_, bestheight, _ := w.is.GetSyncState()
+		tx = w.txFromTxAddress(txid, ta, bi, bestheight)
+	}
+	tx.BackendUnavailable = true
+	return tx, nil
+}
+
+// getBlockFromDB returns the block info stored in db without the transactions of the block
+// the block can be identified only by its height because the db does not map block hashes to heights
+func (w *Worker) getBlockFromDB(bid string, txsOnPage int) (*Block, error) {
+	height, err := strconv.Atoi(bid)
+	if err != nil || height < 0 || height >= int(maxUint32) {
+		return nil, NewAPIError("Block not found, backend unavailable, only the block height can be used", true)
+	}
+	bi, err := w.db.GetBlockInfo(uint32(height))
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBlockInfo %v", height)
+	}
+	if bi == nil {
+		return nil, NewAPIError("Block not found", true)
+	}
+	bestheight, _, err := w.db.GetBestBlock()
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBestBlock")
+	}
+	var prev, next string
+	if bi.Height != 0 {
+		prev, _ = w.db.GetBlockHash(bi.Height - 1)
+	}
+	if bi.Height != bestheight {
+		next, _ = w.db.GetBlockHash(bi.Height + 1)
+	}
+	pg, _, _, _ := computePaging(0, 0, txsOnPage)
+	return &Block{
+		Paging: pg,
+		BlockInfo: BlockInfo{
+			Hash:          bi.Hash,
+			Prev:          prev,
+			Next:          next,
+			Height:        bi.Height,
+			Confirmations: int(bestheight) - int(bi.Height) + 1,
+			Size:          int(bi.Size),
+			Time:          bi.Time,
+		},
+		TxCount:            int(bi.Txs),
+		BackendUnavailable: true,
+	}, nil
+}
@@ -0,0 +1,569 @@
+package api
+
+import (
+	"blockbook/bchain"
+	"blockbook/db"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// esploraTxsChainPage is the number of confirmed transactions returned by the Esplora address txs requests
+const esploraTxsChainPage = 25
+
+// esploraTxsMempoolPage is the maximum number of mempool transactions returned by the Esplora address txs requests
+const esploraTxsMempoolPage = 50
+
+// esploraMempoolRecent is the number of transactions returned by the Esplora mempool/recent request
+const esploraMempoolRecent = 10
+
+// esploraFeeTargets are the confirmation targets of the Esplora fee-estimates request
+var esploraFeeTargets = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 144, 504, 1008}
+
+// EsploraStatus is the confirmation status of a transaction in the Esplora format
+type EsploraStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int    `json:"block_height,omitempty"`
+	BlockHash   string `json:"block_hash,omitempty"`
+	BlockTime   int64  `json:"block_time,omitempty"`
+}
+
+// EsploraVout is a transaction output in the Esplora format
+type EsploraVout struct {
+	Scriptpubkey        string   `json:"scriptpubkey"`
+	ScriptpubkeyType    string   `json:"scriptpubkey_type"`
+	ScriptpubkeyAddress string   `json:"scriptpubkey_address,omitempty"`
+	Value               *big.Int `json:"value"`
+}
+
+// EsploraVin is a transaction input in the Esplora format
+type EsploraVin struct {
+	Txid       string       `json:"txid"`
+	Vout       uint32       `json:"vout"`
+	Prevout    *EsploraVout `json:"prevout"`
+	Scriptsig  string       `json:"scriptsig"`
+	Witness    []string     `json:"witness,omitempty"`
+	IsCoinbase bool         `json:"is_coinbase"`
+	Sequence   int64        `json:"sequence"`
+}
+
+// EsploraTx is a transaction in the Esplora format
+type EsploraTx struct {
+	Txid     string        `json:"txid"`
+	Version  int32         `json:"version"`
+	Locktime uint32        `json:"locktime"`
+	Vin      []EsploraVin  `json:"vin"`
+	Vout     []EsploraVout `json:"vout"`
+	Size     int           `json:"size"`
+	Weight   int           `json:"weight"`
+	Fee      *big.Int      `json:"fee"`
+	Status   EsploraStatus `json:"status"`
+}
+
+// EsploraOutspend is the spending status of a transaction output in the Esplora format
+type EsploraOutspend struct {
+	Spent  bool           `json:"spent"`
+	Txid   string         `json:"txid,omitempty"`
+	Vin    *int           `json:"vin,omitempty"`
+	Status *EsploraStatus `json:"status,omitempty"`
+}
+
+// EsploraAddressStats are the statistics of an address in the Esplora format
+type EsploraAddressStats struct {
+	FundedTxoCount int      `json:"funded_txo_count"`
+	FundedTxoSum   *big.Int `json:"funded_txo_sum"`
+	SpentTxoCount  int      `json:"spent_txo_count"`
+	SpentTxoSum    *big.Int `json:"spent_txo_sum"`
+	TxCount        int      `json:"tx_count"`
+}
+
+// EsploraAddress is an address in the Esplora format
+type EsploraAddress struct {
+	Address      string              `json:"address"`
+	ChainStats   EsploraAddressStats `json:"chain_stats"`
+	MempoolStats EsploraAddressStats `json:"mempool_stats"`
+}
+
+// EsploraUtxo is an unspent transaction output in the Esplora format
+type EsploraUtxo struct {
+	Txid   string        `json:"txid"`
+	Vout   int32         `json:"vout"`
+	Status EsploraStatus `json:"status"`
+	Value  *big.Int      `json:"value"`
+}
+
+// EsploraMempoolTx is a mempool transaction in the Esplora mempool/recent format
+type EsploraMempoolTx struct {
+	Txid  string   `json:"txid"`
+	Fee   *big.Int `json:"fee"`
+	Vsize int      `json:"vsize"`
+	Value *big.Int `json:"value"`
+}
+
+// esploraTxSpecific contains the fields of the backend transaction json not present in Tx
+type esploraTxSpecific struct {
+	Size   int `json:"size"`
+	Vsize  int `json:"vsize"`
+	Weight int `json:"weight"`
+	Vin    []struct {
+		Txinwitness []string `json:"txinwitness"`
+	} `json:"vin"`
+}
+
+// esploraScriptType returns the Esplora name of the type of the output script
+func esploraScriptType(script []byte) string {
+	l := len(script)
+	switch {
+	case l == 0:
+		return "empty"
+	case script[0] == 0x6a:
+		return "op_return"
+	case l == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 0x14 && script[23] == 0x88 && script[24] == 0xac:
+		return "p2pkh"
+	case l == 23 && script[0] == 0xa9 && script[1] == 0x14 && script[22] == 0x87:
+		return "p2sh"
+	case l == 22 && script[0] == 0x00 && script[1] == 0x14:
+		return "v0_p2wpkh"
+	case l == 34 && script[0] == 0x00 && script[1] == 0x20:
+		return "v0_p2wsh"
+	case l == 34 && script[0] == 0x51 && script[1] == 0x20:
+		return "v1_p2tr"
+	case (l == 35 && script[0] == 0x21 || l == 67 && script[0] == 0x41) && script[l-1] == 0xac:
+		return "p2pk"
+	case script[l-1] == 0xae:
+		return "multisig"
+	}
+	return "unknown"
+}
+
+func esploraVout(addrDesc bchain.AddressDescriptor, addresses []string, isAddress bool, value *Amount) *EsploraVout {
+	v := &EsploraVout{
+		Scriptpubkey:     hex.EncodeToString(addrDesc),
+		ScriptpubkeyType: esploraScriptType(addrDesc),
+	}
+	if isAddress && len(addresses) == 1 {
+		v.ScriptpubkeyAddress = addresses[0]
+	}
+	b := value.AsBigInt()
+	v.Value = &b
+	return v
+}
+
+func esploraStatusFromTx(tx *Tx) EsploraStatus {
+	if tx.Confirmations == 0 {
+		return EsploraStatus{}
+	}
+	return EsploraStatus{
+		Confirmed:   true,
+		BlockHeight: tx.Blockheight,
+		BlockHash:   tx.Blockhash,
+		BlockTime:   tx.Blocktime,
+	}
+}
+
+// esploraStatusFromHeight returns the status of a transaction confirmed in the block of given height, blockInfos caches the looked up blocks
+func (w *Worker) esploraStatusFromHeight(height uint32, blockInfos map[uint32]*db.BlockInfo) (EsploraStatus, error) {
+	if height == 0 {
+		return EsploraStatus{}, nil
+	}
+	bi, found := blockInfos[height]
+	if !found {
+		var err error
+		bi, err = w.db.GetBlockInfo(height)
+		if err != nil {
+			return EsploraStatus{}, err
+		}
+		if bi == nil {
+			return EsploraStatus{}, errors.Errorf("Block %d not found", height)
+		}
+		blockInfos[height] = bi
+	}
+	return EsploraStatus{
+		Confirmed:   true,
+		BlockHeight: int(height),
+		BlockHash:   bi.Hash,
+		BlockTime:   bi.Time,
+	}, nil
+}
+
+// TxToEsplora converts Tx to EsploraTx, the size, weight and witness data are taken from CoinSpecificJSON
+func (w *Worker) TxToEsplora(tx *Tx) *EsploraTx {
+	var ts esploraTxSpecific
+	if len(tx.CoinSpecificJSON) > 0 {
+		if err := json.Unmarshal(tx.CoinSpecificJSON, &ts); err != nil {
+			glog.Warning("TxToEsplora ", tx.Txid, ": ", err)
+		}
+	}
+	vins := make([]EsploraVin, len(tx.Vin))
+	for i := range tx.Vin {
+		v := &tx.Vin[i]
+		ev := EsploraVin{
+			Txid:      v.Txid,
+			Vout:      v.Vout,
+			Scriptsig: v.Hex,
+			Sequence:  v.Sequence,
+		}
+		if v.Coinbase != "" {
+			ev.IsCoinbase = true
+			ev.Txid = "0000000000000000000000000000000000000000000000000000000000000000"
+			ev.Vout = 0xffffffff
+			ev.Scriptsig = v.Coinbase
+		} else {
+			ev.Prevout = esploraVout(v.AddrDesc, v.Addresses, v.IsAddress, v.ValueSat)
+		}
+		if i < len(ts.Vin) {
+			ev.Witness = ts.Vin[i].Txinwitness
+		}
+		vins[i] = ev
+	}
+	vouts := make([]EsploraVout, len(tx.Vout))
+	for i := range tx.Vout {
+		v := &tx.Vout[i]
+		vouts[i] = *esploraVout(v.AddrDesc, v.Addresses, v.IsAddress, v.ValueSat)
+	}
+	size := ts.Size
+	if size == 0 {
+		if tx.Size > 0 {
+			size = tx.Size
+		} else {
+			size = len(tx.Hex) / 2
+		}
+	}
+	weight := ts.Weight
+	if weight == 0 {
+		if ts.Vsize > 0 {
+			weight = ts.Vsize * 4
+		} else {
+			weight = size * 4
+		}
+	}
+	fee := tx.FeesSat.AsBigInt()
+	return &EsploraTx{
+		Txid:     tx.Txid,
+		Version:  tx.Version,
+		Locktime: tx.Locktime,
+		Vin:      vins,
+		Vout:     vouts,
+		Size:     size,
+		Weight:   weight,
+		Fee:      &fee,
+		Status:   esploraStatusFromTx(tx),
+	}
+}
+
+// GetEsploraTx returns the transaction in the Esplora format
+func (w *Worker) GetEsploraTx(txid string) (*EsploraTx, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	tx, err := w.GetTransaction(txid, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return w.TxToEsplora(tx), nil
+}
+
+// GetEsploraTxStatus returns the confirmation status of the transaction in the Esplora format
+func (w *Worker) GetEsploraTxStatus(txid string) (*EsploraStatus, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	tx, err := w.GetTransaction(txid, false, false)
+	if err != nil {
+		return nil, err
+	}
+	s := esploraStatusFromTx(tx)
+	return &s, nil
+}
+
+// GetEsploraOutspends returns the spending status of all outputs of the transaction in the Esplora format
+func (w *Worker) GetEsploraOutspends(txid string) ([]EsploraOutspend, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	tx, err := w.GetTransaction(txid, true, false)
+	if err != nil {
+		return nil, err
+	}
+	blockInfos := make(map[uint32]*db.BlockInfo)
+	r := make([]EsploraOutspend, len(tx.Vout))
+	for i := range tx.Vout {
+		v := &tx.Vout[i]
+		if spent := w.getEsploraMempoolOutspend(txid, v); spent != nil {
+			r[i] = *spent
+			continue
+		}
+		if !v.Spent {
+			continue
+		}
+		status, err := w.esploraStatusFromHeight(uint32(v.SpentHeight), blockInfos)
+		if err != nil {
+			return nil, err
+		}
+		vin := v.SpentIndex
+		r[i] = EsploraOutspend{
+			Spent:  true,
+			Txid:   v.SpentTxID,
+			Vin:    &vin,
+			Status: &status,
+		}
+	}
+	return r, nil
+}
+
+// getEsploraMempoolOutspend returns the spending of the output by a mempool transaction or nil if it is not spent in mempool
+func (w *Worker) getEsploraMempoolOutspend(txid string, v *Vout) *EsploraOutspend {
+	if len(v.AddrDesc) == 0 {
+		return nil
+	}
+	outpoints, err := w.mempool.GetAddrDescTransactions(v.AddrDesc)
+	if err != nil {
+		glog.Warning("GetAddrDescTransactions in mempool: ", err)
+		return nil
+	}
+	for _, o := range outpoints {
+		// the inputs of the mempool transactions are marked by a negative vout, it is the negated vout of the spent output
+		if o.Vout >= 0 || int(^o.Vout) != v.N {
+			continue
+		}
+		spendingTx, _, err := w.txCache.GetTransaction(o.Txid)
+		// mempool transaction may fail
+		if err != nil || spendingTx == nil {
+			continue
+		}
+		for i := range spendingTx.Vin {
+			if spendingTx.Vin[i].Txid == txid && int(spendingTx.Vin[i].Vout) == v.N {
+				vin := i
+				return &EsploraOutspend{
+					Spent:  true,
+					Txid:   o.Txid,
+					Vin:    &vin,
+					Status: &EsploraStatus{},
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetEsploraAddress returns the confirmed and mempool statistics of the address in the Esplora format
+func (w *Worker) GetEsploraAddress(address string) (*EsploraAddress, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	addrDesc, address, err := w.getAddrDescAndNormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	r := &EsploraAddress{
+		Address: address,
+		ChainStats: EsploraAddressStats{
+			FundedTxoSum: new(big.Int),
+			SpentTxoSum:  new(big.Int),
+		},
+		MempoolStats: EsploraAddressStats{
+			FundedTxoSum: new(big.Int),
+			SpentTxoSum:  new(big.Int),
+		},
+	}
+	ba, err := w.db.GetAddrDescBalance(addrDesc, db.AddressBalanceDetailNoUTXO)
+	if err != nil {
+		return nil, NewAPIError(fmt.Sprintf("Address not found, %v", err), true)
+	}
+	// ba can be nil if the address is only in mempool
+	if ba != nil {
+		cs := &r.ChainStats
+		cs.TxCount = int(ba.Txs)
+		cs.FundedTxoSum.Set(ba.ReceivedSat())
+		cs.SpentTxoSum.Set(&ba.SentSat)
+		// the number of funded and spent outputs is not stored in the balance, count it from the index
+		err = w.db.GetAddrDescTransactions(addrDesc, 0, maxUint32, func(txid string, height uint32, indexes []int32) error {
+			for _, index := range indexes {
+				if index < 0 {
+					cs.SpentTxoCount++
+				} else {
+					cs.FundedTxoCount++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	txm, _, err := w.getAddressTxids(addrDesc, true, &AddressFilter{Vout: AddressFilterVoutOff}, maxInt)
+	if err != nil {
+		return nil, errors.Annotatef(err, "getAddressTxids %v true", addrDesc)
+	}
+	ms := &r.MempoolStats
+	for _, txid := range txm {
+		tx, err := w.GetTransaction(txid, false, false)
+		// mempool transaction may fail
+		if err != nil || tx == nil {
+			glog.Warning("GetTransaction in mempool: ", err)
+			continue
+		}
+		// skip already confirmed txs, mempool may be out of sync
+		if tx.Confirmations != 0 {
+			continue
+		}
+		ms.TxCount++
+		for i := range tx.Vin {
+			if tx.Vin[i].ValueSat != nil && bytes.Equal(tx.Vin[i].AddrDesc, addrDesc) {
+				ms.SpentTxoCount++
+				ms.SpentTxoSum.Add(ms.SpentTxoSum, (*big.Int)(tx.Vin[i].ValueSat))
+			}
+		}
+		for i := range tx.Vout {
+			if tx.Vout[i].ValueSat != nil && bytes.Equal(tx.Vout[i].AddrDesc, addrDesc) {
+				ms.FundedTxoCount++
+				ms.FundedTxoSum.Add(ms.FundedTxoSum, (*big.Int)(tx.Vout[i].ValueSat))
+			}
+		}
+	}
+	return r, nil
+}
+
+// GetEsploraAddressTxs returns transactions of the address in the Esplora format, newest first
+// if mempool is true, up to 50 mempool transactions are returned first
+// if chain is true, 25 confirmed transactions following the transaction lastSeenTxid (or the newest ones if lastSeenTxid is empty) are returned
+func (w *Worker) GetEsploraAddressTxs(address string, mempool bool, chain bool, lastSeenTxid string) ([]*EsploraTx, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	addrDesc, _, err := w.getAddrDescAndNormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]*EsploraTx, 0)
+	if mempool {
+		txm, _, err := w.getAddressTxids(addrDesc, true, &AddressFilter{Vout: AddressFilterVoutOff}, esploraTxsMempoolPage)
+		if err != nil {
+			return nil, errors.Annotatef(err, "getAddressTxids %v true", addrDesc)
+		}
+		for _, txid := range txm {
+			tx, err := w.GetTransaction(txid, false, true)
+			// mempool transaction may fail
+			if err != nil || tx == nil {
+				glog.Warning("GetTransaction in mempool: ", err)
+				continue
+			}
+			// skip already confirmed txs, mempool may be out of sync
+			if tx.Confirmations == 0 {
+				r = append(r, w.TxToEsplora(tx))
+			}
+		}
+	}
+	if chain {
+		txids := make([]string, 0, esploraTxsChainPage)
+		found := lastSeenTxid == ""
+		err = w.db.GetAddrDescTransactions(addrDesc, 0, maxUint32, func(txid string, height uint32, indexes []int32) error {
+			if !found {
+				found = txid == lastSeenTxid
+				return nil
+			}
+			txids = append(txids, txid)
+			if len(txids) >= esploraTxsChainPage {
+				return &db.StopIteration{}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, NewAPIError(fmt.Sprintf("Transaction %v not found in the history of the address", lastSeenTxid), true)
+		}
+		for _, txid := range txids {
+			tx, err := w.GetTransaction(txid, false, true)
+			if err != nil {
+				return nil, errors.Annotatef(err, "GetTransaction %v", txid)
+			}
+			r = append(r, w.TxToEsplora(tx))
+		}
+	}
+	return r, nil
+}
+
+// GetEsploraAddressUtxo returns unspent outputs of the address in the Esplora format
+func (w *Worker) GetEsploraAddressUtxo(address string) ([]EsploraUtxo, error) {
+	utxos, err := w.GetAddressUtxo(address, false)
+	if err != nil {
+		return nil, err
+	}
+	blockInfos := make(map[uint32]*db.BlockInfo)
+	r := make([]EsploraUtxo, len(utxos))
+	for i := range utxos {
+		u := &utxos[i]
+		status, err := w.esploraStatusFromHeight(uint32(u.Height), blockInfos)
+		if err != nil {
+			return nil, err
+		}
+		v := u.AmountSat.AsBigInt()
+		r[i] = EsploraUtxo{
+			Txid:   u.Txid,
+			Vout:   u.Vout,
+			Status: status,
+			Value:  &v,
+		}
+	}
+	return r, nil
+}
+
+// GetEsploraFeeEstimates returns the fee estimates in sat/vB for the Esplora confirmation targets
+// targets for which the backend cannot estimate the fee are omitted
+func (w *Worker) GetEsploraFeeEstimates() (map[string]float64, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	r := make(map[string]float64, len(esploraFeeTargets))
+	for _, blocks := range esploraFeeTargets {
+		fee, err := w.chain.EstimateSmartFee(blocks, true)
+		if err != nil {
+			return nil, err
+		}
+		if fee.Sign() <= 0 {
+			continue
+		}
+		// EstimateSmartFee returns the fee per kilobyte
+		f, _ := new(big.Float).SetInt(&fee).Float64()
+		r[strconv.Itoa(blocks)] = f / 1000
+	}
+	return r, nil
+}
+
+// GetEsploraMempoolRecent returns the last transactions added to the mempool in the Esplora format
+func (w *Worker) GetEsploraMempoolRecent() ([]EsploraMempoolTx, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	// the entries are sorted from the newest
+	entries := w.mempool.GetAllEntries()
+	r := make([]EsploraMempoolTx, 0, esploraMempoolRecent)
+	for i := range entries {
+		if len(r) >= esploraMempoolRecent {
+			break
+		}
+		tx, err := w.GetTransaction(entries[i].Txid, false, true)
+		// mempool transaction may fail
+		if err != nil || tx == nil {
+			glog.Warning("GetTransaction in mempool: ", err)
+			continue
+		}
+		etx := w.TxToEsplora(tx)
+		value := tx.ValueOutSat.AsBigInt()
+		r = append(r, EsploraMempoolTx{
+			Txid:  etx.Txid,
+			Fee:   etx.Fee,
+			Vsize: (etx.Weight + 3) / 4,
+			Value: &value,
+		})
+	}
+	return r, nil
+}
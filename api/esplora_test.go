@@ -0,0 +1,125 @@
+// +build unittest
+
+package api
+
+import (
+	"blockbook/bchain"
+	"blockbook/bchain/coins/btc"
+	"blockbook/common"
+	"blockbook/db"
+	"blockbook/tests/dbtestdata"
+	"reflect"
+	"testing"
+)
+
+const testMempoolTxid = "00000000000000000000000000000000000000000000000000000000000000aa"
+
+// testMempool contains the outpoints of the mempool transactions by the address descriptor
+type testMempool struct {
+	outpoints map[string][]bchain.Outpoint
+}
+
+func (m *testMempool) Resync() (int, error) { return 0, nil }
+
+func (m *testMempool) GetTransactions(address string) ([]bchain.Outpoint, error) { return nil, nil }
+
+func (m *testMempool) GetAddrDescTransactions(addrDesc bchain.AddressDescriptor) ([]bchain.Outpoint, error) {
+	return m.outpoints[string(addrDesc)], nil
+}
+
+func (m *testMempool) GetAllEntries() bchain.MempoolTxidEntries { return nil }
+
+func (m *testMempool) GetTransactionTime(txid string) uint32 { return 0 }
+
+// testMempoolChain returns the mempool transaction in addition to the transactions of the fake chain
+type testMempoolChain struct {
+	bchain.BlockChain
+	tx *bchain.Tx
+}
+
+func (c *testMempoolChain) GetTransaction(txid string) (*bchain.Tx, error) {
+	if txid == c.tx.Txid {
+		return c.tx, nil
+	}
+	return c.BlockChain.GetTransaction(txid)
+}
+
+func Test_getEsploraMempoolOutspend(t *testing.T) {
+	parser := btc.NewBitcoinParser(btc.GetChainParams("test"), &btc.Configuration{BlockAddressesToKeep: 1})
+	d, err := db.NewMemoryIndex(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := dbtestdata.NewFakeBlockChain(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mempool transaction spends the output 1 of TxidB2T1 by its input 2
+	chain := &testMempoolChain{
+		BlockChain: fc,
+		tx: &bchain.Tx{
+			Txid: testMempoolTxid,
+			Vin: []bchain.Vin{
+				{Txid: dbtestdata.TxidB1T1, Vout: 0},
+				{Txid: dbtestdata.TxidB2T1, Vout: 0},
+				{Txid: dbtestdata.TxidB2T1, Vout: 1},
+			},
+		},
+	}
+	addrDesc, err := parser.GetAddrDescFromAddress(dbtestdata.Addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mempool := &testMempool{outpoints: map[string][]bchain.Outpoint{
+		string(addrDesc): {{Txid: testMempoolTxid, Vout: ^1}},
+	}}
+	metrics, err := common.GetMetrics("Fakecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := &common.InternalState{}
+	txCache, err := db.NewTxCache(d, chain, metrics, is, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWorker(d, chain, mempool, txCache, is)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vin := 2
+	tests := []struct {
+		name string
+		txid string
+		vout Vout
+		want *EsploraOutspend
+	}{
+		{
+			name: "spent by input with different index",
+			txid: dbtestdata.TxidB2T1,
+			vout: Vout{N: 1, AddrDesc: addrDesc},
+			want: &EsploraOutspend{Spent: true, Txid: testMempoolTxid, Vin: &vin, Status: &EsploraStatus{}},
+		},
+		{
+			name: "other output of the address",
+			txid: dbtestdata.TxidB2T1,
+			vout: Vout{N: 0, AddrDesc: addrDesc},
+		},
+		{
+			name: "other transaction",
+			txid: dbtestdata.TxidB1T1,
+			vout: Vout{N: 1, AddrDesc: addrDesc},
+		},
+		{
+			name: "no address",
+			txid: dbtestdata.TxidB2T1,
+			vout: Vout{N: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := w.getEsploraMempoolOutspend(tt.txid, &tt.vout); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getEsploraMempoolOutspend() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
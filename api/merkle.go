@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/martinboehm/btcd/chaincfg/chainhash"
+)
+
+// GetTxMerkleProof returns the merkle branch proving that the confirmed transaction is included in its block
+// it is supported only for coins with the merkle tree using double-SHA256 of txids
+func (w *Worker) GetTxMerkleProof(txid string) (*TxMerkleProof, error) {
+	if !w.chainParser.IsMerkleTreeDoubleSHA256() {
+		return nil, NewAPIError("Not supported", true)
+	}
+	start := time.Now()
+	ta, err := w.db.GetTxAddresses(txid)
+	if err != nil {
+		return nil, NewAPIError(fmt.Sprintf("Invalid txid %v, %v", txid, err), true)
+	}
+	if ta == nil {
+		return nil, NewAPIError(fmt.Sprintf("Transaction %v not found or not confirmed", txid), true)
+	}
+	hash, err := w.db.GetBlockHash(ta.Height)
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBlockHash %v", ta.Height)
+	}
+	bi, err := w.chain.GetBlockInfo(hash)
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBlockInfo %v", hash)
+	}
+	pos := -1
+	for i := range bi.Txids {
+		if bi.Txids[i] == txid {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil, NewAPIError(fmt.Sprintf("Transaction %v not found in block %v", txid, hash), true)
+	}
+	branch, root, err := merkleBranch(bi.Txids, pos)
+	if err != nil {
+		return nil, err
+	}
+	if root != bi.MerkleRoot {
+		return nil, errors.Errorf("Computed merkle root %v does not match merkle root %v of block %v", root, bi.MerkleRoot, hash)
+	}
+	header, err := w.getBlockHeaderRaw(ta.Height, hash)
+	if err != nil {
+		return nil, err
+	}
+	glog.Info("GetTxMerkleProof ", txid, " finished in ", time.Since(start))
+	return &TxMerkleProof{
+		Txid:        txid,
+		BlockHash:   hash,
+		BlockHeight: ta.Height,
+		BlockHeader: header,
+		MerkleRoot:  root,
+		Pos:         pos,
+		Merkle:      branch,
+	}, nil
+}
+
+// merkleBranch returns the hashes needed to compute the merkle root from the txid at position pos and the merkle root
+// the txids, the hashes and the root are in the display (reversed) byte order
+func merkleBranch(txids []string, pos int) ([]string, string, error) {
+	level := make([][]byte, len(txids))
+	for i, txid := range txids {
+		b, err := hex.DecodeString(txid)
+		if err != nil || len(b) != sha256.Size {
+			return nil, "", errors.Errorf("Invalid txid %v", txid)
+		}
+		level[i] = reverseHash(b)
+	}
+	var branch []string
+	for len(level) > 1 {
+		// the last hash of an odd level is paired with itself
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, hex.EncodeToString(reverseHash(level[pos^1])))
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			next[i] = chainhash.DoubleHashB(append(append(make([]byte, 0, 2*sha256.Size), level[2*i]...), level[2*i+1]...))
+		}
+		level = next
+		pos /= 2
+	}
+	if len(level) == 0 {
+		return nil, "", errors.New("Empty block")
+	}
+	return branch, hex.EncodeToString(reverseHash(level[0])), nil
+}
+
+func reverseHash(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
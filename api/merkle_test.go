@@ -0,0 +1,70 @@
+// +build unittest
+
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+// txids of bitcoin block 100000
+var merkleTestTxids = []string{
+	"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+	"fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4",
+	"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+	"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+}
+
+func Test_merkleBranch(t *testing.T) {
+	tests := []struct {
+		name       string
+		txids      []string
+		pos        int
+		wantBranch []string
+		wantRoot   string
+	}{
+		{
+			name:     "single tx",
+			txids:    merkleTestTxids[:1],
+			pos:      0,
+			wantRoot: "8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+		},
+		{
+			name:  "block 100000",
+			txids: merkleTestTxids,
+			pos:   2,
+			wantBranch: []string{
+				"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+				"ccdafb73d8dcd0173d5d5c3c9a0770d0b3953db889dab99ef05b1907518cb815",
+			},
+			wantRoot: "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766",
+		},
+		{
+			name:  "odd number of txs",
+			txids: merkleTestTxids[:3],
+			pos:   2,
+			wantBranch: []string{
+				"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+				"ccdafb73d8dcd0173d5d5c3c9a0770d0b3953db889dab99ef05b1907518cb815",
+			},
+			wantRoot: "fa435470825de273081dcc706b25514c936fa6dc80ab965ce6970d68ddd0b553",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branch, root, err := merkleBranch(tt.txids, tt.pos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(branch, tt.wantBranch) {
+				t.Errorf("merkleBranch() branch = %v, want %v", branch, tt.wantBranch)
+			}
+			if root != tt.wantRoot {
+				t.Errorf("merkleBranch() root = %v, want %v", root, tt.wantRoot)
+			}
+		})
+	}
+	if _, _, err := merkleBranch([]string{"invalid"}, 0); err == nil {
+		t.Error("merkleBranch() of invalid txid did not fail")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"math/big"
+	"sort"
 	"time"
 )
 
@@ -177,25 +178,28 @@ type EthereumSpecific struct {
 
 // Tx holds information about a transaction
 type Tx struct {
-	Txid             string            `json:"txid"`
-	Version          int32             `json:"version,omitempty"`
-	Locktime         uint32            `json:"lockTime,omitempty"`
-	Vin              []Vin             `json:"vin"`
-	Vout             []Vout            `json:"vout"`
-	Blockhash        string            `json:"blockHash,omitempty"`
-	Blockheight      int               `json:"blockHeight"`
-	Confirmations    uint32            `json:"confirmations"`
-	Blocktime        int64             `json:"blockTime"`
-	Size             int               `json:"size,omitempty"`
-	ValueOutSat      *Amount           `json:"value"`
-	ValueInSat       *Amount           `json:"valueIn,omitempty"`
-	FeesSat          *Amount           `json:"fees,omitempty"`
-	Hex              string            `json:"hex,omitempty"`
-	Rbf              bool              `json:"rbf,omitempty"`
-	CoinSpecificData interface{}       `json:"-"`
-	CoinSpecificJSON json.RawMessage   `json:"-"`
-	TokenTransfers   []TokenTransfer   `json:"tokenTransfers,omitempty"`
-	EthereumSpecific *EthereumSpecific `json:"ethereumSpecific,omitempty"`
+	Txid             string             `json:"txid"`
+	Version          int32              `json:"version,omitempty"`
+	Locktime         uint32             `json:"lockTime,omitempty"`
+	Vin              []Vin              `json:"vin"`
+	Vout             []Vout             `json:"vout"`
+	Blockhash        string             `json:"blockHash,omitempty"`
+	Blockheight      int                `json:"blockHeight"`
+	Confirmations    uint32             `json:"confirmations"`
+	Blocktime        int64              `json:"blockTime"`
+	Size             int                `json:"size,omitempty"`
+	ValueOutSat      *Amount            `json:"value"`
+	ValueInSat       *Amount            `json:"valueIn,omitempty"`
+	FeesSat          *Amount            `json:"fees,omitempty"`
+	Hex              string             `json:"hex,omitempty"`
+	Rbf              bool               `json:"rbf,omitempty"`
+	CoinSpecificData interface{}        `json:"-"`
+	CoinSpecificJSON json.RawMessage    `json:"-"`
+	TokenTransfers   []TokenTransfer    `json:"tokenTransfers,omitempty"`
+	EthereumSpecific *EthereumSpecific  `json:"ethereumSpecific,omitempty"`
+	FiatRates        map[string]float64 `json:"fiatRates,omitempty"`
+	// the transaction was assembled only from the data stored in db, the backend is unavailable
+	BackendUnavailable bool `json:"backendUnavailable,omitempty"`
 }
 
 // FeeStats contains detailed block fee statistics
@@ -241,6 +245,8 @@ type AddressFilter struct {
 	TokensToReturn TokensToReturn
 	// OnlyConfirmed set to true will ignore mempool transactions; mempool is also ignored if FromHeight/ToHeight filter is specified
 	OnlyConfirmed bool
+	// FiatCurrency if set, the fiat rate of the currency at the block time is returned with each transaction
+	FiatCurrency string
 }
 
 // Address holds information about address and its transactions
@@ -260,6 +266,9 @@ type Address struct {
 	UsedTokens            int                   `json:"usedTokens,omitempty"`
 	Tokens                []Token               `json:"tokens,omitempty"`
 	Erc20Contract         *bchain.Erc20Contract `json:"erc20Contract,omitempty"`
+	BackendUnavailable    bool                  `json:"backendUnavailable,omitempty"`
+	Pruned                bool                  `json:"pruned,omitempty"`
+	HistoryFromHeight     uint32                `json:"historyFromHeight,omitempty"`
 	// helpers for explorer
 	Filter        string              `json:"-"`
 	XPubAddresses map[string]struct{} `json:"-"`
@@ -296,6 +305,72 @@ func (a Utxos) Less(i, j int) bool {
 	return hi >= hj
 }
 
+// BalanceHistory contains info about one point in time of balance history
+type BalanceHistory struct {
+	Time        uint32  `json:"time"`
+	Txs         uint32  `json:"txs"`
+	ReceivedSat *Amount `json:"received"`
+	SentSat     *Amount `json:"sent"`
+	Txid        string  `json:"txid,omitempty"`
+}
+
+// BalanceHistories is array of BalanceHistory
+type BalanceHistories []BalanceHistory
+
+func (a BalanceHistories) Len() int      { return len(a) }
+func (a BalanceHistories) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a BalanceHistories) Less(i, j int) bool {
+	ti := a[i].Time
+	tj := a[j].Time
+	if ti == tj {
+		return a[i].Txid < a[j].Txid
+	}
+	return ti < tj
+}
+
+// SortAndAggregate sums BalanceHistories to groups defined by the time interval groupByTime (in seconds)
+// the transactions are counted only once in a group even if there are more entries of the same transaction
+func (a BalanceHistories) SortAndAggregate(groupByTime uint32) BalanceHistories {
+	bhs := make(BalanceHistories, 0)
+	if len(a) > 0 {
+		if groupByTime == 0 {
+			groupByTime = 1
+		}
+		bha := BalanceHistory{
+			SentSat:     &Amount{},
+			ReceivedSat: &Amount{},
+		}
+		sort.Sort(a)
+		for i := range a {
+			bh := &a[i]
+			time := bh.Time - bh.Time%groupByTime
+			if bha.Time != time || bha.Txs == 0 {
+				if bha.Txs != 0 {
+					// in aggregate, do not return txid as there could be multiple of them
+					bha.Txid = ""
+					bhs = append(bhs, bha)
+				}
+				bha = BalanceHistory{
+					Time:        time,
+					SentSat:     &Amount{},
+					ReceivedSat: &Amount{},
+				}
+			}
+			if bha.Txid != bh.Txid || bha.Txs == 0 {
+				bha.Txs += bh.Txs
+				bha.Txid = bh.Txid
+			}
+			(*big.Int)(bha.SentSat).Add((*big.Int)(bha.SentSat), (*big.Int)(bh.SentSat))
+			(*big.Int)(bha.ReceivedSat).Add((*big.Int)(bha.ReceivedSat), (*big.Int)(bh.ReceivedSat))
+		}
+		if bha.Txs != 0 {
+			bha.Txid = ""
+			bhs = append(bhs, bha)
+		}
+	}
+	return bhs
+}
+
 // Blocks is list of blocks with paging information
 type Blocks struct {
 	Paging
@@ -323,46 +398,50 @@ type BlockInfo struct {
 type Block struct {
 	Paging
 	BlockInfo
-	TxCount      int   `json:"txCount"`
-	Transactions []*Tx `json:"txs,omitempty"`
+	TxCount            int   `json:"txCount"`
+	Transactions       []*Tx `json:"txs,omitempty"`
+	BackendUnavailable bool  `json:"backendUnavailable,omitempty"`
 }
 
 // BlockbookInfo contains information about the running blockbook instance
 type BlockbookInfo struct {
-	Coin              string                       `json:"coin"`
-	Host              string                       `json:"host"`
-	Version           string                       `json:"version"`
-	GitCommit         string                       `json:"gitCommit"`
-	BuildTime         string                       `json:"buildTime"`
-	SyncMode          bool                         `json:"syncMode"`
-	InitialSync       bool                         `json:"initialSync"`
-	InSync            bool                         `json:"inSync"`
-	BestHeight        uint32                       `json:"bestHeight"`
-	LastBlockTime     time.Time                    `json:"lastBlockTime"`
-	InSyncMempool     bool                         `json:"inSyncMempool"`
-	LastMempoolTime   time.Time                    `json:"lastMempoolTime"`
-	MempoolSize       int                          `json:"mempoolSize"`
-	Decimals          int                          `json:"decimals"`
-	DbSize            int64                        `json:"dbSize"`
-	DbSizeFromColumns int64                        `json:"dbSizeFromColumns,omitempty"`
-	DbColumns         []common.InternalStateColumn `json:"dbColumns,omitempty"`
-	About             string                       `json:"about"`
+	Coin                    string                       `json:"coin"`
+	Host                    string                       `json:"host"`
+	Version                 string                       `json:"version"`
+	GitCommit               string                       `json:"gitCommit"`
+	BuildTime               string                       `json:"buildTime"`
+	SyncMode                bool                         `json:"syncMode"`
+	InitialSync             bool                         `json:"initialSync"`
+	InSync                  bool                         `json:"inSync"`
+	BestHeight              uint32                       `json:"bestHeight"`
+	LastBlockTime           time.Time                    `json:"lastBlockTime"`
+	InSyncMempool           bool                         `json:"inSyncMempool"`
+	LastMempoolTime         time.Time                    `json:"lastMempoolTime"`
+	MempoolSize             int                          `json:"mempoolSize"`
+	Decimals                int                          `json:"decimals"`
+	DbSize                  int64                        `json:"dbSize"`
+	DbSizeFromColumns       int64                        `json:"dbSizeFromColumns,omitempty"`
+	DbColumns               []common.InternalStateColumn `json:"dbColumns,omitempty"`
+	About                   string                       `json:"about"`
+	BackendUnavailable      bool                         `json:"backendUnavailable,omitempty"`
+	BackendUnavailableSince *time.Time                   `json:"backendUnavailableSince,omitempty"`
 }
 
 // BackendInfo is used to get information about blockchain
 type BackendInfo struct {
-	BackendError    string  `json:"error,omitempty"`
-	Chain           string  `json:"chain,omitempty"`
-	Blocks          int     `json:"blocks,omitempty"`
-	Headers         int     `json:"headers,omitempty"`
-	BestBlockHash   string  `json:"bestBlockHash,omitempty"`
-	Difficulty      string  `json:"difficulty,omitempty"`
-	SizeOnDisk      int64   `json:"sizeOnDisk,omitempty"`
-	Version         string  `json:"version,omitempty"`
-	Subversion      string  `json:"subversion,omitempty"`
-	ProtocolVersion string  `json:"protocolVersion,omitempty"`
-	Timeoffset      float64 `json:"timeOffset,omitempty"`
-	Warnings        string  `json:"warnings,omitempty"`
+	BackendError    string                     `json:"error,omitempty"`
+	Chain           string                     `json:"chain,omitempty"`
+	Blocks          int                        `json:"blocks,omitempty"`
+	Headers         int                        `json:"headers,omitempty"`
+	BestBlockHash   string                     `json:"bestBlockHash,omitempty"`
+	Difficulty      string                     `json:"difficulty,omitempty"`
+	SizeOnDisk      int64                      `json:"sizeOnDisk,omitempty"`
+	Version         string                     `json:"version,omitempty"`
+	Subversion      string                     `json:"subversion,omitempty"`
+	ProtocolVersion string                     `json:"protocolVersion,omitempty"`
+	Timeoffset      float64                    `json:"timeOffset,omitempty"`
+	Warnings        string                     `json:"warnings,omitempty"`
+	Endpoints       []bchain.RPCEndpointStatus `json:"endpoints,omitempty"`
 }
 
 // SystemInfo contains information about the running blockbook and backend instance
@@ -383,3 +462,48 @@ type MempoolTxids struct {
 	Mempool     []MempoolTxid `json:"mempool"`
 	MempoolSize int           `json:"mempoolSize"`
 }
+
+// ResultTickerAsString contains formatted CurrencyRatesTicker data
+type ResultTickerAsString struct {
+	Timestamp int64              `json:"ts,omitempty"`
+	Rates     map[string]float64 `json:"rates"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// ResultTickersAsString contains a formatted CurrencyRatesTicker list
+type ResultTickersAsString struct {
+	Tickers []ResultTickerAsString `json:"tickers"`
+}
+
+// ResultTickerListAsString contains formatted data about available currency tickers
+type ResultTickerListAsString struct {
+	Timestamp int64    `json:"ts,omitempty"`
+	Tickers   []string `json:"available_currencies"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// BlockFilter contains the basic BIP158 filter of a block and its BIP157 filter header
+type BlockFilter struct {
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+	Filter string `json:"filter"`
+	Header string `json:"header"`
+}
+
+// TxMerkleProof contains the merkle branch of a confirmed transaction and the raw header of its block
+type TxMerkleProof struct {
+	Txid        string   `json:"txid"`
+	BlockHash   string   `json:"blockHash"`
+	BlockHeight uint32   `json:"blockHeight"`
+	BlockHeader string   `json:"blockHeader"`
+	MerkleRoot  string   `json:"merkleRoot"`
+	Pos         int      `json:"pos"`
+	Merkle      []string `json:"merkle"`
+}
+
+// BlockHeaders contains hex encoded raw headers of consecutive blocks starting from the height From
+type BlockHeaders struct {
+	From    int      `json:"from"`
+	Count   int      `json:"count"`
+	Headers []string `json:"headers"`
+}
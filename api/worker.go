@@ -6,6 +6,8 @@ import (
 	"blockbook/common"
 	"blockbook/db"
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -13,6 +15,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -21,7 +24,7 @@ import (
 
 // Worker is handle to api worker
 type Worker struct {
-	db          *db.RocksDB
+	db          db.Index
 	txCache     *db.TxCache
 	chain       bchain.BlockChain
 	chainParser bchain.BlockChainParser
@@ -31,7 +34,7 @@ type Worker struct {
 }
 
 // NewWorker creates new api worker
-func NewWorker(db *db.RocksDB, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, is *common.InternalState) (*Worker, error) {
+func NewWorker(db db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, is *common.InternalState) (*Worker, error) {
 	w := &Worker{
 		db:          db,
 		txCache:     txCache,
@@ -54,9 +57,36 @@ func (w *Worker) getAddressesFromVout(vout *bchain.Vout) (bchain.AddressDescript
 }
 
 // setSpendingTxToVout is helper function, that finds transaction that spent given output and sets it to the output
-// there is no direct index for the operation, it must be found using addresses -> txaddresses -> tx
+// the spending index is used, outputs spent before the index was created are found using addresses -> txaddresses -> tx
 func (w *Worker) setSpendingTxToVout(vout *Vout, txid string, height uint32) error {
-	err := w.db.GetAddrDescTransactions(vout.AddrDesc, height, maxUint32, func(t string, height uint32, indexes []int32) error {
+	// the spending index and the address history with inputs exist only for bitcoin type coins
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil
+	}
+	stx, err := w.db.GetSpendingTx(txid, int32(vout.N))
+	if err != nil {
+		return err
+	}
+	if stx != nil {
+		t, err := w.chainParser.UnpackTxid(stx.BtxID)
+		if err != nil {
+			return err
+		}
+		vout.SpentTxID = t
+		vout.SpentHeight = int(stx.Height)
+		vout.SpentIndex = int(stx.Index)
+		return nil
+	}
+	from := w.is.GetSpendingIndexFromHeight()
+	if height >= from {
+		return nil
+	}
+	return w.scanSpendingTxToVout(vout, txid, height, from-1)
+}
+
+// scanSpendingTxToVout finds the spending transaction in the address history in the range lower-higher
+func (w *Worker) scanSpendingTxToVout(vout *Vout, txid string, lower, higher uint32) error {
+	err := w.db.GetAddrDescTransactions(vout.AddrDesc, lower, higher, func(t string, height uint32, indexes []int32) error {
 		for _, index := range indexes {
 			// take only inputs
 			if index < 0 {
@@ -92,6 +122,9 @@ func (w *Worker) setSpendingTxToVout(vout *Vout, txid string, height uint32) err
 
 // GetSpendingTxid returns transaction id of transaction that spent given output
 func (w *Worker) GetSpendingTxid(txid string, n int) (string, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return "", NewAPIError("Not supported", true)
+	}
 	start := time.Now()
 	tx, err := w.GetTransaction(txid, false, false)
 	if err != nil {
@@ -110,11 +143,17 @@ func (w *Worker) GetSpendingTxid(txid string, n int) (string, error) {
 
 // GetTransaction reads transaction data from txid
 func (w *Worker) GetTransaction(txid string, spendingTxs bool, specificJSON bool) (*Tx, error) {
+	if w.is.IsBackendUnavailable() {
+		return w.getTransactionFromDB(txid, spendingTxs)
+	}
 	bchainTx, height, err := w.txCache.GetTransaction(txid)
 	if err != nil {
 		if err == bchain.ErrTxNotFound {
 			return nil, NewAPIError(fmt.Sprintf("Transaction '%v' not found", txid), true)
 		}
+		if w.isBackendUnavailableError(err) {
+			return w.getTransactionFromDB(txid, spendingTxs)
+		}
 		return nil, NewAPIError(fmt.Sprintf("Transaction '%v' not found (%v)", txid, err), true)
 	}
 	return w.GetTransactionFromBchainTx(bchainTx, height, spendingTxs, specificJSON)
@@ -333,8 +372,11 @@ func (w *Worker) GetTransactionFromBchainTx(bchainTx *bchain.Tx, height uint32,
 	return r, nil
 }
 
-func (w *Worker) getAddressTxids(addrDesc bchain.AddressDescriptor, mempool bool, filter *AddressFilter, maxResults int) ([]string, error) {
+// getAddressTxids returns txids of the address from the mempool or from the index
+// if the address history in the index is pruned, the returned history starts at the returned height (0 for complete history)
+func (w *Worker) getAddressTxids(addrDesc bchain.AddressDescriptor, mempool bool, filter *AddressFilter, maxResults int) ([]string, uint32, error) {
 	var err error
+	var historyFrom uint32
 	txids := make([]string, 0, 4)
 	var callback db.GetTransactionsCallback
 	if filter.Vout == AddressFilterVoutOff {
@@ -369,7 +411,7 @@ func (w *Worker) getAddressTxids(addrDesc bchain.AddressDescriptor, mempool bool
 		uniqueTxs := make(map[string]struct{})
 		o, err := w.mempool.GetAddrDescTransactions(addrDesc)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		for _, m := range o {
 			if _, found := uniqueTxs[m.Txid]; !found {
@@ -385,12 +427,22 @@ func (w *Worker) getAddressTxids(addrDesc bchain.AddressDescriptor, mempool bool
 		if to == 0 {
 			to = maxUint32
 		}
-		err = w.db.GetAddrDescTransactions(addrDesc, filter.FromHeight, to, callback)
+		historyFrom, err = w.db.AddressHistoryFromHeight()
 		if err != nil {
-			return nil, err
+			return nil, 0, err
+		}
+		from := filter.FromHeight
+		if from < historyFrom {
+			from = historyFrom
+		}
+		if from <= to {
+			err = w.db.GetAddrDescTransactions(addrDesc, from, to, callback)
+			if err != nil {
+				return nil, 0, err
+			}
 		}
 	}
-	return txids, nil
+	return txids, historyFrom, nil
 }
 
 func (t *Tx) getAddrVoutValue(addrDesc bchain.AddressDescriptor) *big.Int {
@@ -685,6 +737,7 @@ func (w *Worker) GetAddress(address string, page int, txsOnPage int, option Acco
 		unconfirmedTxs           int
 		nonTokenTxs              int
 		totalResults             int
+		historyFrom              uint32
 	)
 	addrDesc, address, err := w.getAddrDescAndNormalizeAddress(address)
 	if err != nil {
@@ -704,8 +757,8 @@ func (w *Worker) GetAddress(address string, page int, txsOnPage int, option Acco
 			return nil, NewAPIError(fmt.Sprintf("Address not found, %v", err), true)
 		}
 		if ba != nil {
-			// totalResults is known only if there is no filter
-			if filter.Vout == AddressFilterVoutOff && filter.FromHeight == 0 && filter.ToHeight == 0 {
+			// totalResults is known only if there is no filter and the history is not pruned
+			if filter.Vout == AddressFilterVoutOff && filter.FromHeight == 0 && filter.ToHeight == 0 && !w.db.IsPruned() {
 				totalResults = int(ba.Txs)
 			} else {
 				totalResults = -1
@@ -717,9 +770,11 @@ func (w *Worker) GetAddress(address string, page int, txsOnPage int, option Acco
 		ba = &db.AddrBalance{}
 		page = 0
 	}
+	// the mempool cannot be used if the backend is unavailable, the mempool transactions cannot be loaded
+	backendUnavailable := w.is.IsBackendUnavailable()
 	// process mempool, only if toHeight is not specified
-	if filter.ToHeight == 0 && !filter.OnlyConfirmed {
-		txm, err = w.getAddressTxids(addrDesc, true, filter, maxInt)
+	if filter.ToHeight == 0 && !filter.OnlyConfirmed && !backendUnavailable {
+		txm, _, err = w.getAddressTxids(addrDesc, true, filter, maxInt)
 		if err != nil {
 			return nil, errors.Annotatef(err, "getAddressTxids %v true", addrDesc)
 		}
@@ -747,7 +802,8 @@ func (w *Worker) GetAddress(address string, page int, txsOnPage int, option Acco
 	}
 	// get tx history if requested by option or check mempool if there are some transactions for a new address
 	if option >= AccountDetailsTxidHistory {
-		txc, err := w.getAddressTxids(addrDesc, false, filter, (page+1)*txsOnPage)
+		var txc []string
+		txc, historyFrom, err = w.getAddressTxids(addrDesc, false, filter, (page+1)*txsOnPage)
 		if err != nil {
 			return nil, errors.Annotatef(err, "getAddressTxids %v false", addrDesc)
 		}
@@ -777,6 +833,11 @@ func (w *Worker) GetAddress(address string, page int, txsOnPage int, option Acco
 			}
 		}
 	}
+	if filter.FiatCurrency != "" {
+		if err = w.setFiatRatesToTxs(txs, filter.FiatCurrency); err != nil {
+			return nil, err
+		}
+	}
 	if w.chainType == bchain.ChainBitcoinType {
 		totalReceived = ba.ReceivedSat()
 		totalSent = &ba.SentSat
@@ -796,12 +857,19 @@ func (w *Worker) GetAddress(address string, page int, txsOnPage int, option Acco
 		Tokens:                tokens,
 		Erc20Contract:         erc20c,
 		Nonce:                 nonce,
+		BackendUnavailable:    backendUnavailable,
+		Pruned:                w.db.IsPruned(),
+		HistoryFromHeight:     historyFrom,
 	}
 	glog.Info("GetAddress ", address, " finished in ", time.Since(start))
 	return r, nil
 }
 
 func (w *Worker) waitForBackendSync() {
+	// there is no synchronization if the backend is unavailable
+	if w.is.IsBackendUnavailable() {
+		return
+	}
 	// wait a short time if blockbook is synchronizing with backend
 	inSync, _, _ := w.is.GetSyncState()
 	count := 30
@@ -817,9 +885,10 @@ func (w *Worker) getAddrDescUtxo(addrDesc bchain.AddressDescriptor, ba *db.AddrB
 	var err error
 	r := make(Utxos, 0, 8)
 	spentInMempool := make(map[string]struct{})
-	if !onlyConfirmed {
+	// the mempool transactions cannot be loaded if the backend is unavailable
+	if !onlyConfirmed && !w.is.IsBackendUnavailable() {
 		// get utxo from mempool
-		txm, err := w.getAddressTxids(addrDesc, true, &AddressFilter{Vout: AddressFilterVoutOff}, maxInt)
+		txm, _, err := w.getAddressTxids(addrDesc, true, &AddressFilter{Vout: AddressFilterVoutOff}, maxInt)
 		if err != nil {
 			return nil, err
 		}
@@ -924,6 +993,14 @@ func (w *Worker) getAddrDescUtxo(addrDesc bchain.AddressDescriptor, ba *db.AddrB
 	return r, nil
 }
 
+// GetAddrDescUtxo returns unspent outputs for given address descriptor
+func (w *Worker) GetAddrDescUtxo(addrDesc bchain.AddressDescriptor, onlyConfirmed bool) (Utxos, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	return w.getAddrDescUtxo(addrDesc, nil, onlyConfirmed, false)
+}
+
 // GetAddressUtxo returns unspent outputs for given address
 func (w *Worker) GetAddressUtxo(address string, onlyConfirmed bool) (Utxos, error) {
 	if w.chainType != bchain.ChainBitcoinType {
@@ -942,6 +1019,111 @@ func (w *Worker) GetAddressUtxo(address string, onlyConfirmed bool) (Utxos, erro
 	return r, nil
 }
 
+// balanceHistoryTimeRange converts unix timestamps to the range [fromUnix, toUnix), zero means unlimited
+func balanceHistoryTimeRange(fromTime, toTime int64) (uint32, uint32) {
+	fromUnix := uint32(0)
+	toUnix := maxUint32
+	if fromTime > 0 {
+		fromUnix = uint32(fromTime)
+	}
+	if toTime > 0 && toTime < int64(maxUint32) {
+		toUnix = uint32(toTime)
+	}
+	return fromUnix, toUnix
+}
+
+// getBlockTime returns time of the block at given height, block times are cached in blockTimes map
+func (w *Worker) getBlockTime(height uint32, blockTimes map[uint32]uint32) (uint32, error) {
+	if t, found := blockTimes[height]; found {
+		return t, nil
+	}
+	bi, err := w.db.GetBlockInfo(height)
+	if err != nil {
+		return 0, errors.Annotatef(err, "GetBlockInfo %v", height)
+	}
+	if bi == nil {
+		return 0, errors.Errorf("Block info for height %v not found", height)
+	}
+	t := uint32(bi.Time)
+	blockTimes[height] = t
+	return t, nil
+}
+
+// balanceHistoryForTxid returns amounts received and sent by the address in the transaction
+// or nil if the transaction is not in the time range [fromUnix, toUnix)
+func (w *Worker) balanceHistoryForTxid(addrDesc bchain.AddressDescriptor, txid string, fromUnix, toUnix uint32, blockTimes map[uint32]uint32) (*BalanceHistory, error) {
+	ta, err := w.db.GetTxAddresses(txid)
+	if err != nil {
+		return nil, err
+	}
+	if ta == nil {
+		glog.Warning("DB inconsistency:  tx ", txid, ": not found in txAddresses")
+		return nil, nil
+	}
+	t, err := w.getBlockTime(ta.Height, blockTimes)
+	if err != nil {
+		return nil, err
+	}
+	if t < fromUnix || t >= toUnix {
+		return nil, nil
+	}
+	bh := BalanceHistory{
+		Time:        t,
+		Txs:         1,
+		SentSat:     &Amount{},
+		ReceivedSat: &Amount{},
+		Txid:        txid,
+	}
+	for i := range ta.Inputs {
+		tai := &ta.Inputs[i]
+		if bytes.Equal(addrDesc, tai.AddrDesc) {
+			(*big.Int)(bh.SentSat).Add((*big.Int)(bh.SentSat), &tai.ValueSat)
+		}
+	}
+	for i := range ta.Outputs {
+		tao := &ta.Outputs[i]
+		if bytes.Equal(addrDesc, tao.AddrDesc) {
+			(*big.Int)(bh.ReceivedSat).Add((*big.Int)(bh.ReceivedSat), &tao.ValueSat)
+		}
+	}
+	return &bh, nil
+}
+
+// GetBalanceHistory returns amounts received and sent by the address, aggregated to groups of groupBy seconds
+// fromTime and toTime are unix timestamps, zero means unlimited
+func (w *Worker) GetBalanceHistory(address string, fromTime, toTime int64, groupBy uint32) (BalanceHistories, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, NewAPIError("Not supported", true)
+	}
+	start := time.Now()
+	addrDesc, _, err := w.getAddrDescAndNormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	fromUnix, toUnix := balanceHistoryTimeRange(fromTime, toTime)
+	txs, historyFrom, err := w.getAddressTxids(addrDesc, false, &AddressFilter{Vout: AddressFilterVoutOff}, maxInt)
+	if err != nil {
+		return nil, err
+	}
+	if historyFrom > 0 {
+		return nil, NewAPIError(fmt.Sprintf("Balance history is not available, the address history is pruned below height %d", historyFrom), true)
+	}
+	bhs := make(BalanceHistories, 0, len(txs))
+	blockTimes := make(map[uint32]uint32)
+	for _, txid := range txs {
+		bh, err := w.balanceHistoryForTxid(addrDesc, txid, fromUnix, toUnix, blockTimes)
+		if err != nil {
+			return nil, err
+		}
+		if bh != nil {
+			bhs = append(bhs, *bh)
+		}
+	}
+	bha := bhs.SortAndAggregate(groupBy)
+	glog.Info("GetBalanceHistory ", address, ", blocks ", len(blockTimes), ", count ", len(bha), ", finished in ", time.Since(start))
+	return bha, nil
+}
+
 // GetBlocks returns BlockInfo for blocks on given page
 func (w *Worker) GetBlocks(page int, blocksOnPage int) (*Blocks, error) {
 	start := time.Now()
@@ -972,26 +1154,242 @@ func (w *Worker) GetBlocks(page int, blocksOnPage int) (*Blocks, error) {
 	return r, nil
 }
 
+// maxBlockHeaders is the maximum number of block headers returned by one call of GetBlockHeaders
+const maxBlockHeaders = 2000
+
+// getBlockHeaderRaw returns the hex encoded raw header of the block, the header is taken from db
+// or from the backend if it is not stored
+func (w *Worker) getBlockHeaderRaw(height uint32, hash string) (string, error) {
+	h, err := w.db.GetBlockHeaderRaw(height)
+	if err != nil {
+		return "", errors.Annotatef(err, "GetBlockHeaderRaw %v", height)
+	}
+	if h != nil {
+		return hex.EncodeToString(h), nil
+	}
+	header, err := w.chain.GetBlockHeaderRaw(hash)
+	if err != nil {
+		if err == bchain.ErrNotSupported {
+			return "", NewAPIError("Not supported", true)
+		}
+		return "", errors.Annotatef(err, "GetBlockHeaderRaw %v", hash)
+	}
+	return header, nil
+}
+
+// GetBlockHeaders returns hex encoded raw headers of count blocks starting from the height from, the range is limited by the best block
+func (w *Worker) GetBlockHeaders(from, count int) (*BlockHeaders, error) {
+	if from < 0 {
+		return nil, NewAPIError("Invalid block height", true)
+	}
+	if count <= 0 || count > maxBlockHeaders {
+		count = maxBlockHeaders
+	}
+	start := time.Now()
+	bestHeight, _, err := w.db.GetBestBlock()
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBestBlock")
+	}
+	r := &BlockHeaders{From: from, Headers: make([]string, 0)}
+	for height := from; height < from+count && height <= int(bestHeight); height++ {
+		hash, err := w.db.GetBlockHash(uint32(height))
+		if err != nil {
+			return nil, errors.Annotatef(err, "GetBlockHash %v", height)
+		}
+		if hash == "" {
+			break
+		}
+		h, err := w.getBlockHeaderRaw(uint32(height), hash)
+		if err != nil {
+			return nil, err
+		}
+		r.Headers = append(r.Headers, h)
+	}
+	r.Count = len(r.Headers)
+	glog.Info("GetBlockHeaders from ", from, ", count ", r.Count, " finished in ", time.Since(start))
+	return r, nil
+}
+
+// maxBlockFilters is the maximum number of block filters returned by one call of GetBlockFilters
+const maxBlockFilters = 1000
+
+// GetBlockFilters returns the filters of the blocks in the range from-to, the range is limited by the best block
+func (w *Worker) GetBlockFilters(from, to int) ([]BlockFilter, error) {
+	if !w.db.IsBlockFilterIndexEnabled() {
+		return nil, NewAPIError("Block filters are not enabled", true)
+	}
+	if from < 0 || to < from {
+		return nil, NewAPIError("Invalid block range", true)
+	}
+	if to-from >= maxBlockFilters {
+		return nil, NewAPIError(fmt.Sprintf("Too many blocks requested, the maximum is %d", maxBlockFilters), true)
+	}
+	bestHeight, _, err := w.db.GetBestBlock()
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBestBlock")
+	}
+	if from > int(bestHeight) {
+		return nil, NewAPIError("Block not found", true)
+	}
+	if to > int(bestHeight) {
+		to = int(bestHeight)
+	}
+	r := make([]BlockFilter, 0, to-from+1)
+	for height := uint32(from); height <= uint32(to); height++ {
+		hash, err := w.db.GetBlockHash(height)
+		if err != nil {
+			return nil, errors.Annotatef(err, "GetBlockHash %v", height)
+		}
+		bf, err := w.db.GetBlockFilter(height)
+		if err != nil {
+			return nil, errors.Annotatef(err, "GetBlockFilter %v", height)
+		}
+		if bf == nil || hash == "" {
+			return nil, NewAPIError(fmt.Sprintf("Block filter of block %d not found", height), true)
+		}
+		// the header is returned in the same byte order as the block hash
+		header := make([]byte, len(bf.Header))
+		for i := range bf.Header {
+			header[len(header)-1-i] = bf.Header[i]
+		}
+		r = append(r, BlockFilter{
+			Height: height,
+			Hash:   hash,
+			Filter: hex.EncodeToString(bf.Filter),
+			Header: hex.EncodeToString(header),
+		})
+	}
+	return r, nil
+}
+
 func (w *Worker) getBlockInfoFromBlockID(bid string) (*bchain.BlockInfo, error) {
 	// try to decide if passed string (bid) is block height or block hash
 	// if it's a number, must be less than int32
 	var hash string
+	var indexedHeight uint32
+	indexed := false
 	height, err := strconv.Atoi(bid)
 	if err == nil && height < int(maxUint32) {
 		hash, err = w.db.GetBlockHash(uint32(height))
 		if err != nil {
 			hash = bid
+		} else {
+			indexedHeight, indexed = uint32(height), height >= 0 && hash != ""
 		}
 	} else {
 		hash = bid
+		if indexedHeight, indexed, err = w.FindBlockHeight(hash); err != nil {
+			return nil, err
+		}
 	}
 	if hash == "" {
 		return nil, NewAPIError("Block not found", true)
 	}
+	if indexed {
+		bi, err := w.getBlockInfoFromDB(indexedHeight, hash)
+		if err != nil {
+			return nil, err
+		}
+		if bi != nil {
+			return bi, nil
+		}
+	}
 	bi, err := w.chain.GetBlockInfo(hash)
 	return bi, err
 }
 
+// maxBlocksSearchedByHash is the number of the last blocks in which FindBlockHeight searches the block hash
+const maxBlocksSearchedByHash = 100
+
+// FindBlockHeight returns the height of the block with given hash if it is one of the last blocks of the index,
+// the index does not map block hashes to heights, therefore older blocks are not found
+func (w *Worker) FindBlockHeight(hash string) (uint32, bool, error) {
+	bestHeight, bestHash, err := w.db.GetBestBlock()
+	if err != nil {
+		return 0, false, errors.Annotatef(err, "GetBestBlock")
+	}
+	if hash == bestHash {
+		return bestHeight, true, nil
+	}
+	for i := uint32(1); i < maxBlocksSearchedByHash && i <= bestHeight; i++ {
+		h, err := w.db.GetBlockHash(bestHeight - i)
+		if err != nil {
+			return 0, false, errors.Annotatef(err, "GetBlockHash %v", bestHeight-i)
+		}
+		if h == hash {
+			return bestHeight - i, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// blockHeaderSize is the size of the standard serialized block header of bitcoin type coins
+const blockHeaderSize = 80
+
+// getBlockInfoFromDB assembles the block info of a bitcoin type coin from the stored raw header and txids of the block,
+// it returns nil if the index does not contain all the data of the block and the block info must be taken from the backend
+func (w *Worker) getBlockInfoFromDB(height uint32, hash string) (*bchain.BlockInfo, error) {
+	if w.chainType != bchain.ChainBitcoinType {
+		return nil, nil
+	}
+	header, err := w.db.GetBlockHeaderRaw(height)
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBlockHeaderRaw %v", height)
+	}
+	if len(header) != blockHeaderSize {
+		return nil, nil
+	}
+	dbi, err := w.db.GetBlockInfo(height)
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBlockInfo %v", height)
+	}
+	if dbi == nil || dbi.Hash != hash {
+		return nil, nil
+	}
+	// the txids are kept only for the last blocks
+	txids, err := w.db.GetBlockTxids(height)
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBlockTxids %v", height)
+	}
+	if len(txids) == 0 || len(txids) != int(dbi.Txs) {
+		return nil, nil
+	}
+	bestHeight, _, err := w.db.GetBestBlock()
+	if err != nil {
+		return nil, errors.Annotatef(err, "GetBestBlock")
+	}
+	bits := binary.LittleEndian.Uint32(header[72:76])
+	return &bchain.BlockInfo{
+		BlockHeader: bchain.BlockHeader{
+			Hash:          hash,
+			Prev:          hex.EncodeToString(reverseHash(header[4:36])),
+			Height:        height,
+			Confirmations: int(bestHeight-height) + 1,
+			Size:          int(dbi.Size),
+			Time:          dbi.Time,
+		},
+		Version:    json.Number(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(header[0:4]))), 10)),
+		MerkleRoot: hex.EncodeToString(reverseHash(header[36:68])),
+		Nonce:      json.Number(strconv.FormatUint(uint64(binary.LittleEndian.Uint32(header[76:80])), 10)),
+		Bits:       fmt.Sprintf("%08x", bits),
+		Difficulty: json.Number(strconv.FormatFloat(difficultyFromBits(bits), 'g', 16, 64)),
+		Txids:      txids,
+	}, nil
+}
+
+// difficultyFromBits computes the difficulty from the compact target the same way as bitcoind
+func difficultyFromBits(bits uint32) float64 {
+	shift := (bits >> 24) & 0xff
+	d := float64(0xffff) / float64(bits&0x00ffffff)
+	for ; shift < 29; shift++ {
+		d *= 256
+	}
+	for ; shift > 29; shift-- {
+		d /= 256
+	}
+	return d
+}
+
 // GetFeeStats returns statistics about block fees
 func (w *Worker) GetFeeStats(bid string) (*FeeStats, error) {
 	// txSpecific extends Tx with an additional Size and Vsize info
@@ -1105,11 +1503,17 @@ func (w *Worker) GetBlock(bid string, page int, txsOnPage int) (*Block, error) {
 	if page < 0 {
 		page = 0
 	}
+	if w.is.IsBackendUnavailable() {
+		return w.getBlockFromDB(bid, txsOnPage)
+	}
 	bi, err := w.getBlockInfoFromBlockID(bid)
 	if err != nil {
 		if err == bchain.ErrBlockNotFound {
 			return nil, NewAPIError("Block not found", true)
 		}
+		if w.isBackendUnavailableError(err) {
+			return w.getBlockFromDB(bid, txsOnPage)
+		}
 		return nil, NewAPIError(fmt.Sprintf("Block not found, %v", err), true)
 	}
 	dbi := &db.BlockInfo{
@@ -1233,6 +1637,7 @@ func (w *Worker) GetSystemInfo(internal bool) (*SystemInfo, error) {
 	var backendError string
 	if err != nil {
 		glog.Error("GetChainInfo error ", err)
+		w.isBackendUnavailableError(err)
 		backendError = errors.Annotatef(err, "GetChainInfo").Error()
 		ci = &bchain.ChainInfo{}
 		// set not in sync in case of backend error
@@ -1245,25 +1650,32 @@ func (w *Worker) GetSystemInfo(internal bool) (*SystemInfo, error) {
 		columnStats = w.is.GetAllDBColumnStats()
 		internalDBSize = w.is.DBSizeTotal()
 	}
+	backendUnavailable, since := w.is.GetBackendState()
+	var backendUnavailableSince *time.Time
+	if backendUnavailable {
+		backendUnavailableSince = &since
+	}
 	blockbookInfo := &BlockbookInfo{
-		Coin:              w.is.Coin,
-		Host:              w.is.Host,
-		Version:           vi.Version,
-		GitCommit:         vi.GitCommit,
-		BuildTime:         vi.BuildTime,
-		SyncMode:          w.is.SyncMode,
-		InitialSync:       w.is.InitialSync,
-		InSync:            inSync,
-		BestHeight:        bestHeight,
-		LastBlockTime:     lastBlockTime,
-		InSyncMempool:     inSyncMempool,
-		LastMempoolTime:   lastMempoolTime,
-		MempoolSize:       mempoolSize,
-		Decimals:          w.chainParser.AmountDecimals(),
-		DbSize:            w.db.DatabaseSizeOnDisk(),
-		DbSizeFromColumns: internalDBSize,
-		DbColumns:         columnStats,
-		About:             Text.BlockbookAbout,
+		Coin:                    w.is.Coin,
+		Host:                    w.is.Host,
+		Version:                 vi.Version,
+		GitCommit:               vi.GitCommit,
+		BuildTime:               vi.BuildTime,
+		SyncMode:                w.is.SyncMode,
+		InitialSync:             w.is.InitialSync,
+		InSync:                  inSync,
+		BestHeight:              bestHeight,
+		LastBlockTime:           lastBlockTime,
+		InSyncMempool:           inSyncMempool,
+		LastMempoolTime:         lastMempoolTime,
+		MempoolSize:             mempoolSize,
+		Decimals:                w.chainParser.AmountDecimals(),
+		DbSize:                  w.db.DatabaseSizeOnDisk(),
+		DbSizeFromColumns:       internalDBSize,
+		DbColumns:               columnStats,
+		About:                   Text.BlockbookAbout,
+		BackendUnavailable:      backendUnavailable,
+		BackendUnavailableSince: backendUnavailableSince,
 	}
 	backendInfo := &BackendInfo{
 		BackendError:    backendError,
@@ -1278,6 +1690,7 @@ func (w *Worker) GetSystemInfo(internal bool) (*SystemInfo, error) {
 		Timeoffset:      ci.Timeoffset,
 		Version:         ci.Version,
 		Warnings:        ci.Warnings,
+		Endpoints:       w.chain.GetRPCEndpoints(),
 	}
 	glog.Info("GetSystemInfo finished in ", time.Since(start))
 	return &SystemInfo{blockbookInfo, backendInfo}, nil
@@ -1305,3 +1718,124 @@ func (w *Worker) GetMempool(page int, itemsOnPage int) (*MempoolTxids, error) {
 	}
 	return r, nil
 }
+
+// getFiatRatesResult returns the rates of the ticker for the requested currencies, all rates if no currency is specified
+// a rate of a currency missing in the ticker is returned as -1
+func (w *Worker) getFiatRatesResult(currencies []string, ticker *db.CurrencyRatesTicker) *ResultTickerAsString {
+	if len(currencies) == 0 {
+		return &ResultTickerAsString{
+			Timestamp: ticker.Timestamp.UTC().Unix(),
+			Rates:     ticker.Rates,
+		}
+	}
+	rates := make(map[string]float64, len(currencies))
+	for _, currency := range currencies {
+		currency = strings.ToLower(currency)
+		if rate, found := ticker.Rates[currency]; found {
+			rates[currency] = rate
+		} else {
+			rates[currency] = -1
+		}
+	}
+	return &ResultTickerAsString{
+		Timestamp: ticker.Timestamp.UTC().Unix(),
+		Rates:     rates,
+	}
+}
+
+// GetCurrentFiatRates returns last available fiat rates
+func (w *Worker) GetCurrentFiatRates(currencies []string) (*ResultTickerAsString, error) {
+	ticker, err := w.db.FiatRatesFindLastTicker()
+	if err != nil {
+		return nil, NewAPIError(fmt.Sprintf("Error finding ticker: %v", err), false)
+	} else if ticker == nil {
+		return nil, NewAPIError("No tickers found!", true)
+	}
+	return w.getFiatRatesResult(currencies, ticker), nil
+}
+
+// GetFiatRatesForTimestamps returns fiat rates for each of the provided timestamps
+func (w *Worker) GetFiatRatesForTimestamps(timestamps []int64, currencies []string) (*ResultTickersAsString, error) {
+	if len(timestamps) == 0 {
+		return nil, NewAPIError("No timestamps provided", true)
+	}
+	ret := &ResultTickersAsString{}
+	for _, timestamp := range timestamps {
+		date := time.Unix(timestamp, 0).UTC()
+		ticker, err := w.db.FiatRatesFindTicker(&date)
+		if err != nil {
+			glog.Errorf("Error finding ticker for date %v. Error: %v", date, err)
+			ret.Tickers = append(ret.Tickers, ResultTickerAsString{Timestamp: date.Unix(), Error: "Error finding ticker"})
+			continue
+		} else if ticker == nil {
+			ret.Tickers = append(ret.Tickers, ResultTickerAsString{Timestamp: date.Unix(), Error: fmt.Sprintf("No tickers available for %s", date)})
+			continue
+		}
+		ret.Tickers = append(ret.Tickers, *w.getFiatRatesResult(currencies, ticker))
+	}
+	return ret, nil
+}
+
+// GetFiatRatesTickersList returns the list of currencies available in the ticker closest to the timestamp
+// if the timestamp is 0, the last available ticker is used
+func (w *Worker) GetFiatRatesTickersList(timestamp int64) (*ResultTickerListAsString, error) {
+	var ticker *db.CurrencyRatesTicker
+	var err error
+	if timestamp == 0 {
+		ticker, err = w.db.FiatRatesFindLastTicker()
+		if err != nil {
+			return nil, NewAPIError(fmt.Sprintf("Error finding ticker: %v", err), false)
+		} else if ticker == nil {
+			return nil, NewAPIError("No tickers found", true)
+		}
+	} else {
+		date := time.Unix(timestamp, 0).UTC()
+		ticker, err = w.db.FiatRatesFindTicker(&date)
+		if err != nil {
+			return nil, NewAPIError(fmt.Sprintf("Error finding ticker: %v", err), false)
+		} else if ticker == nil {
+			return nil, NewAPIError(fmt.Sprintf("No tickers found for date %v.", date), true)
+		}
+	}
+	keys := make([]string, 0, len(ticker.Rates))
+	for k := range ticker.Rates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // sort to get deterministic results
+	return &ResultTickerListAsString{
+		Timestamp: ticker.Timestamp.UTC().Unix(),
+		Tickers:   keys,
+	}, nil
+}
+
+// setFiatRatesToTxs sets the rate of the fiat currency at the block time to the transactions
+// the last ticker is used for transactions newer than the last ticker
+func (w *Worker) setFiatRatesToTxs(txs []*Tx, currency string) error {
+	currency = strings.ToLower(currency)
+	var last *db.CurrencyRatesTicker
+	for _, tx := range txs {
+		if tx.Blocktime == 0 {
+			continue
+		}
+		date := time.Unix(tx.Blocktime, 0).UTC()
+		ticker, err := w.db.FiatRatesFindTicker(&date)
+		if err != nil {
+			return errors.Annotatef(err, "FiatRatesFindTicker %v", date)
+		}
+		if ticker == nil {
+			if last == nil {
+				if last, err = w.db.FiatRatesFindLastTicker(); err != nil {
+					return errors.Annotatef(err, "FiatRatesFindLastTicker")
+				}
+				if last == nil {
+					return nil
+				}
+			}
+			ticker = last
+		}
+		if rate, found := ticker.Rates[currency]; found {
+			tx.FiatRates = map[string]float64{currency: rate}
+		}
+	}
+	return nil
+}
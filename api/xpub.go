@@ -111,10 +111,21 @@ func (w *Worker) xpubGetAddressTxids(addrDesc bchain.AddressDescriptor, mempool
 			}
 		}
 	} else {
-		err = w.db.GetAddrDescTransactions(addrDesc, fromHeight, toHeight, callback)
+		// in the pruned index the history is available only for the last blocks
+		var historyFrom uint32
+		historyFrom, err = w.db.AddressHistoryFromHeight()
 		if err != nil {
 			return nil, false, err
 		}
+		if fromHeight < historyFrom {
+			fromHeight = historyFrom
+		}
+		if fromHeight <= toHeight {
+			err = w.db.GetAddrDescTransactions(addrDesc, fromHeight, toHeight, callback)
+			if err != nil {
+				return nil, false, err
+			}
+		}
 	}
 	return txs, complete, nil
 }
@@ -132,7 +143,7 @@ func (w *Worker) xpubCheckAndLoadTxids(ad *xpubAddress, filter *AddressFilter, m
 				ad.txids = append(newTxids, ad.txids...)
 				ad.maxHeight = maxHeight
 				ad.txs = uint32(len(ad.txids))
-				if ad.txs != ad.balance.Txs {
+				if ad.txs != ad.balance.Txs && !w.db.IsPruned() {
 					glog.Warning("xpubCheckAndLoadTxids inconsistency ", ad.addrDesc, ", ad.txs=", ad.txs, ", ad.balance.Txs=", ad.balance.Txs)
 				}
 			}
@@ -150,7 +161,7 @@ func (w *Worker) xpubCheckAndLoadTxids(ad *xpubAddress, filter *AddressFilter, m
 	ad.maxHeight = maxHeight
 	if complete {
 		ad.txs = uint32(len(ad.txids))
-		if ad.txs != ad.balance.Txs {
+		if ad.txs != ad.balance.Txs && !w.db.IsPruned() {
 			glog.Warning("xpubCheckAndLoadTxids inconsistency ", ad.addrDesc, ", ad.txs=", ad.txs, ", ad.balance.Txs=", ad.balance.Txs)
 		}
 	}
@@ -402,8 +413,10 @@ func (w *Worker) GetXpubAddress(xpub string, page int, txsOnPage int, option Acc
 		}
 		filtered = true
 	}
+	// the mempool cannot be used if the backend is unavailable, the mempool transactions cannot be loaded
+	backendUnavailable := w.is.IsBackendUnavailable()
 	// process mempool, only if ToHeight is not specified
-	if filter.ToHeight == 0 && !filter.OnlyConfirmed {
+	if filter.ToHeight == 0 && !filter.OnlyConfirmed && !backendUnavailable {
 		txmMap = make(map[string]*Tx)
 		mempoolEntries := make(bchain.MempoolTxidEntries, 0)
 		for _, da := range [][]xpubAddress{data.addresses, data.changeAddresses} {
@@ -476,7 +489,7 @@ func (w *Worker) GetXpubAddress(xpub string, page int, txsOnPage int, option Acc
 		sort.Stable(txc)
 		txCount = len(txcMap)
 		totalResults := txCount
-		if filtered {
+		if filtered || w.db.IsPruned() {
 			totalResults = -1
 		}
 		var from, to int
@@ -528,6 +541,15 @@ func (w *Worker) GetXpubAddress(xpub string, page int, txsOnPage int, option Acc
 			}
 		}
 	}
+	if filter.FiatCurrency != "" {
+		if err = w.setFiatRatesToTxs(txs, filter.FiatCurrency); err != nil {
+			return nil, err
+		}
+	}
+	historyFrom, err := w.db.AddressHistoryFromHeight()
+	if err != nil {
+		return nil, err
+	}
 	var totalReceived big.Int
 	totalReceived.Add(&data.balanceSat, &data.sentSat)
 	addr := Address{
@@ -544,6 +566,9 @@ func (w *Worker) GetXpubAddress(xpub string, page int, txsOnPage int, option Acc
 		UsedTokens:            usedTokens,
 		Tokens:                tokens,
 		XPubAddresses:         xpubAddresses,
+		BackendUnavailable:    backendUnavailable,
+		Pruned:                w.db.IsPruned(),
+		HistoryFromHeight:     historyFrom,
 	}
 	glog.Info("GetXpubAddress ", xpub[:16], ", ", len(data.addresses)+len(data.changeAddresses), " derived addresses, ", txCount, " confirmed txs, finished in ", time.Since(start))
 	return &addr, nil
@@ -589,3 +614,75 @@ func (w *Worker) GetXpubUtxo(xpub string, onlyConfirmed bool, gap int) (Utxos, e
 	glog.Info("GetXpubUtxo ", xpub[:16], ", ", len(r), " utxos, finished in ", time.Since(start))
 	return r, nil
 }
+
+// GetXpubBalanceHistory returns amounts received and sent by the addresses of the xpub, aggregated to groups of groupBy seconds
+// fromTime and toTime are unix timestamps, zero means unlimited
+func (w *Worker) GetXpubBalanceHistory(xpub string, fromTime, toTime int64, groupBy uint32, gap int) (BalanceHistories, error) {
+	start := time.Now()
+	historyFrom, err := w.db.AddressHistoryFromHeight()
+	if err != nil {
+		return nil, err
+	}
+	if historyFrom > 0 {
+		return nil, NewAPIError(fmt.Sprintf("Balance history is not available, the address history is pruned below height %d", historyFrom), true)
+	}
+	fromUnix, toUnix := balanceHistoryTimeRange(fromTime, toTime)
+	data, _, err := w.getXpubData(xpub, 0, 1, AccountDetailsTxidHistory, &AddressFilter{
+		Vout:          AddressFilterVoutOff,
+		OnlyConfirmed: true,
+	}, gap)
+	if err != nil {
+		return nil, err
+	}
+	bhs := make(BalanceHistories, 0)
+	blockTimes := make(map[uint32]uint32)
+	for _, da := range [][]xpubAddress{data.addresses, data.changeAddresses} {
+		for i := range da {
+			ad := &da[i]
+			for _, txid := range ad.txids {
+				bh, err := w.balanceHistoryForTxid(ad.addrDesc, txid.txid, fromUnix, toUnix, blockTimes)
+				if err != nil {
+					return nil, err
+				}
+				if bh != nil {
+					bhs = append(bhs, *bh)
+				}
+			}
+		}
+	}
+	bha := bhs.SortAndAggregate(groupBy)
+	glog.Info("GetXpubBalanceHistory ", xpub[:16], ", blocks ", len(blockTimes), ", count ", len(bha), ", finished in ", time.Since(start))
+	return bha, nil
+}
+
+// XpubAddressDescriptors are the address descriptors derived from xpub, receiving (index 0) and change (index 1) addresses
+// up to the gap of unused addresses after the last used address, LastUsed is -1 if no address of the chain was used
+type XpubAddressDescriptors struct {
+	BasePath  string
+	Gap       int
+	AddrDescs [2][]bchain.AddressDescriptor
+	LastUsed  [2]int
+}
+
+// GetXpubAddressDescriptors returns the address descriptors derived from xpub using the same gap logic as GetXpubAddress
+func (w *Worker) GetXpubAddressDescriptors(xpub string, gap int) (*XpubAddressDescriptors, error) {
+	data, _, err := w.getXpubData(xpub, 0, 1, AccountDetailsBasic, &AddressFilter{Vout: AddressFilterVoutOff}, gap)
+	if err != nil {
+		return nil, err
+	}
+	r := &XpubAddressDescriptors{
+		BasePath: data.basePath,
+		Gap:      data.gap,
+		LastUsed: [2]int{-1, -1},
+	}
+	for change, da := range [][]xpubAddress{data.addresses, data.changeAddresses} {
+		r.AddrDescs[change] = make([]bchain.AddressDescriptor, len(da))
+		for i := range da {
+			r.AddrDescs[change][i] = da[i].addrDesc
+			if da[i].balance != nil {
+				r.LastUsed[change] = i
+			}
+		}
+	}
+	return r, nil
+}
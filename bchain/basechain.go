@@ -29,6 +29,21 @@ func (b *BaseChain) GetNetworkName() string {
 	return b.Network
 }
 
+// GetRPCEndpoints returns nil, multiple backend endpoints are not supported by default
+func (b *BaseChain) GetRPCEndpoints() []RPCEndpointStatus {
+	return nil
+}
+
+// GetBlockHeaderRaw is not supported by default
+func (b *BaseChain) GetBlockHeaderRaw(hash string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// GetTransactionsForMempool is not supported by default, the transactions are fetched one by one
+func (b *BaseChain) GetTransactionsForMempool(txids []string) ([]*Tx, error) {
+	return nil, ErrNotSupported
+}
+
 // GetMempoolEntry is not supported by default
 func (b *BaseChain) GetMempoolEntry(txid string) (*MempoolEntry, error) {
 	return nil, errors.New("GetMempoolEntry: not supported")
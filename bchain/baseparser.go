@@ -166,6 +166,11 @@ func (p *BaseParser) MinimumCoinbaseConfirmations() int {
 	return 0
 }
 
+// IsMerkleTreeDoubleSHA256 returns true if the merkle root of the block is computed from txids using double-SHA256, default is false
+func (p *BaseParser) IsMerkleTreeDoubleSHA256() bool {
+	return false
+}
+
 // PackTx packs transaction to byte array using protobuf
 func (p *BaseParser) PackTx(tx *Tx, height uint32, blockTime int64) ([]byte, error) {
 	var err error
@@ -0,0 +1,165 @@
+package bchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// size of the record header in blk*.dat files - network magic and block size
+const blockFileRecordHeaderSize = 8
+
+// size of the bitcoin type block header
+const blockHeaderSize = 80
+
+type blockFileLocation struct {
+	file   int
+	offset int64
+	size   uint32
+}
+
+// BlockFiles reads blocks directly from the blk*.dat files of a bitcoind type backend
+// the blocks are stored in the files in the order in which they were received, not by height,
+// therefore all files are indexed by the block hash first
+// the files may be obfuscated by the key stored in the xor.dat file (bitcoind 28+) or not obfuscated (legacy format)
+type BlockFiles struct {
+	files  []string
+	xorKey []byte
+	magic  []byte
+	index  map[[32]byte]blockFileLocation
+}
+
+// NewBlockFiles indexes the blocks in the blk*.dat files in the directory dir
+func NewBlockFiles(dir string) (*BlockFiles, error) {
+	start := time.Now()
+	files, err := filepath.Glob(filepath.Join(dir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("No blk*.dat files in %v", dir)
+	}
+	sort.Strings(files)
+	bf := &BlockFiles{
+		files: files,
+		index: make(map[[32]byte]blockFileLocation),
+	}
+	key, err := ioutil.ReadFile(filepath.Join(dir, "xor.dat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// zero key means that the files are not obfuscated
+	if len(key) > 0 && !bytes.Equal(key, make([]byte, len(key))) {
+		bf.xorKey = key
+		glog.Info("blockfiles: the block files are obfuscated")
+	}
+	for i := range files {
+		if err := bf.indexFile(i); err != nil {
+			return nil, errors.Annotatef(err, "%v", files[i])
+		}
+	}
+	glog.Info("blockfiles: indexed ", len(bf.index), " blocks in ", len(files), " files in ", time.Since(start))
+	return bf, nil
+}
+
+// deobfuscate removes the obfuscation of the data read from the offset of the file
+func (bf *BlockFiles) deobfuscate(data []byte, offset int64) {
+	if bf.xorKey == nil {
+		return
+	}
+	l := int64(len(bf.xorKey))
+	for i := range data {
+		data[i] ^= bf.xorKey[(offset+int64(i))%l]
+	}
+}
+
+func (bf *BlockFiles) indexFile(i int) error {
+	f, err := os.Open(bf.files[i])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, blockFileRecordHeaderSize+blockHeaderSize)
+	var offset int64
+	for {
+		n, _ := f.ReadAt(buf, offset)
+		if n < blockFileRecordHeaderSize {
+			break
+		}
+		bf.deobfuscate(buf[:n], offset)
+		magic := buf[:4]
+		// the files are preallocated, the unused space at the end is filled by zeros
+		if bytes.Equal(magic, []byte{0, 0, 0, 0}) {
+			break
+		}
+		if bf.magic == nil {
+			bf.magic = append([]byte{}, magic...)
+			glog.Info("blockfiles: network magic ", hex.EncodeToString(bf.magic))
+		}
+		// skip the garbage after unclean shutdown of the backend until the next record
+		if !bytes.Equal(magic, bf.magic) {
+			offset++
+			continue
+		}
+		size := binary.LittleEndian.Uint32(buf[4:8])
+		if n < len(buf) || size < blockHeaderSize {
+			break
+		}
+		bf.index[blockHashFromHeader(buf[blockFileRecordHeaderSize:])] = blockFileLocation{
+			file:   i,
+			offset: offset + blockFileRecordHeaderSize,
+			size:   size,
+		}
+		offset += blockFileRecordHeaderSize + int64(size)
+	}
+	return nil
+}
+
+// blockHashFromHeader returns the double sha256 hash of the bitcoin type block header
+func blockHashFromHeader(header []byte) [32]byte {
+	h := sha256.Sum256(header[:blockHeaderSize])
+	return sha256.Sum256(h[:])
+}
+
+// Len returns the number of indexed blocks
+func (bf *BlockFiles) Len() int {
+	return len(bf.index)
+}
+
+// GetBlockRaw returns the serialized block with the given hash, nil if the block is not stored in the files
+func (bf *BlockFiles) GetBlockRaw(hash string) ([]byte, error) {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != 32 {
+		return nil, errors.Errorf("Invalid block hash %v", hash)
+	}
+	// the hash is displayed in the reversed byte order
+	var key [32]byte
+	for i := range b {
+		key[31-i] = b[i]
+	}
+	loc, found := bf.index[key]
+	if !found {
+		return nil, nil
+	}
+	// the file is opened for each read, not to keep thousands of block files open
+	f, err := os.Open(bf.files[loc.file])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, loc.size)
+	if _, err := f.ReadAt(data, loc.offset); err != nil {
+		return nil, errors.Annotatef(err, "%v block %v", bf.files[loc.file], hash)
+	}
+	bf.deobfuscate(data, loc.offset)
+	return data, nil
+}
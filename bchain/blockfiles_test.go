@@ -0,0 +1,99 @@
+package bchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testBlock(n byte, size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = n + byte(i)
+	}
+	return b
+}
+
+func testBlockHash(block []byte) string {
+	h := blockHashFromHeader(block)
+	for i := 0; i < 16; i++ {
+		h[i], h[31-i] = h[31-i], h[i]
+	}
+	return hex.EncodeToString(h[:])
+}
+
+func writeTestBlockFile(t *testing.T, path string, records [][]byte, xorKey []byte) {
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Write(r)
+	}
+	// preallocated space
+	buf.Write(make([]byte, 64))
+	data := buf.Bytes()
+	for i := range data {
+		if xorKey != nil {
+			data[i] ^= xorKey[i%len(xorKey)]
+		}
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func blockRecord(magic []byte, block []byte) []byte {
+	r := make([]byte, 8, 8+len(block))
+	copy(r, magic)
+	binary.LittleEndian.PutUint32(r[4:], uint32(len(block)))
+	return append(r, block...)
+}
+
+func TestBlockFiles(t *testing.T) {
+	magic := []byte{0xf9, 0xbe, 0xb4, 0xd9}
+	blocks := [][]byte{testBlock(1, 200), testBlock(2, 81), testBlock(3, 1000), testBlock(4, 300)}
+	for _, xorKey := range [][]byte{nil, {0, 0, 0, 0, 0, 0, 0, 0}, {1, 2, 3, 4, 5, 6, 7, 8}} {
+		dir, err := ioutil.TempDir("", "blockfiles")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if xorKey != nil {
+			if err := ioutil.WriteFile(filepath.Join(dir, "xor.dat"), xorKey, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the blocks are stored out of order, with garbage between the records
+		writeTestBlockFile(t, filepath.Join(dir, "blk00000.dat"), [][]byte{
+			blockRecord(magic, blocks[1]),
+			{0x55, 0x66, 0x77},
+			blockRecord(magic, blocks[0]),
+		}, xorKey)
+		writeTestBlockFile(t, filepath.Join(dir, "blk00001.dat"), [][]byte{
+			blockRecord(magic, blocks[3]),
+			blockRecord(magic, blocks[2]),
+		}, xorKey)
+		bf, err := NewBlockFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bf.Len() != len(blocks) {
+			t.Errorf("Len() = %v, want %v", bf.Len(), len(blocks))
+		}
+		for i, b := range blocks {
+			got, err := bf.GetBlockRaw(testBlockHash(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, b) {
+				t.Errorf("xor %v: GetBlockRaw(block %d) = %v, want %v", xorKey, i, got, b)
+			}
+		}
+		got, err := bf.GetBlockRaw(testBlockHash(testBlock(5, 100)))
+		if err != nil || got != nil {
+			t.Errorf("GetBlockRaw(missing block) = %v, %v, want nil, nil", got, err)
+		}
+	}
+}
@@ -197,6 +197,10 @@ func (c *blockChainWithMetrics) GetChainInfo() (v *bchain.ChainInfo, err error)
 	return c.b.GetChainInfo()
 }
 
+func (c *blockChainWithMetrics) GetRPCEndpoints() []bchain.RPCEndpointStatus {
+	return c.b.GetRPCEndpoints()
+}
+
 func (c *blockChainWithMetrics) GetBestBlockHash() (v string, err error) {
 	defer func(s time.Time) { c.observeRPCLatency("GetBestBlockHash", s, err) }(time.Now())
 	return c.b.GetBestBlockHash()
@@ -217,6 +221,11 @@ func (c *blockChainWithMetrics) GetBlockHeader(hash string) (v *bchain.BlockHead
 	return c.b.GetBlockHeader(hash)
 }
 
+func (c *blockChainWithMetrics) GetBlockHeaderRaw(hash string) (v string, err error) {
+	defer func(s time.Time) { c.observeRPCLatency("GetBlockHeaderRaw", s, err) }(time.Now())
+	return c.b.GetBlockHeaderRaw(hash)
+}
+
 func (c *blockChainWithMetrics) GetBlock(hash string, height uint32) (v *bchain.Block, err error) {
 	defer func(s time.Time) { c.observeRPCLatency("GetBlock", s, err) }(time.Now())
 	return c.b.GetBlock(hash, height)
@@ -247,6 +256,11 @@ func (c *blockChainWithMetrics) GetTransactionForMempool(txid string) (v *bchain
 	return c.b.GetTransactionForMempool(txid)
 }
 
+func (c *blockChainWithMetrics) GetTransactionsForMempool(txids []string) (v []*bchain.Tx, err error) {
+	defer func(s time.Time) { c.observeRPCLatency("GetTransactionsForMempool", s, err) }(time.Now())
+	return c.b.GetTransactionsForMempool(txids)
+}
+
 func (c *blockChainWithMetrics) EstimateSmartFee(blocks int, conservative bool) (v big.Int, err error) {
 	defer func(s time.Time) { c.observeRPCLatency("EstimateSmartFee", s, err) }(time.Now())
 	return c.b.EstimateSmartFee(blocks, conservative)
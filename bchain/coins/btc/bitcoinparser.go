@@ -303,6 +303,8 @@ func (p *BitcoinParser) ParseBlock(b []byte) (*bchain.Block, error) {
 			Time: w.Header.Timestamp.Unix(),
 		},
 		Txs: txs,
+		// the serialized block starts with the header
+		HeaderRaw: append([]byte(nil), b[:wire.MaxBlockHeaderPayload]...),
 	}, nil
 }
 
@@ -333,6 +335,11 @@ func (p *BitcoinParser) MinimumCoinbaseConfirmations() int {
 	return p.minimumCoinbaseConfirmations
 }
 
+// IsMerkleTreeDoubleSHA256 returns true, the bitcoin merkle tree uses double-SHA256 of txids
+func (p *BitcoinParser) IsMerkleTreeDoubleSHA256() bool {
+	return true
+}
+
 func (p *BitcoinParser) addrDescFromExtKey(extKey *hdkeychain.ExtendedKey) (bchain.AddressDescriptor, error) {
 	var a btcutil.Address
 	var err error
@@ -6,12 +6,14 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/big"
 	"net"
 	"net/http"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -23,7 +25,7 @@ import (
 type BitcoinRPC struct {
 	*bchain.BaseChain
 	client       http.Client
-	rpcURL       string
+	endpoints    *bchain.RPCEndpoints
 	user         string
 	password     string
 	Mempool      *bchain.MempoolBitcoinType
@@ -32,32 +34,50 @@ type BitcoinRPC struct {
 	mq           *bchain.MQ
 	ChainConfig  *Configuration
 	RPCMarshaler RPCMarshaler
+	txFlight     bchain.SingleFlight
+	rawBlocksMux sync.Mutex
+	rawBlocks    []rawBlock
 }
 
+// rawBlock is a block received in the rawblock notification
+type rawBlock struct {
+	hash string
+	data []byte
+}
+
+// number of the last blocks received in the rawblock notifications kept in memory
+const maxRawBlocks = 4
+
 // Configuration represents json config file
 type Configuration struct {
-	CoinName                     string `json:"coin_name"`
-	CoinShortcut                 string `json:"coin_shortcut"`
-	RPCURL                       string `json:"rpc_url"`
-	RPCUser                      string `json:"rpc_user"`
-	RPCPass                      string `json:"rpc_pass"`
-	RPCTimeout                   int    `json:"rpc_timeout"`
-	Parse                        bool   `json:"parse"`
-	MessageQueueBinding          string `json:"message_queue_binding"`
-	Subversion                   string `json:"subversion"`
-	BlockAddressesToKeep         int    `json:"block_addresses_to_keep"`
-	MempoolWorkers               int    `json:"mempool_workers"`
-	MempoolSubWorkers            int    `json:"mempool_sub_workers"`
-	AddressFormat                string `json:"address_format"`
-	SupportsEstimateFee          bool   `json:"supports_estimate_fee"`
-	SupportsEstimateSmartFee     bool   `json:"supports_estimate_smart_fee"`
-	XPubMagic                    uint32 `json:"xpub_magic,omitempty"`
-	XPubMagicSegwitP2sh          uint32 `json:"xpub_magic_segwit_p2sh,omitempty"`
-	XPubMagicSegwitNative        uint32 `json:"xpub_magic_segwit_native,omitempty"`
-	Slip44                       uint32 `json:"slip44,omitempty"`
-	AlternativeEstimateFee       string `json:"alternativeEstimateFee,omitempty"`
-	AlternativeEstimateFeeParams string `json:"alternativeEstimateFeeParams,omitempty"`
-	MinimumCoinbaseConfirmations int    `json:"minimumCoinbaseConfirmations,omitempty"`
+	CoinName                     string   `json:"coin_name"`
+	CoinShortcut                 string   `json:"coin_shortcut"`
+	RPCURL                       string   `json:"rpc_url"`
+	RPCUser                      string   `json:"rpc_user"`
+	RPCPass                      string   `json:"rpc_pass"`
+	RPCURLs                      []string `json:"rpc_urls,omitempty"`
+	RPCTimeout                   int      `json:"rpc_timeout"`
+	RPCHealthCheckPeriod         int      `json:"rpc_health_check_period,omitempty"`
+	RPCMaxHeightLag              uint32   `json:"rpc_max_height_lag,omitempty"`
+	Parse                        bool     `json:"parse"`
+	MessageQueueBinding          string   `json:"message_queue_binding"`
+	MessageQueueBindings         []string `json:"message_queue_bindings,omitempty"`
+	MessageQueueRaw              bool     `json:"message_queue_raw,omitempty"`
+	Subversion                   string   `json:"subversion"`
+	BlockAddressesToKeep         int      `json:"block_addresses_to_keep"`
+	MempoolWorkers               int      `json:"mempool_workers"`
+	MempoolSubWorkers            int      `json:"mempool_sub_workers"`
+	MempoolBatchSize             int      `json:"mempool_batch_size,omitempty"`
+	AddressFormat                string   `json:"address_format"`
+	SupportsEstimateFee          bool     `json:"supports_estimate_fee"`
+	SupportsEstimateSmartFee     bool     `json:"supports_estimate_smart_fee"`
+	XPubMagic                    uint32   `json:"xpub_magic,omitempty"`
+	XPubMagicSegwitP2sh          uint32   `json:"xpub_magic_segwit_p2sh,omitempty"`
+	XPubMagicSegwitNative        uint32   `json:"xpub_magic_segwit_native,omitempty"`
+	Slip44                       uint32   `json:"slip44,omitempty"`
+	AlternativeEstimateFee       string   `json:"alternativeEstimateFee,omitempty"`
+	AlternativeEstimateFeeParams string   `json:"alternativeEstimateFeeParams,omitempty"`
+	MinimumCoinbaseConfirmations int      `json:"minimumCoinbaseConfirmations,omitempty"`
 }
 
 // NewBitcoinRPC returns new BitcoinRPC instance.
@@ -83,6 +103,14 @@ func NewBitcoinRPC(config json.RawMessage, pushHandler func(bchain.NotificationT
 	if c.MempoolSubWorkers < 1 {
 		c.MempoolSubWorkers = 1
 	}
+	// mempool transactions are fetched by batches of 100 requests by default, negative value disables batching
+	if c.MempoolBatchSize == 0 {
+		c.MempoolBatchSize = 100
+	}
+	// health check of multiple backend endpoints every 10 seconds by default
+	if c.RPCHealthCheckPeriod <= 0 {
+		c.RPCHealthCheckPeriod = 10
+	}
 	// btc supports both calls, other coins overriding BitcoinRPC can change this
 	c.SupportsEstimateFee = true
 	c.SupportsEstimateSmartFee = true
@@ -96,7 +124,7 @@ func NewBitcoinRPC(config json.RawMessage, pushHandler func(bchain.NotificationT
 	s := &BitcoinRPC{
 		BaseChain:    &bchain.BaseChain{},
 		client:       http.Client{Timeout: time.Duration(c.RPCTimeout) * time.Second, Transport: transport},
-		rpcURL:       c.RPCURL,
+		endpoints:    bchain.NewRPCEndpoints(append([]string{c.RPCURL}, c.RPCURLs...), c.RPCMaxHeightLag),
 		user:         c.RPCUser,
 		password:     c.RPCPass,
 		ParseBlocks:  c.Parse,
@@ -112,6 +140,8 @@ func NewBitcoinRPC(config json.RawMessage, pushHandler func(bchain.NotificationT
 func (b *BitcoinRPC) Initialize() error {
 	b.ChainConfig.SupportsEstimateFee = false
 
+	b.endpoints.StartHealthChecks(time.Duration(b.ChainConfig.RPCHealthCheckPeriod)*time.Second, b.checkEndpoint, b.endpointBlockHash, nil)
+
 	ci, err := b.GetChainInfo()
 	if err != nil {
 		return err
@@ -148,7 +178,7 @@ func (b *BitcoinRPC) Initialize() error {
 // CreateMempool creates mempool if not already created, however does not initialize it
 func (b *BitcoinRPC) CreateMempool(chain bchain.BlockChain) (bchain.Mempool, error) {
 	if b.Mempool == nil {
-		b.Mempool = bchain.NewMempoolBitcoinType(chain, b.ChainConfig.MempoolWorkers, b.ChainConfig.MempoolSubWorkers)
+		b.Mempool = bchain.NewMempoolBitcoinType(chain, b.ChainConfig.MempoolWorkers, b.ChainConfig.MempoolSubWorkers, b.ChainConfig.MempoolBatchSize)
 	}
 	return b.Mempool, nil
 }
@@ -161,7 +191,11 @@ func (b *BitcoinRPC) InitializeMempool(addrDescForOutpoint bchain.AddrDescForOut
 	b.Mempool.AddrDescForOutpoint = addrDescForOutpoint
 	b.Mempool.OnNewTxAddr = onNewTxAddr
 	if b.mq == nil {
-		mq, err := bchain.NewMQ(b.ChainConfig.MessageQueueBinding, b.pushHandler)
+		var rawHandler bchain.MQRawHandler
+		if b.ChainConfig.MessageQueueRaw {
+			rawHandler = b.onRawNotification
+		}
+		mq, err := bchain.NewMQ(append([]string{b.ChainConfig.MessageQueueBinding}, b.ChainConfig.MessageQueueBindings...), b.pushHandler, rawHandler)
 		if err != nil {
 			glog.Error("mq: ", err)
 			return err
@@ -171,8 +205,48 @@ func (b *BitcoinRPC) InitializeMempool(addrDescForOutpoint bchain.AddrDescForOut
 	return nil
 }
 
+// onRawNotification processes the payload of the rawtx and rawblock notifications
+// the transactions are queued to the mempool worker, the blocks are kept for the following GetBlockRaw call
+func (b *BitcoinRPC) onRawNotification(nt bchain.NotificationType, data []byte) {
+	switch nt {
+	case bchain.NotificationNewTx:
+		tx, err := b.Parser.ParseTx(data)
+		if err != nil {
+			glog.Error("mq: rawtx ", err)
+			return
+		}
+		b.Mempool.AddTransaction(tx)
+	case bchain.NotificationNewBlock:
+		// only the headers of the bitcoin format can be hashed here, other blocks are not found in GetBlockRaw and are fetched
+		var header wire.BlockHeader
+		if err := header.Deserialize(bytes.NewReader(data)); err != nil {
+			glog.Error("mq: rawblock ", err)
+			return
+		}
+		b.rawBlocksMux.Lock()
+		if len(b.rawBlocks) >= maxRawBlocks {
+			b.rawBlocks = b.rawBlocks[1:]
+		}
+		b.rawBlocks = append(b.rawBlocks, rawBlock{header.BlockHash().String(), data})
+		b.rawBlocksMux.Unlock()
+	}
+}
+
+// getNotifiedRawBlock returns the block received in the rawblock notification or nil
+func (b *BitcoinRPC) getNotifiedRawBlock(hash string) []byte {
+	b.rawBlocksMux.Lock()
+	defer b.rawBlocksMux.Unlock()
+	for i := range b.rawBlocks {
+		if b.rawBlocks[i].hash == hash {
+			return b.rawBlocks[i].data
+		}
+	}
+	return nil
+}
+
 // Shutdown ZeroMQ and other resources
 func (b *BitcoinRPC) Shutdown(ctx context.Context) error {
+	b.endpoints.Stop()
 	if b.mq != nil {
 		if err := b.mq.Shutdown(ctx); err != nil {
 			glog.Error("MQ.Shutdown error: ", err)
@@ -260,6 +334,7 @@ type ResGetNetworkInfo struct {
 		Subversion      json.Number `json:"subversion"`
 		ProtocolVersion json.Number `json:"protocolversion"`
 		Timeoffset      float64     `json:"timeoffset"`
+		RelayFee        float64     `json:"relayfee"`
 		Warnings        string      `json:"warnings"`
 	} `json:"result"`
 }
@@ -468,6 +543,7 @@ func (b *BitcoinRPC) GetChainInfo() (*bchain.ChainInfo, error) {
 		SizeOnDisk:    resCi.Result.SizeOnDisk,
 		Subversion:    string(resNi.Result.Subversion),
 		Timeoffset:    resNi.Result.Timeoffset,
+		RelayFee:      resNi.Result.RelayFee,
 	}
 	rv.Version = string(resNi.Result.Version)
 	rv.ProtocolVersion = string(resNi.Result.ProtocolVersion)
@@ -529,6 +605,28 @@ func (b *BitcoinRPC) GetBlockHeader(hash string) (*bchain.BlockHeader, error) {
 	return &res.Result, nil
 }
 
+// GetBlockHeaderRaw returns header of block with given hash in hex, as serialized by the backend.
+func (b *BitcoinRPC) GetBlockHeaderRaw(hash string) (string, error) {
+	glog.V(1).Info("rpc: getblockheader (verbose=false) ", hash)
+
+	res := ResGetBlockRaw{}
+	req := CmdGetBlockHeader{Method: "getblockheader"}
+	req.Params.BlockHash = hash
+	req.Params.Verbose = false
+	err := b.Call(&req, &res)
+
+	if err != nil {
+		return "", errors.Annotatef(err, "hash %v", hash)
+	}
+	if res.Error != nil {
+		if IsErrBlockNotFound(res.Error) {
+			return "", bchain.ErrBlockNotFound
+		}
+		return "", errors.Annotatef(res.Error, "hash %v", hash)
+	}
+	return res.Result, nil
+}
+
 // GetBlock returns block with given hash.
 func (b *BitcoinRPC) GetBlock(hash string, height uint32) (*bchain.Block, error) {
 	var err error
@@ -601,6 +699,9 @@ func (b *BitcoinRPC) GetBlockWithoutHeader(hash string, height uint32) (*bchain.
 
 // GetBlockRaw returns block with given hash as bytes
 func (b *BitcoinRPC) GetBlockRaw(hash string) ([]byte, error) {
+	if data := b.getNotifiedRawBlock(hash); data != nil {
+		return data, nil
+	}
 	glog.V(1).Info("rpc: getblock (verbosity=0) ", hash)
 
 	res := ResGetBlockRaw{}
@@ -712,6 +813,81 @@ func (b *BitcoinRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return tx, nil
 }
 
+// GetTransactionsForMempool returns transactions by the transaction IDs using one batch of nonverbose getrawtransaction requests
+// the result has the same order as txids, the transactions which cannot be fetched are nil
+func (b *BitcoinRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	glog.V(1).Info("rpc: getrawtransaction nonverbose batch of ", len(txids), " txs")
+
+	reqs := make([]interface{}, len(txids))
+	res := make([]interface{}, len(txids))
+	for i, txid := range txids {
+		req := CmdGetRawTransaction{Method: "getrawtransaction"}
+		req.Params.Txid = txid
+		req.Params.Verbose = false
+		reqs[i] = &req
+		res[i] = &ResGetRawTransactionNonverbose{}
+	}
+	if err := b.CallBatch(reqs, res); err != nil {
+		return nil, err
+	}
+	txs := make([]*bchain.Tx, len(txids))
+	for i := range res {
+		r := res[i].(*ResGetRawTransactionNonverbose)
+		if r.Error != nil {
+			// the transaction may have been removed from mempool in the meantime
+			if !IsMissingTx(r.Error) {
+				glog.Error("cannot get transaction ", txids[i], ": ", r.Error)
+			}
+			continue
+		}
+		data, err := hex.DecodeString(r.Result)
+		if err == nil {
+			txs[i], err = b.Parser.ParseTx(data)
+		}
+		if err != nil {
+			glog.Error("cannot parse transaction ", txids[i], ": ", err)
+		}
+	}
+	return txs, nil
+}
+
+// GetTransactions returns transactions by the transaction IDs using one batch of verbose getrawtransaction requests
+// the result has the same order as txids, the transactions which cannot be fetched are nil
+func (b *BitcoinRPC) GetTransactions(txids []string) ([]*bchain.Tx, error) {
+	glog.V(1).Info("rpc: getrawtransaction batch of ", len(txids), " txs")
+
+	reqs := make([]interface{}, len(txids))
+	res := make([]interface{}, len(txids))
+	for i, txid := range txids {
+		req := CmdGetRawTransaction{Method: "getrawtransaction"}
+		req.Params.Txid = txid
+		req.Params.Verbose = true
+		reqs[i] = &req
+		res[i] = &ResGetRawTransaction{}
+	}
+	if err := b.CallBatch(reqs, res); err != nil {
+		return nil, err
+	}
+	txs := make([]*bchain.Tx, len(txids))
+	for i := range res {
+		r := res[i].(*ResGetRawTransaction)
+		if r.Error != nil {
+			if !IsMissingTx(r.Error) {
+				glog.Error("cannot get transaction ", txids[i], ": ", r.Error)
+			}
+			continue
+		}
+		tx, err := b.Parser.ParseTxFromJson(r.Result)
+		if err != nil {
+			glog.Error("cannot parse transaction ", txids[i], ": ", err)
+			continue
+		}
+		tx.CoinSpecificData = r.Result
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
 // GetTransaction returns a transaction by the transaction ID
 func (b *BitcoinRPC) GetTransaction(txid string) (*bchain.Tx, error) {
 	r, err := b.getRawTransaction(txid)
@@ -735,7 +911,18 @@ func (b *BitcoinRPC) GetTransactionSpecific(tx *bchain.Tx) (json.RawMessage, err
 }
 
 // getRawTransaction returns json as returned by backend, with all coin specific data
+// concurrent requests for the same transaction share one backend call
 func (b *BitcoinRPC) getRawTransaction(txid string) (json.RawMessage, error) {
+	v, err, _ := b.txFlight.Do(txid, func() (interface{}, error) {
+		return b.getRawTransactionFromBackend(txid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(json.RawMessage), nil
+}
+
+func (b *BitcoinRPC) getRawTransactionFromBackend(txid string) (json.RawMessage, error) {
 	glog.V(1).Info("rpc: getrawtransaction ", txid)
 
 	res := ResGetRawTransaction{}
@@ -824,7 +1011,12 @@ func (b *BitcoinRPC) SendRawTransaction(tx string) (string, error) {
 	res := ResSendRawTransaction{}
 	req := CmdSendRawTransaction{Method: "sendrawtransaction"}
 	req.Params = []string{tx}
-	err := b.Call(&req, &res)
+	// the transaction can be sent also by an endpoint which is not at the tip
+	httpData, err := b.RPCMarshaler.Marshal(&req)
+	if err != nil {
+		return "", err
+	}
+	err = b.callEndpoints(httpData, &res, false)
 
 	if err != nil {
 		return "", err
@@ -882,17 +1074,137 @@ func safeDecodeResponse(body io.ReadCloser, res interface{}) (err error) {
 	return json.Unmarshal(data, &res)
 }
 
+// GetRPCEndpoints returns the state of the backend rpc endpoints
+func (b *BitcoinRPC) GetRPCEndpoints() []bchain.RPCEndpointStatus {
+	return b.endpoints.Status()
+}
+
+// checkEndpoint returns the best height and the best block hash of the backend at the endpoint i
+func (b *BitcoinRPC) checkEndpoint(i int) (uint32, string, error) {
+	httpData, err := b.RPCMarshaler.Marshal(&CmdGetBlockChainInfo{Method: "getblockchaininfo"})
+	if err != nil {
+		return 0, "", err
+	}
+	res := ResGetBlockChainInfo{}
+	if err := b.call(b.endpoints.URL(i), httpData, &res); err != nil {
+		return 0, "", err
+	}
+	if res.Error != nil {
+		return 0, "", res.Error
+	}
+	return uint32(res.Result.Blocks), res.Result.Bestblockhash, nil
+}
+
+// endpointBlockHash returns the hash of the block at given height in the chain of the backend at the endpoint i
+func (b *BitcoinRPC) endpointBlockHash(i int, height uint32) (string, error) {
+	req := CmdGetBlockHash{Method: "getblockhash"}
+	req.Params.Height = height
+	httpData, err := b.RPCMarshaler.Marshal(&req)
+	if err != nil {
+		return "", err
+	}
+	res := ResGetBlockHash{}
+	if err := b.call(b.endpoints.URL(i), httpData, &res); err != nil {
+		return "", err
+	}
+	if res.Error != nil {
+		return "", res.Error
+	}
+	return res.Result, nil
+}
+
 // Call calls Backend RPC interface, using RPCMarshaler interface to marshall the request
+// if the backend endpoint cannot be reached, the call is repeated using the other configured endpoints,
+// the endpoints behind the tip or on a fork are not used
 func (b *BitcoinRPC) Call(req interface{}, res interface{}) error {
 	httpData, err := b.RPCMarshaler.Marshal(req)
 	if err != nil {
 		return err
 	}
-	httpReq, err := http.NewRequest("POST", b.rpcURL, bytes.NewBuffer(httpData))
+	return b.callEndpoints(httpData, res, true)
+}
+
+// CallBatch calls Backend RPC interface with a batch of requests sent in one http request
+// the response to reqs[i] is unmarshalled to res[i], the errors of the individual requests are returned in the responses
+func (b *BitcoinRPC) CallBatch(reqs []interface{}, res []interface{}) error {
+	if len(reqs) != len(res) {
+		return errors.New("CallBatch: number of requests and responses differ")
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, req := range reqs {
+		d, err := b.RPCMarshaler.Marshal(req)
+		if err != nil {
+			return err
+		}
+		if len(d) < 2 || d[0] != '{' {
+			return ErrInvalidValue
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		// the responses are paired with the requests by id, the backend does not have to keep the order
+		fmt.Fprintf(&buf, `{"id":%d,`, i)
+		buf.Write(d[1:])
+	}
+	buf.WriteByte(']')
+	var items []json.RawMessage
+	if err := b.callEndpoints(buf.Bytes(), &items, true); err != nil {
+		return errors.Annotatef(err, "CallBatch")
+	}
+	done := make([]bool, len(res))
+	for _, item := range items {
+		var r struct {
+			ID *int `json:"id"`
+		}
+		if err := json.Unmarshal(item, &r); err != nil {
+			return errors.Annotatef(err, "CallBatch")
+		}
+		if r.ID == nil || *r.ID < 0 || *r.ID >= len(res) || done[*r.ID] {
+			return errors.Errorf("CallBatch: unexpected response %v", string(item))
+		}
+		if err := json.Unmarshal(item, res[*r.ID]); err != nil {
+			return errors.Annotatef(err, "CallBatch")
+		}
+		done[*r.ID] = true
+	}
+	for i := range done {
+		if !done[i] {
+			return errors.Errorf("CallBatch: missing response to request %d", i)
+		}
+	}
+	return nil
+}
+
+// callEndpoints sends the request to the active endpoint, if it cannot be reached, the other endpoints are tried
+// if upToDate is set, only the endpoints at the tip are used, the results of such calls are indexed
+func (b *BitcoinRPC) callEndpoints(httpData []byte, res interface{}, upToDate bool) error {
+	err := bchain.ErrNoUpToDateEndpoint
+	for _, i := range b.endpoints.Order(upToDate) {
+		start := time.Now()
+		err = b.call(b.endpoints.URL(i), httpData, res)
+		// errors returned by the backend mean that the endpoint is working
+		if err == nil || !bchain.IsConnectionError(err) {
+			b.endpoints.ReportSuccess(i, time.Since(start))
+			return err
+		}
+		b.endpoints.ReportFailure(i, err)
+	}
+	return err
+}
+
+func (b *BitcoinRPC) call(rpcURL string, httpData []byte, res interface{}) error {
+	httpReq, err := http.NewRequest("POST", rpcURL, bytes.NewBuffer(httpData))
 	if err != nil {
 		return err
 	}
-	httpReq.SetBasicAuth(b.user, b.password)
+	// the credentials can be specified in the url of the endpoint
+	if httpReq.URL.User == nil {
+		httpReq.SetBasicAuth(b.user, b.password)
+	}
 	httpRes, err := b.client.Do(httpReq)
 	// in some cases the httpRes can contain data even if it returns error
 	// see http://devs.cloudimmunity.com/gotchas-and-common-mistakes-in-go-golang/
@@ -0,0 +1,87 @@
+// +build unittest
+
+package btc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBitcoinRPC_CallBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var reqs []struct {
+			ID     int             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			t.Error(err)
+			return
+		}
+		// answer in the reverse order to check pairing of the responses by id
+		res := make([]string, 0, len(reqs))
+		for i := len(reqs) - 1; i >= 0; i-- {
+			r := reqs[i]
+			id, _ := json.Marshal(r.ID)
+			// JSONMarshalerV1 sends the params as an array, JSONMarshalerV2 as an object
+			var txid string
+			var p []interface{}
+			if json.Unmarshal(r.Params, &p) == nil {
+				txid = p[0].(string)
+			} else {
+				var o CmdGetRawTransaction
+				json.Unmarshal([]byte(`{"params":`+string(r.Params)+`}`), &o)
+				txid = o.Params.Txid
+			}
+			if txid == "missing" {
+				res = append(res, `{"id":`+string(id)+`,"result":null,"error":{"code":-5,"message":"No such mempool or blockchain transaction"}}`)
+			} else {
+				res = append(res, `{"id":`+string(id)+`,"result":"`+txid+`","error":null}`)
+			}
+		}
+		out := "["
+		for i := range res {
+			if i > 0 {
+				out += ","
+			}
+			out += res[i]
+		}
+		w.Write([]byte(out + "]"))
+	}))
+	defer ts.Close()
+
+	for _, m := range []RPCMarshaler{JSONMarshalerV2{}, JSONMarshalerV1{}} {
+		chain, err := NewBitcoinRPC(json.RawMessage(`{"rpc_url":"`+ts.URL+`","rpc_timeout":5}`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := chain.(*BitcoinRPC)
+		b.RPCMarshaler = m
+		txids := []string{"aa", "missing", "bb"}
+		reqs := make([]interface{}, len(txids))
+		res := make([]interface{}, len(txids))
+		for i, txid := range txids {
+			req := CmdGetRawTransaction{Method: "getrawtransaction"}
+			req.Params.Txid = txid
+			reqs[i] = &req
+			res[i] = &ResGetRawTransactionNonverbose{}
+		}
+		if err := b.CallBatch(reqs, res); err != nil {
+			t.Fatal(err)
+		}
+		for i, txid := range txids {
+			r := res[i].(*ResGetRawTransactionNonverbose)
+			if txid == "missing" {
+				if r.Error == nil || !IsMissingTx(r.Error) {
+					t.Errorf("%T: response %d = %+v, want missing tx error", m, i, r)
+				}
+			} else if r.Error != nil || r.Result != txid {
+				t.Errorf("%T: response %d = %+v, want %v", m, i, r, txid)
+			}
+		}
+	}
+}
@@ -112,6 +112,10 @@ func TestParseBlock(t *testing.T) {
 			t.Errorf("ParseBlock() number of transactions: got %d, want %d", len(blk.Txs), len(tb.txs))
 		}
 
+		if !bytes.Equal(blk.HeaderRaw, b[:80]) {
+			t.Errorf("ParseBlock() block header: got %x, want %x", blk.HeaderRaw, b[:80])
+		}
+
 		for ti, tx := range tb.txs {
 			if blk.Txs[ti].Txid != tx {
 				t.Errorf("ParseBlock() transaction %d: got %s, want %s", ti, blk.Txs[ti].Txid, tx)
@@ -112,3 +112,8 @@ func (b *DashRPC) GetBlock(hash string, height uint32) (*bchain.Block, error) {
 func (b *DashRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return b.GetTransaction(txid)
 }
+
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (b *DashRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return b.GetTransactions(txids)
+}
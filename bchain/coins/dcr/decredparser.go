@@ -229,3 +229,8 @@ func (p *DecredParser) PackTx(tx *bchain.Tx, height uint32, blockTime int64) ([]
 func (p *DecredParser) UnpackTx(buf []byte) (*bchain.Tx, uint32, error) {
 	return p.baseParser.UnpackTx(buf)
 }
+
+// IsMerkleTreeDoubleSHA256 returns false, the merkle tree of Decred uses BLAKE-256
+func (p *DecredParser) IsMerkleTreeDoubleSHA256() bool {
+	return false
+}
@@ -701,6 +701,11 @@ func (d *DecredRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return d.GetTransaction(txid)
 }
 
+// GetTransactionsForMempool is not supported, the mempool transactions are fetched one by one
+func (d *DecredRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return nil, bchain.ErrNotSupported
+}
+
 // GetMempoolTransactions returns a slice of regular transactions currently in
 // the mempool. The block whose validation is still undecided will have its txs,
 // listed like they are still in the mempool till the block is confirmed.
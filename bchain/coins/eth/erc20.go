@@ -2,7 +2,6 @@ package eth
 
 import (
 	"blockbook/bchain"
-	"context"
 	"encoding/hex"
 	"math/big"
 	"strings"
@@ -106,10 +105,8 @@ func erc20GetTransfersFromTx(tx *rpcTransaction) ([]bchain.Erc20Transfer, error)
 }
 
 func (b *EthereumRPC) ethCall(data, to string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	var r string
-	err := b.rpc.CallContext(ctx, &r, "eth_call", map[string]interface{}{
+	err := b.callContext(&r, "eth_call", map[string]interface{}{
 		"data": data,
 		"to":   to,
 	}, "latest")
@@ -12,6 +12,7 @@ import (
 
 	ethereum "github.com/ethereum/go-ethereum"
 	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
@@ -31,20 +32,30 @@ const (
 
 // Configuration represents json config file
 type Configuration struct {
-	CoinName                    string `json:"coin_name"`
-	CoinShortcut                string `json:"coin_shortcut"`
-	RPCURL                      string `json:"rpc_url"`
-	RPCTimeout                  int    `json:"rpc_timeout"`
-	BlockAddressesToKeep        int    `json:"block_addresses_to_keep"`
-	MempoolTxTimeoutHours       int    `json:"mempoolTxTimeoutHours"`
-	QueryBackendOnMempoolResync bool   `json:"queryBackendOnMempoolResync"`
+	CoinName                    string   `json:"coin_name"`
+	CoinShortcut                string   `json:"coin_shortcut"`
+	RPCURL                      string   `json:"rpc_url"`
+	RPCURLs                     []string `json:"rpc_urls,omitempty"`
+	RPCTimeout                  int      `json:"rpc_timeout"`
+	RPCHealthCheckPeriod        int      `json:"rpc_health_check_period,omitempty"`
+	RPCMaxHeightLag             uint32   `json:"rpc_max_height_lag,omitempty"`
+	BlockAddressesToKeep        int      `json:"block_addresses_to_keep"`
+	MempoolTxTimeoutHours       int      `json:"mempoolTxTimeoutHours"`
+	QueryBackendOnMempoolResync bool     `json:"queryBackendOnMempoolResync"`
 }
 
 // EthereumRPC is an interface to JSON-RPC eth service.
 type EthereumRPC struct {
 	*bchain.BaseChain
+	// client, rpc and connected are replaced on the switch of the endpoint, they are guarded by clientLock
+	clientLock           sync.RWMutex
 	client               *ethclient.Client
 	rpc                  *rpc.Client
+	connected            int
+	reconnectLock        sync.Mutex
+	endpoints            *bchain.RPCEndpoints
+	checkClientsLock     sync.Mutex
+	checkClients         map[int]*rpc.Client
 	timeout              time.Duration
 	Parser               *EthereumParser
 	Mempool              *bchain.MempoolEthereumType
@@ -72,17 +83,24 @@ func NewEthereumRPC(config json.RawMessage, pushHandler func(bchain.Notification
 	if c.BlockAddressesToKeep < 100 {
 		c.BlockAddressesToKeep = 100
 	}
+	// health check of multiple backend endpoints every 10 seconds by default
+	if c.RPCHealthCheckPeriod <= 0 {
+		c.RPCHealthCheckPeriod = 10
+	}
 
-	rc, ec, err := openRPC(c.RPCURL)
+	endpoints := bchain.NewRPCEndpoints(append([]string{c.RPCURL}, c.RPCURLs...), c.RPCMaxHeightLag)
+	rc, ec, err := openRPC(endpoints.URL(endpoints.Active()))
 	if err != nil {
 		return nil, err
 	}
 
 	s := &EthereumRPC{
-		BaseChain:   &bchain.BaseChain{},
-		client:      ec,
-		rpc:         rc,
-		ChainConfig: &c,
+		BaseChain:    &bchain.BaseChain{},
+		client:       ec,
+		rpc:          rc,
+		endpoints:    endpoints,
+		checkClients: make(map[int]*rpc.Client),
+		ChainConfig:  &c,
 	}
 
 	// always create parser
@@ -144,10 +162,11 @@ func openRPC(url string) (*rpc.Client, *ethclient.Client, error) {
 
 // Initialize initializes ethereum rpc interface
 func (b *EthereumRPC) Initialize() error {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
-
-	id, err := b.client.NetworkID(ctx)
+	var id *big.Int
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		id, err = ec.NetworkID(ctx)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -167,9 +186,112 @@ func (b *EthereumRPC) Initialize() error {
 	}
 	glog.Info("rpc: block chain ", b.Network)
 
+	b.endpoints.StartHealthChecks(time.Duration(b.ChainConfig.RPCHealthCheckPeriod)*time.Second, b.checkEndpoint, b.endpointBlockHash, b.switchEndpoint)
+
 	return nil
 }
 
+// GetRPCEndpoints returns the state of the backend rpc endpoints
+func (b *EthereumRPC) GetRPCEndpoints() []bchain.RPCEndpointStatus {
+	return b.endpoints.Status()
+}
+
+// checkEndpoint returns the best height and the best block hash of the backend at the endpoint i
+func (b *EthereumRPC) checkEndpoint(i int) (uint32, string, error) {
+	return b.endpointBlock(i, "latest")
+}
+
+// endpointBlockHash returns the hash of the block at given height in the chain of the backend at the endpoint i
+func (b *EthereumRPC) endpointBlockHash(i int, height uint32) (string, error) {
+	_, hash, err := b.endpointBlock(i, hexutil.EncodeUint64(uint64(height)))
+	return hash, err
+}
+
+// endpointBlock returns the height and hash of the block at the endpoint i
+// the checks use separate connections so that they do not interfere with the subscriptions
+func (b *EthereumRPC) endpointBlock(i int, number string) (uint32, string, error) {
+	b.checkClientsLock.Lock()
+	rc, found := b.checkClients[i]
+	if !found {
+		var err error
+		if rc, err = rpc.Dial(b.endpoints.URL(i)); err != nil {
+			b.checkClientsLock.Unlock()
+			return 0, "", err
+		}
+		b.checkClients[i] = rc
+	}
+	b.checkClientsLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	var h *struct {
+		Number hexutil.Uint64 `json:"number"`
+		Hash   ethcommon.Hash `json:"hash"`
+	}
+	if err := rc.CallContext(ctx, &h, "eth_getBlockByNumber", number, false); err != nil {
+		// reconnect in the next check
+		b.checkClientsLock.Lock()
+		delete(b.checkClients, i)
+		b.checkClientsLock.Unlock()
+		rc.Close()
+		return 0, "", err
+	}
+	if h == nil {
+		return 0, "", bchain.ErrBlockNotFound
+	}
+	return uint32(h.Number), h.Hash.Hex(), nil
+}
+
+// switchEndpoint reconnects to the endpoint i selected by the health check or after a failed call
+func (b *EthereumRPC) switchEndpoint(i int) {
+	if _, _, connected := b.clients(); i == connected {
+		return
+	}
+	if err := b.reconnectRPC(); err != nil {
+		glog.Error("rpc: switch to endpoint ", i, " failed, ", err)
+		return
+	}
+	// the best header of the new endpoint must be loaded
+	b.bestHeaderLock.Lock()
+	b.bestHeader = nil
+	b.bestHeaderLock.Unlock()
+}
+
+// clients returns the clients of the connected endpoint and its index
+func (b *EthereumRPC) clients() (*rpc.Client, *ethclient.Client, int) {
+	b.clientLock.RLock()
+	defer b.clientLock.RUnlock()
+	return b.rpc, b.client, b.connected
+}
+
+// call runs f using the clients of the connected endpoint, if the endpoint cannot be reached, the client is switched
+// to another endpoint and f is repeated, the same way as the calls of the bitcoin type backends are repeated
+func (b *EthereumRPC) call(f func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) error) error {
+	var err error
+	for attempt := 0; attempt < b.endpoints.Len(); attempt++ {
+		rc, ec, connected := b.clients()
+		ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+		err = f(ctx, rc, ec)
+		cancel()
+		if err == nil || b.endpoints.Len() < 2 || !(bchain.IsConnectionError(err) || err == rpc.ErrClientQuit) {
+			return err
+		}
+		b.endpoints.ReportFailure(connected, err)
+		active := b.endpoints.Active()
+		if active == connected {
+			return err
+		}
+		b.switchEndpoint(active)
+	}
+	return err
+}
+
+// callContext calls the rpc method of the connected endpoint, see call
+func (b *EthereumRPC) callContext(result interface{}, method string, args ...interface{}) error {
+	return b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) error {
+		return rc.CallContext(ctx, result, method, args...)
+	})
+}
+
 // CreateMempool creates mempool if not already created, however does not initialize it
 func (b *EthereumRPC) CreateMempool(chain bchain.BlockChain) (bchain.Mempool, error) {
 	if b.Mempool == nil {
@@ -215,7 +337,8 @@ func (b *EthereumRPC) subscribeEvents() error {
 			b.newBlockSubscription = nil
 			ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
 			defer cancel()
-			sub, err := b.rpc.EthSubscribe(ctx, b.chanNewBlock, "newHeads")
+			rc, _, _ := b.clients()
+			sub, err := rc.EthSubscribe(ctx, b.chanNewBlock, "newHeads")
 			if err != nil {
 				return nil, errors.Annotatef(err, "EthSubscribe newHeads")
 			}
@@ -232,7 +355,8 @@ func (b *EthereumRPC) subscribeEvents() error {
 		b.newTxSubscription = nil
 		ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
 		defer cancel()
-		sub, err := b.rpc.EthSubscribe(ctx, b.chanNewTx, "newPendingTransactions")
+		rc, _, _ := b.clients()
+		sub, err := rc.EthSubscribe(ctx, b.chanNewTx, "newPendingTransactions")
 		if err != nil {
 			return nil, errors.Annotatef(err, "EthSubscribe newPendingTransactions")
 		}
@@ -293,25 +417,43 @@ func (b *EthereumRPC) closeRPC() {
 	if b.newTxSubscription != nil {
 		b.newTxSubscription.Unsubscribe()
 	}
-	if b.rpc != nil {
-		b.rpc.Close()
+	if rc, _, _ := b.clients(); rc != nil {
+		rc.Close()
+	}
+}
+
+func (b *EthereumRPC) closeCheckClients() {
+	b.checkClientsLock.Lock()
+	defer b.checkClientsLock.Unlock()
+	for i, rc := range b.checkClients {
+		rc.Close()
+		delete(b.checkClients, i)
 	}
 }
 
 func (b *EthereumRPC) reconnectRPC() error {
-	glog.Info("Reconnecting RPC")
+	b.reconnectLock.Lock()
+	defer b.reconnectLock.Unlock()
+	// connect to the endpoint selected by the health checks
+	active := b.endpoints.Active()
+	glog.Info("Reconnecting RPC to ", b.endpoints.Status()[active].URL)
 	b.closeRPC()
-	rc, ec, err := openRPC(b.ChainConfig.RPCURL)
+	rc, ec, err := openRPC(b.endpoints.URL(active))
 	if err != nil {
 		return err
 	}
+	b.clientLock.Lock()
 	b.rpc = rc
 	b.client = ec
+	b.connected = active
+	b.clientLock.Unlock()
 	return b.subscribeEvents()
 }
 
 // Shutdown cleans up rpc interface to ethereum
 func (b *EthereumRPC) Shutdown(ctx context.Context) error {
+	b.endpoints.Stop()
+	b.closeCheckClients()
 	b.closeRPC()
 	close(b.chanNewBlock)
 	glog.Info("rpc: shutdown")
@@ -334,17 +476,19 @@ func (b *EthereumRPC) GetChainInfo() (*bchain.ChainInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
-	id, err := b.client.NetworkID(ctx)
+	var id *big.Int
+	err = b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		id, err = ec.NetworkID(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	var ver, protocol string
-	if err := b.rpc.CallContext(ctx, &ver, "web3_clientVersion"); err != nil {
+	if err := b.callContext(&ver, "web3_clientVersion"); err != nil {
 		return nil, err
 	}
-	if err := b.rpc.CallContext(ctx, &protocol, "eth_protocolVersion"); err != nil {
+	if err := b.callContext(&protocol, "eth_protocolVersion"); err != nil {
 		return nil, err
 	}
 	rv := &bchain.ChainInfo{
@@ -365,7 +509,6 @@ func (b *EthereumRPC) GetChainInfo() (*bchain.ChainInfo, error) {
 
 func (b *EthereumRPC) getBestHeader() (*ethtypes.Header, error) {
 	b.bestHeaderLock.Lock()
-	defer b.bestHeaderLock.Unlock()
 	// ETC does not have newBlocks subscription, bestHeader must be updated very often (each 1 second)
 	if b.isETC {
 		if b.bestHeaderTime.Add(1 * time.Second).Before(time.Now()) {
@@ -377,22 +520,29 @@ func (b *EthereumRPC) getBestHeader() (*ethtypes.Header, error) {
 	if b.bestHeaderTime.Add(15*time.Minute).Before(time.Now()) && !b.bestHeaderTime.IsZero() && b.mempoolInitialized {
 		err := b.reconnectRPC()
 		if err != nil {
+			b.bestHeaderLock.Unlock()
 			return nil, err
 		}
 		b.bestHeader = nil
 	}
-	if b.bestHeader == nil {
-		var err error
-		ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-		defer cancel()
-		b.bestHeader, err = b.client.HeaderByNumber(ctx, nil)
-		if err != nil {
-			b.bestHeader = nil
-			return nil, err
-		}
-		b.bestHeaderTime = time.Now()
+	h := b.bestHeader
+	b.bestHeaderLock.Unlock()
+	if h != nil {
+		return h, nil
 	}
-	return b.bestHeader, nil
+	// the lock is not held during the call, the call can switch the endpoint, which resets the best header
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		h, err = ec.HeaderByNumber(ctx, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.bestHeaderLock.Lock()
+	b.bestHeader = h
+	b.bestHeaderTime = time.Now()
+	b.bestHeaderLock.Unlock()
+	return h, nil
 }
 
 // GetBestBlockHash returns hash of the tip of the best-block-chain
@@ -417,9 +567,11 @@ func (b *EthereumRPC) GetBestBlockHeight() (uint32, error) {
 func (b *EthereumRPC) GetBlockHash(height uint32) (string, error) {
 	var n big.Int
 	n.SetUint64(uint64(height))
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
-	h, err := b.client.HeaderByNumber(ctx, &n)
+	var h *ethtypes.Header
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		h, err = ec.HeaderByNumber(ctx, &n)
+		return err
+	})
 	if err != nil {
 		if err == ethereum.NotFound {
 			return "", bchain.ErrBlockNotFound
@@ -480,18 +632,16 @@ func (b *EthereumRPC) computeConfirmations(n uint64) (uint32, error) {
 }
 
 func (b *EthereumRPC) getBlockRaw(hash string, height uint32, fullTxs bool) (json.RawMessage, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	var raw json.RawMessage
 	var err error
 	if hash != "" {
 		if hash == "pending" {
-			err = b.rpc.CallContext(ctx, &raw, "eth_getBlockByNumber", hash, fullTxs)
+			err = b.callContext(&raw, "eth_getBlockByNumber", hash, fullTxs)
 		} else {
-			err = b.rpc.CallContext(ctx, &raw, "eth_getBlockByHash", ethcommon.HexToHash(hash), fullTxs)
+			err = b.callContext(&raw, "eth_getBlockByHash", ethcommon.HexToHash(hash), fullTxs)
 		}
 	} else {
-		err = b.rpc.CallContext(ctx, &raw, "eth_getBlockByNumber", fmt.Sprintf("%#x", height), fullTxs)
+		err = b.callContext(&raw, "eth_getBlockByNumber", fmt.Sprintf("%#x", height), fullTxs)
 	}
 	if err != nil {
 		return nil, errors.Annotatef(err, "hash %v, height %v", hash, height)
@@ -502,10 +652,8 @@ func (b *EthereumRPC) getBlockRaw(hash string, height uint32, fullTxs bool) (jso
 }
 
 func (b *EthereumRPC) getERC20EventsForBlock(blockNumber string) (map[string][]*rpcLog, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	var logs []rpcLogWithTxHash
-	err := b.rpc.CallContext(ctx, &logs, "eth_getLogs", map[string]interface{}{
+	err := b.callContext(&logs, "eth_getLogs", map[string]interface{}{
 		"fromBlock": blockNumber,
 		"toBlock":   blockNumber,
 		"topics":    []string{erc20TransferEventSignature},
@@ -597,11 +745,9 @@ func (b *EthereumRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error)
 
 // GetTransaction returns a transaction by the transaction ID.
 func (b *EthereumRPC) GetTransaction(txid string) (*bchain.Tx, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	var tx *rpcTransaction
 	hash := ethcommon.HexToHash(txid)
-	err := b.rpc.CallContext(ctx, &tx, "eth_getTransactionByHash", hash)
+	err := b.callContext(&tx, "eth_getTransactionByHash", hash)
 	if err != nil {
 		return nil, err
 	} else if tx == nil {
@@ -637,7 +783,7 @@ func (b *EthereumRPC) GetTransaction(txid string) (*bchain.Tx, error) {
 		if b.isETC {
 			var rawReceipt json.RawMessage
 			var etcReceipt rpcEtcReceipt
-			err = b.rpc.CallContext(ctx, &rawReceipt, "eth_getTransactionReceipt", hash)
+			err = b.callContext(&rawReceipt, "eth_getTransactionReceipt", hash)
 			if err != nil {
 				return nil, errors.Annotatef(err, "txid %v", txid)
 			}
@@ -657,7 +803,7 @@ func (b *EthereumRPC) GetTransaction(txid string) (*bchain.Tx, error) {
 				}
 			}
 		} else {
-			err = b.rpc.CallContext(ctx, &receipt, "eth_getTransactionReceipt", hash)
+			err = b.callContext(&receipt, "eth_getTransactionReceipt", hash)
 			if err != nil {
 				return nil, errors.Annotatef(err, "txid %v", txid)
 			}
@@ -721,10 +867,12 @@ func (b *EthereumRPC) EstimateFee(blocks int) (big.Int, error) {
 
 // EstimateSmartFee returns fee estimation
 func (b *EthereumRPC) EstimateSmartFee(blocks int, conservative bool) (big.Int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	var r big.Int
-	gp, err := b.client.SuggestGasPrice(ctx)
+	var gp *big.Int
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		gp, err = ec.SuggestGasPrice(ctx)
+		return err
+	})
 	if err == nil && b != nil {
 		r = *gp
 	}
@@ -742,8 +890,6 @@ func getStringFromMap(p string, params map[string]interface{}) (string, bool) {
 
 // EthereumTypeEstimateGas returns estimation of gas consumption for given transaction parameters
 func (b *EthereumRPC) EthereumTypeEstimateGas(params map[string]interface{}) (uint64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	msg := ethereum.CallMsg{}
 	s, ok := getStringFromMap("from", params)
 	if ok && len(s) > 0 {
@@ -758,15 +904,18 @@ func (b *EthereumRPC) EthereumTypeEstimateGas(params map[string]interface{}) (ui
 	if ok && len(s) > 0 {
 		msg.Data = ethcommon.FromHex(s)
 	}
-	return b.client.EstimateGas(ctx, msg)
+	var gas uint64
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		gas, err = ec.EstimateGas(ctx, msg)
+		return err
+	})
+	return gas, err
 }
 
 // SendRawTransaction sends raw transaction
 func (b *EthereumRPC) SendRawTransaction(hex string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
 	var raw json.RawMessage
-	err := b.rpc.CallContext(ctx, &raw, "eth_sendRawTransaction", hex)
+	err := b.callContext(&raw, "eth_sendRawTransaction", hex)
 	if err != nil {
 		return "", err
 	} else if len(raw) == 0 {
@@ -784,16 +933,22 @@ func (b *EthereumRPC) SendRawTransaction(hex string) (string, error) {
 
 // EthereumTypeGetBalance returns current balance of an address
 func (b *EthereumRPC) EthereumTypeGetBalance(addrDesc bchain.AddressDescriptor) (*big.Int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
-	return b.client.BalanceAt(ctx, ethcommon.BytesToAddress(addrDesc), nil)
+	var balance *big.Int
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		balance, err = ec.BalanceAt(ctx, ethcommon.BytesToAddress(addrDesc), nil)
+		return err
+	})
+	return balance, err
 }
 
 // EthereumTypeGetNonce returns current balance of an address
 func (b *EthereumRPC) EthereumTypeGetNonce(addrDesc bchain.AddressDescriptor) (uint64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
-	defer cancel()
-	return b.client.NonceAt(ctx, ethcommon.BytesToAddress(addrDesc), nil)
+	var nonce uint64
+	err := b.call(func(ctx context.Context, rc *rpc.Client, ec *ethclient.Client) (err error) {
+		nonce, err = ec.NonceAt(ctx, ethcommon.BytesToAddress(addrDesc), nil)
+		return err
+	})
+	return nonce, err
 }
 
 // GetChainParser returns ethereum BlockChainParser
@@ -132,3 +132,8 @@ func (f *FloRPC) GetBlockFull(hash string) (*bchain.Block, error) {
 func (f *FloRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return f.GetTransaction(txid)
 }
+
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (f *FloRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return f.GetTransactions(txids)
+}
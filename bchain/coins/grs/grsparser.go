@@ -85,3 +85,8 @@ func (p *GroestlcoinParser) PackTx(tx *bchain.Tx, height uint32, blockTime int64
 func (p *GroestlcoinParser) UnpackTx(buf []byte) (*bchain.Tx, uint32, error) {
 	return p.baseparser.UnpackTx(buf)
 }
+
+// IsMerkleTreeDoubleSHA256 returns false, the merkle tree of Groestlcoin uses single SHA256
+func (p *GroestlcoinParser) IsMerkleTreeDoubleSHA256() bool {
+	return false
+}
@@ -102,3 +102,8 @@ func (g *GroestlcoinRPC) GetBlock(hash string, height uint32) (*bchain.Block, er
 func (g *GroestlcoinRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return g.GetTransaction(txid)
 }
+
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (g *GroestlcoinRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return g.GetTransactions(txids)
+}
@@ -104,6 +104,11 @@ func (z *KotoRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return z.GetTransaction(txid)
 }
 
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (z *KotoRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return z.GetTransactions(txids)
+}
+
 // GetMempoolEntry returns mempool data for given transaction
 func (z *KotoRPC) GetMempoolEntry(txid string) (*bchain.MempoolEntry, error) {
 	return nil, errors.New("GetMempoolEntry: not implemented")
@@ -103,6 +103,11 @@ func (b *LiquidRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return b.GetTransaction(txid)
 }
 
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (b *LiquidRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return b.GetTransactions(txids)
+}
+
 // GetMempoolEntry returns mempool data for given transaction
 func (b *LiquidRPC) GetMempoolEntry(txid string) (*bchain.MempoolEntry, error) {
 	return nil, errors.New("GetMempoolEntry: not implemented")
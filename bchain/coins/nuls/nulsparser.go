@@ -98,6 +98,11 @@ func (p *NulsParser) PackedTxidLen() int {
 	return 34
 }
 
+// IsMerkleTreeDoubleSHA256 returns false, NULS does not use the bitcoin merkle tree
+func (p *NulsParser) IsMerkleTreeDoubleSHA256() bool {
+	return false
+}
+
 // GetAddrDescFromAddress returns internal address representation (descriptor) of given address
 func (p *NulsParser) GetAddrDescFromAddress(address string) (bchain.AddressDescriptor, error) {
 	addressByte := base58.Decode(address)
@@ -434,6 +434,11 @@ func (n *NulsRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return nil, nil
 }
 
+// GetTransactionsForMempool is not supported, the mempool transactions are fetched one by one
+func (n *NulsRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return nil, bchain.ErrNotSupported
+}
+
 func (n *NulsRPC) GetTransactionSpecific(tx *bchain.Tx) (json.RawMessage, error) {
 	if tx == nil {
 		return nil, bchain.ErrTxNotFound
@@ -65,6 +65,11 @@ func (b *QtumRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return b.GetTransaction(txid)
 }
 
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (b *QtumRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return b.GetTransactions(txids)
+}
+
 // EstimateSmartFee returns fee estimation
 func (b *QtumRPC) EstimateSmartFee(blocks int, conservative bool) (big.Int, error) {
 	feeRate, err := b.BitcoinRPC.EstimateSmartFee(blocks, conservative)
@@ -62,3 +62,8 @@ func (b *VIPSTARCOINRPC) Initialize() error {
 func (b *VIPSTARCOINRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return b.GetTransaction(txid)
 }
+
+// GetTransactionsForMempool returns transactions by the transaction IDs in one batch, the same way as GetTransactionForMempool
+func (b *VIPSTARCOINRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return b.GetTransactions(txids)
+}
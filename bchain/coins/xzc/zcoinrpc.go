@@ -183,6 +183,11 @@ func (zc *ZcoinRPC) GetTransactionForMempool(txid string) (*bchain.Tx, error) {
 	return tx, nil
 }
 
+// GetTransactionsForMempool is not supported, the mempool transactions are fetched one by one
+func (zc *ZcoinRPC) GetTransactionsForMempool(txids []string) ([]*bchain.Tx, error) {
+	return nil, bchain.ErrNotSupported
+}
+
 func (zc *ZcoinRPC) GetTransaction(txid string) (*bchain.Tx, error) {
 	r, err := zc.getRawTransaction(txid)
 	if err != nil {
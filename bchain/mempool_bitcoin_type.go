@@ -1,22 +1,31 @@
 package bchain
 
 import (
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 )
 
+// addTxQueueSize is the number of notified transactions waiting to be added to the mempool
+const addTxQueueSize = 10000
+
 // MempoolBitcoinType is mempool handle.
 type MempoolBitcoinType struct {
 	BaseMempool
 	chanTxid            chan string
 	chanAddrIndex       chan txidio
+	chanTx              chan *Tx
 	AddrDescForOutpoint AddrDescForOutpointFunc
+	workers             int
+	batchSize           int
 }
 
 // NewMempoolBitcoinType creates new mempool handler.
 // For now there is no cleanup of sync routines, the expectation is that the mempool is created only once per process
-func NewMempoolBitcoinType(chain BlockChain, workers int, subworkers int) *MempoolBitcoinType {
+// If batchSize is positive, the transactions are fetched from the backend in batches of batchSize requests,
+// if the backend does not support batches, they are fetched one by one by the sync workers
+func NewMempoolBitcoinType(chain BlockChain, workers int, subworkers int, batchSize int) *MempoolBitcoinType {
 	m := &MempoolBitcoinType{
 		BaseMempool: BaseMempool{
 			chain:        chain,
@@ -25,6 +34,9 @@ func NewMempoolBitcoinType(chain BlockChain, workers int, subworkers int) *Mempo
 		},
 		chanTxid:      make(chan string, 1),
 		chanAddrIndex: make(chan txidio, 1),
+		chanTx:        make(chan *Tx, addTxQueueSize),
+		workers:       workers,
+		batchSize:     batchSize,
 	}
 	for i := 0; i < workers; i++ {
 		go func(i int) {
@@ -47,7 +59,14 @@ func NewMempoolBitcoinType(chain BlockChain, workers int, subworkers int) *Mempo
 			}
 		}(i)
 	}
-	glog.Info("mempool: starting with ", workers, "*", subworkers, " sync workers")
+	// the notified transactions are added by a single goroutine in the order of arrival,
+	// so that a transaction spending an output of a previous one finds it in the mempool
+	go func() {
+		for tx := range m.chanTx {
+			m.addTransaction(tx)
+		}
+	}()
+	glog.Info("mempool: starting with ", workers, "*", subworkers, " sync workers, batch size ", batchSize)
 	return m
 }
 
@@ -62,32 +81,32 @@ func (m *MempoolBitcoinType) getInputAddress(input Outpoint) *addrIndex {
 			glog.Error("cannot get transaction ", input.Txid, ": ", err)
 			return nil
 		}
-		if int(input.Vout) >= len(itx.Vout) {
-			glog.Error("Vout len in transaction ", input.Txid, " ", len(itx.Vout), " input.Vout=", input.Vout)
-			return nil
-		}
-		addrDesc, err = m.chain.GetChainParser().GetAddrDescFromVout(&itx.Vout[input.Vout])
-		if err != nil {
-			glog.Error("error in addrDesc in ", input.Txid, " ", input.Vout, ": ", err)
-			return nil
-		}
+		return m.getInputAddressFromTx(input, itx)
 	}
 	return &addrIndex{string(addrDesc), ^input.Vout}
 
 }
 
-func (m *MempoolBitcoinType) getTxAddrs(txid string, chanInput chan Outpoint, chanResult chan *addrIndex) ([]addrIndex, bool) {
-	tx, err := m.chain.GetTransactionForMempool(txid)
+// getInputAddressFromTx returns the address of the input from the output of the spent transaction itx
+func (m *MempoolBitcoinType) getInputAddressFromTx(input Outpoint, itx *Tx) *addrIndex {
+	if int(input.Vout) >= len(itx.Vout) {
+		glog.Error("Vout len in transaction ", input.Txid, " ", len(itx.Vout), " input.Vout=", input.Vout)
+		return nil
+	}
+	addrDesc, err := m.chain.GetChainParser().GetAddrDescFromVout(&itx.Vout[input.Vout])
 	if err != nil {
-		glog.Error("cannot get transaction ", txid, ": ", err)
-		return nil, false
+		glog.Error("error in addrDesc in ", input.Txid, " ", input.Vout, ": ", err)
+		return nil
 	}
-	glog.V(2).Info("mempool: gettxaddrs ", txid, ", ", len(tx.Vin), " inputs")
-	io := make([]addrIndex, 0, len(tx.Vout)+len(tx.Vin))
+	return &addrIndex{string(addrDesc), ^input.Vout}
+}
+
+// getOutputAddresses appends the addresses of the outputs of the transaction to io
+func (m *MempoolBitcoinType) getOutputAddresses(tx *Tx, io []addrIndex) []addrIndex {
 	for _, output := range tx.Vout {
 		addrDesc, err := m.chain.GetChainParser().GetAddrDescFromVout(&output)
 		if err != nil {
-			glog.Error("error in addrDesc in ", txid, " ", output.N, ": ", err)
+			glog.Error("error in addrDesc in ", tx.Txid, " ", output.N, ": ", err)
 			continue
 		}
 		if len(addrDesc) > 0 {
@@ -97,6 +116,17 @@ func (m *MempoolBitcoinType) getTxAddrs(txid string, chanInput chan Outpoint, ch
 			m.OnNewTxAddr(tx, addrDesc)
 		}
 	}
+	return io
+}
+
+func (m *MempoolBitcoinType) getTxAddrs(txid string, chanInput chan Outpoint, chanResult chan *addrIndex) ([]addrIndex, bool) {
+	tx, err := m.chain.GetTransactionForMempool(txid)
+	if err != nil {
+		glog.Error("cannot get transaction ", txid, ": ", err)
+		return nil, false
+	}
+	glog.V(2).Info("mempool: gettxaddrs ", txid, ", ", len(tx.Vin), " inputs")
+	io := m.getOutputAddresses(tx, make([]addrIndex, 0, len(tx.Vout)+len(tx.Vin)))
 	dispatched := 0
 	for _, input := range tx.Vin {
 		if input.Coinbase != "" {
@@ -128,6 +158,130 @@ func (m *MempoolBitcoinType) getTxAddrs(txid string, chanInput chan Outpoint, ch
 	return io, true
 }
 
+// getTxAddrsBatch gets the transactions and the transactions spent by their inputs using batched requests
+// the io of the transactions which cannot be fetched is empty
+func (m *MempoolBitcoinType) getTxAddrsBatch(txids []string) ([]txidio, error) {
+	txs, err := m.chain.GetTransactionsForMempool(txids)
+	if err != nil {
+		return nil, err
+	}
+	// the inputs often spend outputs of other mempool transactions, which may be in the same batch
+	spentTxs := make(map[string]*Tx, len(txs))
+	for i, tx := range txs {
+		if tx != nil {
+			spentTxs[txids[i]] = tx
+		}
+	}
+	inputAddrDescs := make(map[Outpoint]AddressDescriptor)
+	var missing []string
+	for _, tx := range txs {
+		if tx == nil {
+			continue
+		}
+		for _, input := range tx.Vin {
+			if input.Coinbase != "" {
+				continue
+			}
+			o := Outpoint{input.Txid, int32(input.Vout)}
+			if m.AddrDescForOutpoint != nil {
+				if addrDesc := m.AddrDescForOutpoint(o); addrDesc != nil {
+					inputAddrDescs[o] = addrDesc
+					continue
+				}
+			}
+			if _, found := spentTxs[input.Txid]; !found {
+				spentTxs[input.Txid] = nil
+				missing = append(missing, input.Txid)
+			}
+		}
+	}
+	for len(missing) > 0 {
+		n := len(missing)
+		if n > m.batchSize {
+			n = m.batchSize
+		}
+		itxs, err := m.chain.GetTransactionsForMempool(missing[:n])
+		if err != nil {
+			return nil, err
+		}
+		for i, itx := range itxs {
+			spentTxs[missing[i]] = itx
+		}
+		missing = missing[n:]
+	}
+	r := make([]txidio, len(txids))
+	for i, tx := range txs {
+		r[i].txid = txids[i]
+		if tx == nil {
+			r[i].io = []addrIndex{}
+			continue
+		}
+		glog.V(2).Info("mempool: gettxaddrs ", txids[i], ", ", len(tx.Vin), " inputs")
+		io := m.getOutputAddresses(tx, make([]addrIndex, 0, len(tx.Vout)+len(tx.Vin)))
+		for _, input := range tx.Vin {
+			if input.Coinbase != "" {
+				continue
+			}
+			o := Outpoint{input.Txid, int32(input.Vout)}
+			if addrDesc, found := inputAddrDescs[o]; found {
+				io = append(io, addrIndex{string(addrDesc), ^o.Vout})
+			} else if itx := spentTxs[o.Txid]; itx != nil {
+				if ai := m.getInputAddressFromTx(o, itx); ai != nil {
+					io = append(io, *ai)
+				}
+			} else {
+				glog.Error("cannot get transaction ", o.Txid)
+			}
+		}
+		r[i].io = io
+	}
+	return r, nil
+}
+
+// resyncBatch gets the transactions in batches, the batches are processed in parallel by the sync workers
+func (m *MempoolBitcoinType) resyncBatch(txids []string, onNewEntry func(txid string, entry txEntry), txTime uint32) error {
+	var wg sync.WaitGroup
+	var errMux sync.Mutex
+	var batchErr error
+	chanBatch := make(chan []string)
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range chanBatch {
+				errMux.Lock()
+				failed := batchErr != nil
+				errMux.Unlock()
+				// skip the remaining batches after an error
+				if failed {
+					continue
+				}
+				tios, err := m.getTxAddrsBatch(batch)
+				if err != nil {
+					errMux.Lock()
+					batchErr = err
+					errMux.Unlock()
+					continue
+				}
+				for _, tio := range tios {
+					onNewEntry(tio.txid, txEntry{tio.io, txTime})
+				}
+			}
+		}()
+	}
+	for len(txids) > 0 {
+		n := len(txids)
+		if n > m.batchSize {
+			n = m.batchSize
+		}
+		chanBatch <- txids[:n]
+		txids = txids[n:]
+	}
+	close(chanBatch)
+	wg.Wait()
+	return batchErr
+}
+
 // Resync gets mempool transactions and maps outputs to transactions.
 // Resync is not reentrant, it should be called from a single thread.
 // Read operations (GetTransactions) are safe.
@@ -140,35 +294,45 @@ func (m *MempoolBitcoinType) Resync() (int, error) {
 	}
 	glog.V(2).Info("mempool: resync ", len(txs), " txs")
 	onNewEntry := func(txid string, entry txEntry) {
-		if len(entry.addrIndexes) > 0 {
-			m.mux.Lock()
-			m.txEntries[txid] = entry
-			for _, si := range entry.addrIndexes {
-				m.addrDescToTx[si.addrDesc] = append(m.addrDescToTx[si.addrDesc], Outpoint{txid, si.n})
-			}
-			m.mux.Unlock()
-		}
+		m.addEntry(txid, entry)
 	}
 	txsMap := make(map[string]struct{}, len(txs))
-	dispatched := 0
-	txTime := uint32(time.Now().Unix())
-	// get transaction in parallel using goroutines created in NewUTXOMempool
+	newTxids := make([]string, 0, len(txs))
+	// the transactions can be added concurrently by AddTransaction, the entries must be accessed under lock
+	m.mux.Lock()
 	for _, txid := range txs {
 		txsMap[txid] = struct{}{}
-		_, exists := m.txEntries[txid]
-		if !exists {
-		loop:
-			for {
-				select {
-				// store as many processed transactions as possible
-				case tio := <-m.chanAddrIndex:
-					onNewEntry(tio.txid, txEntry{tio.io, txTime})
-					dispatched--
-				// send transaction to be processed
-				case m.chanTxid <- txid:
-					dispatched++
-					break loop
-				}
+		if _, exists := m.txEntries[txid]; !exists {
+			newTxids = append(newTxids, txid)
+		}
+	}
+	m.mux.Unlock()
+	txTime := uint32(time.Now().Unix())
+	if m.batchSize > 0 && len(newTxids) > 0 {
+		err = m.resyncBatch(newTxids, onNewEntry, txTime)
+		if err == ErrNotSupported {
+			glog.Info("mempool: batch requests not supported, fetching transactions one by one")
+			m.batchSize = 0
+		} else if err != nil {
+			return 0, err
+		} else {
+			newTxids = nil
+		}
+	}
+	dispatched := 0
+	// get transaction in parallel using goroutines created in NewUTXOMempool
+	for _, txid := range newTxids {
+	loop:
+		for {
+			select {
+			// store as many processed transactions as possible
+			case tio := <-m.chanAddrIndex:
+				onNewEntry(tio.txid, txEntry{tio.io, txTime})
+				dispatched--
+			// send transaction to be processed
+			case m.chanTxid <- txid:
+				dispatched++
+				break loop
 			}
 		}
 	}
@@ -177,13 +341,82 @@ func (m *MempoolBitcoinType) Resync() (int, error) {
 		onNewEntry(tio.txid, txEntry{tio.io, txTime})
 	}
 
+	// do not remove the transactions added by AddTransaction after the list of mempool transactions was fetched
+	startTime := uint32(start.Unix())
+	m.mux.Lock()
 	for txid, entry := range m.txEntries {
-		if _, exists := txsMap[txid]; !exists {
-			m.mux.Lock()
+		if _, exists := txsMap[txid]; !exists && entry.time < startTime {
 			m.removeEntryFromMempool(txid, entry)
-			m.mux.Unlock()
 		}
 	}
-	glog.Info("mempool: resync finished in ", time.Since(start), ", ", len(m.txEntries), " transactions in mempool")
-	return len(m.txEntries), nil
+	count := len(m.txEntries)
+	m.mux.Unlock()
+	glog.Info("mempool: resync finished in ", time.Since(start), ", ", count, " transactions in mempool")
+	return count, nil
+}
+
+// addEntry adds the transaction entry with at least one address to the mempool if it is not already there
+func (m *MempoolBitcoinType) addEntry(txid string, entry txEntry) {
+	if len(entry.addrIndexes) == 0 {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, exists := m.txEntries[txid]; exists {
+		return
+	}
+	m.txEntries[txid] = entry
+	for _, si := range entry.addrIndexes {
+		m.addrDescToTx[si.addrDesc] = append(m.addrDescToTx[si.addrDesc], Outpoint{txid, si.n})
+	}
+}
+
+// AddTransaction hands the transaction received in a backend notification to the mempool worker and returns immediately,
+// if the queue is full, the transaction is dropped and added by the next Resync
+func (m *MempoolBitcoinType) AddTransaction(tx *Tx) {
+	select {
+	case m.chanTx <- tx:
+	default:
+		glog.Warning("mempool: queue of notified transactions is full, skipping ", tx.Txid)
+	}
+}
+
+// addTransaction adds the transaction to the mempool without contacting the backend,
+// unless the address of an input is neither in the index nor in the mempool
+func (m *MempoolBitcoinType) addTransaction(tx *Tx) {
+	m.mux.Lock()
+	_, exists := m.txEntries[tx.Txid]
+	m.mux.Unlock()
+	if exists {
+		return
+	}
+	io := m.getOutputAddresses(tx, make([]addrIndex, 0, len(tx.Vout)+len(tx.Vin)))
+	for _, input := range tx.Vin {
+		if input.Coinbase != "" {
+			continue
+		}
+		o := Outpoint{input.Txid, int32(input.Vout)}
+		ai := m.getInputAddressFromMempool(o)
+		if ai == nil {
+			ai = m.getInputAddress(o)
+		}
+		if ai != nil {
+			io = append(io, *ai)
+		}
+	}
+	m.addEntry(tx.Txid, txEntry{io, uint32(time.Now().Unix())})
+}
+
+// getInputAddressFromMempool returns the address of the input spending an output of another mempool transaction
+func (m *MempoolBitcoinType) getInputAddressFromMempool(input Outpoint) *addrIndex {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if entry, found := m.txEntries[input.Txid]; found {
+		for _, ai := range entry.addrIndexes {
+			if ai.n == input.Vout {
+				return &addrIndex{ai.addrDesc, ^input.Vout}
+			}
+		}
+	}
+	return nil
 }
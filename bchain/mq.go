@@ -3,6 +3,7 @@ package bchain
 import (
 	"context"
 	"encoding/binary"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -12,10 +13,11 @@ import (
 // MQ is message queue listener handle
 type MQ struct {
 	context   *zmq.Context
-	socket    *zmq.Socket
+	sockets   []*zmq.Socket
 	isRunning bool
 	finished  chan error
-	binding   string
+	bindings  []string
+	topics    []string
 }
 
 // NotificationType is type of notification
@@ -30,51 +32,81 @@ const (
 	NotificationNewTx NotificationType = iota
 )
 
+// MQRawHandler processes the payload of the raw notifications, the serialized block or transaction
+type MQRawHandler func(nt NotificationType, data []byte)
+
 // NewMQ creates new Bitcoind ZeroMQ listener
+// the listener can be connected to multiple backends, the notifications of all of them are received
 // callback function receives messages
-func NewMQ(binding string, callback func(NotificationType)) (*MQ, error) {
+// if rawHandler is not nil, the listener subscribes to rawblock and rawtx notifications instead of hashblock and hashtx,
+// passes their payload to rawHandler and calls callback for each block, for transactions only if some notifications were lost
+func NewMQ(bindings []string, callback func(NotificationType), rawHandler MQRawHandler) (*MQ, error) {
 	context, err := zmq.NewContext()
 	if err != nil {
 		return nil, err
 	}
-	socket, err := context.NewSocket(zmq.SUB)
-	if err != nil {
-		return nil, err
+	topics := []string{"hashblock", "hashtx"}
+	if rawHandler != nil {
+		topics = []string{"rawblock", "rawtx"}
 	}
-	err = socket.SetSubscribe("hashblock")
-	if err != nil {
-		return nil, err
+	mq := &MQ{
+		context:  context,
+		finished: make(chan error),
+		topics:   topics,
 	}
-	err = socket.SetSubscribe("hashtx")
-	if err != nil {
-		return nil, err
+	// each backend has its own socket, the sequence numbers of the notifications are per publisher
+	for _, binding := range bindings {
+		if binding == "" {
+			continue
+		}
+		socket, err := context.NewSocket(zmq.SUB)
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range topics {
+			if err = socket.SetSubscribe(topic); err != nil {
+				return nil, err
+			}
+		}
+		err = socket.Connect(binding)
+		if err != nil {
+			return nil, err
+		}
+		glog.Info("MQ listening to ", binding, " ", topics)
+		mq.sockets = append(mq.sockets, socket)
+		mq.bindings = append(mq.bindings, binding)
 	}
-	// for now do not use raw subscriptions - we would have to handle skipped/lost notifications from zeromq
-	// on each notification we do sync or syncmempool respectively
-	// socket.SetSubscribe("rawblock")
-	// socket.SetSubscribe("rawtx")
-	err = socket.Connect(binding)
-	if err != nil {
-		return nil, err
+	if len(mq.sockets) == 0 {
+		return mq, nil
+	}
+	mq.isRunning = true
+	var wg sync.WaitGroup
+	for i := range mq.sockets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mq.run(mq.sockets[i], callback, rawHandler)
+		}(i)
 	}
-	glog.Info("MQ listening to ", binding)
-	mq := &MQ{context, socket, true, make(chan error), binding}
-	go mq.run(callback)
+	go func() {
+		wg.Wait()
+		mq.isRunning = false
+		glog.Info("MQ loop terminated")
+		mq.finished <- nil
+	}()
 	return mq, nil
 }
 
-func (mq *MQ) run(callback func(NotificationType)) {
+func (mq *MQ) run(socket *zmq.Socket, callback func(NotificationType), rawHandler MQRawHandler) {
 	defer func() {
 		if r := recover(); r != nil {
 			glog.Error("MQ loop recovered from ", r)
 		}
-		mq.isRunning = false
-		glog.Info("MQ loop terminated")
-		mq.finished <- nil
 	}()
-	mq.isRunning = true
+	// last sequence number by topic, used to detect lost notifications
+	sequences := make(map[string]uint32)
 	for {
-		msg, err := mq.socket.RecvMessageBytes(0)
+		msg, err := socket.RecvMessageBytes(0)
 		if err != nil {
 			if zmq.AsErrno(err) == zmq.Errno(zmq.ETERM) || err.Error() == "Socket is closed" {
 				break
@@ -84,25 +116,39 @@ func (mq *MQ) run(callback func(NotificationType)) {
 		}
 		if msg != nil && len(msg) >= 3 {
 			var nt NotificationType
-			switch string(msg[0]) {
-			case "hashblock":
+			topic := string(msg[0])
+			switch topic {
+			case "hashblock", "rawblock":
 				nt = NotificationNewBlock
-				break
-			case "hashtx":
+			case "hashtx", "rawtx":
 				nt = NotificationNewTx
-				break
 			default:
 				nt = NotificationUnknown
-				glog.Infof("MQ: NotificationUnknown %v", string(msg[0]))
+				glog.Infof("MQ: NotificationUnknown %v", topic)
+			}
+			sequence := uint32(0)
+			hasSequence := len(msg[len(msg)-1]) == 4
+			if hasSequence {
+				sequence = binary.LittleEndian.Uint32(msg[len(msg)-1])
 			}
 			if glog.V(2) {
-				sequence := uint32(0)
-				if len(msg[len(msg)-1]) == 4 {
-					sequence = binary.LittleEndian.Uint32(msg[len(msg)-1])
-				}
-				glog.Infof("MQ: %v %s-%d", nt, string(msg[0]), sequence)
+				glog.Infof("MQ: %v %s-%d", nt, topic, sequence)
+			}
+			if rawHandler == nil || nt == NotificationUnknown {
+				callback(nt)
+				continue
+			}
+			// the notifications sent before the first received one or lost in between are handled by a full resync
+			last, found := sequences[topic]
+			gap := !hasSequence || !found || sequence != last+1
+			sequences[topic] = sequence
+			if gap && found {
+				glog.Warning("MQ: lost notifications ", topic, " ", last, "-", sequence, ", resyncing")
+			}
+			rawHandler(nt, msg[1])
+			if nt == NotificationNewBlock || gap {
+				callback(nt)
 			}
-			callback(nt)
 		}
 	}
 }
@@ -113,21 +159,21 @@ func (mq *MQ) Shutdown(ctx context.Context) error {
 	if mq.isRunning {
 		go func() {
 			// if errors in the closing sequence, let it close ungracefully
-			if err := mq.socket.SetUnsubscribe("hashtx"); err != nil {
-				mq.finished <- err
-				return
-			}
-			if err := mq.socket.SetUnsubscribe("hashblock"); err != nil {
-				mq.finished <- err
-				return
-			}
-			if err := mq.socket.Unbind(mq.binding); err != nil {
-				mq.finished <- err
-				return
-			}
-			if err := mq.socket.Close(); err != nil {
-				mq.finished <- err
-				return
+			for i, socket := range mq.sockets {
+				for _, topic := range mq.topics {
+					if err := socket.SetUnsubscribe(topic); err != nil {
+						mq.finished <- err
+						return
+					}
+				}
+				if err := socket.Unbind(mq.bindings[i]); err != nil {
+					mq.finished <- err
+					return
+				}
+				if err := socket.Close(); err != nil {
+					mq.finished <- err
+					return
+				}
 			}
 			if err := mq.context.Term(); err != nil {
 				mq.finished <- err
@@ -0,0 +1,377 @@
+package bchain
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// RPCEndpointStatus contains the state of a backend rpc endpoint
+type RPCEndpointStatus struct {
+	URL        string    `json:"url"`
+	Active     bool      `json:"active"`
+	Healthy    bool      `json:"healthy"`
+	Behind     bool      `json:"behind,omitempty"`
+	Forked     bool      `json:"forked,omitempty"`
+	BestHeight uint32    `json:"bestHeight"`
+	BestHash   string    `json:"bestHash,omitempty"`
+	LatencyMs  float64   `json:"latencyMs"`
+	LastCheck  time.Time `json:"lastCheck"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ErrNoUpToDateEndpoint is returned if all backend endpoints are behind the best known tip or on a fork
+var ErrNoUpToDateEndpoint = errors.New("No backend endpoint is at the best known tip")
+
+// RPCEndpointCheckFunc returns the best height and the best block hash of the backend at the endpoint with index i
+type RPCEndpointCheckFunc func(i int) (uint32, string, error)
+
+// RPCEndpointHashFunc returns the hash of the block at given height in the chain of the backend at the endpoint with index i
+type RPCEndpointHashFunc func(i int, height uint32) (string, error)
+
+type rpcEndpoint struct {
+	url        string
+	healthy    bool
+	forked     bool
+	bestHeight uint32
+	bestHash   string
+	latency    time.Duration
+	lastCheck  time.Time
+	lastError  string
+}
+
+// RPCEndpoints selects the backend rpc endpoint to be used from a list of endpoints
+// the endpoints are periodically checked, the active endpoint is the one with the lowest latency
+// from the healthy endpoints which are not behind the best known tip by more than maxHeightLag blocks
+// and whose best block is in the chain of the endpoint with the best tip (they are not on a fork)
+type RPCEndpoints struct {
+	mux          sync.Mutex
+	endpoints    []rpcEndpoint
+	active       int
+	maxHeightLag uint32
+	stop         chan struct{}
+}
+
+// NewRPCEndpoints creates the list of endpoints, the first url is the initially active endpoint
+func NewRPCEndpoints(urls []string, maxHeightLag uint32) *RPCEndpoints {
+	e := &RPCEndpoints{
+		endpoints:    make([]rpcEndpoint, 0, len(urls)),
+		maxHeightLag: maxHeightLag,
+	}
+	for _, u := range urls {
+		dup := false
+		for i := range e.endpoints {
+			if e.endpoints[i].url == u {
+				dup = true
+				break
+			}
+		}
+		if u != "" && !dup {
+			// the endpoints are considered healthy until the first failure or check
+			e.endpoints = append(e.endpoints, rpcEndpoint{url: u, healthy: true})
+		}
+	}
+	return e
+}
+
+// Len returns the number of endpoints
+func (e *RPCEndpoints) Len() int {
+	return len(e.endpoints)
+}
+
+// URL returns url of the endpoint with index i
+func (e *RPCEndpoints) URL(i int) string {
+	return e.endpoints[i].url
+}
+
+// Active returns the index of the active endpoint
+func (e *RPCEndpoints) Active() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.active
+}
+
+// Order returns the indexes of the endpoints in the order in which they should be tried
+// the active endpoint is the first, followed by the usable endpoints by latency, the unusable endpoints are the last
+// if upToDate is set, the endpoints which were behind the tip or on a fork at the last check are left out,
+// only such endpoints can be used for the calls whose results are indexed
+func (e *RPCEndpoints) Order(upToDate bool) []int {
+	if len(e.endpoints) == 1 {
+		return []int{0}
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	tip := e.tip()
+	o := make([]int, 0, len(e.endpoints))
+	for i := range e.endpoints {
+		if i != e.active && (!upToDate || !e.outdated(i, tip)) {
+			o = append(o, i)
+		}
+	}
+	sort.SliceStable(o, func(i, j int) bool {
+		ui, uj := e.usable(o[i], tip), e.usable(o[j], tip)
+		if ui != uj {
+			return ui
+		}
+		return e.endpoints[o[i]].latency < e.endpoints[o[j]].latency
+	})
+	if upToDate && e.outdated(e.active, tip) {
+		return o
+	}
+	return append([]int{e.active}, o...)
+}
+
+// ReportSuccess records a successful call to the endpoint i
+func (e *RPCEndpoints) ReportSuccess(i int, latency time.Duration) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	ep := &e.endpoints[i]
+	ep.updateLatency(latency)
+	if !ep.healthy {
+		ep.healthy = true
+		ep.lastError = ""
+		glog.Info("rpc: endpoint ", redactURL(ep.url), " is available")
+	}
+}
+
+// ReportFailure records a failed connection to the endpoint i, if it is the active endpoint, another one is selected
+func (e *RPCEndpoints) ReportFailure(i int, err error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	ep := &e.endpoints[i]
+	if ep.healthy {
+		glog.Warning("rpc: endpoint ", redactURL(ep.url), " is unavailable, ", err)
+	}
+	ep.healthy = false
+	ep.lastError = err.Error()
+	if i == e.active {
+		e.selectActive()
+	}
+}
+
+// Check checks all endpoints concurrently using the function check, selects and returns the active endpoint
+// the best blocks of the endpoints are compared with the chain of the endpoint with the best tip, using the function
+// hashAt for the endpoints below the tip, if hashAt is nil, only the endpoints at the same height are compared
+func (e *RPCEndpoints) Check(check RPCEndpointCheckFunc, hashAt RPCEndpointHashFunc) int {
+	type result struct {
+		height  uint32
+		hash    string
+		latency time.Duration
+		err     error
+		forked  bool
+	}
+	results := make([]result, len(e.endpoints))
+	var wg sync.WaitGroup
+	for i := range e.endpoints {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			h, hash, err := check(i)
+			results[i] = result{height: h, hash: hash, latency: time.Since(start), err: err}
+		}(i)
+	}
+	wg.Wait()
+	// the reference chain is the chain of the most endpoints at the best tip, preferably of the active endpoint
+	var tip uint32
+	votes := make(map[string]int)
+	for i := range results {
+		if results[i].err == nil && results[i].height > tip {
+			tip = results[i].height
+		}
+	}
+	for i := range results {
+		if results[i].err == nil && results[i].height == tip {
+			votes[results[i].hash]++
+		}
+	}
+	active := e.Active()
+	ref := -1
+	for i := range results {
+		r := &results[i]
+		if r.err != nil || r.height != tip {
+			continue
+		}
+		if ref < 0 || votes[r.hash] > votes[results[ref].hash] || votes[r.hash] == votes[results[ref].hash] && i == active {
+			ref = i
+		}
+	}
+	if ref >= 0 {
+		for i := range results {
+			r := &results[i]
+			if i == ref || r.err != nil || r.height > results[ref].height || r.height+e.maxHeightLag < results[ref].height {
+				continue
+			}
+			hash := results[ref].hash
+			if r.height != results[ref].height {
+				if hashAt == nil {
+					continue
+				}
+				var err error
+				if hash, err = hashAt(ref, r.height); err != nil {
+					glog.Warning("rpc: endpoint ", redactURL(e.endpoints[ref].url), " cannot return block hash at height ", r.height, ", ", err)
+					continue
+				}
+			}
+			r.forked = r.hash != hash
+		}
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	now := time.Now()
+	for i := range results {
+		ep := &e.endpoints[i]
+		r := &results[i]
+		ep.lastCheck = now
+		if r.err != nil {
+			if ep.healthy {
+				glog.Warning("rpc: endpoint ", redactURL(ep.url), " failed the health check, ", r.err)
+			}
+			ep.healthy = false
+			ep.lastError = r.err.Error()
+			continue
+		}
+		if !ep.healthy {
+			glog.Info("rpc: endpoint ", redactURL(ep.url), " is available")
+		}
+		if r.forked && !ep.forked {
+			glog.Warning("rpc: endpoint ", redactURL(ep.url), " is on a fork, best block ", r.height, " ", r.hash)
+		}
+		ep.healthy = true
+		ep.forked = r.forked
+		ep.lastError = ""
+		ep.bestHeight = r.height
+		ep.bestHash = r.hash
+		ep.updateLatency(r.latency)
+	}
+	e.selectActive()
+	return e.active
+}
+
+// StartHealthChecks runs Check periodically until Stop is called, it is not necessary for a single endpoint
+// the optional function onSwitch is called if the health check selects a different active endpoint
+func (e *RPCEndpoints) StartHealthChecks(period time.Duration, check RPCEndpointCheckFunc, hashAt RPCEndpointHashFunc, onSwitch func(i int)) {
+	if len(e.endpoints) < 2 || e.stop != nil {
+		return
+	}
+	e.stop = make(chan struct{})
+	active := e.Active()
+	doCheck := func() {
+		if a := e.Check(check, hashAt); a != active {
+			active = a
+			if onSwitch != nil {
+				onSwitch(a)
+			}
+		}
+	}
+	doCheck()
+	stop := e.stop
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				doCheck()
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic health checks
+func (e *RPCEndpoints) Stop() {
+	if e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+}
+
+// Status returns the state of all endpoints
+func (e *RPCEndpoints) Status() []RPCEndpointStatus {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	tip := e.tip()
+	r := make([]RPCEndpointStatus, len(e.endpoints))
+	for i := range e.endpoints {
+		ep := &e.endpoints[i]
+		r[i] = RPCEndpointStatus{
+			URL:        redactURL(ep.url),
+			Active:     i == e.active,
+			Healthy:    ep.healthy,
+			Behind:     ep.healthy && ep.bestHeight+e.maxHeightLag < tip,
+			Forked:     ep.forked,
+			BestHeight: ep.bestHeight,
+			BestHash:   ep.bestHash,
+			LatencyMs:  float64(ep.latency) / float64(time.Millisecond),
+			LastCheck:  ep.lastCheck,
+			Error:      ep.lastError,
+		}
+	}
+	return r
+}
+
+// tip returns the best height of the healthy endpoints, must be called with the lock held
+func (e *RPCEndpoints) tip() uint32 {
+	var tip uint32
+	for i := range e.endpoints {
+		if e.endpoints[i].healthy && e.endpoints[i].bestHeight > tip {
+			tip = e.endpoints[i].bestHeight
+		}
+	}
+	return tip
+}
+
+func (e *RPCEndpoints) usable(i int, tip uint32) bool {
+	return e.endpoints[i].healthy && !e.outdated(i, tip)
+}
+
+// outdated returns true if the endpoint was behind the tip or on a fork at the last check
+func (e *RPCEndpoints) outdated(i int, tip uint32) bool {
+	ep := &e.endpoints[i]
+	return ep.forked || ep.bestHeight+e.maxHeightLag < tip
+}
+
+// selectActive keeps the active endpoint if it is usable and there is no usable endpoint with less than half of its latency
+// otherwise it selects the usable endpoint with the lowest latency, must be called with the lock held
+func (e *RPCEndpoints) selectActive() {
+	tip := e.tip()
+	best := -1
+	for i := range e.endpoints {
+		if e.usable(i, tip) && (best < 0 || e.endpoints[i].latency < e.endpoints[best].latency) {
+			best = i
+		}
+	}
+	if best < 0 || best == e.active {
+		return
+	}
+	if e.usable(e.active, tip) && 2*e.endpoints[best].latency >= e.endpoints[e.active].latency {
+		return
+	}
+	glog.Info("rpc: switching from endpoint ", redactURL(e.endpoints[e.active].url), " to ", redactURL(e.endpoints[best].url))
+	e.active = best
+}
+
+// updateLatency computes the exponential moving average of the latency
+func (ep *rpcEndpoint) updateLatency(latency time.Duration) {
+	if ep.latency == 0 {
+		ep.latency = latency
+	} else {
+		ep.latency = (4*ep.latency + latency) / 5
+	}
+}
+
+// redactURL removes the password from the url
+func redactURL(u string) string {
+	p, err := url.Parse(u)
+	if err != nil || p.User == nil {
+		return u
+	}
+	p.User = url.User(p.User.Username())
+	return p.String()
+}
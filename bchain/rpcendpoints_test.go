@@ -0,0 +1,143 @@
+package bchain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRPCEndpoints(t *testing.T) {
+	e := NewRPCEndpoints([]string{"http://a:1", "", "http://b:2", "http://a:1"}, 0)
+	if e.Len() != 2 || e.URL(0) != "http://a:1" || e.URL(1) != "http://b:2" {
+		t.Errorf("NewRPCEndpoints() = %+v", e.endpoints)
+	}
+	if e.Active() != 0 {
+		t.Errorf("Active() = %v, want 0", e.Active())
+	}
+}
+
+func TestRPCEndpoints_Check(t *testing.T) {
+	type result struct {
+		height uint32
+		delay  time.Duration
+		err    error
+		hash   string
+	}
+	tests := []struct {
+		name         string
+		maxHeightLag uint32
+		results      []result
+		chains       []string
+		wantActive   int
+		wantOrder    []int
+		wantUpToDate []int
+	}{
+		{
+			name:         "keep the active endpoint",
+			results:      []result{{100, 20 * time.Millisecond, nil, ""}, {100, 15 * time.Millisecond, nil, ""}, {100, 30 * time.Millisecond, nil, ""}},
+			wantActive:   0,
+			wantOrder:    []int{0, 1, 2},
+			wantUpToDate: []int{0, 1, 2},
+		},
+		{
+			name:         "switch to much faster endpoint",
+			results:      []result{{100, 100 * time.Millisecond, nil, ""}, {100, 30 * time.Millisecond, nil, ""}, {100, 1 * time.Millisecond, nil, ""}},
+			wantActive:   2,
+			wantOrder:    []int{2, 1, 0},
+			wantUpToDate: []int{2, 1, 0},
+		},
+		{
+			name:         "switch from failed endpoint",
+			results:      []result{{0, 0, errors.New("connection refused"), ""}, {100, 30 * time.Millisecond, nil, ""}, {100, 10 * time.Millisecond, nil, ""}},
+			wantActive:   2,
+			wantOrder:    []int{2, 1, 0},
+			wantUpToDate: []int{2, 1},
+		},
+		{
+			name:         "switch from endpoint behind",
+			results:      []result{{98, 1 * time.Millisecond, nil, ""}, {100, 30 * time.Millisecond, nil, ""}, {99, 10 * time.Millisecond, nil, ""}},
+			wantActive:   1,
+			wantOrder:    []int{1, 0, 2},
+			wantUpToDate: []int{1},
+		},
+		{
+			name:         "endpoint behind within lag",
+			maxHeightLag: 2,
+			results:      []result{{98, 1 * time.Millisecond, nil, ""}, {100, 30 * time.Millisecond, nil, ""}, {99, 10 * time.Millisecond, nil, ""}},
+			wantActive:   0,
+			wantOrder:    []int{0, 2, 1},
+			wantUpToDate: []int{0, 2, 1},
+		},
+		{
+			name:         "switch from forked endpoint at the tip",
+			results:      []result{{100, 1 * time.Millisecond, nil, "x"}, {100, 30 * time.Millisecond, nil, ""}, {100, 10 * time.Millisecond, nil, ""}},
+			wantActive:   2,
+			wantOrder:    []int{2, 1, 0},
+			wantUpToDate: []int{2, 1},
+		},
+		{
+			name:         "forked endpoint below the tip",
+			maxHeightLag: 2,
+			results:      []result{{100, 30 * time.Millisecond, nil, ""}, {99, 1 * time.Millisecond, nil, "x"}, {98, 5 * time.Millisecond, nil, ""}},
+			chains:       []string{"", "", ""},
+			wantActive:   2,
+			wantOrder:    []int{2, 0, 1},
+			wantUpToDate: []int{2, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewRPCEndpoints([]string{"http://a:1", "http://b:2", "http://c:3"}, tt.maxHeightLag)
+			// the block hash is derived from the height, the hash of a fork has a suffix
+			hash := func(height uint32, fork string) string {
+				return fmt.Sprint(height, fork)
+			}
+			var hashAt RPCEndpointHashFunc
+			if tt.chains != nil {
+				hashAt = func(i int, height uint32) (string, error) {
+					return hash(height, tt.chains[i]), nil
+				}
+			}
+			got := e.Check(func(i int) (uint32, string, error) {
+				time.Sleep(tt.results[i].delay)
+				return tt.results[i].height, hash(tt.results[i].height, tt.results[i].hash), tt.results[i].err
+			}, hashAt)
+			if got != tt.wantActive {
+				t.Errorf("Check() = %v, want %v", got, tt.wantActive)
+			}
+			if o := e.Order(false); !reflect.DeepEqual(o, tt.wantOrder) {
+				t.Errorf("Order(false) = %v, want %v", o, tt.wantOrder)
+			}
+			if o := e.Order(true); !reflect.DeepEqual(o, tt.wantUpToDate) {
+				t.Errorf("Order(true) = %v, want %v", o, tt.wantUpToDate)
+			}
+		})
+	}
+}
+
+func TestRPCEndpoints_ReportFailure(t *testing.T) {
+	e := NewRPCEndpoints([]string{"http://user:secret@a:1", "http://b:2"}, 0)
+	e.ReportSuccess(1, 10*time.Millisecond)
+	e.ReportFailure(1, errors.New("connection refused"))
+	if e.Active() != 0 {
+		t.Errorf("Active() = %v, want 0", e.Active())
+	}
+	e.ReportFailure(0, errors.New("connection refused"))
+	if e.Active() != 0 {
+		t.Errorf("Active() = %v, want 0 if there is no usable endpoint", e.Active())
+	}
+	e.ReportSuccess(1, 10*time.Millisecond)
+	e.ReportFailure(0, errors.New("connection refused"))
+	if e.Active() != 1 {
+		t.Errorf("Active() = %v, want 1", e.Active())
+	}
+	want := []RPCEndpointStatus{
+		{URL: "http://user@a:1", Error: "connection refused"},
+		{URL: "http://b:2", Active: true, Healthy: true, LatencyMs: 10},
+	}
+	if s := e.Status(); !reflect.DeepEqual(s, want) {
+		t.Errorf("Status() = %+v, want %+v", s, want)
+	}
+}
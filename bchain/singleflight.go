@@ -0,0 +1,43 @@
+package bchain
+
+import "sync"
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// SingleFlight suppresses duplicate concurrent calls, the callers with the same key share the result of one call
+// the zero value is ready to use
+type SingleFlight struct {
+	mux   sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do executes fn for the given key if there is no call for the key in progress, otherwise it waits for the call
+// in progress and returns its result; shared is true if the result comes from a call started by another caller
+func (s *SingleFlight) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	s.mux.Lock()
+	if s.calls == nil {
+		s.calls = make(map[string]*flightCall)
+	}
+	if c, found := s.calls[key]; found {
+		s.mux.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	s.calls[key] = c
+	s.mux.Unlock()
+
+	defer func() {
+		s.mux.Lock()
+		delete(s.calls, key)
+		s.mux.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
@@ -0,0 +1,52 @@
+package bchain
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlight_Do(t *testing.T) {
+	var s SingleFlight
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "result", nil
+	}
+	const n = 10
+	var wg sync.WaitGroup
+	var sharedCount int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := s.Do("key", fn)
+			if err != nil || v.(string) != "result" {
+				t.Errorf("Do() = %v, %v", v, err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+		}()
+	}
+	// let all goroutines join the call in progress
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if sharedCount != n-1 {
+		t.Errorf("shared %d times, want %d", sharedCount, n-1)
+	}
+	// the finished call is not cached
+	wantErr := errors.New("error")
+	_, err, shared := s.Do("key", func() (interface{}, error) { return nil, wantErr })
+	if err != wantErr || shared {
+		t.Errorf("Do() = %v, %v, want %v, false", err, shared, wantErr)
+	}
+}
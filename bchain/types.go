@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net"
 )
 
 // ChainType is type of the blockchain
@@ -33,8 +34,26 @@ var (
 	ErrTxidMissing = errors.New("Txid missing")
 	// ErrTxNotFound is returned if transaction was not found
 	ErrTxNotFound = errors.New("Tx not found")
+	// ErrNotSupported is returned if the method is not supported by the blockchain rpc of the coin
+	ErrNotSupported = errors.New("Not supported")
 )
 
+// IsConnectionError returns true if the error was caused by a failure to connect to the backend or by a network timeout
+// the annotated errors are unwrapped to the original cause
+func IsConnectionError(err error) bool {
+	for err != nil {
+		if _, ok := err.(net.Error); ok {
+			return true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok || c.Cause() == err {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
 // Outpoint is txid together with output (or input) index
 type Outpoint struct {
 	Txid string
@@ -93,6 +112,9 @@ type Tx struct {
 type Block struct {
 	BlockHeader
 	Txs []Tx `json:"tx"`
+	// HeaderRaw is the header of the block as serialized by the backend, it is set by the parser
+	// of the bitcoin type coins with the standard 80 byte header or by the sync worker
+	HeaderRaw []byte `json:"-"`
 }
 
 // BlockHeader contains limited data (as needed for indexing) from backend block header
@@ -147,6 +169,7 @@ type ChainInfo struct {
 	Subversion      string  `json:"subversion"`
 	ProtocolVersion string  `json:"protocolversion"`
 	Timeoffset      float64 `json:"timeoffset"`
+	RelayFee        float64 `json:"relayfee"`
 	Warnings        string  `json:"warnings"`
 }
 
@@ -220,16 +243,21 @@ type BlockChain interface {
 	GetSubversion() string
 	GetCoinName() string
 	GetChainInfo() (*ChainInfo, error)
+	// state of the backend rpc endpoints, nil if the coin does not support multiple endpoints
+	GetRPCEndpoints() []RPCEndpointStatus
 	// requests
 	GetBestBlockHash() (string, error)
 	GetBestBlockHeight() (uint32, error)
 	GetBlockHash(height uint32) (string, error)
 	GetBlockHeader(hash string) (*BlockHeader, error)
+	GetBlockHeaderRaw(hash string) (string, error)
 	GetBlock(hash string, height uint32) (*Block, error)
 	GetBlockInfo(hash string) (*BlockInfo, error)
 	GetMempoolTransactions() ([]string, error)
 	GetTransaction(txid string) (*Tx, error)
 	GetTransactionForMempool(txid string) (*Tx, error)
+	// batched GetTransactionForMempool, the result has the same order as txids, nil for transactions which cannot be fetched
+	GetTransactionsForMempool(txids []string) ([]*Tx, error)
 	GetTransactionSpecific(tx *Tx) (json.RawMessage, error)
 	EstimateSmartFee(blocks int, conservative bool) (big.Int, error)
 	EstimateFee(blocks int) (big.Int, error)
@@ -280,6 +308,8 @@ type BlockChainParser interface {
 	PackBlockHash(hash string) ([]byte, error)
 	UnpackBlockHash(buf []byte) (string, error)
 	ParseBlock(b []byte) (*Block, error)
+	// IsMerkleTreeDoubleSHA256 returns true if the merkle root of the block is computed from txids using double-SHA256
+	IsMerkleTreeDoubleSHA256() bool
 	// xpub
 	DerivationBasePath(xpub string) (string, error)
 	DeriveAddressDescriptors(xpub string, change uint32, indexes []uint32) ([]AddressDescriptor, error)
@@ -6,9 +6,15 @@ import (
 	"blockbook/bchain/coins"
 	"blockbook/common"
 	"blockbook/db"
+	"blockbook/eventsink"
+	"blockbook/fiat"
 	"blockbook/server"
+	"blockbook/webhook"
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
@@ -16,6 +22,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"syscall"
@@ -34,6 +41,9 @@ const debounceResyncMempoolMs = 1009
 // store internal state about once every minute
 const storeInternalStatePeriodMs = 59699
 
+// the replica catches up with the primary about once every second
+const replicaCatchUpPeriodMs = 997
+
 // exit codes from the main function
 const exitCodeOK = 0
 const exitCodeFatal = 255
@@ -51,11 +61,18 @@ var (
 
 	synchronize = flag.Bool("sync", false, "synchronizes until tip, if together with zeromq, keeps index synchronized")
 	repair      = flag.Bool("repair", false, "repair the database")
+	backupDir   = flag.String("backup", "", "create a consistent checkpoint of the database in the given directory, which must not exist, and exit")
+	restoreDir  = flag.String("restore", "", "restore the database from the checkpoint in the given directory to the empty datadir before start")
+	verifyDB    = newHeightRangeFlag("verifydb", "verify the consistency of the database and exit, with the value from,to verify the blocks in the range against the backend and the addresses of these blocks, print the report of mismatches to stdout")
+	fixDB       = flag.Bool("fixdb", false, "together with -verifydb, rebuild the balances of the addresses with mismatches")
+	reindex     = flag.String("reindex", "", "reindex the blocks in the height range from,to using the blocks from the backend and exit, the rest of the index is kept (default no reindex)")
+	replica     = flag.Bool("replica", false, "serve the api from the database in datadir synchronized by another Blockbook process, as its read-only replica")
 	prof        = flag.String("prof", "", "http server binding [address]:port of the interface to profiling data /debug/pprof/ (default no profiling)")
 
 	syncChunk   = flag.Int("chunk", 100, "block chunk size for processing in bulk mode")
 	syncWorkers = flag.Int("workers", 8, "number of workers to process blocks in bulk mode")
 	dryRun      = flag.Bool("dryrun", false, "do not index blocks, only download")
+	blocksDir   = flag.String("blocksdir", "", "path to the blocks directory of the bitcoind type backend, the blk*.dat files are used as the source of blocks in bulk mode (default blocks fetched over RPC)")
 
 	debugMode = flag.Bool("debug", false, "debug mode, return more verbose errors, reload templates on each request")
 
@@ -63,6 +80,16 @@ var (
 
 	publicBinding = flag.String("public", "", "public http server binding [address]:port[/path] (default no public server)")
 
+	esploraAPI = flag.Bool("esplora", false, "enable Esplora compatible REST API at [path]esplora/api/ of the public server")
+
+	eventSink = flag.String("eventsink", "", "publish connected and disconnected blocks and mempool transactions to zmq:<endpoint> (ZeroMQ PUB socket) or file:<path> (NDJSON file) (default no publishing)")
+
+	blockFilterIndex = flag.Bool("blockfilterindex", false, "compute BIP158 block filters served by the public server, can be enabled only when the db is created")
+
+	pruneHistory = flag.Int("prunehistory", 0, "keep the address history only for the given number of last blocks, balances and utxos are complete, can be enabled only when the db is created (default full history)")
+
+	electrumBinding = flag.String("electrum", "", "electrum protocol server binding [address]:port, uses SSL if certfile is set (default no electrum server)")
+
 	certFiles = flag.String("certfile", "", "to enable SSL specify path to certificate files without extension, expecting <certfile>.crt and <certfile>.key (default no SSL)")
 
 	explorerURL = flag.String("explorer", "", "address of blockchain explorer")
@@ -71,6 +98,7 @@ var (
 
 	computeColumnStats  = flag.Bool("computedbstats", false, "compute column stats and exit")
 	computeFeeStatsFlag = flag.Bool("computefeestats", false, "compute fee stats for blocks in blockheight-blockuntil range and exit")
+	buildSpendingIndex  = flag.Bool("buildspendingindex", false, "build spending index for blocks indexed before the index existed and exit")
 	dbStatsPeriodHours  = flag.Int("dbstatsperiod", 24, "period of db stats collection in hours, 0 disables stats collection")
 
 	// resync index at least each resyncIndexPeriodMs (could be more often if invoked by message from ZeroMQ)
@@ -87,6 +115,8 @@ var (
 	chanSyncIndexDone          = make(chan struct{})
 	chanSyncMempoolDone        = make(chan struct{})
 	chanStoreInternalStateDone = make(chan struct{})
+	chanBuildScripthashIndex   = make(chan os.Signal)
+	chanBuildScripthashDone    = make(chan struct{})
 	chain                      bchain.BlockChain
 	mempool                    bchain.Mempool
 	index                      *db.RocksDB
@@ -96,6 +126,7 @@ var (
 	internalState              *common.InternalState
 	callbacksOnNewBlock        []bchain.OnNewBlockFunc
 	callbacksOnNewTxAddr       []bchain.OnNewTxAddrFunc
+	callbacksOnMempoolResync   []func()
 	chanOsSignal               chan os.Signal
 	inShutdown                 int32
 )
@@ -148,6 +179,24 @@ func mainWithExitCode() int {
 		return exitCodeFatal
 	}
 
+	if *fixDB && !verifyDB.set {
+		glog.Error("fixdb: the parameter can be used only together with -verifydb")
+		return exitCodeFatal
+	}
+
+	if *reindex != "" {
+		if _, _, err := parseHeightRange(*reindex); err != nil {
+			glog.Error("reindex: ", err)
+			return exitCodeFatal
+		}
+	}
+
+	if *replica && (*synchronize || *restoreDir != "" || *backupDir != "" || *rollbackHeight >= 0 || *blockFrom >= 0 ||
+		*computeColumnStats || *computeFeeStatsFlag || *buildSpendingIndex || *blockFilterIndex || *pruneHistory > 0 || verifyDB.set || *reindex != "" || *blocksDir != "" || *eventSink != "") {
+		glog.Error("replica: the replica is read-only, it cannot be combined with the parameters which write to the database")
+		return exitCodeFatal
+	}
+
 	coin, coinShortcut, coinLabel, err := coins.GetCoinNameFromConfig(*blockchain)
 	if err != nil {
 		glog.Error("config: ", err)
@@ -167,7 +216,18 @@ func mainWithExitCode() int {
 		return exitCodeFatal
 	}
 
-	index, err = db.NewRocksDB(*dbPath, *dbCache, *dbMaxOpenFiles, chain.GetChainParser(), metrics)
+	if *restoreDir != "" {
+		if err = db.RestoreCheckpoint(*restoreDir, *dbPath, *dbCache, *dbMaxOpenFiles, chain.GetChainParser(), coin); err != nil {
+			glog.Error("restore: ", err)
+			return exitCodeFatal
+		}
+	}
+
+	if *replica {
+		index, err = db.NewRocksDBReplica(*dbPath, *dbCache, chain.GetChainParser(), metrics)
+	} else {
+		index, err = db.NewRocksDB(*dbPath, *dbCache, *dbMaxOpenFiles, chain.GetChainParser(), metrics)
+	}
 	if err != nil {
 		glog.Error("rocksDB: ", err)
 		return exitCodeFatal
@@ -180,12 +240,52 @@ func mainWithExitCode() int {
 		return exitCodeFatal
 	}
 	index.SetInternalState(internalState)
+	migrationInProgress, err := index.MigrationInProgress()
+	if err != nil {
+		glog.Error("migration: ", err)
+		return exitCodeFatal
+	}
 	if internalState.DbState != common.DbStateClosed {
-		if internalState.DbState == common.DbStateInconsistent {
+		// the state is inconsistent also during the migration of the db, the migration is resumed below
+		if internalState.DbState == common.DbStateInconsistent && (!migrationInProgress || index.IsReplica()) {
 			glog.Error("internalState: database is in inconsistent state and cannot be used")
 			return exitCodeFatal
 		}
-		glog.Warning("internalState: database was left in open state, possibly previous ungraceful shutdown")
+		// the state of the db of a running primary is open
+		if internalState.DbState == common.DbStateOpen && !index.IsReplica() {
+			glog.Warning("internalState: database was left in open state, possibly previous ungraceful shutdown")
+		}
+	}
+
+	if err = index.Migrate(chanOsSignal); err != nil {
+		if err == db.ErrOperationInterrupted {
+			glog.Info("migration: interrupted, it will be resumed by the next start")
+		} else {
+			glog.Error("migration: ", err)
+		}
+		return exitCodeFatal
+	}
+
+	if *backupDir != "" {
+		if _, err = index.CreateCheckpoint(*backupDir); err != nil {
+			glog.Error("backup: ", err)
+			return exitCodeFatal
+		}
+		return exitCodeOK
+	}
+
+	if *blockFilterIndex {
+		if err = index.EnableBlockFilterIndex(); err != nil {
+			glog.Error("blockFilterIndex: ", err)
+			return exitCodeFatal
+		}
+	}
+
+	if *pruneHistory > 0 {
+		if err = index.EnablePrunedIndex(*pruneHistory); err != nil {
+			glog.Error("pruneHistory: ", err)
+			return exitCodeFatal
+		}
 	}
 
 	if *computeFeeStatsFlag {
@@ -209,18 +309,64 @@ func mainWithExitCode() int {
 		return exitCodeOK
 	}
 
-	syncWorker, err = db.NewSyncWorker(index, chain, *syncWorkers, *syncChunk, *blockFrom, *dryRun, chanOsSignal, metrics, internalState)
-	if err != nil {
-		glog.Errorf("NewSyncWorker %v", err)
-		return exitCodeFatal
+	if verifyDB.set {
+		return verifyDatabase()
 	}
 
-	// set the DbState to open at this moment, after all important workers are initialized
-	internalState.DbState = common.DbStateOpen
-	err = index.StoreInternalState(internalState)
-	if err != nil {
-		glog.Error("internalState: ", err)
-		return exitCodeFatal
+	if *reindex != "" {
+		return reindexBlocks()
+	}
+
+	// the replica does not synchronize the index, it is synchronized by the primary
+	if !index.IsReplica() {
+		syncWorker, err = db.NewSyncWorker(index, chain, *syncWorkers, *syncChunk, *blockFrom, *dryRun, chanOsSignal, metrics, internalState)
+		if err != nil {
+			glog.Errorf("NewSyncWorker %v", err)
+			return exitCodeFatal
+		}
+	}
+
+	if *blocksDir != "" {
+		if chain.GetChainParser().GetChainType() != bchain.ChainBitcoinType {
+			glog.Error("blocksdir: supported only for bitcoin type coins")
+			return exitCodeFatal
+		}
+		bf, err := bchain.NewBlockFiles(*blocksDir)
+		if err != nil {
+			glog.Error("blocksdir: ", err)
+			return exitCodeFatal
+		}
+		syncWorker.SetBlockFiles(bf)
+	}
+
+	if *eventSink != "" {
+		sink, err := eventsink.New(*eventSink)
+		if err != nil {
+			glog.Error("eventSink: ", err)
+			return exitCodeFatal
+		}
+		defer func() {
+			if err := sink.Close(); err != nil {
+				glog.Error("eventSink: ", err)
+			}
+		}()
+		syncWorker.SetEventSink(sink)
+		callbacksOnNewTxAddr = append(callbacksOnNewTxAddr, syncWorker.OnNewTxAddr)
+	}
+
+	if index.IsReplica() {
+		if _, _, err = index.CatchUpWithPrimary(); err != nil {
+			glog.Error("replica: ", err)
+			return exitCodeFatal
+		}
+	} else {
+		// set the DbState to open at this moment, after all important workers are initialized
+		internalState.DbState = common.DbStateOpen
+		err = index.StoreInternalState(internalState)
+		if err != nil {
+			glog.Error("internalState: ", err)
+			return exitCodeFatal
+		}
 	}
 
 	if *rollbackHeight >= 0 {
@@ -231,6 +377,14 @@ func mainWithExitCode() int {
 		return exitCodeOK
 	}
 
+	if *buildSpendingIndex {
+		if err = syncWorker.BuildSpendingIndex(); err != nil {
+			glog.Error("buildSpendingIndex ", err)
+			return exitCodeFatal
+		}
+		return exitCodeOK
+	}
+
 	if txCache, err = db.NewTxCache(index, chain, metrics, internalState, !*noTxCache); err != nil {
 		glog.Error("txCache ", err)
 		return exitCodeFatal
@@ -259,6 +413,15 @@ func mainWithExitCode() int {
 		}
 	}
 
+	var electrumServer *server.ElectrumServer
+	if *electrumBinding != "" {
+		electrumServer, err = startElectrumServer()
+		if err != nil {
+			glog.Error("electrum server: ", err)
+			return exitCodeFatal
+		}
+	}
+
 	if *synchronize {
 		internalState.SyncMode = true
 		internalState.InitialSync = true
@@ -270,32 +433,65 @@ func mainWithExitCode() int {
 			return exitCodeOK
 		}
 		// initialize mempool after the initial sync is complete
-		var addrDescForOutpoint bchain.AddrDescForOutpointFunc
-		if chain.GetChainParser().GetChainType() == bchain.ChainBitcoinType {
-			addrDescForOutpoint = index.AddrDescForOutpoint
-		}
-		err = chain.InitializeMempool(addrDescForOutpoint, onNewTxAddr)
-		if err != nil {
-			glog.Error("initializeMempool ", err)
-			return exitCodeFatal
-		}
-		var mempoolCount int
-		if mempoolCount, err = mempool.Resync(); err != nil {
-			glog.Error("resyncMempool ", err)
+		if err = initializeMempool(); err != nil {
 			return exitCodeFatal
 		}
-		internalState.FinishedMempoolSync(mempoolCount)
 		go syncIndexLoop()
 		go syncMempoolLoop()
 		internalState.InitialSync = false
+		go storeInternalStateLoop()
+	} else if index.IsReplica() {
+		// the replica has its own view of the mempool from the backend, the index is updated from the primary
+		if err = initializeMempool(); err != nil {
+			return exitCodeFatal
+		}
+		go replicaLoop()
+		go syncMempoolLoop()
+	} else {
+		go storeInternalStateLoop()
+	}
+
+	buildingScripthashIndex := false
+	if *synchronize && chain.GetChainParser().GetChainType() == bchain.ChainBitcoinType && !internalState.IsScripthashIndexComplete() {
+		// the database was created before the scripthash index existed, build it in the background
+		buildingScripthashIndex = true
+		go buildScripthashIndex()
+	}
+
+	var webhookManager *webhook.Manager
+	if *synchronize && chain.GetChainParser().GetChainType() == bchain.ChainBitcoinType {
+		webhookManager, err = webhook.NewManager(index, chain, mempool, txCache, metrics, internalState)
+		if err != nil {
+			glog.Error("webhook: ", err)
+			return exitCodeFatal
+		}
+		callbacksOnNewBlock = append(callbacksOnNewBlock, webhookManager.OnNewBlock)
+		callbacksOnNewTxAddr = append(callbacksOnNewTxAddr, webhookManager.OnNewTxAddr)
+		if internalServer != nil {
+			internalServer.ConnectWebhooks(webhookManager)
+		}
+		go webhookManager.Run()
+	}
+
+	if electrumServer != nil {
+		callbacksOnNewBlock = append(callbacksOnNewBlock, electrumServer.OnNewBlock)
+		callbacksOnNewTxAddr = append(callbacksOnNewTxAddr, electrumServer.OnNewTxAddr)
 	}
-	go storeInternalStateLoop()
 
 	if publicServer != nil {
 		// start full public interface
 		callbacksOnNewBlock = append(callbacksOnNewBlock, publicServer.OnNewBlock)
 		callbacksOnNewTxAddr = append(callbacksOnNewTxAddr, publicServer.OnNewTxAddr)
+		callbacksOnMempoolResync = append(callbacksOnMempoolResync, publicServer.OnMempoolResync)
 		publicServer.ConnectFullPublicInterface()
+		if *esploraAPI {
+			publicServer.ConnectEsploraInterface()
+		}
+	}
+
+	if *synchronize {
+		// start fiat rates downloader only if not shutting down immediately
+		initDownloaders(index, *blockchain, publicServer)
 	}
 
 	if *blockFrom >= 0 {
@@ -317,7 +513,16 @@ func mainWithExitCode() int {
 	}
 
 	if internalServer != nil || publicServer != nil || chain != nil {
-		waitForSignalAndShutdown(internalServer, publicServer, chain, 10*time.Second)
+		waitForSignalAndShutdown(internalServer, publicServer, electrumServer, chain, 10*time.Second)
+	}
+
+	if webhookManager != nil {
+		webhookManager.Stop()
+	}
+
+	if buildingScripthashIndex {
+		close(chanBuildScripthashIndex)
+		<-chanBuildScripthashDone
 	}
 
 	if *synchronize {
@@ -327,6 +532,11 @@ func mainWithExitCode() int {
 		<-chanSyncIndexDone
 		<-chanSyncMempoolDone
 		<-chanStoreInternalStateDone
+	} else if index.IsReplica() {
+		close(chanSyncIndex)
+		close(chanSyncMempool)
+		<-chanSyncIndexDone
+		<-chanSyncMempoolDone
 	}
 	return exitCodeOK
 }
@@ -394,6 +604,33 @@ func startPublicServer() (*server.PublicServer, error) {
 	return publicServer, err
 }
 
+func startElectrumServer() (*server.ElectrumServer, error) {
+	electrumServer, err := server.NewElectrumServer(*electrumBinding, *certFiles, index, chain, mempool, txCache, metrics, internalState)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err = electrumServer.Run()
+		if err != nil {
+			glog.Error("electrum server: ", err)
+		}
+	}()
+	return electrumServer, nil
+}
+
+func buildScripthashIndex() {
+	defer close(chanBuildScripthashDone)
+	start := time.Now()
+	glog.Info("buildScripthashIndex start")
+	if err := index.BuildScripthashIndex(chanBuildScripthashIndex); err != nil {
+		if err != db.ErrOperationInterrupted {
+			glog.Error("buildScripthashIndex ", err)
+		}
+		return
+	}
+	glog.Info("buildScripthashIndex finished in ", time.Since(start))
+}
+
 func performRollback() error {
 	bestHeight, bestHash, err := index.GetBestBlock()
 	if err != nil {
@@ -515,6 +752,57 @@ func syncIndexLoop() {
 	glog.Info("syncIndexLoop stopped")
 }
 
+func initializeMempool() error {
+	var addrDescForOutpoint bchain.AddrDescForOutpointFunc
+	if chain.GetChainParser().GetChainType() == bchain.ChainBitcoinType {
+		addrDescForOutpoint = index.AddrDescForOutpoint
+	}
+	err := chain.InitializeMempool(addrDescForOutpoint, onNewTxAddr)
+	if err != nil {
+		glog.Error("initializeMempool ", err)
+		return err
+	}
+	mempoolCount, err := mempool.Resync()
+	if err != nil {
+		glog.Error("resyncMempool ", err)
+		return err
+	}
+	internalState.FinishedMempoolSync(mempoolCount)
+	return nil
+}
+
+func replicaLoop() {
+	defer close(chanSyncIndexDone)
+	glog.Info("replicaLoop starting")
+	_, lastHeight, _ := internalState.GetSyncState()
+	lastHash, err := index.GetBlockHash(lastHeight)
+	if err != nil {
+		glog.Error("replicaLoop ", err)
+	}
+	// catch up with the primary about every second or after a new block notification
+	tickAndDebounce(replicaCatchUpPeriodMs*time.Millisecond, debounceResyncIndexMs*time.Millisecond, chanSyncIndex, func() {
+		height, hash, err := index.CatchUpWithPrimary()
+		if err != nil {
+			glog.Error("replicaLoop ", errors.ErrorStack(err))
+			return
+		}
+		if hash == lastHash {
+			return
+		}
+		// notify the blocks connected by the primary since the last catch up
+		if lastHash != "" {
+			for h := lastHeight + 1; h < height; h++ {
+				if bh, err := index.GetBlockHash(h); err == nil && bh != "" {
+					onNewBlockHash(bh, h)
+				}
+			}
+		}
+		onNewBlockHash(hash, height)
+		lastHeight, lastHash = height, hash
+	})
+	glog.Info("replicaLoop stopped")
+}
+
 func onNewBlockHash(hash string, height uint32) {
 	for _, c := range callbacksOnNewBlock {
 		c(hash, height)
@@ -529,9 +817,19 @@ func syncMempoolLoop() {
 		internalState.StartedMempoolSync()
 		if count, err := mempool.Resync(); err != nil {
 			glog.Error("syncMempoolLoop ", errors.ErrorStack(err))
+			if bchain.IsConnectionError(err) && internalState.SetBackendAvailable(false) {
+				glog.Warning("syncMempoolLoop: backend is unavailable, serving only the data stored in db")
+			}
 		} else {
+			if internalState.SetBackendAvailable(true) {
+				glog.Info("syncMempoolLoop: backend is available again")
+				// blocks may have been missed while the backend was down
+				pushSynchronizationHandler(bchain.NotificationNewBlock)
+			}
 			internalState.FinishedMempoolSync(count)
-
+			for _, c := range callbacksOnMempoolResync {
+				c()
+			}
 		}
 	})
 	glog.Info("syncMempoolLoop stopped")
@@ -580,6 +878,43 @@ func storeInternalStateLoop() {
 	glog.Info("storeInternalStateLoop stopped")
 }
 
+// initDownloaders starts the fiat rates downloader if it is configured in the blockchain configuration file
+func initDownloaders(d *db.RocksDB, configfile string, publicServer *server.PublicServer) {
+	data, err := ioutil.ReadFile(configfile)
+	if err != nil {
+		glog.Errorf("Error reading file %v, %v", configfile, err)
+		return
+	}
+
+	var config struct {
+		FiatRates       string `json:"fiat_rates"`
+		FiatRatesParams string `json:"fiat_rates_params"`
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		glog.Errorf("Error parsing config file %v, %v", configfile, err)
+		return
+	}
+
+	if config.FiatRates == "" || config.FiatRatesParams == "" {
+		glog.Infof("FiatRates config (%v) is empty, so the functionality is disabled.", configfile)
+		return
+	}
+	onNewTicker := func(ticker *db.CurrencyRatesTicker) {
+		if publicServer != nil {
+			publicServer.OnNewFiatRatesTicker(ticker)
+		}
+	}
+	fiatRates, err := fiat.NewFiatRatesDownloader(d, config.FiatRates, config.FiatRatesParams, nil, onNewTicker)
+	if err != nil {
+		glog.Errorf("NewFiatRatesDownloader Init error: %v", err)
+		return
+	}
+	glog.Infof("Starting %v FiatRates downloader...", config.FiatRates)
+	go fiatRates.Run()
+}
+
 func onNewTxAddr(tx *bchain.Tx, desc bchain.AddressDescriptor) {
 	for _, c := range callbacksOnNewTxAddr {
 		c(tx, desc)
@@ -600,7 +935,7 @@ func pushSynchronizationHandler(nt bchain.NotificationType) {
 	}
 }
 
-func waitForSignalAndShutdown(internal *server.InternalServer, public *server.PublicServer, chain bchain.BlockChain, timeout time.Duration) {
+func waitForSignalAndShutdown(internal *server.InternalServer, public *server.PublicServer, electrum *server.ElectrumServer, chain bchain.BlockChain, timeout time.Duration) {
 	sig := <-chanOsSignal
 	atomic.StoreInt32(&inShutdown, 1)
 	glog.Infof("shutdown: %v", sig)
@@ -620,6 +955,12 @@ func waitForSignalAndShutdown(internal *server.InternalServer, public *server.Pu
 		}
 	}
 
+	if electrum != nil {
+		if err := electrum.Close(); err != nil {
+			glog.Error("electrum server: shutdown error: ", err)
+		}
+	}
+
 	if chain != nil {
 		if err := chain.Shutdown(ctx); err != nil {
 			glog.Error("rpc: shutdown error: ", err)
@@ -627,6 +968,116 @@ func waitForSignalAndShutdown(internal *server.InternalServer, public *server.Pu
 	}
 }
 
+// verifyDatabase verifies the db, prints the report to stdout and returns exitCodeFatal if some mismatches were not fixed
+func verifyDatabase() int {
+	var report *db.VerifyReport
+	var err error
+	if *fixDB {
+		internalState.DbState = common.DbStateOpen
+	}
+	if verifyDB.isRange {
+		report, err = index.VerifyBlocks(verifyDB.from, verifyDB.to, chain, *fixDB, chanOsSignal)
+	} else {
+		report, err = index.VerifyDB(*fixDB, chanOsSignal)
+	}
+	if err != nil {
+		glog.Error("verifyDB: ", err)
+		return exitCodeFatal
+	}
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		glog.Error("verifyDB: ", err)
+		return exitCodeFatal
+	}
+	fmt.Println(string(buf))
+	if report.Interrupted || report.Mismatches > report.Fixed {
+		return exitCodeFatal
+	}
+	return exitCodeOK
+}
+
+// reindexBlocks reindexes the blocks in the height range given by the parameter -reindex
+func reindexBlocks() int {
+	from, to, err := parseHeightRange(*reindex)
+	if err != nil {
+		glog.Error("reindex: ", err)
+		return exitCodeFatal
+	}
+	internalState.DbState = common.DbStateOpen
+	if err = index.ReindexBlocks(from, to, chain, chanOsSignal); err != nil {
+		if err != db.ErrOperationInterrupted {
+			glog.Error("reindex: ", err)
+		}
+		return exitCodeFatal
+	}
+	return exitCodeOK
+}
+
+// heightRangeFlag is a flag with an optional value from,to, the flag without value means all blocks
+type heightRangeFlag struct {
+	set      bool
+	isRange  bool
+	from, to uint32
+}
+
+func newHeightRangeFlag(name, usage string) *heightRangeFlag {
+	f := &heightRangeFlag{}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *heightRangeFlag) String() string {
+	if f == nil || !f.set {
+		return ""
+	}
+	if !f.isRange {
+		return "true"
+	}
+	return fmt.Sprintf("%d,%d", f.from, f.to)
+}
+
+func (f *heightRangeFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*f = heightRangeFlag{set: true}
+		return nil
+	case "false":
+		*f = heightRangeFlag{}
+		return nil
+	}
+	from, to, err := parseHeightRange(s)
+	if err != nil {
+		return err
+	}
+	*f = heightRangeFlag{set: true, isRange: true, from: from, to: to}
+	return nil
+}
+
+// IsBoolFlag allows the flag to be used without value
+func (f *heightRangeFlag) IsBoolFlag() bool {
+	return true
+}
+
+// parseHeightRange parses the block height range in the format from,to
+func parseHeightRange(s string) (uint32, uint32, error) {
+	p := strings.Split(s, ",")
+	if len(p) != 2 {
+		return 0, 0, errors.Errorf("invalid height range %v, expected from,to", s)
+	}
+	from, err := strconv.ParseUint(strings.TrimSpace(p[0]), 10, 32)
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "invalid height range %v", s)
+	}
+	to, err := strconv.ParseUint(strings.TrimSpace(p[1]), 10, 32)
+	if err != nil {
+		return 0, 0, errors.Annotatef(err, "invalid height range %v", s)
+	}
+	if from > to {
+		return 0, 0, errors.Errorf("invalid height range %v, from is greater than to", s)
+	}
+	return uint32(from), uint32(to), nil
+}
+
 func printResult(txid string, vout int32, isOutput bool) error {
 	glog.Info(txid, vout, isOutput)
 	return nil
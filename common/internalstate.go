@@ -51,6 +51,22 @@ type InternalState struct {
 	LastMempoolSync       time.Time `json:"lastMempoolSync"`
 
 	DbColumns []InternalStateColumn `json:"dbColumns"`
+
+	// spending index is complete only from this height, lower spends must be searched using the address index
+	SpendingIndexFromHeight uint32 `json:"spendingIndexFromHeight,omitempty"`
+
+	// scripthash index was added to an existing db and does not yet contain the addresses indexed before
+	ScripthashIndexIncomplete bool `json:"scripthashIndexIncomplete,omitempty"`
+
+	// block filter index was enabled when the db was created, the filters are computed for all blocks
+	BlockFilterIndex bool `json:"blockFilterIndex,omitempty"`
+
+	// address history is kept only for this number of last blocks, the balances and utxos are complete
+	PrunedHistoryBlocks uint32 `json:"prunedHistoryBlocks,omitempty"`
+
+	// backend is not reachable, only the data stored in db can be served, the state is not stored
+	BackendUnavailable      bool      `json:"-"`
+	BackendUnavailableSince time.Time `json:"-"`
 }
 
 // StartedSync signals start of synchronization
@@ -114,6 +130,112 @@ func (is *InternalState) GetMempoolSyncState() (bool, time.Time, int) {
 	return is.IsMempoolSynchronized, is.LastMempoolSync, is.MempoolSize
 }
 
+// GetSpendingIndexFromHeight returns the height from which the spending index is complete
+func (is *InternalState) GetSpendingIndexFromHeight() uint32 {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	return is.SpendingIndexFromHeight
+}
+
+// SetSpendingIndexFromHeight sets the height from which the spending index is complete
+func (is *InternalState) SetSpendingIndexFromHeight(height uint32) {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	is.SpendingIndexFromHeight = height
+}
+
+// IsScripthashIndexComplete returns true if the scripthash index contains all indexed addresses
+func (is *InternalState) IsScripthashIndexComplete() bool {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	return !is.ScripthashIndexIncomplete
+}
+
+// SetScripthashIndexComplete marks the scripthash index as complete
+func (is *InternalState) SetScripthashIndexComplete() {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	is.ScripthashIndexIncomplete = false
+}
+
+// IsBlockFilterIndex returns true if the block filters are computed
+func (is *InternalState) IsBlockFilterIndex() bool {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	return is.BlockFilterIndex
+}
+
+// SetBlockFilterIndex switches on the computation of the block filters
+func (is *InternalState) SetBlockFilterIndex() {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	is.BlockFilterIndex = true
+}
+
+// GetPrunedHistoryBlocks returns the number of last blocks with address history, 0 if the history is not pruned
+func (is *InternalState) GetPrunedHistoryBlocks() uint32 {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	return is.PrunedHistoryBlocks
+}
+
+// SetPrunedHistoryBlocks sets the number of last blocks with address history
+func (is *InternalState) SetPrunedHistoryBlocks(blocks uint32) {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	is.PrunedHistoryBlocks = blocks
+}
+
+// SetPrimaryState updates the internal state of a read-only replica by the state stored by the primary instance,
+// bestHeight is the best height of the replica, the replica is synchronized if it caught up with the primary
+func (is *InternalState) SetPrimaryState(primary *InternalState, bestHeight uint32) {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	is.DbState = primary.DbState
+	is.IsSynchronized = primary.DbState != DbStateInconsistent
+	if is.BestHeight != bestHeight {
+		is.BestHeight = bestHeight
+		is.LastSync = time.Now()
+	}
+	if len(primary.DbColumns) == len(is.DbColumns) {
+		is.DbColumns = primary.DbColumns
+	}
+	is.SpendingIndexFromHeight = primary.SpendingIndexFromHeight
+	is.ScripthashIndexIncomplete = primary.ScripthashIndexIncomplete
+	is.BlockFilterIndex = primary.BlockFilterIndex
+	is.PrunedHistoryBlocks = primary.PrunedHistoryBlocks
+}
+
+// SetBackendAvailable records if the backend is reachable, returns true if the state changed
+func (is *InternalState) SetBackendAvailable(available bool) bool {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	if is.BackendUnavailable != available {
+		return false
+	}
+	is.BackendUnavailable = !available
+	if available {
+		is.BackendUnavailableSince = time.Time{}
+	} else {
+		is.BackendUnavailableSince = time.Now()
+	}
+	return true
+}
+
+// GetBackendState returns true if the backend is unavailable and the time since when it is unavailable
+func (is *InternalState) GetBackendState() (bool, time.Time) {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	return is.BackendUnavailable, is.BackendUnavailableSince
+}
+
+// IsBackendUnavailable returns true if the backend is not reachable
+func (is *InternalState) IsBackendUnavailable() bool {
+	is.mux.Lock()
+	defer is.mux.Unlock()
+	return is.BackendUnavailable
+}
+
 // AddDBColumnStats adds differences in column statistics to column stats
 func (is *InternalState) AddDBColumnStats(c int, rowsDiff int64, keyBytesDiff int64, valueBytesDiff int64) {
 	is.mux.Lock()
@@ -16,6 +16,9 @@ type Metrics struct {
 	WebsocketSubscribes   *prometheus.CounterVec
 	WebsocketClients      prometheus.Gauge
 	WebsocketReqDuration  *prometheus.HistogramVec
+	ElectrumRequests      *prometheus.CounterVec
+	ElectrumClients       prometheus.Gauge
+	WebhookDeliveries     *prometheus.CounterVec
 	IndexResyncDuration   prometheus.Histogram
 	MempoolResyncDuration prometheus.Histogram
 	TxCacheEfficiency     *prometheus.CounterVec
@@ -100,6 +103,29 @@ func GetMetrics(coin string) (*Metrics, error) {
 		},
 		[]string{"method"},
 	)
+	metrics.ElectrumRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "blockbook_electrum_requests",
+			Help:        "Total number of electrum protocol requests by method and status",
+			ConstLabels: Labels{"coin": coin},
+		},
+		[]string{"method", "status"},
+	)
+	metrics.ElectrumClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        "blockbook_electrum_clients",
+			Help:        "Number of currently connected electrum protocol clients",
+			ConstLabels: Labels{"coin": coin},
+		},
+	)
+	metrics.WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "blockbook_webhook_deliveries",
+			Help:        "Total number of webhook delivery attempts by event and status",
+			ConstLabels: Labels{"coin": coin},
+		},
+		[]string{"event", "status"},
+	)
 	metrics.IndexResyncDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:        "blockbook_index_resync_duration",
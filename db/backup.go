@@ -0,0 +1,220 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/common"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// Checkpoint describes a created checkpoint of the database
+type Checkpoint struct {
+	Dir    string `json:"dir"`
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// only one checkpoint can be created at a time
+var checkpointMux sync.Mutex
+
+// CreateCheckpoint creates a consistent snapshot of the database in the directory dir, which must not exist
+// the checkpoint can be created while the db is being synchronized, it uses hard links to the db files if dir is on the same filesystem
+// the internal state in the checkpoint is marked as closed so that the checkpoint can be used to seed a new instance
+func (d *RocksDB) CreateCheckpoint(dir string) (*Checkpoint, error) {
+	checkpointMux.Lock()
+	defer checkpointMux.Unlock()
+	if d.replica != nil {
+		return nil, errors.New("The checkpoint must be created by the primary instance")
+	}
+	if d.is != nil && d.is.DbState == common.DbStateInconsistent {
+		return nil, errors.New("The database is in inconsistent state, the checkpoint cannot be created")
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return nil, errors.Errorf("Directory %v already exists", dir)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	start := time.Now()
+	glog.Info("rocksdb: creating checkpoint in ", dir)
+	cp, err := d.db.NewCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	// flush the memtables so that the checkpoint does not depend on the write ahead log
+	err = cp.CreateCheckpoint(dir, 0)
+	cp.Destroy()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.finalizeCheckpoint(dir)
+	if err != nil {
+		if e := os.RemoveAll(dir); e != nil {
+			glog.Error("rocksdb: cannot remove checkpoint ", dir, ": ", e)
+		}
+		return nil, err
+	}
+	glog.Info("rocksdb: checkpoint in ", dir, " created at height ", c.Height, " in ", time.Since(start))
+	return c, nil
+}
+
+// finalizeCheckpoint checks the internal state stored in the checkpoint and marks it as closed
+func (d *RocksDB) finalizeCheckpoint(dir string) (*Checkpoint, error) {
+	db, cfh, err := openDB(dir, d.cache, d.maxOpenFiles)
+	if err != nil {
+		return nil, err
+	}
+	cd := &RocksDB{
+		path:         dir,
+		db:           db,
+		wo:           d.wo,
+		ro:           d.ro,
+		cfh:          cfh,
+		chainParser:  d.chainParser,
+		cache:        d.cache,
+		maxOpenFiles: d.maxOpenFiles,
+	}
+	defer cd.closeDB()
+	// the internal state is stored periodically, the state in the checkpoint is the last stored one
+	// the state is inconsistent if the checkpoint was created during the initial bulk import
+	is, err := cd.loadStoredInternalState()
+	if err != nil {
+		return nil, err
+	}
+	if is.DbState == common.DbStateInconsistent {
+		return nil, errors.New("The checkpoint is in inconsistent state, the database is being imported in bulk mode")
+	}
+	height, hash, err := cd.GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+	is.DbState = common.DbStateClosed
+	is.BestHeight = height
+	is.IsSynchronized = false
+	is.IsMempoolSynchronized = false
+	if err = cd.storeState(is); err != nil {
+		return nil, err
+	}
+	return &Checkpoint{Dir: dir, Height: height, Hash: hash}, nil
+}
+
+// loadStoredInternalState returns the internal state stored in db without any checks, error if there is no stored state
+func (d *RocksDB) loadStoredInternalState() (*common.InternalState, error) {
+	val, err := d.db.GetCF(d.ro, d.cfh[cfDefault], []byte(internalStateKey))
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	data := val.Data()
+	if len(data) == 0 {
+		return nil, errors.New("Missing internal state, the database is not a blockbook database")
+	}
+	return common.UnpackInternalState(data)
+}
+
+// RestoreCheckpoint restores the checkpoint created by CreateCheckpoint to the empty or not existing directory path
+// the restored database is opened and its version and coin are validated, if the validation fails, the restored files are removed
+func RestoreCheckpoint(checkpointDir, path string, cacheSize, maxOpenFiles int, parser bchain.BlockChainParser, coin string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(files) > 0 {
+		return errors.Errorf("Directory %v is not empty, the checkpoint can be restored only to an empty directory", path)
+	}
+	glog.Info("rocksdb: restoring checkpoint ", checkpointDir, " to ", path)
+	if err = copyCheckpoint(checkpointDir, path); err == nil {
+		err = validateRestoredDB(path, cacheSize, maxOpenFiles, parser, coin)
+	}
+	if err != nil {
+		if files, e := ioutil.ReadDir(path); e == nil {
+			for _, f := range files {
+				os.RemoveAll(filepath.Join(path, f.Name()))
+			}
+		}
+		return err
+	}
+	glog.Info("rocksdb: checkpoint ", checkpointDir, " restored to ", path)
+	return nil
+}
+
+func validateRestoredDB(path string, cacheSize, maxOpenFiles int, parser bchain.BlockChainParser, coin string) error {
+	d, err := NewRocksDB(path, cacheSize, maxOpenFiles, parser, nil)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	stored, err := d.loadStoredInternalState()
+	if err != nil {
+		return err
+	}
+	if stored.DbState != common.DbStateClosed {
+		return errors.New("The checkpoint is not in closed state")
+	}
+	// LoadInternalState checks the coin and the version of the columns
+	if _, err = d.LoadInternalState(coin); err != nil {
+		return err
+	}
+	_, hash, err := d.GetBestBlock()
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		return errors.New("The checkpoint does not contain any block")
+	}
+	return nil
+}
+
+// copyCheckpoint copies the files of the checkpoint
+// the sst files are never modified by rocksdb, they are hard linked if possible, the other files must be copied
+func copyCheckpoint(checkpointDir, path string) error {
+	files, err := ioutil.ReadDir(checkpointDir)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		from := filepath.Join(checkpointDir, f.Name())
+		to := filepath.Join(path, f.Name())
+		if strings.HasSuffix(f.Name(), ".sst") && os.Link(from, to) == nil {
+			continue
+		}
+		if err = copyFile(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
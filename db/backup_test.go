@@ -0,0 +1,84 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/common"
+	"blockbook/tests/dbtestdata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRocksDB_CreateRestoreCheckpoint(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	tmp, err := ioutil.TempDir("", "testcheckpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	d.is.DbState = common.DbStateOpen
+	if err := d.StoreInternalState(d.is); err != nil {
+		t.Fatal(err)
+	}
+
+	cpDir := filepath.Join(tmp, "checkpoint")
+	c, err := d.CreateCheckpoint(cpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Height != 225494 || c.Hash != "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6" {
+		t.Errorf("CreateCheckpoint() = %+v", c)
+	}
+	if _, err := d.CreateCheckpoint(cpDir); err == nil {
+		t.Error("CreateCheckpoint() to existing directory: expected error")
+	}
+	d.is.DbState = common.DbStateInconsistent
+	if _, err := d.CreateCheckpoint(filepath.Join(tmp, "inconsistent")); err == nil {
+		t.Error("CreateCheckpoint() of inconsistent db: expected error")
+	}
+	d.is.DbState = common.DbStateOpen
+
+	// the checkpoint for another coin must be refused and the restored files removed
+	otherDir := filepath.Join(tmp, "other")
+	if err := RestoreCheckpoint(cpDir, otherDir, 100000, -1, d.chainParser, "other-coin"); err == nil {
+		t.Error("RestoreCheckpoint() of another coin: expected error")
+	}
+	if files, _ := ioutil.ReadDir(otherDir); len(files) != 0 {
+		t.Errorf("RestoreCheckpoint() of another coin left %d files", len(files))
+	}
+
+	restoredDir := filepath.Join(tmp, "restored")
+	if err := RestoreCheckpoint(cpDir, restoredDir, 100000, -1, d.chainParser, "coin-unittest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreCheckpoint(cpDir, restoredDir, 100000, -1, d.chainParser, "coin-unittest"); err == nil {
+		t.Error("RestoreCheckpoint() to not empty directory: expected error")
+	}
+	r, err := NewRocksDB(restoredDir, 100000, -1, d.chainParser, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	is, err := r.LoadInternalState("coin-unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is.DbState != common.DbStateClosed || is.BestHeight != 225494 {
+		t.Errorf("restored internal state: DbState %v, BestHeight %v", is.DbState, is.BestHeight)
+	}
+	r.SetInternalState(is)
+	verifyAfterBitcoinTypeBlock2(t, r)
+}
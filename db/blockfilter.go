@@ -0,0 +1,321 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/martinboehm/btcd/chaincfg/chainhash"
+	"github.com/tecbot/gorocksdb"
+)
+
+// Block filters
+// the column blockFilters contains basic BIP158 compact block filters and BIP157 filter headers of the blocks
+// the filters are computed only if the index was enabled when the db was created, the chain of filter headers
+// must start at the first indexed block
+// the filter of a block spending an output unknown to the index cannot be computed, such a block and all following
+// blocks are stored with an unavailable filter (a single zero byte) so that no filter diverging from BIP158 is served
+
+const (
+	// parameters of the basic filter type defined by BIP158
+	blockFilterP = 19
+	blockFilterM = 784931
+
+	blockFilterHeaderLen = 32
+)
+
+// BlockFilter is the basic BIP158 filter of a block together with its BIP157 filter header
+// the header is in the internal byte order, the filter and header are nil if the filter is not available
+type BlockFilter struct {
+	Filter []byte
+	Header []byte
+}
+
+func (bf *BlockFilter) available() bool {
+	return bf.Header != nil
+}
+
+// IsBlockFilterIndexEnabled returns true if the db computes block filters
+func (d *RocksDB) IsBlockFilterIndexEnabled() bool {
+	return d.is != nil && d.is.IsBlockFilterIndex()
+}
+
+// EnableBlockFilterIndex switches on the computation of block filters
+// it is possible only for an empty db because the filter headers are chained from the first block
+func (d *RocksDB) EnableBlockFilterIndex() error {
+	if d.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return errors.New("Block filters are supported only for bitcoin type coins")
+	}
+	if d.is.IsBlockFilterIndex() {
+		return nil
+	}
+	_, hash, err := d.GetBestBlock()
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		return errors.New("Block filter index can be enabled only for a new db, it is necessary to rebuild index")
+	}
+	d.is.SetBlockFilterIndex()
+	glog.Info("rocksdb: block filter index enabled")
+	return d.StoreInternalState(d.is)
+}
+
+// GetBlockFilter returns the filter of the block at given height or nil if the filter is not stored or not available
+func (d *RocksDB) GetBlockFilter(height uint32) (*BlockFilter, error) {
+	bf, err := d.getBlockFilter(height)
+	if err != nil || bf == nil || !bf.available() {
+		return nil, err
+	}
+	return bf, nil
+}
+
+func (d *RocksDB) getBlockFilter(height uint32) (*BlockFilter, error) {
+	val, err := d.db.GetCF(d.ro, d.cfh[cfBlockFilters], packUint(height))
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	return unpackBlockFilter(val.Data())
+}
+
+func packBlockFilter(bf *BlockFilter) []byte {
+	if !bf.available() {
+		return []byte{0}
+	}
+	buf := make([]byte, 0, len(bf.Header)+len(bf.Filter))
+	buf = append(buf, bf.Header...)
+	return append(buf, bf.Filter...)
+}
+
+func unpackBlockFilter(buf []byte) (*BlockFilter, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	if len(buf) == 1 && buf[0] == 0 {
+		return &BlockFilter{}, nil
+	}
+	if len(buf) <= blockFilterHeaderLen {
+		return nil, errors.New("Inconsistent data in blockFilters")
+	}
+	return &BlockFilter{
+		Header: append([]byte(nil), buf[:blockFilterHeaderLen]...),
+		Filter: append([]byte(nil), buf[blockFilterHeaderLen:]...),
+	}, nil
+}
+
+func (d *RocksDB) storeBlockFilter(wb *gorocksdb.WriteBatch, height uint32, bf *BlockFilter) {
+	wb.PutCF(d.cfh[cfBlockFilters], packUint(height), packBlockFilter(bf))
+}
+
+// getPrevBlockFilterHeader returns the filter header of the block preceding the block at given height
+// the first filter in the db is chained to the zero header, nil is returned if the previous filter is not available
+func (d *RocksDB) getPrevBlockFilterHeader(height uint32) ([]byte, error) {
+	if height > 0 {
+		bf, err := d.getBlockFilter(height - 1)
+		if err != nil {
+			return nil, err
+		}
+		if bf != nil {
+			return bf.Header, nil
+		}
+	}
+	return make([]byte, blockFilterHeaderLen), nil
+}
+
+// computeBlockFilter computes the filter of the block processed by processAddressesBitcoinType
+// the scripts of the spent outputs are taken from the outputs of the spent transactions in txAddressesMap,
+// if any of them is unknown or the previous header is not available, the filter is not available
+func (d *RocksDB) computeBlockFilter(block *bchain.Block, txAddressesMap map[string]*TxAddresses, prevHeader []byte) (*BlockFilter, error) {
+	if prevHeader == nil {
+		return &BlockFilter{}, nil
+	}
+	var scripts [][]byte
+	for txi := range block.Txs {
+		tx := &block.Txs[txi]
+		for i := range tx.Vout {
+			script, err := d.chainParser.GetAddrDescFromVout(&tx.Vout[i])
+			// OP_RETURN outputs are not part of the filter
+			if err != nil || len(script) == 0 || script[0] == 0x6a {
+				continue
+			}
+			scripts = append(scripts, script)
+		}
+		for i := range tx.Vin {
+			input := &tx.Vin[i]
+			btxID, err := d.chainParser.PackTxid(input.Txid)
+			if err != nil {
+				// coinbase inputs do not spend any script
+				if err == bchain.ErrTxidMissing {
+					continue
+				}
+				return nil, err
+			}
+			ita := txAddressesMap[string(btxID)]
+			if ita == nil || len(ita.Outputs) <= int(input.Vout) {
+				glog.Warningf("rocksdb: height %d, tx %v, input tx %v vout %v is unknown, the block filters from this block on are not available", block.Height, tx.Txid, input.Txid, input.Vout)
+				return &BlockFilter{}, nil
+			}
+			// empty scripts are not part of the filter
+			if script := ita.Outputs[input.Vout].AddrDesc; len(script) > 0 {
+				scripts = append(scripts, script)
+			}
+		}
+	}
+	filter, err := buildBlockFilter(block.Hash, scripts)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockFilter{
+		Filter: filter,
+		Header: blockFilterHeader(filter, prevHeader),
+	}, nil
+}
+
+// buildBlockFilter builds the basic BIP158 filter matching the scripts
+// the filter is keyed by the first 16 bytes of the block hash in the internal byte order
+func buildBlockFilter(blockHash string, scripts [][]byte) ([]byte, error) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) != 32 {
+		return nil, errors.Errorf("Invalid block hash %v", blockHash)
+	}
+	// reverse the hash to the internal byte order
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	k0 := binary.LittleEndian.Uint64(hash[0:8])
+	k1 := binary.LittleEndian.Uint64(hash[8:16])
+	// remove duplicate scripts
+	sort.Slice(scripts, func(i, j int) bool {
+		return bytes.Compare(scripts[i], scripts[j]) < 0
+	})
+	unique := scripts[:0]
+	for i := range scripts {
+		if i == 0 || !bytes.Equal(scripts[i], scripts[i-1]) {
+			unique = append(unique, scripts[i])
+		}
+	}
+	n := uint64(len(unique))
+	// map the scripts uniformly to the range [0, N*M)
+	f := n * blockFilterM
+	values := make([]uint64, len(unique))
+	for i, s := range unique {
+		values[i], _ = bits.Mul64(sipHash24(k0, k1, s), f)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	// the filter is the number of items followed by the Golomb-Rice coded differences of the sorted values
+	w := bitWriter{buf: appendCompactSize(make([]byte, 0, 9+len(values)*3), n)}
+	var last uint64
+	for _, v := range values {
+		w.writeGolombRice(v - last)
+		last = v
+	}
+	return w.buf, nil
+}
+
+// appendCompactSize appends the bitcoin CompactSize encoding of v
+func appendCompactSize(buf []byte, v uint64) []byte {
+	switch {
+	case v < 0xfd:
+		return append(buf, byte(v))
+	case v <= 0xffff:
+		return append(buf, 0xfd, byte(v), byte(v>>8))
+	case v <= 0xffffffff:
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(v))
+		return append(append(buf, 0xfe), b...)
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return append(append(buf, 0xff), b...)
+}
+
+// blockFilterHeader computes the BIP157 filter header double-SHA256(double-SHA256(filter) || prevHeader)
+func blockFilterHeader(filter []byte, prevHeader []byte) []byte {
+	h := chainhash.DoubleHashB(filter)
+	return chainhash.DoubleHashB(append(h, prevHeader...))
+}
+
+// bitWriter writes bits to a byte slice, starting from the most significant bit of each byte
+type bitWriter struct {
+	buf  []byte
+	free uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeGolombRice writes the quotient of v in unary and the remainder in blockFilterP bits
+func (w *bitWriter) writeGolombRice(v uint64) {
+	for q := v >> blockFilterP; q > 0; q-- {
+		w.writeBit(true)
+	}
+	w.writeBit(false)
+	for i := blockFilterP - 1; i >= 0; i-- {
+		w.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+// sipHash24 computes the SipHash-2-4 of b using the key k0, k1
+func sipHash24(k0, k1 uint64, b []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	l := len(b)
+	for ; len(b) >= 8; b = b[8:] {
+		m := binary.LittleEndian.Uint64(b)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	last := make([]byte, 8)
+	copy(last, b)
+	last[7] = byte(l)
+	m := binary.LittleEndian.Uint64(last)
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
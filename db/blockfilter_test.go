@@ -0,0 +1,202 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/tests/dbtestdata"
+	"encoding/hex"
+	"testing"
+)
+
+func Test_sipHash24(t *testing.T) {
+	// test vectors from the SipHash reference implementation, key is 00 01 .. 0f, message 00 01 .. 0e
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if got := sipHash24(k0, k1, msg); got != 0xa129ca6149be45e5 {
+		t.Errorf("sipHash24() = %x, want a129ca6149be45e5", got)
+	}
+	if got := sipHash24(k0, k1, nil); got != 0x726fdb47dd0e0e31 {
+		t.Errorf("sipHash24() of empty message = %x, want 726fdb47dd0e0e31", got)
+	}
+}
+
+func Test_buildBlockFilter(t *testing.T) {
+	// testnet genesis block, test vector from BIP158
+	script, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	filter, err := buildBlockFilter("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943", [][]byte{script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(filter); got != "019dfca8" {
+		t.Errorf("buildBlockFilter() = %v, want 019dfca8", got)
+	}
+	header := blockFilterHeader(filter, make([]byte, blockFilterHeaderLen))
+	if got := hex.EncodeToString(reverseBytes(header)); got != "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750" {
+		t.Errorf("blockFilterHeader() = %v", got)
+	}
+	filter, err = buildBlockFilter("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(filter); got != "00" {
+		t.Errorf("buildBlockFilter() of empty block = %v, want 00", got)
+	}
+}
+
+func reverseBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+var wantBlockFilters = []struct {
+	height uint32
+	filter string
+	header string
+}{
+	{225493, "0503a28c0bf22c1aa04f72dc5ffec0", "06747ea618f788e390dbc081266ddcacfa1d092c98586ae83971009775307f8e"},
+	{225494, "09ea6890f708b5824e9724de06a5539aa7624e22b784875628", "9a278437d963ccb49dd484e04f658f5eb12779f537109f79e5cab771ad5fbc62"},
+}
+
+func checkBlockFilters(t *testing.T, d *RocksDB) {
+	for _, w := range wantBlockFilters {
+		bf, err := d.GetBlockFilter(w.height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bf == nil {
+			t.Fatalf("GetBlockFilter(%d) not found", w.height)
+		}
+		if got := hex.EncodeToString(bf.Filter); got != w.filter {
+			t.Errorf("GetBlockFilter(%d) filter = %v, want %v", w.height, got, w.filter)
+		}
+		if got := hex.EncodeToString(reverseBytes(bf.Header)); got != w.header {
+			t.Errorf("GetBlockFilter(%d) header = %v, want %v", w.height, got, w.header)
+		}
+	}
+}
+
+func TestRocksDB_BlockFilters(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.EnableBlockFilterIndex(); err != nil {
+		t.Fatal(err)
+	}
+	// the filter of block 2 contains output scripts except OP_RETURN and the scripts of the outputs spent from block 1
+	for _, b := range []*bchain.Block{dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser), dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)} {
+		if err := d.ConnectBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBlockFilters(t, d)
+
+	if err := d.DisconnectBlockRangeBitcoinType(225494, 225494); err != nil {
+		t.Fatal(err)
+	}
+	bf, err := d.GetBlockFilter(225494)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf != nil {
+		t.Error("GetBlockFilter() returned filter of disconnected block")
+	}
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	checkBlockFilters(t, d)
+}
+
+func TestBulkConnect_BlockFilters(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.EnableBlockFilterIndex(); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := d.InitBulkConnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkBlockFilters(t, d)
+}
+
+func TestRocksDB_BlockFilterUnavailable(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.EnableBlockFilterIndex(); err != nil {
+		t.Fatal(err)
+	}
+	// the outputs spent by block 2 are not in the index, the filter cannot be computed
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	bf, err := d.GetBlockFilter(225494)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf != nil {
+		t.Errorf("GetBlockFilter() = %+v, want nil", bf)
+	}
+	bf, err = d.getBlockFilter(225494)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf == nil || bf.available() {
+		t.Errorf("getBlockFilter() = %+v, want unavailable filter", bf)
+	}
+	// the unavailability is propagated to the following blocks
+	prevHeader, err := d.getPrevBlockFilterHeader(225495)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prevHeader != nil {
+		t.Errorf("getPrevBlockFilterHeader() = %v, want nil", prevHeader)
+	}
+}
+
+func TestRocksDB_EnableBlockFilterIndex(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EnableBlockFilterIndex(); err == nil {
+		t.Error("EnableBlockFilterIndex() of non empty db did not fail")
+	}
+	if d.IsBlockFilterIndexEnabled() {
+		t.Error("IsBlockFilterIndexEnabled() = true")
+	}
+	bf, err := d.GetBlockFilter(225493)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf != nil {
+		t.Error("GetBlockFilter() returned filter while the index is disabled")
+	}
+}
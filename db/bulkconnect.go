@@ -16,6 +16,7 @@ import (
 
 type bulkAddresses struct {
 	bi        BlockInfo
+	headerRaw []byte
 	addresses addressesMap
 }
 
@@ -27,8 +28,16 @@ type BulkConnect struct {
 	bulkAddressesCount int
 	txAddressesMap     map[string]*TxAddresses
 	balances           map[string]*AddrBalance
+	spending           spendingMap
+	scripthashes       scripthashMap
+	blockFilters       map[uint32]*BlockFilter
+	blockFilterHeader  []byte
+	blockFilterLoaded  bool
 	addressContracts   map[string]*AddrContracts
 	height             uint32
+	// in the pruned mode the spent txAddresses are removed until the first block of the rollback window
+	// and the address history is not stored for the blocks below the window
+	pruneHistory bool
 }
 
 const (
@@ -48,7 +57,11 @@ func (d *RocksDB) InitBulkConnect() (*BulkConnect, error) {
 		chainType:        d.chainParser.GetChainType(),
 		txAddressesMap:   make(map[string]*TxAddresses),
 		balances:         make(map[string]*AddrBalance),
+		spending:         make(spendingMap),
+		scripthashes:     make(scripthashMap),
+		blockFilters:     make(map[uint32]*BlockFilter),
 		addressContracts: make(map[string]*AddrContracts),
+		pruneHistory:     d.IsPruned(),
 	}
 	if err := d.SetInconsistentState(true); err != nil {
 		return nil, err
@@ -57,9 +70,27 @@ func (d *RocksDB) InitBulkConnect() (*BulkConnect, error) {
 	return b, nil
 }
 
+// removeSpentTxAddresses removes the transactions which cannot be spent any more from the cache and from db
+// all their spends are below the rollback window, they are not necessary for disconnect
+func (b *BulkConnect) removeSpentTxAddresses(wb *gorocksdb.WriteBatch) int {
+	var n int
+	for k, a := range b.txAddressesMap {
+		if b.d.isTxAddressesSpent(a) {
+			// the transaction may have been stored by a previous partial store
+			wb.DeleteCF(b.d.cfh[cfTxAddresses], []byte(k))
+			delete(b.txAddressesMap, k)
+			n++
+		}
+	}
+	return n
+}
+
 func (b *BulkConnect) storeTxAddresses(wb *gorocksdb.WriteBatch, all bool) (int, int, error) {
 	var txm map[string]*TxAddresses
 	var sp int
+	if b.pruneHistory {
+		sp = b.removeSpentTxAddresses(wb)
+	}
 	if all {
 		txm = b.txAddressesMap
 		b.txAddressesMap = make(map[string]*TxAddresses)
@@ -79,7 +110,7 @@ func (b *BulkConnect) storeTxAddresses(wb *gorocksdb.WriteBatch, all bool) (int,
 				delete(b.txAddressesMap, k)
 			}
 		}
-		sp = len(txm)
+		sp += len(txm)
 		// store some other random transactions if necessary
 		if len(txm) < partialStoreAddresses {
 			for k, a := range b.txAddressesMap {
@@ -163,6 +194,26 @@ func (b *BulkConnect) storeBulkAddresses(wb *gorocksdb.WriteBatch) error {
 		if err := b.d.writeHeight(wb, ba.bi.Height, &ba.bi, opInsert); err != nil {
 			return err
 		}
+		b.d.storeBlockHeader(wb, ba.bi.Height, ba.headerRaw)
+	}
+	// spending data belong to the same blocks as the addresses, store them together
+	if len(b.spending) > 0 {
+		if err := b.d.storeSpending(wb, b.spending); err != nil {
+			return err
+		}
+		b.spending = make(spendingMap)
+	}
+	if len(b.scripthashes) > 0 {
+		if err := b.d.storeScripthashes(wb, b.scripthashes); err != nil {
+			return err
+		}
+		b.scripthashes = make(scripthashMap)
+	}
+	if len(b.blockFilters) > 0 {
+		for height, bf := range b.blockFilters {
+			b.d.storeBlockFilter(wb, height, bf)
+		}
+		b.blockFilters = make(map[uint32]*BlockFilter)
 	}
 	b.bulkAddressesCount = 0
 	b.bulkAddresses = b.bulkAddresses[:0]
@@ -170,10 +221,25 @@ func (b *BulkConnect) storeBulkAddresses(wb *gorocksdb.WriteBatch) error {
 }
 
 func (b *BulkConnect) connectBlockBitcoinType(block *bchain.Block, storeBlockTxs bool) error {
+	if b.pruneHistory && storeBlockTxs {
+		// the first block of the rollback window, the spends of the transactions spent so far are below the window
+		if err := b.removeSpentTxAddressesBeforeWindow(); err != nil {
+			return err
+		}
+	}
 	addresses := make(addressesMap)
-	if err := b.d.processAddressesBitcoinType(block, addresses, b.txAddressesMap, b.balances); err != nil {
+	if err := b.d.processAddressesBitcoinType(block, addresses, b.txAddressesMap, b.balances, b.spending, b.scripthashes); err != nil {
 		return err
 	}
+	if b.pruneHistory {
+		// the address history of the blocks below the rollback window is not stored
+		addresses = nil
+	}
+	if b.d.IsBlockFilterIndexEnabled() {
+		if err := b.connectBlockFilter(block); err != nil {
+			return err
+		}
+	}
 	var storeAddressesChan, storeBalancesChan chan error
 	var sa bool
 	if len(b.txAddressesMap) > maxBulkTxAddresses || len(b.balances) > maxBulkBalances {
@@ -195,6 +261,7 @@ func (b *BulkConnect) connectBlockBitcoinType(block *bchain.Block, storeBlockTxs
 			Size:   uint32(block.Size),
 			Height: block.Height,
 		},
+		headerRaw: block.HeaderRaw,
 		addresses: addresses,
 	})
 	b.bulkAddressesCount += len(addresses)
@@ -234,6 +301,39 @@ func (b *BulkConnect) connectBlockBitcoinType(block *bchain.Block, storeBlockTxs
 	return nil
 }
 
+func (b *BulkConnect) removeSpentTxAddressesBeforeWindow() error {
+	start := time.Now()
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	n := b.removeSpentTxAddresses(wb)
+	if err := b.d.db.Write(b.d.wo, wb); err != nil {
+		return err
+	}
+	b.pruneHistory = false
+	glog.Info("rocksdb: height ", b.height, ", removed ", n, " spent txAddresses, done in ", time.Since(start))
+	return nil
+}
+
+// connectBlockFilter computes the filter of the block, the filters are stored together with the addresses
+// the header of the last filter is kept because the filters of the previous blocks may not yet be stored
+func (b *BulkConnect) connectBlockFilter(block *bchain.Block) error {
+	if !b.blockFilterLoaded {
+		prevHeader, err := b.d.getPrevBlockFilterHeader(block.Height)
+		if err != nil {
+			return err
+		}
+		b.blockFilterHeader = prevHeader
+		b.blockFilterLoaded = true
+	}
+	bf, err := b.d.computeBlockFilter(block, b.txAddressesMap, b.blockFilterHeader)
+	if err != nil {
+		return err
+	}
+	b.blockFilters[block.Height] = bf
+	b.blockFilterHeader = bf.Header
+	return nil
+}
+
 func (b *BulkConnect) storeAddressContracts(wb *gorocksdb.WriteBatch, all bool) (int, error) {
 	var ac map[string]*AddrContracts
 	if all {
@@ -295,6 +395,7 @@ func (b *BulkConnect) connectBlockEthereumType(block *bchain.Block, storeBlockTx
 			Size:   uint32(block.Size),
 			Height: block.Height,
 		},
+		headerRaw: block.HeaderRaw,
 		addresses: addresses,
 	})
 	b.bulkAddressesCount += len(addresses)
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// types of the events published to the EventSink
+const (
+	SinkEventBlockConnected    = "blockConnected"
+	SinkEventBlockDisconnected = "blockDisconnected"
+	SinkEventMempoolTx         = "mempoolTx"
+)
+
+// maxSinkMempoolTxs is the number of recently published mempool transactions remembered to avoid duplicate events
+const maxSinkMempoolTxs = 10000
+
+// EventSink is a consumer of the events about the connected and disconnected blocks and the new mempool transactions
+// Publish is called synchronously from the sync and the mempool goroutines, the implementation must be safe for concurrent use
+type EventSink interface {
+	Publish(e *SinkEvent) error
+	Close() error
+}
+
+// SinkEvent is an event published to the EventSink
+type SinkEvent struct {
+	Type     string   `json:"type"`
+	Sequence uint64   `json:"sequence"`
+	Height   uint32   `json:"height,omitempty"`
+	Hash     string   `json:"hash,omitempty"`
+	Time     int64    `json:"time,omitempty"`
+	Txs      []SinkTx `json:"txs,omitempty"`
+	// Txids are the ids of the transactions of the disconnected block
+	Txids []string `json:"txids,omitempty"`
+}
+
+// SinkTx is a transaction with the addresses of its inputs and outputs resolved
+type SinkTx struct {
+	Txid string         `json:"txid"`
+	Vin  []SinkTxInput  `json:"vin"`
+	Vout []SinkTxOutput `json:"vout"`
+}
+
+// SinkTxInput is an input of SinkTx, Addresses and Value are not set if the spent output is not known
+type SinkTxInput struct {
+	Txid      string   `json:"txid,omitempty"`
+	Vout      uint32   `json:"vout"`
+	Coinbase  bool     `json:"coinbase,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Value     *big.Int `json:"value,omitempty"`
+}
+
+// SinkTxOutput is an output of SinkTx
+type SinkTxOutput struct {
+	N         uint32   `json:"n"`
+	Addresses []string `json:"addresses,omitempty"`
+	Value     *big.Int `json:"value"`
+}
+
+// eventPublisher creates the events and publishes them to the sink
+type eventPublisher struct {
+	sink       EventSink
+	db         Index
+	parser     bchain.BlockChainParser
+	sequence   uint64
+	mempoolMux sync.Mutex
+	mempoolTxs map[string]struct{}
+}
+
+// SetEventSink sets the sink to which the events of the connected and disconnected blocks are published
+// the events are not published by ConnectBlocksParallel used in the initial synchronization
+func (w *SyncWorker) SetEventSink(sink EventSink) {
+	w.events = &eventPublisher{
+		sink:       sink,
+		db:         w.db,
+		parser:     w.chain.GetChainParser(),
+		mempoolTxs: make(map[string]struct{}),
+	}
+}
+
+// OnNewTxAddr is a callback of the mempool which publishes the event of the new mempool transaction
+// the mempool calls the callback for each address of the transaction, the event is published only once
+func (w *SyncWorker) OnNewTxAddr(tx *bchain.Tx, addrDesc bchain.AddressDescriptor) {
+	if w.events == nil {
+		return
+	}
+	w.events.mempoolTx(tx)
+}
+
+func (p *eventPublisher) publish(e *SinkEvent) {
+	e.Sequence = atomic.AddUint64(&p.sequence, 1)
+	if err := p.sink.Publish(e); err != nil {
+		glog.Error("EventSink: ", e.Type, " ", e.Hash, ": ", err)
+	}
+}
+
+func (p *eventPublisher) blockConnected(block *bchain.Block) {
+	e := &SinkEvent{
+		Type:   SinkEventBlockConnected,
+		Height: block.Height,
+		Hash:   block.Hash,
+		Time:   block.Time,
+		Txs:    make([]SinkTx, len(block.Txs)),
+	}
+	for i := range block.Txs {
+		p.resolveTx(&block.Txs[i], &e.Txs[i])
+	}
+	// the block was just connected, it is not necessary to publish its transactions again when they come from mempool
+	p.mempoolMux.Lock()
+	for i := range block.Txs {
+		delete(p.mempoolTxs, block.Txs[i].Txid)
+	}
+	p.mempoolMux.Unlock()
+	p.publish(e)
+}
+
+// blockDisconnecting creates the event of the block before it is removed from the index, the event is published after the removal
+func (p *eventPublisher) blockDisconnecting(height uint32) (*SinkEvent, error) {
+	hash, err := p.db.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	e := &SinkEvent{
+		Type:   SinkEventBlockDisconnected,
+		Height: height,
+		Hash:   hash,
+	}
+	if p.parser.GetChainType() == bchain.ChainBitcoinType {
+		if e.Txids, err = p.db.GetBlockTxids(height); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (p *eventPublisher) mempoolTx(tx *bchain.Tx) {
+	p.mempoolMux.Lock()
+	if _, found := p.mempoolTxs[tx.Txid]; found {
+		p.mempoolMux.Unlock()
+		return
+	}
+	if len(p.mempoolTxs) >= maxSinkMempoolTxs {
+		p.mempoolTxs = make(map[string]struct{})
+	}
+	p.mempoolTxs[tx.Txid] = struct{}{}
+	p.mempoolMux.Unlock()
+	e := &SinkEvent{
+		Type: SinkEventMempoolTx,
+		Time: time.Now().Unix(),
+		Txs:  make([]SinkTx, 1),
+	}
+	p.resolveTx(tx, &e.Txs[0])
+	p.publish(e)
+}
+
+// resolveTx fills the addresses of the inputs and outputs of the transaction
+// for bitcoin type coins the inputs are resolved using the index, unknown inputs (spending mempool transactions) are left unresolved
+func (p *eventPublisher) resolveTx(tx *bchain.Tx, st *SinkTx) {
+	st.Txid = tx.Txid
+	st.Vin = make([]SinkTxInput, len(tx.Vin))
+	st.Vout = make([]SinkTxOutput, len(tx.Vout))
+	for i := range tx.Vout {
+		vout := &tx.Vout[i]
+		so := &st.Vout[i]
+		so.N = vout.N
+		so.Value = new(big.Int).Set(&vout.ValueSat)
+		addrDesc, err := p.parser.GetAddrDescFromVout(vout)
+		if err == nil {
+			so.Addresses, _, err = p.parser.GetAddressesFromAddrDesc(addrDesc)
+		}
+		if err != nil {
+			glog.Warning("EventSink: tx ", tx.Txid, " output ", vout.N, ": ", err)
+		}
+	}
+	bitcoinType := p.parser.GetChainType() == bchain.ChainBitcoinType
+	for i := range tx.Vin {
+		vin := &tx.Vin[i]
+		si := &st.Vin[i]
+		si.Txid = vin.Txid
+		si.Vout = vin.Vout
+		si.Coinbase = vin.Coinbase != ""
+		if si.Coinbase {
+			continue
+		}
+		if !bitcoinType {
+			si.Addresses = vin.Addresses
+			continue
+		}
+		ta, err := p.db.GetTxAddresses(vin.Txid)
+		if err != nil {
+			glog.Warning("EventSink: tx ", tx.Txid, " input ", i, ": ", err)
+			continue
+		}
+		if ta == nil || int(vin.Vout) >= len(ta.Outputs) {
+			continue
+		}
+		to := &ta.Outputs[vin.Vout]
+		si.Value = new(big.Int).Set(&to.ValueSat)
+		if si.Addresses, _, err = to.Addresses(p.parser); err != nil {
+			glog.Warning("EventSink: tx ", tx.Txid, " input ", i, ": ", err)
+		}
+	}
+}
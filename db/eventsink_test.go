@@ -0,0 +1,103 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/tests/dbtestdata"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testEventSink struct {
+	sync.Mutex
+	events []*SinkEvent
+}
+
+func (s *testEventSink) Publish(e *SinkEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *testEventSink) Close() error {
+	return nil
+}
+
+func TestSyncWorker_EventSink_BitcoinType(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	chain, err := dbtestdata.NewFakeBlockChain(d.chainParser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewSyncWorker(d, chain, 1, 1, 0, false, nil, nil, d.is)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testEventSink{}
+	w.SetEventSink(sink)
+
+	block1 := dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)
+	block2 := dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)
+	for _, b := range []*bchain.Block{block1, block2} {
+		if err := d.ConnectBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		w.events.blockConnected(b)
+	}
+	// the mempool calls the callback for each output, only one event is published
+	tx := block2.Txs[2]
+	w.OnNewTxAddr(&tx, nil)
+	w.OnNewTxAddr(&tx, nil)
+	if err := w.DisconnectBlocks(225494, 225494, []string{block2.Hash}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.events) != 4 {
+		t.Fatalf("got %d events, want 4", len(sink.events))
+	}
+	for i, e := range sink.events {
+		if e.Sequence != uint64(i+1) {
+			t.Errorf("event %d sequence %d", i, e.Sequence)
+		}
+	}
+	e := sink.events[1]
+	if e.Type != SinkEventBlockConnected || e.Height != 225494 || e.Hash != block2.Hash || len(e.Txs) != len(block2.Txs) {
+		t.Fatalf("blockConnected event %+v", e)
+	}
+	wantTx := SinkTx{
+		Txid: dbtestdata.TxidB2T3,
+		Vin: []SinkTxInput{
+			{Txid: dbtestdata.TxidB1T2, Vout: 2, Addresses: []string{dbtestdata.Addr5}, Value: dbtestdata.SatB1T2A5},
+		},
+		Vout: []SinkTxOutput{
+			{N: 0, Addresses: []string{dbtestdata.Addr5}, Value: dbtestdata.SatB2T3A5},
+		},
+	}
+	if !reflect.DeepEqual(e.Txs[2], wantTx) {
+		t.Errorf("blockConnected tx %+v, want %+v", e.Txs[2], wantTx)
+	}
+	if !e.Txs[3].Vin[0].Coinbase || e.Txs[3].Vin[0].Addresses != nil {
+		t.Errorf("blockConnected coinbase tx %+v", e.Txs[3])
+	}
+
+	e = sink.events[2]
+	if e.Type != SinkEventMempoolTx || len(e.Txs) != 1 || !reflect.DeepEqual(e.Txs[0], wantTx) {
+		t.Errorf("mempoolTx event %+v", e)
+	}
+
+	e = sink.events[3]
+	wantTxids := []string{dbtestdata.TxidB2T1, dbtestdata.TxidB2T2, dbtestdata.TxidB2T3, dbtestdata.TxidB2T4}
+	if e.Type != SinkEventBlockDisconnected || e.Height != 225494 || e.Hash != block2.Hash || !reflect.DeepEqual(e.Txids, wantTxids) {
+		t.Errorf("blockDisconnected event %+v", e)
+	}
+	if e.Txs != nil {
+		t.Errorf("blockDisconnected event contains txs")
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/common"
+	"time"
+)
+
+// Index is the storage of the indexed blockchain data used by the api, the servers and the synchronization
+// it is implemented by RocksDB and by the in-memory MemoryIndex
+type Index interface {
+	// blocks
+	GetBestBlock() (uint32, string, error)
+	GetBlockHash(height uint32) (string, error)
+	GetBlockInfo(height uint32) (*BlockInfo, error)
+	GetBlockHeaderRaw(height uint32) ([]byte, error)
+	GetBlockTxids(height uint32) ([]string, error)
+	GetBlockFilter(height uint32) (*BlockFilter, error)
+	IsBlockFilterIndexEnabled() bool
+	ConnectBlock(block *bchain.Block) error
+	ConnectBlockSpending(block *bchain.Block) error
+	DisconnectBlockRangeBitcoinType(lower uint32, higher uint32) error
+	DisconnectBlockRangeEthereumType(lower uint32, higher uint32) error
+
+	// addresses
+	GetTransactions(address string, lower uint32, higher uint32, fn GetTransactionsCallback) error
+	GetAddrDescTransactions(addrDesc bchain.AddressDescriptor, lower uint32, higher uint32, fn GetTransactionsCallback) error
+	GetAddressBalance(address string, detail AddressBalanceDetail) (*AddrBalance, error)
+	GetAddrDescBalance(addrDesc bchain.AddressDescriptor, detail AddressBalanceDetail) (*AddrBalance, error)
+	GetAddrDescContracts(addrDesc bchain.AddressDescriptor) (*AddrContracts, error)
+	GetAddrDescForScripthash(scripthash []byte) (bchain.AddressDescriptor, error)
+	IsPruned() bool
+	AddressHistoryFromHeight() (uint32, error)
+
+	// transactions
+	GetTxAddresses(txid string) (*TxAddresses, error)
+	GetSpendingTx(txid string, vout int32) (*SpendingTx, error)
+	GetTx(txid string) (*bchain.Tx, uint32, error)
+	PutTx(tx *bchain.Tx, height uint32, blockTime int64) error
+	DeleteTx(txid string) error
+
+	// fiat rates
+	FiatRatesStoreTicker(ticker *CurrencyRatesTicker) error
+	FiatRatesFindTicker(tickerTime *time.Time) (*CurrencyRatesTicker, error)
+	FiatRatesFindLastTicker() (*CurrencyRatesTicker, error)
+
+	// webhooks
+	StoreWebhook(w *Webhook) error
+	GetWebhooks() ([]*Webhook, error)
+	DeleteWebhook(id string) error
+	StoreWebhookTx(t *WebhookTx) error
+	GetWebhookTx(webhookID, txid string) (*WebhookTx, error)
+	GetWebhookTxs() ([]*WebhookTx, error)
+	DeleteWebhookTx(webhookID, txid string) error
+	StoreWebhookDelivery(dl *WebhookDelivery) error
+	GetDueWebhookDeliveries(now time.Time, max int) ([]*WebhookDelivery, error)
+	RescheduleWebhookDelivery(dl *WebhookDelivery, nextAttempt int64) error
+	DeleteWebhookDelivery(dl *WebhookDelivery) error
+
+	// internal state and maintenance
+	LoadInternalState(rpcCoin string) (*common.InternalState, error)
+	SetInternalState(is *common.InternalState)
+	StoreInternalState(is *common.InternalState) error
+	SetInconsistentState(inconsistent bool) error
+	IsReplica() bool
+	DatabaseSizeOnDisk() int64
+	CreateCheckpoint(dir string) (*Checkpoint, error)
+	Close() error
+}
@@ -0,0 +1,319 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/tests/dbtestdata"
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type indexBalance struct {
+	addr    string
+	txs     uint32
+	sent    *big.Int
+	balance *big.Int
+	utxos   int
+}
+
+// testIndexes are the implementations of Index tested by the same tests
+var testIndexes = []struct {
+	name  string
+	setup func(t *testing.T, p bchain.BlockChainParser) (Index, func())
+}{
+	{
+		name: "RocksDB",
+		setup: func(t *testing.T, p bchain.BlockChainParser) (Index, func()) {
+			d := setupRocksDB(t, p)
+			return d, func() { closeAndDestroyRocksDB(t, d) }
+		},
+	},
+	{
+		name: "MemoryIndex",
+		setup: func(t *testing.T, p bchain.BlockChainParser) (Index, func()) {
+			d, err := NewMemoryIndex(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			is, err := d.LoadInternalState("coin-unittest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.SetInternalState(is)
+			return d, func() { d.Close() }
+		},
+	},
+}
+
+func verifyIndexBlock(t *testing.T, d Index, height uint32, hash string, blockTime int64, txs, size uint32, txids []string) {
+	bi, err := d.GetBlockInfo(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BlockInfo{Hash: hash, Time: blockTime, Txs: txs, Size: size, Height: height}
+	if !reflect.DeepEqual(bi, want) {
+		t.Errorf("GetBlockInfo(%v) = %+v, want %+v", height, bi, want)
+	}
+	h, err := d.GetBlockHash(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != hash {
+		t.Errorf("GetBlockHash(%v) = %v, want %v", height, h, hash)
+	}
+	// the txids are checked only for the blocks in the rollback window of RocksDB
+	if txids == nil {
+		return
+	}
+	got, err := d.GetBlockTxids(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, txids) {
+		t.Errorf("GetBlockTxids(%v) = %v, want %v", height, got, txids)
+	}
+}
+
+func verifyIndexBestBlock(t *testing.T, d Index, height uint32, hash string) {
+	h, bh, err := d.GetBestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != height || bh != hash {
+		t.Errorf("GetBestBlock() = %v %v, want %v %v", h, bh, height, hash)
+	}
+}
+
+func verifyIndexBalances(t *testing.T, d Index, balances []indexBalance) {
+	for _, b := range balances {
+		ab, err := d.GetAddressBalance(b.addr, AddressBalanceDetailUTXO)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.balance == nil {
+			if ab != nil {
+				t.Errorf("GetAddressBalance(%v) = %+v, want nil", b.addr, ab)
+			}
+			continue
+		}
+		if ab == nil {
+			t.Errorf("GetAddressBalance(%v) = nil", b.addr)
+			continue
+		}
+		if ab.Txs != b.txs || ab.SentSat.Cmp(b.sent) != 0 || ab.BalanceSat.Cmp(b.balance) != 0 || len(ab.Utxos) != b.utxos {
+			t.Errorf("GetAddressBalance(%v) = txs %v, sent %v, balance %v, utxos %v, want %v, %v, %v, %v", b.addr,
+				ab.Txs, ab.SentSat.String(), ab.BalanceSat.String(), len(ab.Utxos), b.txs, b.sent.String(), b.balance.String(), b.utxos)
+		}
+	}
+}
+
+func verifyIndexScripthash(t *testing.T, d Index, p bchain.BlockChainParser, addr string, exists bool) {
+	addrDesc, err := p.GetAddrDescFromAddress(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.GetAddrDescForScripthash(Scripthash(addrDesc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists && !bytes.Equal(got, addrDesc) || !exists && got != nil {
+		t.Errorf("GetAddrDescForScripthash(%v) = %v, exists %v", addr, got, exists)
+	}
+}
+
+func TestIndex_BitcoinType(t *testing.T) {
+	for _, ti := range testIndexes {
+		t.Run(ti.name, func(t *testing.T) {
+			parser := &testBitcoinParser{
+				BitcoinParser: bitcoinTestnetParser(),
+			}
+			d, cleanup := ti.setup(t, parser)
+			defer cleanup()
+
+			block1 := dbtestdata.GetTestBitcoinTypeBlock1(parser)
+			if err := d.ConnectBlock(block1); err != nil {
+				t.Fatal(err)
+			}
+			block2 := dbtestdata.GetTestBitcoinTypeBlock2(parser)
+			if err := d.ConnectBlock(block2); err != nil {
+				t.Fatal(err)
+			}
+
+			verifyIndexBestBlock(t, d, 225494, "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6")
+			verifyIndexBlock(t, d, 225493, "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997", 1534858021, 2, 1234567, nil)
+			verifyIndexBlock(t, d, 225494, "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6", 1534859123, 4, 2345678,
+				[]string{dbtestdata.TxidB2T1, dbtestdata.TxidB2T2, dbtestdata.TxidB2T3, dbtestdata.TxidB2T4})
+			verifyIndexBalances(t, d, []indexBalance{
+				{dbtestdata.Addr1, 1, dbtestdata.SatZero, dbtestdata.SatB1T1A1, 1},
+				{dbtestdata.Addr2, 2, dbtestdata.SatB1T1A2, dbtestdata.SatZero, 0},
+				{dbtestdata.Addr3, 2, dbtestdata.SatB1T2A3, dbtestdata.SatZero, 0},
+				{dbtestdata.Addr5, 2, dbtestdata.SatB1T2A5, dbtestdata.SatB2T3A5, 1},
+				{dbtestdata.Addr6, 2, dbtestdata.SatB2T1A6, dbtestdata.SatZero, 0},
+				{dbtestdata.Addr7, 1, dbtestdata.SatZero, dbtestdata.SatB2T1A7, 1},
+				{dbtestdata.AddrA, 1, dbtestdata.SatZero, dbtestdata.SatB2T4AA, 1},
+			})
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 0, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T1, ^1},
+				{dbtestdata.TxidB1T1, 1},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 225494, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T1, ^1},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr6, 0, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T2, ^0},
+				{dbtestdata.TxidB2T1, 0},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 500000, 1000000, []txidIndex{}, nil)
+			verifyIndexScripthash(t, d, parser, dbtestdata.Addr6, true)
+
+			stx, err := d.GetSpendingTx(dbtestdata.TxidB1T2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &SpendingTx{BtxID: mustPackTxid(t, parser, dbtestdata.TxidB2T1), Index: 0, Height: 225494}
+			if !reflect.DeepEqual(stx, want) {
+				t.Errorf("GetSpendingTx() = %+v, want %+v", stx, want)
+			}
+			verifyIndexTxOutputsSpent(t, d, dbtestdata.TxidB1T2, 225493, []bool{true, true, true})
+
+			testTxCache(t, d, block1, &block1.Txs[0])
+			testTxCache(t, d, block2, &block2.Txs[0])
+
+			// disconnect the block 2, the index must be in the state after the block 1
+			if err := d.DisconnectBlockRangeBitcoinType(225494, 225494); err != nil {
+				t.Fatal(err)
+			}
+			verifyIndexBestBlock(t, d, 225493, "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997")
+			bi, err := d.GetBlockInfo(225494)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bi != nil {
+				t.Errorf("GetBlockInfo(225494) = %+v, want nil", bi)
+			}
+			verifyIndexBalances(t, d, []indexBalance{
+				{dbtestdata.Addr1, 1, dbtestdata.SatZero, dbtestdata.SatB1T1A1, 1},
+				{dbtestdata.Addr2, 1, dbtestdata.SatZero, dbtestdata.SatB1T1A2, 1},
+				{dbtestdata.Addr3, 1, dbtestdata.SatZero, dbtestdata.SatB1T2A3, 1},
+				{dbtestdata.Addr5, 1, dbtestdata.SatZero, dbtestdata.SatB1T2A5, 1},
+				{addr: dbtestdata.Addr6},
+				{addr: dbtestdata.AddrA},
+			})
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 0, 1000000, []txidIndex{
+				{dbtestdata.TxidB1T1, 1},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr6, 0, 1000000, []txidIndex{}, nil)
+			verifyIndexScripthash(t, d, parser, dbtestdata.Addr6, false)
+			verifyIndexScripthash(t, d, parser, dbtestdata.Addr1, true)
+			stx, err = d.GetSpendingTx(dbtestdata.TxidB1T2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stx != nil {
+				t.Errorf("GetSpendingTx() = %+v, want nil", stx)
+			}
+			verifyIndexTxOutputsSpent(t, d, dbtestdata.TxidB1T2, 225493, []bool{false, false, false})
+			ta, err := d.GetTxAddresses(dbtestdata.TxidB2T1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ta != nil {
+				t.Errorf("GetTxAddresses(%v) = %+v, want nil", dbtestdata.TxidB2T1, ta)
+			}
+
+			// reconnect the block 2
+			if err := d.ConnectBlock(block2); err != nil {
+				t.Fatal(err)
+			}
+			verifyIndexBestBlock(t, d, 225494, "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6")
+			verifyIndexBalances(t, d, []indexBalance{
+				{dbtestdata.Addr2, 2, dbtestdata.SatB1T1A2, dbtestdata.SatZero, 0},
+				{dbtestdata.Addr5, 2, dbtestdata.SatB1T2A5, dbtestdata.SatB2T3A5, 1},
+				{dbtestdata.AddrA, 1, dbtestdata.SatZero, dbtestdata.SatB2T4AA, 1},
+			})
+		})
+	}
+}
+
+func verifyIndexTxOutputsSpent(t *testing.T, d Index, txid string, height uint32, spent []bool) {
+	ta, err := d.GetTxAddresses(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta == nil {
+		t.Fatalf("GetTxAddresses(%v) = nil", txid)
+	}
+	got := make([]bool, len(ta.Outputs))
+	for i := range ta.Outputs {
+		got[i] = ta.Outputs[i].Spent
+	}
+	if ta.Height != height || !reflect.DeepEqual(got, spent) {
+		t.Errorf("GetTxAddresses(%v) = height %v, spent %v, want %v, %v", txid, ta.Height, got, height, spent)
+	}
+}
+
+func TestIndex_FiatRates(t *testing.T) {
+	for _, ti := range testIndexes {
+		t.Run(ti.name, func(t *testing.T) {
+			d, cleanup := ti.setup(t, &testBitcoinParser{
+				BitcoinParser: bitcoinTestnetParser(),
+			})
+			defer cleanup()
+
+			ticker, err := d.FiatRatesFindLastTicker()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticker != nil {
+				t.Errorf("FiatRatesFindLastTicker() = %+v, want nil", ticker)
+			}
+			for _, ts := range []string{"20190628000000", "20190627000000"} {
+				tm, err := time.Parse(FiatRatesTimeFormat, ts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := d.FiatRatesStoreTicker(&CurrencyRatesTicker{Timestamp: &tm, Rates: map[string]float64{"usd": 1}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.FiatRatesStoreTicker(&CurrencyRatesTicker{}); err == nil {
+				t.Error("FiatRatesStoreTicker() of empty ticker must fail")
+			}
+			for _, tt := range []struct {
+				ts   string
+				want string
+			}{
+				{"20190101000000", "20190627000000"},
+				{"20190627120000", "20190628000000"},
+				{"20190629000000", ""},
+			} {
+				tm, err := time.Parse(FiatRatesTimeFormat, tt.ts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ticker, err := d.FiatRatesFindTicker(&tm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := ""
+				if ticker != nil {
+					got = ticker.Timestamp.Format(FiatRatesTimeFormat)
+				}
+				if got != tt.want {
+					t.Errorf("FiatRatesFindTicker(%v) = %v, want %v", tt.ts, got, tt.want)
+				}
+			}
+			ticker, err = d.FiatRatesFindLastTicker()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticker == nil || ticker.Timestamp.Format(FiatRatesTimeFormat) != "20190628000000" {
+				t.Errorf("FiatRatesFindLastTicker() = %+v, want ticker 20190628000000", ticker)
+			}
+		})
+	}
+}
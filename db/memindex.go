@@ -0,0 +1,1041 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/common"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// MemoryIndex is the in-memory implementation of Index for bitcoin type coins, it is intended for the tests
+// and for small deployments (for example regtest), the data are lost when the process exits
+// the balances, txAddresses and spending data are kept packed in the same format as in RocksDB,
+// so that the returned data are never shared with the index
+// the rollback data are kept for all blocks, any block can be disconnected; block filters and checkpoints are not supported
+type MemoryIndex struct {
+	mux          sync.RWMutex
+	chainParser  bchain.BlockChainParser
+	is           *common.InternalState
+	bestHeight   uint32
+	blocks       map[uint32]BlockInfo
+	headers      map[uint32][]byte
+	blockTxs     map[uint32][]blockTxs
+	addresses    map[string]map[uint32][]txIndexes
+	balances     map[string][]byte
+	txAddresses  map[string][]byte
+	spending     map[string][]byte
+	scripthashes map[string]bchain.AddressDescriptor
+	txs          map[string][]byte
+	fiatRates    map[string][]byte
+	// webhooks and webhookOutbox are keyed by the same keys as the webhook columns in RocksDB
+	webhooks      map[string][]byte
+	webhookOutbox map[string][]byte
+}
+
+// NewMemoryIndex creates an empty in-memory index
+func NewMemoryIndex(parser bchain.BlockChainParser) (*MemoryIndex, error) {
+	if parser.GetChainType() != bchain.ChainBitcoinType {
+		return nil, errors.New("MemoryIndex supports only bitcoin type coins")
+	}
+	return &MemoryIndex{
+		chainParser:   parser,
+		blocks:        make(map[uint32]BlockInfo),
+		headers:       make(map[uint32][]byte),
+		blockTxs:      make(map[uint32][]blockTxs),
+		addresses:     make(map[string]map[uint32][]txIndexes),
+		balances:      make(map[string][]byte),
+		txAddresses:   make(map[string][]byte),
+		spending:      make(map[string][]byte),
+		scripthashes:  make(map[string]bchain.AddressDescriptor),
+		txs:           make(map[string][]byte),
+		fiatRates:     make(map[string][]byte),
+		webhooks:      make(map[string][]byte),
+		webhookOutbox: make(map[string][]byte),
+	}, nil
+}
+
+// Blocks
+
+// GetBestBlock returns the height and hash of the best block in the index
+func (m *MemoryIndex) GetBestBlock() (uint32, string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if bi, found := m.blocks[m.bestHeight]; found {
+		return m.bestHeight, bi.Hash, nil
+	}
+	return 0, "", nil
+}
+
+// GetBlockHash returns block hash at given height or empty string if not found
+func (m *MemoryIndex) GetBlockHash(height uint32) (string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.blocks[height].Hash, nil
+}
+
+// GetBlockInfo returns block info stored in the index
+func (m *MemoryIndex) GetBlockInfo(height uint32) (*BlockInfo, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	bi, found := m.blocks[height]
+	if !found {
+		return nil, nil
+	}
+	return &bi, nil
+}
+
+// GetBlockHeaderRaw returns the raw header of the block at given height or nil if the header is not stored
+func (m *MemoryIndex) GetBlockHeaderRaw(height uint32) ([]byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	h, found := m.headers[height]
+	if !found {
+		return nil, nil
+	}
+	return append([]byte(nil), h...), nil
+}
+
+// GetBlockTxids returns txids of the transactions in the block of given height
+func (m *MemoryIndex) GetBlockTxids(height uint32) ([]string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	bt := m.blockTxs[height]
+	txids := make([]string, len(bt))
+	for i := range bt {
+		var err error
+		if txids[i], err = m.chainParser.UnpackTxid(bt[i].btxID); err != nil {
+			return nil, err
+		}
+	}
+	return txids, nil
+}
+
+// GetBlockFilter returns nil, block filters are not supported by MemoryIndex
+func (m *MemoryIndex) GetBlockFilter(height uint32) (*BlockFilter, error) {
+	return nil, nil
+}
+
+// IsBlockFilterIndexEnabled returns false, block filters are not supported by MemoryIndex
+func (m *MemoryIndex) IsBlockFilterIndexEnabled() bool {
+	return false
+}
+
+// ConnectBlock indexes addresses in the block, the processing is the same as in RocksDB
+func (m *MemoryIndex) ConnectBlock(block *bchain.Block) error {
+	bt, err := blockTxsFromBlock(m.chainParser, block)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	addresses := make(addressesMap)
+	txAddressesMap := make(map[string]*TxAddresses)
+	balances := make(map[string]*AddrBalance)
+	spending := make(spendingMap)
+	scripthashes := make(scripthashMap)
+	blockTxAddresses := make([]*TxAddresses, len(block.Txs))
+	// first process all outputs so that inputs can refer to txs in this block
+	for txi := range block.Txs {
+		tx := &block.Txs[txi]
+		btxID := bt[txi].btxID
+		ta := TxAddresses{Height: block.Height}
+		ta.Outputs = make([]TxOutput, len(tx.Vout))
+		txAddressesMap[string(btxID)] = &ta
+		blockTxAddresses[txi] = &ta
+		for i := range tx.Vout {
+			output := &tx.Vout[i]
+			tao := &ta.Outputs[i]
+			tao.ValueSat = output.ValueSat
+			addrDesc, err := m.chainParser.GetAddrDescFromVout(output)
+			if err != nil || len(addrDesc) == 0 || len(addrDesc) > maxAddrDescLen {
+				if err != nil && err != bchain.ErrAddressMissing {
+					glog.Warningf("memindex: addrDesc: %v - height %d, tx %v, output %v", err, block.Height, tx.Txid, i)
+				}
+				continue
+			}
+			tao.AddrDesc = addrDesc
+			if !m.chainParser.IsAddrDescIndexable(addrDesc) {
+				continue
+			}
+			balance, err := m.getBalanceToUpdate(addrDesc, balances, scripthashes)
+			if err != nil {
+				return err
+			}
+			balance.BalanceSat.Add(&balance.BalanceSat, &output.ValueSat)
+			balance.addUtxo(&Utxo{
+				BtxID:    btxID,
+				Vout:     int32(i),
+				Height:   block.Height,
+				ValueSat: output.ValueSat,
+			})
+			if !addToAddressesMap(addresses, string(addrDesc), btxID, int32(i)) {
+				balance.Txs++
+			}
+		}
+	}
+	// process inputs
+	for txi := range block.Txs {
+		tx := &block.Txs[txi]
+		spendingTxid := bt[txi].btxID
+		ta := blockTxAddresses[txi]
+		ta.Inputs = make([]TxInput, len(tx.Vin))
+		for i := range tx.Vin {
+			input := &tx.Vin[i]
+			tai := &ta.Inputs[i]
+			btxID, err := m.chainParser.PackTxid(input.Txid)
+			if err != nil {
+				// do not process inputs without input txid
+				if err == bchain.ErrTxidMissing {
+					continue
+				}
+				return err
+			}
+			spending[string(packSpendingKey(btxID, int32(input.Vout)))] = &SpendingTx{
+				BtxID:  spendingTxid,
+				Index:  int32(i),
+				Height: block.Height,
+			}
+			ita, err := m.getTxAddressesToUpdate(btxID, txAddressesMap)
+			if err != nil {
+				return err
+			}
+			if ita == nil {
+				tai.AddrDesc = m.chainParser.GetAddrDescForUnknownInput(tx, i)
+				continue
+			}
+			if len(ita.Outputs) <= int(input.Vout) {
+				glog.Warningf("memindex: height %d, tx %v, input tx %v vout %v is out of bounds of stored tx", block.Height, tx.Txid, input.Txid, input.Vout)
+				continue
+			}
+			spentOutput := &ita.Outputs[int(input.Vout)]
+			if spentOutput.Spent {
+				glog.Warningf("memindex: height %d, tx %v, input tx %v vout %v is double spend", block.Height, tx.Txid, input.Txid, input.Vout)
+			}
+			tai.AddrDesc = spentOutput.AddrDesc
+			tai.ValueSat = spentOutput.ValueSat
+			spentOutput.Spent = true
+			if len(spentOutput.AddrDesc) == 0 || !m.chainParser.IsAddrDescIndexable(spentOutput.AddrDesc) {
+				continue
+			}
+			balance, err := m.getBalanceToUpdate(spentOutput.AddrDesc, balances, scripthashes)
+			if err != nil {
+				return err
+			}
+			if !addToAddressesMap(addresses, string(spentOutput.AddrDesc), spendingTxid, ^int32(i)) {
+				balance.Txs++
+			}
+			balance.BalanceSat.Sub(&balance.BalanceSat, &spentOutput.ValueSat)
+			balance.markUtxoAsSpent(btxID, int32(input.Vout))
+			m.resetNegativeValueSat(&balance.BalanceSat, spentOutput.AddrDesc, "balance")
+			balance.SentSat.Add(&balance.SentSat, &spentOutput.ValueSat)
+		}
+	}
+	m.storeTxAddresses(txAddressesMap)
+	m.storeBalances(balances)
+	m.storeSpending(spending)
+	for key, addrDesc := range scripthashes {
+		m.scripthashes[key] = addrDesc
+	}
+	for addrDesc, txi := range addresses {
+		rows, found := m.addresses[addrDesc]
+		if !found {
+			rows = make(map[uint32][]txIndexes)
+			m.addresses[addrDesc] = rows
+		}
+		rows[block.Height] = txi
+	}
+	m.blocks[block.Height] = BlockInfo{
+		Hash:   block.Hash,
+		Time:   block.Time,
+		Txs:    uint32(len(block.Txs)),
+		Size:   uint32(block.Size),
+		Height: block.Height,
+	}
+	if len(block.HeaderRaw) > 0 {
+		m.headers[block.Height] = append([]byte(nil), block.HeaderRaw...)
+	}
+	m.blockTxs[block.Height] = bt
+	if len(m.blocks) == 1 || block.Height > m.bestHeight {
+		m.bestHeight = block.Height
+	}
+	m.is.UpdateBestHeight(block.Height)
+	return nil
+}
+
+// ConnectBlockSpending stores only the spending index of the block
+func (m *MemoryIndex) ConnectBlockSpending(block *bchain.Block) error {
+	spending := make(spendingMap)
+	for txi := range block.Txs {
+		tx := &block.Txs[txi]
+		spendingTxid, err := m.chainParser.PackTxid(tx.Txid)
+		if err != nil {
+			return err
+		}
+		for i, input := range tx.Vin {
+			btxID, err := m.chainParser.PackTxid(input.Txid)
+			if err != nil {
+				if err == bchain.ErrTxidMissing {
+					continue
+				}
+				return err
+			}
+			spending[string(packSpendingKey(btxID, int32(input.Vout)))] = &SpendingTx{
+				BtxID:  spendingTxid,
+				Index:  int32(i),
+				Height: block.Height,
+			}
+		}
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.storeSpending(spending)
+	return nil
+}
+
+// DisconnectBlockRangeBitcoinType removes all data belonging to blocks in range lower-higher
+func (m *MemoryIndex) DisconnectBlockRangeBitcoinType(lower uint32, higher uint32) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for height := lower; height <= higher; height++ {
+		if _, found := m.blockTxs[height]; !found {
+			return errors.Errorf("Cannot disconnect block %v, it is not in the index", height)
+		}
+	}
+	txAddressesToUpdate := make(map[string]*TxAddresses)
+	balances := make(map[string]*AddrBalance)
+	txsToDelete := make(map[string]struct{})
+	var spendingToDelete []string
+	// address descriptors of the history rows to delete for each height
+	rowsToDelete := make(map[uint32]map[string]struct{})
+	for height := higher; height >= lower; height-- {
+		bt := m.blockTxs[height]
+		rows := make(map[string]struct{})
+		rowsToDelete[height] = rows
+		// go backwards to avoid interim negative balance
+		for i := len(bt) - 1; i >= 0; i-- {
+			btxID := bt[i].btxID
+			txsToDelete[string(btxID)] = struct{}{}
+			for _, input := range bt[i].inputs {
+				spendingToDelete = append(spendingToDelete, string(packSpendingKey(input.btxID, input.index)))
+			}
+			buf, found := m.txAddresses[string(btxID)]
+			if !found {
+				ut, _ := m.chainParser.UnpackTxid(btxID)
+				glog.Warning("TxAddress for txid ", ut, " not found")
+				continue
+			}
+			ta, err := unpackTxAddresses(buf)
+			if err != nil {
+				return err
+			}
+			if err = m.disconnectTxAddresses(btxID, bt[i].inputs, ta, txAddressesToUpdate, balances, rows); err != nil {
+				return err
+			}
+		}
+		if height == 0 {
+			break
+		}
+	}
+	m.storeTxAddresses(txAddressesToUpdate)
+	for _, b := range balances {
+		if b != nil {
+			// remove utxos marked as spent and sort the rest by height
+			us := make([]Utxo, 0, len(b.Utxos))
+			for _, u := range b.Utxos {
+				if u.Vout >= 0 {
+					us = append(us, u)
+				}
+			}
+			sort.SliceStable(us, func(i, j int) bool {
+				return us[i].Height < us[j].Height
+			})
+			b.Utxos = us
+		}
+	}
+	m.storeBalances(balances)
+	for _, key := range spendingToDelete {
+		delete(m.spending, key)
+	}
+	for height, rows := range rowsToDelete {
+		for addrDesc := range rows {
+			if r, found := m.addresses[addrDesc]; found {
+				delete(r, height)
+				if len(r) == 0 {
+					delete(m.addresses, addrDesc)
+				}
+			}
+		}
+		delete(m.blocks, height)
+		delete(m.headers, height)
+		delete(m.blockTxs, height)
+	}
+	for s := range txsToDelete {
+		delete(m.txAddresses, s)
+		delete(m.txs, s)
+	}
+	m.bestHeight = 0
+	for height := range m.blocks {
+		if height > m.bestHeight {
+			m.bestHeight = height
+		}
+	}
+	m.is.UpdateBestHeight(m.bestHeight)
+	glog.Infof("memindex: blocks %d-%d disconnected", lower, higher)
+	return nil
+}
+
+func (m *MemoryIndex) disconnectTxAddresses(btxID []byte, inputs []outpoint, ta *TxAddresses,
+	txAddressesToUpdate map[string]*TxAddresses, balances map[string]*AddrBalance, rows map[string]struct{}) error {
+	addresses := make(map[string]struct{})
+	getBalance := func(addrDesc bchain.AddressDescriptor) (*AddrBalance, error) {
+		s := string(addrDesc)
+		b, found := balances[s]
+		if !found {
+			var err error
+			if b, err = m.getAddrDescBalance(addrDesc, addressBalanceDetailUTXOIndexed); err != nil {
+				return nil, err
+			}
+			balances[s] = b
+		}
+		if b == nil {
+			ad, _, _ := m.chainParser.GetAddressesFromAddrDesc(addrDesc)
+			glog.Warningf("Balance for address %s (%s) not found", ad, addrDesc)
+		}
+		return b, nil
+	}
+	for i := range ta.Inputs {
+		t := &ta.Inputs[i]
+		if len(t.AddrDesc) == 0 {
+			continue
+		}
+		input := &inputs[i]
+		s := string(t.AddrDesc)
+		_, exist := addresses[s]
+		addresses[s] = struct{}{}
+		sa, err := m.getTxAddressesToUpdate(input.btxID, txAddressesToUpdate)
+		if err != nil {
+			return err
+		}
+		var inputHeight uint32
+		if sa != nil {
+			sa.Outputs[input.index].Spent = false
+			inputHeight = sa.Height
+		}
+		if !m.chainParser.IsAddrDescIndexable(t.AddrDesc) {
+			continue
+		}
+		balance, err := getBalance(t.AddrDesc)
+		if err != nil {
+			return err
+		}
+		if balance == nil {
+			continue
+		}
+		// subtract number of txs only once
+		if !exist {
+			balance.Txs--
+		}
+		balance.SentSat.Sub(&balance.SentSat, &t.ValueSat)
+		m.resetNegativeValueSat(&balance.SentSat, t.AddrDesc, "sent amount")
+		balance.BalanceSat.Add(&balance.BalanceSat, &t.ValueSat)
+		balance.Utxos = append(balance.Utxos, Utxo{
+			BtxID:    input.btxID,
+			Vout:     input.index,
+			Height:   inputHeight,
+			ValueSat: t.ValueSat,
+		})
+	}
+	for i := range ta.Outputs {
+		t := &ta.Outputs[i]
+		if len(t.AddrDesc) == 0 {
+			continue
+		}
+		s := string(t.AddrDesc)
+		_, exist := addresses[s]
+		addresses[s] = struct{}{}
+		if !m.chainParser.IsAddrDescIndexable(t.AddrDesc) {
+			continue
+		}
+		balance, err := getBalance(t.AddrDesc)
+		if err != nil {
+			return err
+		}
+		if balance == nil {
+			continue
+		}
+		// subtract number of txs only once
+		if !exist {
+			balance.Txs--
+		}
+		balance.BalanceSat.Sub(&balance.BalanceSat, &t.ValueSat)
+		m.resetNegativeValueSat(&balance.BalanceSat, t.AddrDesc, "balance")
+		balance.markUtxoAsSpent(btxID, int32(i))
+	}
+	for a := range addresses {
+		rows[a] = struct{}{}
+	}
+	return nil
+}
+
+// DisconnectBlockRangeEthereumType returns error, ethereum type coins are not supported by MemoryIndex
+func (m *MemoryIndex) DisconnectBlockRangeEthereumType(lower uint32, higher uint32) error {
+	return errors.New("MemoryIndex supports only bitcoin type coins")
+}
+
+// Addresses
+
+// GetTransactions finds all input/output transactions for address
+func (m *MemoryIndex) GetTransactions(address string, lower uint32, higher uint32, fn GetTransactionsCallback) error {
+	addrDesc, err := m.chainParser.GetAddrDescFromAddress(address)
+	if err != nil {
+		return err
+	}
+	return m.GetAddrDescTransactions(addrDesc, lower, higher, fn)
+}
+
+type memoryAddressTx struct {
+	txid    string
+	height  uint32
+	indexes []int32
+}
+
+// GetAddrDescTransactions finds all input/output transactions for address descriptor
+// Transaction are passed to callback function in the order from newest block to the oldest
+// the callback is called without the lock of the index, it can use the index
+func (m *MemoryIndex) GetAddrDescTransactions(addrDesc bchain.AddressDescriptor, lower uint32, higher uint32, fn GetTransactionsCallback) error {
+	txs, err := m.getAddrDescTransactions(addrDesc, lower, higher)
+	if err != nil {
+		return err
+	}
+	for i := range txs {
+		if err := fn(txs[i].txid, txs[i].height, txs[i].indexes); err != nil {
+			if _, ok := err.(*StopIteration); ok {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryIndex) getAddrDescTransactions(addrDesc bchain.AddressDescriptor, lower uint32, higher uint32) ([]memoryAddressTx, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	rows := m.addresses[string(addrDesc)]
+	heights := make([]uint32, 0, len(rows))
+	for height := range rows {
+		if height >= lower && height <= higher {
+			heights = append(heights, height)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] > heights[j]
+	})
+	var txs []memoryAddressTx
+	for _, height := range heights {
+		row := rows[height]
+		// the txs in the block from the newest to the oldest as in RocksDB
+		for j := len(row) - 1; j >= 0; j-- {
+			txid, err := m.chainParser.UnpackTxid(row[j].btxID)
+			if err != nil {
+				return nil, err
+			}
+			txs = append(txs, memoryAddressTx{
+				txid:    txid,
+				height:  height,
+				indexes: append([]int32(nil), row[j].indexes...),
+			})
+		}
+	}
+	return txs, nil
+}
+
+// GetAddressBalance returns address balance for an address or nil if address not found
+func (m *MemoryIndex) GetAddressBalance(address string, detail AddressBalanceDetail) (*AddrBalance, error) {
+	addrDesc, err := m.chainParser.GetAddrDescFromAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetAddrDescBalance(addrDesc, detail)
+}
+
+// GetAddrDescBalance returns AddrBalance for given addrDesc
+func (m *MemoryIndex) GetAddrDescBalance(addrDesc bchain.AddressDescriptor, detail AddressBalanceDetail) (*AddrBalance, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.getAddrDescBalance(addrDesc, detail)
+}
+
+func (m *MemoryIndex) getAddrDescBalance(addrDesc bchain.AddressDescriptor, detail AddressBalanceDetail) (*AddrBalance, error) {
+	buf, found := m.balances[string(addrDesc)]
+	if !found {
+		return nil, nil
+	}
+	return unpackAddrBalance(buf, m.chainParser.PackedTxidLen(), detail)
+}
+
+func (m *MemoryIndex) getBalanceToUpdate(addrDesc bchain.AddressDescriptor, balances map[string]*AddrBalance, scripthashes scripthashMap) (*AddrBalance, error) {
+	s := string(addrDesc)
+	balance, found := balances[s]
+	if !found {
+		var err error
+		balance, err = m.getAddrDescBalance(addrDesc, addressBalanceDetailUTXOIndexed)
+		if err != nil {
+			return nil, err
+		}
+		if balance == nil {
+			balance = &AddrBalance{}
+			// the address is new in the index
+			scripthashes[string(Scripthash(addrDesc))] = addrDesc
+		}
+		balances[s] = balance
+	}
+	return balance, nil
+}
+
+func (m *MemoryIndex) storeBalances(balances map[string]*AddrBalance) {
+	varBuf := make([]byte, maxPackedBigintBytes)
+	for addrDesc, ab := range balances {
+		// balance with 0 transactions is removed - happens on disconnect
+		if ab == nil || ab.Txs <= 0 {
+			delete(m.balances, addrDesc)
+			delete(m.scripthashes, string(Scripthash(bchain.AddressDescriptor(addrDesc))))
+		} else {
+			m.balances[addrDesc] = packAddrBalance(ab, nil, varBuf)
+		}
+	}
+}
+
+func (m *MemoryIndex) resetNegativeValueSat(valueSat *big.Int, addrDesc bchain.AddressDescriptor, logText string) {
+	if valueSat.Sign() < 0 {
+		glog.Warningf("memindex: address hex '%v' reached negative %s %v, resetting to 0", addrDesc, logText, valueSat.String())
+		valueSat.SetInt64(0)
+	}
+}
+
+// GetAddrDescContracts returns nil, ethereum type coins are not supported by MemoryIndex
+func (m *MemoryIndex) GetAddrDescContracts(addrDesc bchain.AddressDescriptor) (*AddrContracts, error) {
+	return nil, nil
+}
+
+// GetAddrDescForScripthash returns the address descriptor of the scripthash or nil if the scripthash is not indexed
+func (m *MemoryIndex) GetAddrDescForScripthash(scripthash []byte) (bchain.AddressDescriptor, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	addrDesc, found := m.scripthashes[string(scripthash)]
+	if !found {
+		return nil, nil
+	}
+	return append(bchain.AddressDescriptor(nil), addrDesc...), nil
+}
+
+// IsPruned returns false, MemoryIndex keeps the complete address history
+func (m *MemoryIndex) IsPruned() bool {
+	return false
+}
+
+// AddressHistoryFromHeight returns 0, MemoryIndex keeps the complete address history
+func (m *MemoryIndex) AddressHistoryFromHeight() (uint32, error) {
+	return 0, nil
+}
+
+// Transactions
+
+// GetTxAddresses returns TxAddresses for given txid or nil if not found
+func (m *MemoryIndex) GetTxAddresses(txid string) (*TxAddresses, error) {
+	btxID, err := m.chainParser.PackTxid(txid)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	buf, found := m.txAddresses[string(btxID)]
+	if !found {
+		return nil, nil
+	}
+	return unpackTxAddresses(buf)
+}
+
+func (m *MemoryIndex) getTxAddressesToUpdate(btxID []byte, txAddressesToUpdate map[string]*TxAddresses) (*TxAddresses, error) {
+	s := string(btxID)
+	ta, found := txAddressesToUpdate[s]
+	if !found {
+		buf, found := m.txAddresses[s]
+		if !found {
+			return nil, nil
+		}
+		var err error
+		if ta, err = unpackTxAddresses(buf); err != nil {
+			return nil, err
+		}
+		txAddressesToUpdate[s] = ta
+	}
+	return ta, nil
+}
+
+func (m *MemoryIndex) storeTxAddresses(txAddresses map[string]*TxAddresses) {
+	varBuf := make([]byte, maxPackedBigintBytes)
+	for btxID, ta := range txAddresses {
+		m.txAddresses[btxID] = packTxAddresses(ta, nil, varBuf)
+	}
+}
+
+func (m *MemoryIndex) storeSpending(sm spendingMap) {
+	varBuf := make([]byte, maxPackedBigintBytes)
+	for key, stx := range sm {
+		m.spending[key] = packSpendingTx(stx, nil, varBuf)
+	}
+}
+
+// GetSpendingTx returns the transaction input which spent given output or nil if the output is not spent
+func (m *MemoryIndex) GetSpendingTx(txid string, vout int32) (*SpendingTx, error) {
+	btxID, err := m.chainParser.PackTxid(txid)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	buf, found := m.spending[string(packSpendingKey(btxID, vout))]
+	if !found {
+		return nil, nil
+	}
+	return unpackSpendingTx(buf, m.chainParser.PackedTxidLen())
+}
+
+// GetTx returns transaction stored in the index and height of the block containing it
+func (m *MemoryIndex) GetTx(txid string) (*bchain.Tx, uint32, error) {
+	key, err := m.chainParser.PackTxid(txid)
+	if err != nil {
+		return nil, 0, err
+	}
+	m.mux.RLock()
+	buf, found := m.txs[string(key)]
+	m.mux.RUnlock()
+	if !found {
+		return nil, 0, nil
+	}
+	return m.chainParser.UnpackTx(buf)
+}
+
+// PutTx stores transaction in the index
+func (m *MemoryIndex) PutTx(tx *bchain.Tx, height uint32, blockTime int64) error {
+	key, err := m.chainParser.PackTxid(tx.Txid)
+	if err != nil {
+		return nil
+	}
+	buf, err := m.chainParser.PackTx(tx, height, blockTime)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.txs[string(key)] = buf
+	return nil
+}
+
+// DeleteTx removes transaction from the index
+func (m *MemoryIndex) DeleteTx(txid string) error {
+	key, err := m.chainParser.PackTxid(txid)
+	if err != nil {
+		return nil
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.txs, string(key))
+	return nil
+}
+
+// Fiat rates
+
+// FiatRatesStoreTicker stores ticker data at the specified time
+func (m *MemoryIndex) FiatRatesStoreTicker(ticker *CurrencyRatesTicker) error {
+	if len(ticker.Rates) == 0 {
+		return errors.New("Error storing ticker: empty rates")
+	} else if ticker.Timestamp == nil {
+		return errors.New("Error storing ticker: empty timestamp")
+	}
+	ratesMarshalled, err := json.Marshal(ticker.Rates)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.fiatRates[string(packTimestamp(ticker.Timestamp))] = ratesMarshalled
+	return nil
+}
+
+// FiatRatesFindTicker gets FiatRates data closest to the specified timestamp, i.e. the first ticker at or after the timestamp
+// returns nil if there is no such ticker
+func (m *MemoryIndex) FiatRatesFindTicker(tickerTime *time.Time) (*CurrencyRatesTicker, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	key := string(packTimestamp(tickerTime))
+	found := ""
+	for k := range m.fiatRates {
+		if k >= key && (found == "" || k < found) {
+			found = k
+		}
+	}
+	if found == "" {
+		return nil, nil
+	}
+	return unpackFiatRatesTicker([]byte(found), m.fiatRates[found])
+}
+
+// FiatRatesFindLastTicker gets the last FiatRates record or nil if there is no ticker
+func (m *MemoryIndex) FiatRatesFindLastTicker() (*CurrencyRatesTicker, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	found := ""
+	for k := range m.fiatRates {
+		if k > found {
+			found = k
+		}
+	}
+	if found == "" {
+		return nil, nil
+	}
+	return unpackFiatRatesTicker([]byte(found), m.fiatRates[found])
+}
+
+// Webhooks
+
+// sortedKeys returns the keys of the map with the prefix in the order in which RocksDB iterates them
+func sortedKeys(data map[string][]byte, prefix string) []string {
+	keys := make([]string, 0)
+	for k := range data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StoreWebhook stores the webhook registration
+func (m *MemoryIndex) StoreWebhook(w *Webhook) error {
+	if w.ID == "" || w.URL == "" {
+		return errors.New("Webhook id and url must be set")
+	}
+	buf, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.webhooks[string(append([]byte{webhookKeyRegistration}, w.ID...))] = buf
+	return nil
+}
+
+// GetWebhooks returns all webhook registrations
+func (m *MemoryIndex) GetWebhooks() ([]*Webhook, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	r := make([]*Webhook, 0)
+	for _, k := range sortedKeys(m.webhooks, string(webhookKeyRegistration)) {
+		var w Webhook
+		if err := json.Unmarshal(m.webhooks[k], &w); err != nil {
+			return nil, errors.Annotatef(err, "webhook %s", k[1:])
+		}
+		r = append(r, &w)
+	}
+	return r, nil
+}
+
+// DeleteWebhook deletes the webhook registration together with its tracked transactions
+// the deliveries in the outbox are discarded when their time comes
+func (m *MemoryIndex) DeleteWebhook(id string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.webhooks, string(append([]byte{webhookKeyRegistration}, id...)))
+	for _, k := range sortedKeys(m.webhooks, string(packWebhookTxKey(id, ""))) {
+		delete(m.webhooks, k)
+	}
+	return nil
+}
+
+// StoreWebhookTx stores the transaction tracked for confirmation updates
+func (m *MemoryIndex) StoreWebhookTx(t *WebhookTx) error {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.webhooks[string(packWebhookTxKey(t.WebhookID, t.Txid))] = buf
+	return nil
+}
+
+// GetWebhookTx returns the tracked transaction or nil if the transaction is not tracked by the webhook
+func (m *MemoryIndex) GetWebhookTx(webhookID, txid string) (*WebhookTx, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	val := m.webhooks[string(packWebhookTxKey(webhookID, txid))]
+	if len(val) == 0 {
+		return nil, nil
+	}
+	t := &WebhookTx{WebhookID: webhookID, Txid: txid}
+	if err := json.Unmarshal(val, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetWebhookTxs returns all transactions tracked for confirmation updates
+func (m *MemoryIndex) GetWebhookTxs() ([]*WebhookTx, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	r := make([]*WebhookTx, 0)
+	for _, k := range sortedKeys(m.webhooks, string(webhookKeyTx)) {
+		i := strings.IndexByte(k, 0)
+		if i < 0 {
+			return nil, errors.Errorf("Invalid webhook tx key %q", k)
+		}
+		t := &WebhookTx{WebhookID: k[1:i], Txid: k[i+1:]}
+		if err := json.Unmarshal(m.webhooks[k], t); err != nil {
+			return nil, errors.Annotatef(err, "webhook tx %q", k)
+		}
+		r = append(r, t)
+	}
+	return r, nil
+}
+
+// DeleteWebhookTx stops tracking of the transaction
+func (m *MemoryIndex) DeleteWebhookTx(webhookID, txid string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.webhooks, string(packWebhookTxKey(webhookID, txid)))
+	return nil
+}
+
+// StoreWebhookDelivery stores a new delivery to the outbox, NextAttempt is the time of the first attempt in unix milliseconds
+func (m *MemoryIndex) StoreWebhookDelivery(dl *WebhookDelivery) error {
+	dl.ID = atomic.AddUint64(&webhookDeliverySeq, 1)
+	buf, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.webhookOutbox[string(packWebhookDeliveryKey(dl.NextAttempt, dl.ID))] = buf
+	return nil
+}
+
+// GetDueWebhookDeliveries returns up to max deliveries with the next attempt before or at the time now
+func (m *MemoryIndex) GetDueWebhookDeliveries(now time.Time, max int) ([]*WebhookDelivery, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	r := make([]*WebhookDelivery, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	for _, k := range sortedKeys(m.webhookOutbox, "") {
+		if len(r) >= max {
+			break
+		}
+		key := []byte(k)
+		nextAttempt := int64(binary.BigEndian.Uint64(key))
+		if nextAttempt > nowMs {
+			break
+		}
+		dl := &WebhookDelivery{NextAttempt: nextAttempt, ID: binary.BigEndian.Uint64(key[8:])}
+		if err := json.Unmarshal(m.webhookOutbox[k], dl); err != nil {
+			return nil, errors.Annotatef(err, "webhook delivery %q", key)
+		}
+		r = append(r, dl)
+	}
+	return r, nil
+}
+
+// RescheduleWebhookDelivery moves the delivery in the outbox to the time of the next attempt nextAttempt
+func (m *MemoryIndex) RescheduleWebhookDelivery(dl *WebhookDelivery, nextAttempt int64) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.webhookOutbox, string(packWebhookDeliveryKey(dl.NextAttempt, dl.ID)))
+	dl.NextAttempt = nextAttempt
+	buf, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	m.webhookOutbox[string(packWebhookDeliveryKey(dl.NextAttempt, dl.ID))] = buf
+	return nil
+}
+
+// DeleteWebhookDelivery removes the delivery from the outbox
+func (m *MemoryIndex) DeleteWebhookDelivery(dl *WebhookDelivery) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.webhookOutbox, string(packWebhookDeliveryKey(dl.NextAttempt, dl.ID)))
+	return nil
+}
+
+// Internal state
+
+// LoadInternalState initializes a new internal state, MemoryIndex does not store it
+func (m *MemoryIndex) LoadInternalState(rpcCoin string) (*common.InternalState, error) {
+	return &common.InternalState{Coin: rpcCoin}, nil
+}
+
+// SetInternalState sets the InternalState to be used by the index
+func (m *MemoryIndex) SetInternalState(is *common.InternalState) {
+	m.is = is
+}
+
+// StoreInternalState does nothing, MemoryIndex does not store the internal state
+func (m *MemoryIndex) StoreInternalState(is *common.InternalState) error {
+	return nil
+}
+
+// SetInconsistentState sets the internal state to DbStateInconsistent or DbStateOpen based on inconsistent parameter
+func (m *MemoryIndex) SetInconsistentState(inconsistent bool) error {
+	if m.is == nil {
+		return errors.New("Internal state not created")
+	}
+	if inconsistent {
+		m.is.DbState = common.DbStateInconsistent
+	} else {
+		m.is.DbState = common.DbStateOpen
+	}
+	return nil
+}
+
+// IsReplica returns false, MemoryIndex cannot be shared by processes
+func (m *MemoryIndex) IsReplica() bool {
+	return false
+}
+
+// DatabaseSizeOnDisk returns 0, MemoryIndex does not use disk
+func (m *MemoryIndex) DatabaseSizeOnDisk() int64 {
+	return 0
+}
+
+// CreateCheckpoint returns error, MemoryIndex cannot be backed up
+func (m *MemoryIndex) CreateCheckpoint(dir string) (*Checkpoint, error) {
+	return nil, errors.New("MemoryIndex does not support checkpoints")
+}
+
+// Close releases the data of the index
+func (m *MemoryIndex) Close() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.blocks = make(map[uint32]BlockInfo)
+	m.headers = make(map[uint32][]byte)
+	m.blockTxs = make(map[uint32][]blockTxs)
+	m.addresses = make(map[string]map[uint32][]txIndexes)
+	m.balances = make(map[string][]byte)
+	m.txAddresses = make(map[string][]byte)
+	m.spending = make(map[string][]byte)
+	m.scripthashes = make(map[string]bchain.AddressDescriptor)
+	m.txs = make(map[string][]byte)
+	m.fiatRates = make(map[string][]byte)
+	m.webhooks = make(map[string][]byte)
+	m.webhookOutbox = make(map[string][]byte)
+	return nil
+}
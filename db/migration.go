@@ -0,0 +1,262 @@
+package db
+
+import (
+	"blockbook/common"
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+// migrationStateKey is the key in the default column under which the progress of an unfinished migration is stored
+const migrationStateKey = "migrationState"
+
+// number of rows converted in one write batch, the progress is stored together with each batch
+const migrationBatchSize = 10000
+
+// the progress of the migration is logged after this number of rows
+const migrationLogRows = 1000000
+
+// migration converts the columns of the db from the version-1 to the version
+type migration struct {
+	version     uint32
+	description string
+	// columns converted by the migration, the other columns are only marked by the new version
+	columns []int
+	// migrateRow converts one row of the column col and writes the result to wb,
+	// usually as a put of a new value under the same key or as a delete of the key and a put of a new key,
+	// key and val are valid only during the call
+	// the rows are processed in the order of the keys, new keys must not be written after the processed key
+	migrateRow func(d *RocksDB, col int, key, val []byte, wb *gorocksdb.WriteBatch) error
+}
+
+// migrations contains the registered migrations, one for each version step
+// a change of the data format requires an increase of dbVersion and a migration converting the existing data to the new format
+var migrations = []migration{}
+
+// migrationState is the progress of the migration of a column, it allows the migration to be resumed after interruption
+type migrationState struct {
+	Version uint32 `json:"version"`
+	Column  string `json:"column"`
+	LastKey []byte `json:"lastKey"`
+	Rows    int64  `json:"rows"`
+}
+
+func findMigration(version uint32) *migration {
+	for i := range migrations {
+		if migrations[i].version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+// canMigrate returns true if the registered migrations convert the db from the version to dbVersion
+func canMigrate(version uint32) bool {
+	if version >= dbVersion {
+		return false
+	}
+	for v := version + 1; v <= dbVersion; v++ {
+		if findMigration(v) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// MigrationNeeded returns true if some column of the db has older version than dbVersion
+func (d *RocksDB) MigrationNeeded() bool {
+	if d.is == nil {
+		return false
+	}
+	for _, c := range d.is.DbColumns {
+		if c.Version < dbVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrationInProgress returns true if a migration was interrupted, the db is in inconsistent state until Migrate finishes it
+func (d *RocksDB) MigrationInProgress() (bool, error) {
+	ms, err := d.loadMigrationState()
+	if err != nil {
+		return false, err
+	}
+	return ms != nil, nil
+}
+
+func (d *RocksDB) loadMigrationState() (*migrationState, error) {
+	val, err := d.db.GetCF(d.ro, d.cfh[cfDefault], []byte(migrationStateKey))
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	data := val.Data()
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var ms migrationState
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return nil, err
+	}
+	return &ms, nil
+}
+
+// Migrate converts the columns of the db to dbVersion using the registered migrations
+// the db is in inconsistent state during the migration, the migration can be interrupted by the stop signal
+// and is resumed from the last stored batch by the next call of Migrate
+func (d *RocksDB) Migrate(stop chan os.Signal) error {
+	if d.is == nil {
+		return errors.New("Internal state not created")
+	}
+	if !d.MigrationNeeded() {
+		return nil
+	}
+	if d.replica != nil {
+		return errors.New("The database must be migrated by the primary instance")
+	}
+	minVersion := uint32(dbVersion)
+	for _, c := range d.is.DbColumns {
+		if c.Version < minVersion {
+			minVersion = c.Version
+		}
+	}
+	if !canMigrate(minVersion) {
+		return errors.Errorf("No migration from DB version %v to version %v", minVersion, dbVersion)
+	}
+	ms, err := d.loadMigrationState()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	glog.Info("db: migration from version ", minVersion, " to version ", dbVersion, " start")
+	// the progress is stored before the db is marked inconsistent so that an interrupted migration is always detected
+	if ms == nil {
+		if err = d.storeMigrationStep(minVersion, false); err != nil {
+			return err
+		}
+	}
+	if err = d.SetInconsistentState(true); err != nil {
+		return err
+	}
+	for v := minVersion + 1; v <= dbVersion; v++ {
+		m := findMigration(v)
+		glog.Info("db: migration to version ", v, ": ", m.description)
+		for _, col := range m.columns {
+			// the type specific columns of other chain types do not exist
+			if col >= len(d.is.DbColumns) || d.is.DbColumns[col].Version >= v {
+				continue
+			}
+			var lastKey []byte
+			var rows int64
+			if ms != nil && ms.Version == v && ms.Column == cfNames[col] {
+				lastKey, rows = ms.LastKey, ms.Rows
+				glog.Info("db: migration of column ", cfNames[col], " resumed after ", rows, " rows")
+			}
+			if err = d.migrateColumn(m, col, lastKey, rows, stop); err != nil {
+				return err
+			}
+			d.is.DbColumns[col].Version = v
+			if err = d.storeMigrationStep(v, false); err != nil {
+				return err
+			}
+		}
+		for i := range d.is.DbColumns {
+			if d.is.DbColumns[i].Version < v {
+				d.is.DbColumns[i].Version = v
+			}
+		}
+		if v == dbVersion {
+			d.is.DbState = common.DbStateOpen
+		}
+		if err = d.storeMigrationStep(v, v == dbVersion); err != nil {
+			return err
+		}
+	}
+	glog.Info("db: migration finished in ", time.Since(start))
+	return nil
+}
+
+// storeMigrationStep stores the internal state with the new column versions together with the progress of the migration,
+// the progress is removed after the last step, when the db is no longer inconsistent
+func (d *RocksDB) storeMigrationStep(version uint32, last bool) error {
+	buf, err := d.is.Pack()
+	if err != nil {
+		return err
+	}
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	wb.PutCF(d.cfh[cfDefault], []byte(internalStateKey), buf)
+	if last {
+		wb.DeleteCF(d.cfh[cfDefault], []byte(migrationStateKey))
+	} else {
+		// no column is in progress
+		if buf, err = json.Marshal(migrationState{Version: version}); err != nil {
+			return err
+		}
+		wb.PutCF(d.cfh[cfDefault], []byte(migrationStateKey), buf)
+	}
+	return d.db.Write(d.wo, wb)
+}
+
+// migrateColumn converts the rows of the column after lastKey in batches
+// each batch is written together with the progress of the migration so that the migration can be resumed
+func (d *RocksDB) migrateColumn(m *migration, col int, lastKey []byte, rows int64, stop chan os.Signal) error {
+	// do not use cache
+	ro := gorocksdb.NewDefaultReadOptions()
+	ro.SetFillCache(false)
+	defer ro.Destroy()
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	for {
+		select {
+		case <-stop:
+			return ErrOperationInterrupted
+		default:
+		}
+		// the iterator is recreated for each batch so that it does not hold a snapshot of the whole column
+		it := d.db.NewIteratorCF(ro, d.cfh[col])
+		if lastKey == nil {
+			it.SeekToFirst()
+		} else {
+			it.Seek(lastKey)
+			if it.Valid() && bytes.Equal(it.Key().Data(), lastKey) {
+				it.Next()
+			}
+		}
+		count := 0
+		for ; it.Valid() && count < migrationBatchSize; it.Next() {
+			key := it.Key().Data()
+			if err := m.migrateRow(d, col, key, it.Value().Data(), wb); err != nil {
+				it.Close()
+				return errors.Annotatef(err, "column %v, key %x", cfNames[col], key)
+			}
+			lastKey = append(lastKey[:0], key...)
+			count++
+			rows++
+			if rows%migrationLogRows == 0 {
+				glog.Info("db: migration of column ", cfNames[col], ": ", rows, " rows, in progress...")
+			}
+		}
+		it.Close()
+		if count == 0 {
+			break
+		}
+		buf, err := json.Marshal(migrationState{Version: m.version, Column: cfNames[col], LastKey: lastKey, Rows: rows})
+		if err != nil {
+			return err
+		}
+		wb.PutCF(d.cfh[cfDefault], []byte(migrationStateKey), buf)
+		if err = d.db.Write(d.wo, wb); err != nil {
+			return err
+		}
+		wb.Clear()
+	}
+	glog.Info("db: migration of column ", cfNames[col], " finished, ", rows, " rows")
+	return nil
+}
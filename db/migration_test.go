@@ -0,0 +1,114 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/common"
+	"bytes"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+func TestRocksDB_Migrate(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	// more rows than in one batch to check that the migration is resumed after the last stored batch
+	const rows = 2*migrationBatchSize + 500
+	for i := 0; i < rows; i++ {
+		if err := d.db.PutCF(d.wo, d.cfh[cfFiatRates], packUint(uint32(i)), []byte{1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range d.is.DbColumns {
+		d.is.DbColumns[i].Version = dbVersion - 1
+	}
+	if err := d.StoreInternalState(d.is); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.LoadInternalState("coin-unittest"); err == nil {
+		t.Fatal("LoadInternalState() without migration: expected error")
+	}
+
+	savedMigrations := migrations
+	defer func() { migrations = savedMigrations }()
+	failAt := uint32(migrationBatchSize + 100)
+	migrations = []migration{{
+		version:     dbVersion,
+		description: "test migration",
+		columns:     []int{cfFiatRates},
+		migrateRow: func(d *RocksDB, col int, key, val []byte, wb *gorocksdb.WriteBatch) error {
+			if unpackUint(key) == failAt {
+				return errors.New("test failure")
+			}
+			wb.PutCF(d.cfh[col], key, append(append([]byte{}, val...), 2))
+			return nil
+		},
+	}}
+
+	is, err := d.LoadInternalState("coin-unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetInternalState(is)
+	if !d.MigrationNeeded() {
+		t.Fatal("MigrationNeeded() = false, want true")
+	}
+	if err := d.Migrate(nil); err == nil {
+		t.Fatal("Migrate() expected error")
+	}
+	inProgress, err := d.MigrationInProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inProgress {
+		t.Error("MigrationInProgress() = false, want true")
+	}
+
+	// resume the migration with the state loaded from the db
+	failAt = rows
+	is, err = d.LoadInternalState("coin-unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is.DbState != common.DbStateInconsistent {
+		t.Errorf("DbState = %v, want inconsistent", is.DbState)
+	}
+	d.SetInternalState(is)
+	if err := d.Migrate(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfFiatRates])
+	defer it.Close()
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if !bytes.Equal(it.Value().Data(), []byte{1, 2}) {
+			t.Fatalf("row %v: value %v, want [1 2]", unpackUint(it.Key().Data()), it.Value().Data())
+		}
+		count++
+	}
+	if count != rows {
+		t.Errorf("migrated %v rows, want %v", count, rows)
+	}
+	if inProgress, err = d.MigrationInProgress(); err != nil || inProgress {
+		t.Errorf("MigrationInProgress() = %v, %v, want false", inProgress, err)
+	}
+	is, err = d.LoadInternalState("coin-unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is.DbState != common.DbStateOpen {
+		t.Errorf("DbState = %v, want open", is.DbState)
+	}
+	for _, c := range is.DbColumns {
+		if c.Version != dbVersion {
+			t.Errorf("column %v version %v, want %v", c.Name, c.Version, dbVersion)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"bytes"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+// Pruned index
+// in the pruned (balances only) mode the db keeps the balances and utxos of all addresses but the address history
+// (column addresses) only for the last blocks, which are also kept in the column blockTxs for rollback
+// txAddresses of a transaction are necessary only until all its outputs are spent, then they are removed
+// the data are pruned when a block leaves the rollback window, the mode can be set only when the db is created
+
+// IsPruned returns true if the db keeps the address history only for the last blocks
+func (d *RocksDB) IsPruned() bool {
+	return d.is != nil && d.is.GetPrunedHistoryBlocks() > 0
+}
+
+// EnablePrunedIndex switches on the pruning of the address history, the history is kept for the last blocks
+// it is possible only for an empty db, the already indexed history would never be pruned
+func (d *RocksDB) EnablePrunedIndex(blocks int) error {
+	if d.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return errors.New("Pruned index is supported only for bitcoin type coins")
+	}
+	if blocks <= 0 {
+		return errors.New("The number of blocks with address history must be positive")
+	}
+	if n := d.is.GetPrunedHistoryBlocks(); n > 0 {
+		if n != uint32(blocks) {
+			return errors.Errorf("The db keeps the address history for %v blocks, the number cannot be changed", n)
+		}
+		return nil
+	}
+	_, hash, err := d.GetBestBlock()
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		return errors.New("Pruned index can be enabled only for a new db, it is necessary to rebuild index")
+	}
+	d.is.SetPrunedHistoryBlocks(uint32(blocks))
+	glog.Info("rocksdb: pruned index enabled, address history is kept for ", blocks, " blocks")
+	return d.StoreInternalState(d.is)
+}
+
+// BlockTxsToKeep returns the number of the last blocks kept in the column blockTxs,
+// in the pruned mode the address history is kept for the same blocks
+func (d *RocksDB) BlockTxsToKeep() uint32 {
+	keep := uint32(d.chainParser.KeepBlockAddresses())
+	if d.is != nil {
+		if n := d.is.GetPrunedHistoryBlocks(); n > keep {
+			keep = n
+		}
+	}
+	return keep
+}
+
+// AddressHistoryFromHeight returns the lowest height from which the address history is available
+// 0 means that the history is complete
+func (d *RocksDB) AddressHistoryFromHeight() (uint32, error) {
+	if !d.IsPruned() {
+		return 0, nil
+	}
+	bestHeight, _, err := d.GetBestBlock()
+	if err != nil {
+		return 0, err
+	}
+	keep := d.BlockTxsToKeep()
+	if bestHeight < keep {
+		return 0, nil
+	}
+	return bestHeight - keep + 1, nil
+}
+
+// isTxAddressesSpent returns true if no output of the transaction can be spent any more
+// outputs without address are kept as spendable, only the unspendable outputs (for example OP_RETURN) are not indexed
+func (d *RocksDB) isTxAddressesSpent(ta *TxAddresses) bool {
+	for i := range ta.Outputs {
+		o := &ta.Outputs[i]
+		if !o.Spent && (len(o.AddrDesc) == 0 || d.chainParser.IsAddrDescIndexable(o.AddrDesc)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTxAddressesPrunable returns true if the transaction is spent and all spends happened at or below height,
+// the spends in the rollback window need the txAddresses for disconnect
+func (d *RocksDB) isTxAddressesPrunable(btxID []byte, ta *TxAddresses, height uint32) (bool, error) {
+	if !d.isTxAddressesSpent(ta) {
+		return false, nil
+	}
+	for i := range ta.Outputs {
+		if !ta.Outputs[i].Spent {
+			continue
+		}
+		stx, err := d.getSpendingTx(btxID, int32(i))
+		if err != nil {
+			return false, err
+		}
+		if stx == nil || stx.Height > height {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// pruneBlock removes the address history of the block leaving the rollback window and the txAddresses of the transactions
+// which were completely spent, the candidates are the transactions of the block and the transactions spent by the block
+// the last spend of a transaction is in some block, therefore all spent transactions are eventually removed
+func (d *RocksDB) pruneBlock(wb *gorocksdb.WriteBatch, height uint32) error {
+	bt, err := d.getBlockTxs(height)
+	if err != nil {
+		return err
+	}
+	zeroTx := make([]byte, d.chainParser.PackedTxidLen())
+	addrDescs := make(map[string]struct{})
+	candidates := make(map[string]struct{})
+	for i := range bt {
+		ta, err := d.getTxAddresses(bt[i].btxID)
+		if err != nil {
+			return err
+		}
+		if ta != nil {
+			for j := range ta.Inputs {
+				addrDescs[string(ta.Inputs[j].AddrDesc)] = struct{}{}
+			}
+			for j := range ta.Outputs {
+				addrDescs[string(ta.Outputs[j].AddrDesc)] = struct{}{}
+			}
+		}
+		candidates[string(bt[i].btxID)] = struct{}{}
+		for j := range bt[i].inputs {
+			if !bytes.Equal(bt[i].inputs[j].btxID, zeroTx) {
+				candidates[string(bt[i].inputs[j].btxID)] = struct{}{}
+			}
+		}
+	}
+	for addrDesc := range addrDescs {
+		if len(addrDesc) > 0 {
+			wb.DeleteCF(d.cfh[cfAddresses], packAddressKey(bchain.AddressDescriptor(addrDesc), height))
+		}
+	}
+	for btxID := range candidates {
+		ta, err := d.getTxAddresses([]byte(btxID))
+		if err != nil {
+			return err
+		}
+		if ta == nil {
+			continue
+		}
+		prunable, err := d.isTxAddressesPrunable([]byte(btxID), ta, height)
+		if err != nil {
+			return err
+		}
+		if prunable {
+			wb.DeleteCF(d.cfh[cfTxAddresses], []byte(btxID))
+		}
+	}
+	return nil
+}
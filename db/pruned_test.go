@@ -0,0 +1,124 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/tests/dbtestdata"
+	"testing"
+
+	"github.com/tecbot/gorocksdb"
+)
+
+func TestRocksDB_PrunedIndex(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.EnablePrunedIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	if !d.IsPruned() {
+		t.Fatal("IsPruned() = false, want true")
+	}
+	if err := d.EnablePrunedIndex(2); err == nil {
+		t.Error("EnablePrunedIndex(2) with a different number of blocks: expected error")
+	}
+
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EnablePrunedIndex(1); err != nil {
+		t.Errorf("EnablePrunedIndex(1) with the same number of blocks: %v", err)
+	}
+
+	// the history of the block 225493 left the rollback window and was pruned
+	if err := checkColumn(d, cfAddresses, []keyPair{
+		{addressKeyHex(dbtestdata.Addr6, 225494, d), txIndexesHex(dbtestdata.TxidB2T2, []int32{^0}) + txIndexesHex(dbtestdata.TxidB2T1, []int32{0}), nil},
+		{addressKeyHex(dbtestdata.Addr7, 225494, d), txIndexesHex(dbtestdata.TxidB2T1, []int32{1}), nil},
+		{addressKeyHex(dbtestdata.Addr8, 225494, d), txIndexesHex(dbtestdata.TxidB2T2, []int32{0}), nil},
+		{addressKeyHex(dbtestdata.Addr9, 225494, d), txIndexesHex(dbtestdata.TxidB2T2, []int32{1}), nil},
+		{addressKeyHex(dbtestdata.Addr3, 225494, d), txIndexesHex(dbtestdata.TxidB2T1, []int32{^0}), nil},
+		{addressKeyHex(dbtestdata.Addr2, 225494, d), txIndexesHex(dbtestdata.TxidB2T1, []int32{^1}), nil},
+		{addressKeyHex(dbtestdata.Addr5, 225494, d), txIndexesHex(dbtestdata.TxidB2T3, []int32{0, ^0}), nil},
+		{addressKeyHex(dbtestdata.AddrA, 225494, d), txIndexesHex(dbtestdata.TxidB2T4, []int32{0}), nil},
+		{addressKeyHex(dbtestdata.Addr4, 225494, d), txIndexesHex(dbtestdata.TxidB2T2, []int32{^1}), nil},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	from, err := d.AddressHistoryFromHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 225494 {
+		t.Errorf("AddressHistoryFromHeight() = %v, want 225494", from)
+	}
+	// the balances are complete
+	ab, err := d.GetAddressBalance(dbtestdata.Addr1, AddressBalanceDetailUTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ab == nil || ab.Txs != 1 || len(ab.Utxos) != 1 {
+		t.Errorf("GetAddressBalance(Addr1) = %+v, want 1 tx and 1 utxo", ab)
+	}
+	// TxidB1T2 is completely spent in the block 225494, which is still in the rollback window
+	ta, err := d.GetTxAddresses(dbtestdata.TxidB1T2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta == nil {
+		t.Fatal("GetTxAddresses(TxidB1T2) = nil, the spent tx was pruned inside the rollback window")
+	}
+
+	// simulate that the block 225494 leaves the rollback window
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	if err := d.pruneBlock(wb, 225494); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.db.Write(d.wo, wb); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkColumn(d, cfAddresses, []keyPair{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		txid   string
+		pruned bool
+	}{
+		{dbtestdata.TxidB1T1, false},
+		{dbtestdata.TxidB1T2, true},
+		{dbtestdata.TxidB2T1, false},
+		{dbtestdata.TxidB2T2, false},
+		{dbtestdata.TxidB2T3, false},
+		{dbtestdata.TxidB2T4, false},
+	} {
+		ta, err := d.GetTxAddresses(tc.txid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ta == nil) != tc.pruned {
+			t.Errorf("GetTxAddresses(%v) = %+v, pruned %v", tc.txid, ta, tc.pruned)
+		}
+	}
+}
+
+func TestRocksDB_EnablePrunedIndexNotEmpty(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EnablePrunedIndex(10); err == nil {
+		t.Error("EnablePrunedIndex() on a db with blocks: expected error")
+	}
+	if d.IsPruned() {
+		t.Error("IsPruned() = true, want false")
+	}
+}
@@ -0,0 +1,440 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+// Reindex of a block range
+// the data of the blocks in the range are disconnected and the blocks are connected again from the backend, typically after
+// a fix of the parser, the rest of the index is kept; the rollback data of the blocks are reconstructed from the backend blocks,
+// therefore also the blocks below the rollback window can be reindexed
+// the outputs of the reindexed blocks spent by later blocks are found using the spending index, these spends are moved
+// to the new address descriptors of the outputs - in the balances, in the address history and in the inputs of the spending txs
+// the block filters are computed from the scripts and are not changed
+// the range is processed in chunks of blocks, the db is in the inconsistent state during the processing of a chunk
+
+// number of blocks reindexed in one step
+const reindexChunkSize = 100
+
+// laterSpend is an output of a reindexed block spent by a block above the reindexed blocks
+type laterSpend struct {
+	btxID  []byte
+	vout   int32
+	height uint32
+	stx    *SpendingTx
+}
+
+// ReindexBlocks disconnects the blocks in the height range and connects them again using the blocks from the backend
+func (d *RocksDB) ReindexBlocks(from, to uint32, chain bchain.BlockChain, stop chan os.Signal) error {
+	if d.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return errors.New("Reindex is supported only for bitcoin type coins")
+	}
+	if d.IsPruned() {
+		return errors.New("Reindex is not possible in the pruned mode, the address history of the blocks was removed")
+	}
+	if h := d.is.GetSpendingIndexFromHeight(); h > from {
+		return errors.Errorf("The spending index is complete only from height %v, it must be built first by -buildspendingindex", h)
+	}
+	bestHeight, _, err := d.GetBestBlock()
+	if err != nil {
+		return err
+	}
+	if from > to || to > bestHeight {
+		return errors.Errorf("Invalid range %v-%v, the best indexed block is %v", from, to, bestHeight)
+	}
+	start := time.Now()
+	glog.Info("reindex: start of reindex of blocks ", from, "-", to)
+	for lower := from; ; {
+		select {
+		case <-stop:
+			glog.Info("reindex: interrupted, blocks ", lower, "-", to, " were not reindexed")
+			return ErrOperationInterrupted
+		default:
+		}
+		higher := to
+		if higher-lower >= reindexChunkSize {
+			higher = lower + reindexChunkSize - 1
+		}
+		if err := d.reindexBlocksChunk(lower, higher, chain); err != nil {
+			return err
+		}
+		glog.Info("reindex: blocks ", lower, "-", higher, " reindexed")
+		if higher == to {
+			break
+		}
+		lower = higher + 1
+	}
+	glog.Info("reindex: finished in ", time.Since(start))
+	return nil
+}
+
+func (d *RocksDB) reindexBlocksChunk(lower, higher uint32, chain bchain.BlockChain) error {
+	blocks := make([]*bchain.Block, higher-lower+1)
+	bt := make([][]blockTxs, higher-lower+1)
+	for height := lower; height <= higher; height++ {
+		hash, err := d.GetBlockHash(height)
+		if err != nil {
+			return err
+		}
+		if hash == "" {
+			return errors.Errorf("Block %v is not indexed", height)
+		}
+		// the block is fetched by the indexed hash, the reindex does not handle a fork of the chain
+		block, err := chain.GetBlock(hash, height)
+		if err != nil {
+			return errors.Annotatef(err, "GetBlock %v %v", height, hash)
+		}
+		if block.Hash != hash {
+			return errors.Errorf("Block %v from the backend has hash %v, indexed hash is %v", height, block.Hash, hash)
+		}
+		// the height is not always set by the backend
+		block.Height = height
+		blocks[height-lower] = block
+		if bt[height-lower], err = blockTxsFromBlock(d.chainParser, block); err != nil {
+			return err
+		}
+	}
+	spends, err := d.getLaterSpends(bt, higher)
+	if err != nil {
+		return err
+	}
+	if err = d.SetInconsistentState(true); err != nil {
+		return err
+	}
+	if err = d.disconnectReindexedBlocks(lower, higher, bt, spends); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err = d.connectBlock(block, true); err != nil {
+			return err
+		}
+	}
+	if err = d.respendLaterSpends(spends); err != nil {
+		return err
+	}
+	return d.SetInconsistentState(false)
+}
+
+// getLaterSpends returns the outputs of the blocks spent by the blocks above the height higher
+func (d *RocksDB) getLaterSpends(bt [][]blockTxs, higher uint32) ([]laterSpend, error) {
+	var spends []laterSpend
+	for _, b := range bt {
+		for i := range b {
+			btxID := b[i].btxID
+			ta, err := d.getTxAddresses(btxID)
+			if err != nil {
+				return nil, err
+			}
+			if ta == nil {
+				ut, _ := d.chainParser.UnpackTxid(btxID)
+				return nil, errors.Errorf("TxAddresses of tx %v not found, the index is inconsistent", ut)
+			}
+			for vout := range ta.Outputs {
+				if !ta.Outputs[vout].Spent {
+					continue
+				}
+				stx, err := d.getSpendingTx(btxID, int32(vout))
+				if err != nil {
+					return nil, err
+				}
+				if stx == nil {
+					ut, _ := d.chainParser.UnpackTxid(btxID)
+					return nil, errors.Errorf("Spending tx of output %v:%v not found in the spending index", ut, vout)
+				}
+				if stx.Height <= higher {
+					continue
+				}
+				sta, err := d.getTxAddresses(stx.BtxID)
+				if err != nil {
+					return nil, err
+				}
+				if sta == nil || int(stx.Index) >= len(sta.Inputs) {
+					ut, _ := d.chainParser.UnpackTxid(stx.BtxID)
+					return nil, errors.Errorf("TxAddresses of spending tx %v not found or without input %v, the index is inconsistent", ut, stx.Index)
+				}
+				spends = append(spends, laterSpend{
+					btxID:  btxID,
+					vout:   int32(vout),
+					height: ta.Height,
+					stx:    stx,
+				})
+			}
+		}
+	}
+	return spends, nil
+}
+
+// disconnectReindexedBlocks disconnects the blocks, the later spends of their outputs are first reverted,
+// so that the outputs are disconnected as unspent
+func (d *RocksDB) disconnectReindexedBlocks(lower, higher uint32, bt [][]blockTxs, spends []laterSpend) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	txAddressesToUpdate := make(map[string]*TxAddresses)
+	balances := make(map[string]*AddrBalance)
+	rows := make(map[string][]txIndexes)
+	for i := range spends {
+		ls := &spends[i]
+		sta, err := d.getTxAddressesToUpdate(ls.stx.BtxID, txAddressesToUpdate)
+		if err != nil {
+			return err
+		}
+		input := &sta.Inputs[ls.stx.Index]
+		if len(input.AddrDesc) == 0 || !d.isAddrDescIndexable(input.AddrDesc) {
+			continue
+		}
+		balance, err := d.getBalanceToUpdate(input.AddrDesc, balances)
+		if err != nil {
+			return err
+		}
+		if balance == nil {
+			ad, _, _ := d.chainParser.GetAddressesFromAddrDesc(input.AddrDesc)
+			glog.Warningf("Balance for address %s (%s) not found", ad, input.AddrDesc)
+			continue
+		}
+		balance.SentSat.Sub(&balance.SentSat, &input.ValueSat)
+		if balance.SentSat.Sign() < 0 {
+			d.resetValueSatToZero(&balance.SentSat, input.AddrDesc, "sent amount")
+		}
+		balance.BalanceSat.Add(&balance.BalanceSat, &input.ValueSat)
+		balance.Utxos = append(balance.Utxos, Utxo{
+			BtxID:    ls.btxID,
+			Vout:     ls.vout,
+			Height:   ls.height,
+			ValueSat: input.ValueSat,
+		})
+		removed, err := d.removeFromAddressRow(rows, input.AddrDesc, ls.stx)
+		if err != nil {
+			return err
+		}
+		if removed {
+			balance.Txs--
+		}
+	}
+	d.storeAddressRows(wb, rows)
+	if err := d.disconnectBlocksBitcoinType(wb, lower, higher, bt, txAddressesToUpdate, balances); err != nil {
+		return err
+	}
+	return d.db.Write(d.wo, wb)
+}
+
+// respendLaterSpends marks the outputs of the connected blocks spent by the later blocks as spent
+// and moves the spends to the address descriptors of the outputs
+func (d *RocksDB) respendLaterSpends(spends []laterSpend) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	txAddressesToUpdate := make(map[string]*TxAddresses)
+	balances := make(map[string]*AddrBalance)
+	rows := make(map[string][]txIndexes)
+	for i := range spends {
+		ls := &spends[i]
+		ta, err := d.getTxAddressesToUpdate(ls.btxID, txAddressesToUpdate)
+		if err != nil {
+			return err
+		}
+		if int(ls.vout) >= len(ta.Outputs) {
+			return errors.Errorf("Output %v:%v not found in the reindexed block", hex.EncodeToString(ls.btxID), ls.vout)
+		}
+		sta, err := d.getTxAddressesToUpdate(ls.stx.BtxID, txAddressesToUpdate)
+		if err != nil {
+			return err
+		}
+		output := &ta.Outputs[ls.vout]
+		output.Spent = true
+		input := &sta.Inputs[ls.stx.Index]
+		input.AddrDesc = output.AddrDesc
+		input.ValueSat = output.ValueSat
+		// the cached tx of the spending tx may contain the addresses of the input
+		wb.DeleteCF(d.cfh[cfTransactions], ls.stx.BtxID)
+		if len(output.AddrDesc) == 0 || !d.isAddrDescIndexable(output.AddrDesc) {
+			continue
+		}
+		balance, err := d.getBalanceToUpdate(output.AddrDesc, balances)
+		if err != nil {
+			return err
+		}
+		if balance == nil {
+			ad, _, _ := d.chainParser.GetAddressesFromAddrDesc(output.AddrDesc)
+			glog.Warningf("Balance for address %s (%s) not found", ad, output.AddrDesc)
+			continue
+		}
+		balance.BalanceSat.Sub(&balance.BalanceSat, &output.ValueSat)
+		if balance.BalanceSat.Sign() < 0 {
+			d.resetValueSatToZero(&balance.BalanceSat, output.AddrDesc, "balance")
+		}
+		balance.SentSat.Add(&balance.SentSat, &output.ValueSat)
+		balance.markUtxoAsSpent(ls.btxID, ls.vout)
+		added, err := d.addToAddressRow(rows, output.AddrDesc, ls.stx)
+		if err != nil {
+			return err
+		}
+		if added {
+			balance.Txs++
+		}
+	}
+	d.storeAddressRows(wb, rows)
+	if err := d.storeTxAddresses(wb, txAddressesToUpdate); err != nil {
+		return err
+	}
+	if err := d.storeBalances(wb, balances); err != nil {
+		return err
+	}
+	return d.db.Write(d.wo, wb)
+}
+
+func (d *RocksDB) getTxAddressesToUpdate(btxID []byte, txAddressesToUpdate map[string]*TxAddresses) (*TxAddresses, error) {
+	s := string(btxID)
+	ta, found := txAddressesToUpdate[s]
+	if !found {
+		var err error
+		ta, err = d.getTxAddresses(btxID)
+		if err != nil {
+			return nil, err
+		}
+		if ta == nil {
+			ut, _ := d.chainParser.UnpackTxid(btxID)
+			return nil, errors.Errorf("TxAddresses of tx %v not found", ut)
+		}
+		txAddressesToUpdate[s] = ta
+	}
+	return ta, nil
+}
+
+func (d *RocksDB) getBalanceToUpdate(addrDesc bchain.AddressDescriptor, balances map[string]*AddrBalance) (*AddrBalance, error) {
+	s := string(addrDesc)
+	balance, found := balances[s]
+	if !found {
+		var err error
+		balance, err = d.GetAddrDescBalance(addrDesc, addressBalanceDetailUTXOIndexed)
+		if err != nil {
+			return nil, err
+		}
+		balances[s] = balance
+	}
+	return balance, nil
+}
+
+// getAddressRow returns the transactions of the address in the block, the rows are cached in the map rows
+func (d *RocksDB) getAddressRow(rows map[string][]txIndexes, addrDesc bchain.AddressDescriptor, height uint32) (string, []txIndexes, error) {
+	key := string(packAddressKey(addrDesc, height))
+	if row, found := rows[key]; found {
+		return key, row, nil
+	}
+	val, err := d.db.GetCF(d.ro, d.cfh[cfAddresses], []byte(key))
+	if err != nil {
+		return "", nil, err
+	}
+	defer val.Free()
+	row, err := d.unpackTxIndexes(val.Data())
+	if err != nil {
+		return "", nil, err
+	}
+	rows[key] = row
+	return key, row, nil
+}
+
+// removeFromAddressRow removes the input of the spending tx from the address history,
+// returns true if the tx was removed from the history of the address
+func (d *RocksDB) removeFromAddressRow(rows map[string][]txIndexes, addrDesc bchain.AddressDescriptor, stx *SpendingTx) (bool, error) {
+	key, row, err := d.getAddressRow(rows, addrDesc, stx.Height)
+	if err != nil {
+		return false, err
+	}
+	for i := range row {
+		if bytes.Equal(row[i].btxID, stx.BtxID) {
+			indexes := make([]int32, 0, len(row[i].indexes))
+			for _, index := range row[i].indexes {
+				if index != ^stx.Index {
+					indexes = append(indexes, index)
+				}
+			}
+			if len(indexes) > 0 {
+				row[i].indexes = indexes
+				return false, nil
+			}
+			rows[key] = append(row[:i], row[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addToAddressRow adds the input of the spending tx to the address history,
+// returns true if the tx was added to the history of the address
+func (d *RocksDB) addToAddressRow(rows map[string][]txIndexes, addrDesc bchain.AddressDescriptor, stx *SpendingTx) (bool, error) {
+	key, row, err := d.getAddressRow(rows, addrDesc, stx.Height)
+	if err != nil {
+		return false, err
+	}
+	for i := range row {
+		if bytes.Equal(row[i].btxID, stx.BtxID) {
+			indexes := append(row[i].indexes, ^stx.Index)
+			// keep the order of the connect, outputs first and then inputs
+			sort.SliceStable(indexes, func(a, b int) bool {
+				ia, ib := indexes[a], indexes[b]
+				if (ia < 0) != (ib < 0) {
+					return ia >= 0
+				}
+				if ia >= 0 {
+					return ia < ib
+				}
+				return ia > ib
+			})
+			row[i].indexes = indexes
+			return false, nil
+		}
+	}
+	rows[key] = append(row, txIndexes{
+		btxID:   stx.BtxID,
+		indexes: []int32{^stx.Index},
+	})
+	return true, nil
+}
+
+func (d *RocksDB) storeAddressRows(wb *gorocksdb.WriteBatch, rows map[string][]txIndexes) {
+	for key, row := range rows {
+		if len(row) == 0 {
+			wb.DeleteCF(d.cfh[cfAddresses], []byte(key))
+		} else {
+			wb.PutCF(d.cfh[cfAddresses], []byte(key), d.packTxIndexes(row))
+		}
+	}
+}
+
+// unpackTxIndexes unpacks the row of the column addresses, the txs are returned in the order of the block
+func (d *RocksDB) unpackTxIndexes(buf []byte) ([]txIndexes, error) {
+	pl := d.chainParser.PackedTxidLen()
+	var row []txIndexes
+	for len(buf) > 0 {
+		if len(buf) <= pl {
+			return nil, errors.New("Inconsistent data in addresses")
+		}
+		t := txIndexes{btxID: append([]byte(nil), buf[:pl]...)}
+		buf = buf[pl:]
+		for {
+			if len(buf) == 0 {
+				return nil, errors.New("Inconsistent data in addresses")
+			}
+			index, l := unpackVarint32(buf)
+			t.indexes = append(t.indexes, index>>1)
+			buf = buf[l:]
+			if index&1 == 1 {
+				break
+			}
+		}
+		row = append(row, t)
+	}
+	// the txs are stored in reverse order
+	for i, j := 0, len(row)-1; i < j; i, j = i+1, j-1 {
+		row[i], row[j] = row[j], row[i]
+	}
+	return row, nil
+}
@@ -0,0 +1,83 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/tests/dbtestdata"
+	"bytes"
+	"testing"
+)
+
+// testSkipAddrParser simulates a parser bug, the outputs with the address descriptor skip are not indexed
+type testSkipAddrParser struct {
+	*testBitcoinParser
+	skip bchain.AddressDescriptor
+}
+
+func (p *testSkipAddrParser) GetAddrDescFromVout(output *bchain.Vout) (bchain.AddressDescriptor, error) {
+	addrDesc, err := p.testBitcoinParser.GetAddrDescFromVout(output)
+	if err == nil && bytes.Equal(addrDesc, p.skip) {
+		return nil, bchain.ErrAddressMissing
+	}
+	return addrDesc, err
+}
+
+func TestRocksDB_ReindexBlocks(t *testing.T) {
+	parser := &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	}
+	d := setupRocksDB(t, parser)
+	defer closeAndDestroyRocksDB(t, d)
+
+	skip, err := parser.GetAddrDescFromAddress(dbtestdata.Addr3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.chainParser = &testSkipAddrParser{testBitcoinParser: parser, skip: skip}
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	ab, err := d.GetAddressBalance(dbtestdata.Addr3, AddressBalanceDetailNoUTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ab != nil {
+		t.Fatalf("GetAddressBalance(Addr3) = %+v, the output should not be indexed", ab)
+	}
+
+	chain, err := dbtestdata.NewFakeBlockChain(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.chainParser = parser
+	for _, r := range [][2]uint32{{225494, 225493}, {225493, 225495}} {
+		if err := d.ReindexBlocks(r[0], r[1], chain, nil); err == nil {
+			t.Errorf("ReindexBlocks(%v, %v): expected error", r[0], r[1])
+		}
+	}
+
+	// the block 225493 is below the rollback window, its output to Addr3 is spent in the block 225494
+	if err := d.ReindexBlocks(225493, 225493, chain, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the reindex of a range below the tip does not change the best height
+	if d.is.BestHeight != 225494 {
+		t.Errorf("is.BestHeight = %v, want 225494", d.is.BestHeight)
+	}
+	verifyAfterBitcoinTypeBlock2(t, d)
+	r, err := d.VerifyDB(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyNoMismatches(t, "VerifyDB after reindex", r)
+
+	// reindex of the whole index does not change it
+	if err := d.ReindexBlocks(225493, 225494, chain, nil); err != nil {
+		t.Fatal(err)
+	}
+	verifyAfterBitcoinTypeBlock2(t, d)
+}
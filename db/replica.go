@@ -0,0 +1,131 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/common"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+/*
+	The replica opens the database of the primary in the read-only mode, which sees the data as they were at the time of opening.
+	To follow the primary, the replica reopens the database whenever the primary changes its files.
+	The readers may still use the handles of the previous opening, they are therefore closed only at the next reopening.
+*/
+
+// replicaState keeps the handles of the previous opening of the replica and the time of the last change of the primary's files
+type replicaState struct {
+	modified time.Time
+	prevDB   *gorocksdb.DB
+	prevCfh  []*gorocksdb.ColumnFamilyHandle
+}
+
+func (rs *replicaState) closePrev() {
+	if rs.prevDB != nil {
+		for _, h := range rs.prevCfh {
+			h.Destroy()
+		}
+		rs.prevDB.Close()
+		rs.prevDB, rs.prevCfh = nil, nil
+	}
+}
+
+func openDBReadOnly(path string, c *gorocksdb.Cache) (*gorocksdb.DB, []*gorocksdb.ColumnFamilyHandle, error) {
+	// the replica must keep all files open, otherwise it could try to open a file already deleted by the primary
+	opts, cfOptions := createColumnFamilyOptions(c, -1)
+	return gorocksdb.OpenDbForReadOnlyColumnFamilies(opts, path, cfNames, cfOptions, false)
+}
+
+// lastModified returns the time of the last change of the files written by the primary on every change of the database,
+// the table files and the info logs are skipped, a new table file is always accompanied by a change of the manifest
+func lastModified(path string) (time.Time, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var t time.Time
+	for _, f := range files {
+		n := f.Name()
+		if strings.HasSuffix(n, ".sst") || strings.HasPrefix(n, "LOG") {
+			continue
+		}
+		if f.ModTime().After(t) {
+			t = f.ModTime()
+		}
+	}
+	return t, nil
+}
+
+// NewRocksDBReplica opens the database in path as a read-only replica of the database synchronized by another Blockbook process (the primary)
+// the replica does not own the internal state, it is loaded from the db by CatchUpWithPrimary
+func NewRocksDBReplica(path string, cacheSize int, parser bchain.BlockChainParser, metrics *common.Metrics) (*RocksDB, error) {
+	glog.Infof("rocksdb: opening %s as read-only replica, required data version %v, cache size %v", path, dbVersion, cacheSize)
+	if err := setColumnFamilyNames(parser); err != nil {
+		return nil, err
+	}
+	modified, err := lastModified(path)
+	if err != nil {
+		return nil, err
+	}
+	c := gorocksdb.NewLRUCache(cacheSize)
+	db, cfh, err := openDBReadOnly(path, c)
+	if err != nil {
+		return nil, err
+	}
+	wo := gorocksdb.NewDefaultWriteOptions()
+	ro := gorocksdb.NewDefaultReadOptions()
+	return &RocksDB{path, db, wo, ro, cfh, parser, nil, metrics, c, -1, connectBlockStats{}, &replicaState{modified: modified}}, nil
+}
+
+// IsReplica returns true if the db is opened as a read-only replica
+func (d *RocksDB) IsReplica() bool {
+	return d.replica != nil
+}
+
+// reopenReplica reopens the replica if the primary changed the database since the last opening
+func (d *RocksDB) reopenReplica() error {
+	modified, err := lastModified(d.path)
+	if err != nil {
+		return err
+	}
+	if modified.Equal(d.replica.modified) {
+		return nil
+	}
+	db, cfh, err := openDBReadOnly(d.path, d.cache)
+	if err != nil {
+		return err
+	}
+	d.replica.closePrev()
+	d.replica.prevDB, d.replica.prevCfh = d.db, d.cfh
+	d.db, d.cfh = db, cfh
+	d.replica.modified = modified
+	return nil
+}
+
+// CatchUpWithPrimary reopens the replica to see the changes made by the primary and updates the internal state
+// by the state stored by the primary, returns the best block of the replica
+func (d *RocksDB) CatchUpWithPrimary() (uint32, string, error) {
+	if d.replica == nil {
+		return 0, "", errors.New("The database is not a replica")
+	}
+	if err := d.reopenReplica(); err != nil {
+		return 0, "", err
+	}
+	height, hash, err := d.GetBestBlock()
+	if err != nil {
+		return 0, "", err
+	}
+	if d.is != nil {
+		primary, err := d.loadStoredInternalState()
+		if err != nil {
+			return 0, "", err
+		}
+		d.is.SetPrimaryState(primary, height)
+	}
+	return height, hash, nil
+}
@@ -0,0 +1,62 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/tests/dbtestdata"
+	"testing"
+)
+
+func TestRocksDB_Replica(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StoreInternalState(d.is); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRocksDBReplica(d.path, 100000, d.chainParser, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	is, err := r.LoadInternalState("coin-unittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetInternalState(is)
+
+	height, hash, err := r.CatchUpWithPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 225493 || hash != "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997" {
+		t.Errorf("CatchUpWithPrimary() = %v, %v", height, hash)
+	}
+
+	// the block connected by the primary is visible after the next catch up
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	height, hash, err = r.CatchUpWithPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 225494 || hash != "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6" {
+		t.Errorf("CatchUpWithPrimary() = %v, %v", height, hash)
+	}
+	if synchronized, bestHeight, _ := is.GetSyncState(); !synchronized || bestHeight != 225494 {
+		t.Errorf("GetSyncState() = %v, %v, want true, 225494", synchronized, bestHeight)
+	}
+	verifyAfterBitcoinTypeBlock2(t, r)
+
+	// the replica cannot write
+	if err := r.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err == nil {
+		t.Error("ConnectBlock() on replica: expected error")
+	}
+}
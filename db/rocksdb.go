@@ -4,8 +4,10 @@ import (
 	"blockbook/bchain"
 	"blockbook/common"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"os"
@@ -69,6 +71,7 @@ type RocksDB struct {
 	cache        *gorocksdb.Cache
 	maxOpenFiles int
 	cbs          connectBlockStats
+	replica      *replicaState
 }
 
 const (
@@ -77,34 +80,46 @@ const (
 	cfAddresses
 	cfBlockTxs
 	cfTransactions
+	cfFiatRates
+	cfWebhooks
+	cfWebhookOutbox
+	cfBlockHeaders
 	// BitcoinType
 	cfAddressBalance
 	cfTxAddresses
+	cfSpending
+	cfScripthash
+	cfBlockFilters
 	// EthereumType
 	cfAddressContracts = cfAddressBalance
 )
 
 // common columns
 var cfNames []string
-var cfBaseNames = []string{"default", "height", "addresses", "blockTxs", "transactions"}
+var cfBaseNames = []string{"default", "height", "addresses", "blockTxs", "transactions", "fiatRates", "webhooks", "webhookOutbox", "blockHeaders"}
 
 // type specific columns
-var cfNamesBitcoinType = []string{"addressBalance", "txAddresses"}
+var cfNamesBitcoinType = []string{"addressBalance", "txAddresses", "spending", "scripthash", "blockFilters"}
 var cfNamesEthereumType = []string{"addressContracts"}
 
-func openDB(path string, c *gorocksdb.Cache, openFiles int) (*gorocksdb.DB, []*gorocksdb.ColumnFamilyHandle, error) {
+func createColumnFamilyOptions(c *gorocksdb.Cache, openFiles int) (*gorocksdb.Options, []*gorocksdb.Options) {
 	// opts with bloom filter
 	opts := createAndSetDBOptions(10, c, openFiles)
 	// opts for addresses without bloom filter
 	// from documentation: if most of your queries are executed using iterators, you shouldn't set bloom filter
 	optsAddresses := createAndSetDBOptions(0, c, openFiles)
-	// default, height, addresses, blockTxids, transactions
-	cfOptions := []*gorocksdb.Options{opts, opts, optsAddresses, opts, opts}
+	// default, height, addresses, blockTxids, transactions, fiatRates, webhooks, webhookOutbox, blockHeaders
+	cfOptions := []*gorocksdb.Options{opts, opts, optsAddresses, opts, opts, opts, opts, opts, opts}
 	// append type specific options
 	count := len(cfNames) - len(cfOptions)
 	for i := 0; i < count; i++ {
 		cfOptions = append(cfOptions, opts)
 	}
+	return opts, cfOptions
+}
+
+func openDB(path string, c *gorocksdb.Cache, openFiles int) (*gorocksdb.DB, []*gorocksdb.ColumnFamilyHandle, error) {
+	opts, cfOptions := createColumnFamilyOptions(c, openFiles)
 	db, cfh, err := gorocksdb.OpenDbColumnFamilies(opts, path, cfNames, cfOptions)
 	if err != nil {
 		return nil, nil, err
@@ -112,11 +127,7 @@ func openDB(path string, c *gorocksdb.Cache, openFiles int) (*gorocksdb.DB, []*g
 	return db, cfh, nil
 }
 
-// NewRocksDB opens an internal handle to RocksDB environment.  Close
-// needs to be called to release it.
-func NewRocksDB(path string, cacheSize, maxOpenFiles int, parser bchain.BlockChainParser, metrics *common.Metrics) (d *RocksDB, err error) {
-	glog.Infof("rocksdb: opening %s, required data version %v, cache size %v, max open files %v", path, dbVersion, cacheSize, maxOpenFiles)
-
+func setColumnFamilyNames(parser bchain.BlockChainParser) error {
 	cfNames = append([]string{}, cfBaseNames...)
 	chainType := parser.GetChainType()
 	if chainType == bchain.ChainBitcoinType {
@@ -124,7 +135,18 @@ func NewRocksDB(path string, cacheSize, maxOpenFiles int, parser bchain.BlockCha
 	} else if chainType == bchain.ChainEthereumType {
 		cfNames = append(cfNames, cfNamesEthereumType...)
 	} else {
-		return nil, errors.New("Unknown chain type")
+		return errors.New("Unknown chain type")
+	}
+	return nil
+}
+
+// NewRocksDB opens an internal handle to RocksDB environment.  Close
+// needs to be called to release it.
+func NewRocksDB(path string, cacheSize, maxOpenFiles int, parser bchain.BlockChainParser, metrics *common.Metrics) (d *RocksDB, err error) {
+	glog.Infof("rocksdb: opening %s, required data version %v, cache size %v, max open files %v", path, dbVersion, cacheSize, maxOpenFiles)
+
+	if err = setColumnFamilyNames(parser); err != nil {
+		return nil, err
 	}
 
 	c := gorocksdb.NewLRUCache(cacheSize)
@@ -134,7 +156,7 @@ func NewRocksDB(path string, cacheSize, maxOpenFiles int, parser bchain.BlockCha
 	}
 	wo := gorocksdb.NewDefaultWriteOptions()
 	ro := gorocksdb.NewDefaultReadOptions()
-	return &RocksDB{path, db, wo, ro, cfh, parser, nil, metrics, c, maxOpenFiles, connectBlockStats{}}, nil
+	return &RocksDB{path, db, wo, ro, cfh, parser, nil, metrics, c, maxOpenFiles, connectBlockStats{}, nil}, nil
 }
 
 func (d *RocksDB) closeDB() error {
@@ -149,14 +171,17 @@ func (d *RocksDB) closeDB() error {
 // Close releases the RocksDB environment opened in NewRocksDB.
 func (d *RocksDB) Close() error {
 	if d.db != nil {
-		// store the internal state of the app
-		if d.is != nil && d.is.DbState == common.DbStateOpen {
+		// store the internal state of the app, the replica does not own the internal state
+		if d.is != nil && d.is.DbState == common.DbStateOpen && d.replica == nil {
 			d.is.DbState = common.DbStateClosed
 			if err := d.StoreInternalState(d.is); err != nil {
 				glog.Info("internalState: ", err)
 			}
 		}
 		glog.Infof("rocksdb: close")
+		if d.replica != nil {
+			d.replica.closePrev()
+		}
 		d.closeDB()
 		d.wo.Destroy()
 		d.ro.Destroy()
@@ -298,10 +323,18 @@ func (d *RocksDB) GetAddrDescTransactions(addrDesc bchain.AddressDescriptor, low
 const (
 	opInsert = 0
 	opDelete = 1
+	// opReplace rewrites the block info of a reindexed block, the best height is not changed
+	opReplace = 2
 )
 
 // ConnectBlock indexes addresses in the block and stores them in db
 func (d *RocksDB) ConnectBlock(block *bchain.Block) error {
+	return d.connectBlock(block, false)
+}
+
+// connectBlock indexes the block, in the reindex mode the block filter and the rollback data of the block are not stored,
+// the block filter is kept from the original indexing and the rollback data are handled by the reindex
+func (d *RocksDB) connectBlock(block *bchain.Block, reindex bool) error {
 	wb := gorocksdb.NewWriteBatch()
 	defer wb.Destroy()
 
@@ -311,25 +344,51 @@ func (d *RocksDB) ConnectBlock(block *bchain.Block) error {
 
 	chainType := d.chainParser.GetChainType()
 
-	if err := d.writeHeightFromBlock(wb, block, opInsert); err != nil {
+	op := opInsert
+	if reindex {
+		op = opReplace
+	}
+	if err := d.writeHeightFromBlock(wb, block, op); err != nil {
 		return err
 	}
+	d.storeBlockHeader(wb, block.Height, block.HeaderRaw)
 	addresses := make(addressesMap)
 	if chainType == bchain.ChainBitcoinType {
 		txAddressesMap := make(map[string]*TxAddresses)
 		balances := make(map[string]*AddrBalance)
-		if err := d.processAddressesBitcoinType(block, addresses, txAddressesMap, balances); err != nil {
+		spending := make(spendingMap)
+		scripthashes := make(scripthashMap)
+		if err := d.processAddressesBitcoinType(block, addresses, txAddressesMap, balances, spending, scripthashes); err != nil {
 			return err
 		}
 		if err := d.storeTxAddresses(wb, txAddressesMap); err != nil {
 			return err
 		}
-		if err := d.storeBalances(wb, balances); err != nil {
+		if err := d.storeSpending(wb, spending); err != nil {
 			return err
 		}
-		if err := d.storeAndCleanupBlockTxs(wb, block); err != nil {
+		if err := d.storeScripthashes(wb, scripthashes); err != nil {
 			return err
 		}
+		if d.IsBlockFilterIndexEnabled() && !reindex {
+			prevHeader, err := d.getPrevBlockFilterHeader(block.Height)
+			if err != nil {
+				return err
+			}
+			bf, err := d.computeBlockFilter(block, txAddressesMap, prevHeader)
+			if err != nil {
+				return err
+			}
+			d.storeBlockFilter(wb, block.Height, bf)
+		}
+		if err := d.storeBalances(wb, balances); err != nil {
+			return err
+		}
+		if !reindex {
+			if err := d.storeAndCleanupBlockTxs(wb, block); err != nil {
+				return err
+			}
+		}
 	} else if chainType == bchain.ChainEthereumType {
 		addressContracts := make(map[string]*AddrContracts)
 		blockTxs, err := d.processAddressesEthereumType(block, addresses, addressContracts)
@@ -352,6 +411,71 @@ func (d *RocksDB) ConnectBlock(block *bchain.Block) error {
 	return d.db.Write(d.wo, wb)
 }
 
+// Fiat rates
+
+// FiatRatesTimeFormat is a format string for storing FiatRates timestamps in rocksdb
+const FiatRatesTimeFormat = "20060102150405" // YYYYMMDDhhmmss
+
+// CurrencyRatesTicker contains coin ticker data fetched from API
+type CurrencyRatesTicker struct {
+	Timestamp *time.Time // return as unix timestamp in API
+	Rates     map[string]float64
+}
+
+func packTimestamp(t *time.Time) []byte {
+	return []byte(t.UTC().Format(FiatRatesTimeFormat))
+}
+
+func unpackFiatRatesTicker(key, val []byte) (*CurrencyRatesTicker, error) {
+	timestamp, err := time.Parse(FiatRatesTimeFormat, string(key))
+	if err != nil {
+		return nil, err
+	}
+	ticker := &CurrencyRatesTicker{Timestamp: &timestamp}
+	if err := json.Unmarshal(val, &ticker.Rates); err != nil {
+		return nil, err
+	}
+	return ticker, nil
+}
+
+// FiatRatesStoreTicker stores ticker data at the specified time
+func (d *RocksDB) FiatRatesStoreTicker(ticker *CurrencyRatesTicker) error {
+	if len(ticker.Rates) == 0 {
+		return errors.New("Error storing ticker: empty rates")
+	} else if ticker.Timestamp == nil {
+		return errors.New("Error storing ticker: empty timestamp")
+	}
+	ratesMarshalled, err := json.Marshal(ticker.Rates)
+	if err != nil {
+		glog.Error("Error marshalling ticker rates: ", err)
+		return err
+	}
+	return d.db.PutCF(d.wo, d.cfh[cfFiatRates], packTimestamp(ticker.Timestamp), ratesMarshalled)
+}
+
+// FiatRatesFindTicker gets FiatRates data closest to the specified timestamp, i.e. the first ticker at or after the timestamp
+// returns nil if there is no such ticker
+func (d *RocksDB) FiatRatesFindTicker(tickerTime *time.Time) (*CurrencyRatesTicker, error) {
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfFiatRates])
+	defer it.Close()
+	it.Seek(packTimestamp(tickerTime))
+	if it.Valid() {
+		return unpackFiatRatesTicker(it.Key().Data(), it.Value().Data())
+	}
+	return nil, nil
+}
+
+// FiatRatesFindLastTicker gets the last FiatRates record or nil if there is no ticker
+func (d *RocksDB) FiatRatesFindLastTicker() (*CurrencyRatesTicker, error) {
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfFiatRates])
+	defer it.Close()
+	it.SeekToLast()
+	if it.Valid() {
+		return unpackFiatRatesTicker(it.Key().Data(), it.Value().Data())
+	}
+	return nil, nil
+}
+
 // Addresses index
 
 type txIndexes struct {
@@ -499,7 +623,7 @@ func (d *RocksDB) GetAndResetConnectBlockStats() string {
 	return s
 }
 
-func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses addressesMap, txAddressesMap map[string]*TxAddresses, balances map[string]*AddrBalance) error {
+func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses addressesMap, txAddressesMap map[string]*TxAddresses, balances map[string]*AddrBalance, spending spendingMap, scripthashes scripthashMap) error {
 	blockTxIDs := make([][]byte, len(block.Txs))
 	blockTxAddresses := make([]*TxAddresses, len(block.Txs))
 	// first process all outputs so that inputs can refer to txs in this block
@@ -524,6 +648,9 @@ func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses add
 					if err != bchain.ErrAddressMissing {
 						glog.Warningf("rocksdb: addrDesc: %v - height %d, tx %v, output %v, error %v", err, block.Height, tx.Txid, output, err)
 					}
+				} else if len(addrDesc) > maxAddrDescLen && d.IsBlockFilterIndexEnabled() {
+					// the script is needed by the block filter of the spending block, it is stored but not indexed
+					tao.AddrDesc = addrDesc
 				} else {
 					glog.V(1).Infof("rocksdb: height %d, tx %v, vout %v, skipping addrDesc of length %d", block.Height, tx.Txid, i, len(addrDesc))
 				}
@@ -540,6 +667,8 @@ func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses add
 					}
 					if balance == nil {
 						balance = &AddrBalance{}
+						// the address is new in the index
+						scripthashes[string(Scripthash(addrDesc))] = addrDesc
 					}
 					balances[strAddrDesc] = balance
 					d.cbs.balancesMiss++
@@ -577,6 +706,11 @@ func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses add
 				}
 				return err
 			}
+			spending[string(packSpendingKey(btxID, int32(input.Vout)))] = &SpendingTx{
+				BtxID:  spendingTxid,
+				Index:  int32(i),
+				Height: block.Height,
+			}
 			stxID := string(btxID)
 			ita, e := txAddressesMap[stxID]
 			if !e {
@@ -613,7 +747,7 @@ func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses add
 				}
 				continue
 			}
-			if d.chainParser.IsAddrDescIndexable(spentOutput.AddrDesc) {
+			if d.isAddrDescIndexable(spentOutput.AddrDesc) {
 				strAddrDesc := string(spentOutput.AddrDesc)
 				balance, e := balances[strAddrDesc]
 				if !e {
@@ -645,6 +779,12 @@ func (d *RocksDB) processAddressesBitcoinType(block *bchain.Block, addresses add
 	return nil
 }
 
+// isAddrDescIndexable returns true if the address descriptor is indexed in the address history and balances
+// the scripts longer than maxAddrDescLen may be stored in TxAddresses for the block filters but are never indexed
+func (d *RocksDB) isAddrDescIndexable(addrDesc bchain.AddressDescriptor) bool {
+	return len(addrDesc) <= maxAddrDescLen && d.chainParser.IsAddrDescIndexable(addrDesc)
+}
+
 // addToAddressesMap maintains mapping between addresses and transactions in one block
 // the method assumes that outpus in the block are processed before the inputs
 // the return value is true if the tx was processed before, to not to count the tx multiple times
@@ -696,6 +836,7 @@ func (d *RocksDB) storeBalances(wb *gorocksdb.WriteBatch, abm map[string]*AddrBa
 		// balance with 0 transactions is removed from db - happens on disconnect
 		if ab == nil || ab.Txs <= 0 {
 			wb.DeleteCF(d.cfh[cfAddressBalance], bchain.AddressDescriptor(addrDesc))
+			wb.DeleteCF(d.cfh[cfScripthash], Scripthash(bchain.AddressDescriptor(addrDesc)))
 		} else {
 			buf = packAddrBalance(ab, buf, varBuf)
 			wb.PutCF(d.cfh[cfAddressBalance], bchain.AddressDescriptor(addrDesc), buf)
@@ -705,10 +846,11 @@ func (d *RocksDB) storeBalances(wb *gorocksdb.WriteBatch, abm map[string]*AddrBa
 }
 
 func (d *RocksDB) cleanupBlockTxs(wb *gorocksdb.WriteBatch, block *bchain.Block) error {
-	keep := d.chainParser.KeepBlockAddresses()
+	keep := d.BlockTxsToKeep()
+	pruned := d.IsPruned()
 	// cleanup old block address
-	if block.Height > uint32(keep) {
-		for rh := block.Height - uint32(keep); rh > 0; rh-- {
+	if block.Height > keep {
+		for rh := block.Height - keep; rh > 0; rh-- {
 			key := packUint(rh)
 			val, err := d.db.GetCF(d.ro, d.cfh[cfBlockTxs], key)
 			if err != nil {
@@ -719,46 +861,93 @@ func (d *RocksDB) cleanupBlockTxs(wb *gorocksdb.WriteBatch, block *bchain.Block)
 				break
 			}
 			val.Free()
-			d.db.DeleteCF(d.wo, d.cfh[cfBlockTxs], key)
+			if pruned {
+				// the block leaves the rollback window, prune its data in the same batch
+				if err := d.pruneBlock(wb, rh); err != nil {
+					return err
+				}
+				wb.DeleteCF(d.cfh[cfBlockTxs], key)
+			} else {
+				d.db.DeleteCF(d.wo, d.cfh[cfBlockTxs], key)
+			}
 		}
 	}
 	return nil
 }
 
 func (d *RocksDB) storeAndCleanupBlockTxs(wb *gorocksdb.WriteBatch, block *bchain.Block) error {
+	if err := d.storeBlockTxs(wb, block); err != nil {
+		return err
+	}
+	return d.cleanupBlockTxs(wb, block)
+}
+
+func (d *RocksDB) storeBlockTxs(wb *gorocksdb.WriteBatch, block *bchain.Block) error {
+	bt, err := blockTxsFromBlock(d.chainParser, block)
+	if err != nil {
+		return err
+	}
 	pl := d.chainParser.PackedTxidLen()
-	buf := make([]byte, 0, pl*len(block.Txs))
+	buf := make([]byte, 0, pl*len(bt))
 	varBuf := make([]byte, vlq.MaxLen64)
-	zeroTx := make([]byte, pl)
+	for i := range bt {
+		buf = append(buf, bt[i].btxID...)
+		l := packVaruint(uint(len(bt[i].inputs)), varBuf)
+		buf = append(buf, varBuf[:l]...)
+		buf = append(buf, d.packOutpoints(bt[i].inputs)...)
+	}
+	key := packUint(block.Height)
+	wb.PutCF(d.cfh[cfBlockTxs], key, buf)
+	return nil
+}
+
+// blockTxsFromBlock returns the rollback data of the block in the form stored in the column blockTxs
+func blockTxsFromBlock(parser bchain.BlockChainParser, block *bchain.Block) ([]blockTxs, error) {
+	zeroTx := make([]byte, parser.PackedTxidLen())
+	bt := make([]blockTxs, len(block.Txs))
 	for i := range block.Txs {
 		tx := &block.Txs[i]
 		o := make([]outpoint, len(tx.Vin))
 		for v := range tx.Vin {
 			vin := &tx.Vin[v]
-			btxID, err := d.chainParser.PackTxid(vin.Txid)
+			btxID, err := parser.PackTxid(vin.Txid)
 			if err != nil {
 				// do not process inputs without input txid
 				if err == bchain.ErrTxidMissing {
 					btxID = zeroTx
 				} else {
-					return err
+					return nil, err
 				}
 			}
 			o[v].btxID = btxID
 			o[v].index = int32(vin.Vout)
 		}
-		btxID, err := d.chainParser.PackTxid(tx.Txid)
+		btxID, err := parser.PackTxid(tx.Txid)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		bt[i] = blockTxs{
+			btxID:  btxID,
+			inputs: o,
 		}
-		buf = append(buf, btxID...)
-		l := packVaruint(uint(len(o)), varBuf)
-		buf = append(buf, varBuf[:l]...)
-		buf = append(buf, d.packOutpoints(o)...)
 	}
-	key := packUint(block.Height)
-	wb.PutCF(d.cfh[cfBlockTxs], key, buf)
-	return d.cleanupBlockTxs(wb, block)
+	return bt, nil
+}
+
+// GetBlockTxids returns txids of the transactions in the block of given height
+// the data are available only for the last blocks kept for rollback
+func (d *RocksDB) GetBlockTxids(height uint32) ([]string, error) {
+	bt, err := d.getBlockTxs(height)
+	if err != nil {
+		return nil, err
+	}
+	txids := make([]string, len(bt))
+	for i := range bt {
+		if txids[i], err = d.chainParser.UnpackTxid(bt[i].btxID); err != nil {
+			return nil, err
+		}
+	}
+	return txids, nil
 }
 
 func (d *RocksDB) getBlockTxs(height uint32) ([]blockTxs, error) {
@@ -857,6 +1046,188 @@ func (d *RocksDB) AddrDescForOutpoint(outpoint bchain.Outpoint) bchain.AddressDe
 	return ta.Outputs[outpoint.Vout].AddrDesc
 }
 
+// Spending index
+
+// SpendingTx identifies the input of the transaction which spent an output
+type SpendingTx struct {
+	BtxID  []byte
+	Index  int32
+	Height uint32
+}
+
+// spendingMap is a map of spent outputs (packed by packSpendingKey) to the spending inputs
+type spendingMap map[string]*SpendingTx
+
+func packSpendingKey(btxID []byte, vout int32) []byte {
+	buf := make([]byte, len(btxID)+vlq.MaxLen32)
+	copy(buf, btxID)
+	l := packVarint32(vout, buf[len(btxID):])
+	return buf[:len(btxID)+l]
+}
+
+func packSpendingTx(stx *SpendingTx, buf []byte, varBuf []byte) []byte {
+	buf = append(buf[:0], stx.BtxID...)
+	l := packVarint32(stx.Index, varBuf)
+	buf = append(buf, varBuf[:l]...)
+	l = packVaruint(uint(stx.Height), varBuf)
+	buf = append(buf, varBuf[:l]...)
+	return buf
+}
+
+func unpackSpendingTx(buf []byte, txidUnpackedLen int) (*SpendingTx, error) {
+	if len(buf) < txidUnpackedLen+2 {
+		return nil, errors.New("Inconsistent data in spending")
+	}
+	stx := SpendingTx{
+		BtxID: append([]byte(nil), buf[:txidUnpackedLen]...),
+	}
+	index, l := unpackVarint32(buf[txidUnpackedLen:])
+	stx.Index = index
+	height, _ := unpackVaruint(buf[txidUnpackedLen+l:])
+	stx.Height = uint32(height)
+	return &stx, nil
+}
+
+func (d *RocksDB) storeSpending(wb *gorocksdb.WriteBatch, sm spendingMap) error {
+	buf := make([]byte, 0, 64)
+	varBuf := make([]byte, vlq.MaxLen64)
+	for key, stx := range sm {
+		buf = packSpendingTx(stx, buf, varBuf)
+		wb.PutCF(d.cfh[cfSpending], []byte(key), buf)
+	}
+	return nil
+}
+
+// GetSpendingTx returns the transaction input which spent given output or nil if the output is not spent
+// or the spend happened before SpendingIndexFromHeight of the internal state
+// the spending index exists only for bitcoin type coins
+func (d *RocksDB) GetSpendingTx(txid string, vout int32) (*SpendingTx, error) {
+	btxID, err := d.chainParser.PackTxid(txid)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSpendingTx(btxID, vout)
+}
+
+func (d *RocksDB) getSpendingTx(btxID []byte, vout int32) (*SpendingTx, error) {
+	if d.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return nil, errors.New("Spending index is supported only for bitcoin type coins")
+	}
+	val, err := d.db.GetCF(d.ro, d.cfh[cfSpending], packSpendingKey(btxID, vout))
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	buf := val.Data()
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	return unpackSpendingTx(buf, d.chainParser.PackedTxidLen())
+}
+
+// ConnectBlockSpending stores only the spending index of the block, it is used to build the index for an existing db
+func (d *RocksDB) ConnectBlockSpending(block *bchain.Block) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	spending := make(spendingMap)
+	for txi := range block.Txs {
+		tx := &block.Txs[txi]
+		spendingTxid, err := d.chainParser.PackTxid(tx.Txid)
+		if err != nil {
+			return err
+		}
+		for i, input := range tx.Vin {
+			btxID, err := d.chainParser.PackTxid(input.Txid)
+			if err != nil {
+				if err == bchain.ErrTxidMissing {
+					continue
+				}
+				return err
+			}
+			spending[string(packSpendingKey(btxID, int32(input.Vout)))] = &SpendingTx{
+				BtxID:  spendingTxid,
+				Index:  int32(i),
+				Height: block.Height,
+			}
+		}
+	}
+	if err := d.storeSpending(wb, spending); err != nil {
+		return err
+	}
+	return d.db.Write(d.wo, wb)
+}
+
+// Scripthash index
+
+// scripthashMap is a map of address descriptors new in the index, keyed by their scripthash
+type scripthashMap map[string]bchain.AddressDescriptor
+
+// Scripthash returns sha256 hash of the address descriptor (output script), as used by the electrum protocol
+// electrum presents the hash in hex in the reversed byte order
+func Scripthash(addrDesc bchain.AddressDescriptor) []byte {
+	h := sha256.Sum256(addrDesc)
+	return h[:]
+}
+
+func (d *RocksDB) storeScripthashes(wb *gorocksdb.WriteBatch, sm scripthashMap) error {
+	for key, addrDesc := range sm {
+		wb.PutCF(d.cfh[cfScripthash], []byte(key), addrDesc)
+	}
+	return nil
+}
+
+// GetAddrDescForScripthash returns the address descriptor of the scripthash or nil if the scripthash is not indexed
+func (d *RocksDB) GetAddrDescForScripthash(scripthash []byte) (bchain.AddressDescriptor, error) {
+	val, err := d.db.GetCF(d.ro, d.cfh[cfScripthash], scripthash)
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	buf := val.Data()
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	return append(bchain.AddressDescriptor(nil), buf...), nil
+}
+
+// BuildScripthashIndex adds scripthashes of all addresses in the addressBalance column to the scripthash index
+// it is used to fill the index of an existing db, it can run concurrently with the synchronization
+func (d *RocksDB) BuildScripthashIndex(stop chan os.Signal) error {
+	if d.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return errors.New("Scripthash index is supported only for bitcoin type coins")
+	}
+	start := time.Now()
+	glog.Info("rocksdb: building scripthash index")
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfAddressBalance])
+	defer it.Close()
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		select {
+		case <-stop:
+			return ErrOperationInterrupted
+		default:
+		}
+		addrDesc := it.Key().Data()
+		wb.PutCF(d.cfh[cfScripthash], Scripthash(addrDesc), addrDesc)
+		count++
+		if count%100000 == 0 {
+			if err := d.db.Write(d.wo, wb); err != nil {
+				return err
+			}
+			wb.Clear()
+			glog.Info("rocksdb: scripthash index, ", count, " addresses processed")
+		}
+	}
+	if err := d.db.Write(d.wo, wb); err != nil {
+		return err
+	}
+	d.is.SetScripthashIndexComplete()
+	glog.Info("rocksdb: scripthash index of ", count, " addresses built in ", time.Since(start))
+	return nil
+}
+
 func packTxAddresses(ta *TxAddresses, buf []byte, varBuf []byte) []byte {
 	buf = buf[:0]
 	l := packVaruint(uint(ta.Height), varBuf)
@@ -1128,6 +1499,26 @@ func (d *RocksDB) GetBlockHash(height uint32) (string, error) {
 	return info.Hash, nil
 }
 
+// GetBlockHeaderRaw returns the raw header of the block at given height as serialized by the backend
+// or nil if the header is not stored (the block was indexed before the headers were stored or the backend does not provide them)
+func (d *RocksDB) GetBlockHeaderRaw(height uint32) ([]byte, error) {
+	val, err := d.db.GetCF(d.ro, d.cfh[cfBlockHeaders], packUint(height))
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	if len(val.Data()) == 0 {
+		return nil, nil
+	}
+	return append([]byte(nil), val.Data()...), nil
+}
+
+func (d *RocksDB) storeBlockHeader(wb *gorocksdb.WriteBatch, height uint32, header []byte) {
+	if len(header) > 0 {
+		wb.PutCF(d.cfh[cfBlockHeaders], packUint(height), header)
+	}
+}
+
 // GetBlockInfo returns block info stored in db
 func (d *RocksDB) GetBlockInfo(height uint32) (*BlockInfo, error) {
 	key := packUint(height)
@@ -1157,15 +1548,18 @@ func (d *RocksDB) writeHeightFromBlock(wb *gorocksdb.WriteBatch, block *bchain.B
 func (d *RocksDB) writeHeight(wb *gorocksdb.WriteBatch, height uint32, bi *BlockInfo, op int) error {
 	key := packUint(height)
 	switch op {
-	case opInsert:
+	case opInsert, opReplace:
 		val, err := d.packBlockInfo(bi)
 		if err != nil {
 			return err
 		}
 		wb.PutCF(d.cfh[cfHeight], key, val)
-		d.is.UpdateBestHeight(height)
+		if op == opInsert {
+			d.is.UpdateBestHeight(height)
+		}
 	case opDelete:
 		wb.DeleteCF(d.cfh[cfHeight], key)
+		wb.DeleteCF(d.cfh[cfBlockHeaders], key)
 		d.is.UpdateBestHeight(height - 1)
 	}
 	return nil
@@ -1215,7 +1609,7 @@ func (d *RocksDB) disconnectTxAddresses(wb *gorocksdb.WriteBatch, height uint32,
 				sa.Outputs[input.index].Spent = false
 				inputHeight = sa.Height
 			}
-			if d.chainParser.IsAddrDescIndexable(t.AddrDesc) {
+			if d.isAddrDescIndexable(t.AddrDesc) {
 				balance, err = getAddressBalance(t.AddrDesc)
 				if err != nil {
 					return err
@@ -1250,7 +1644,7 @@ func (d *RocksDB) disconnectTxAddresses(wb *gorocksdb.WriteBatch, height uint32,
 			if !exist {
 				addresses[s] = struct{}{}
 			}
-			if d.chainParser.IsAddrDescIndexable(t.AddrDesc) {
+			if d.isAddrDescIndexable(t.AddrDesc) {
 				balance, err := getAddressBalance(t.AddrDesc)
 				if err != nil {
 					return err
@@ -1295,9 +1689,29 @@ func (d *RocksDB) DisconnectBlockRangeBitcoinType(lower uint32, higher uint32) e
 	}
 	wb := gorocksdb.NewWriteBatch()
 	defer wb.Destroy()
-	txAddressesToUpdate := make(map[string]*TxAddresses)
+	if err := d.disconnectBlocksBitcoinType(wb, lower, higher, blocks, make(map[string]*TxAddresses), make(map[string]*AddrBalance)); err != nil {
+		return err
+	}
+	for height := lower; height <= higher; height++ {
+		key := packUint(height)
+		wb.DeleteCF(d.cfh[cfBlockTxs], key)
+		wb.DeleteCF(d.cfh[cfHeight], key)
+		wb.DeleteCF(d.cfh[cfBlockHeaders], key)
+		wb.DeleteCF(d.cfh[cfBlockFilters], key)
+	}
+	err := d.db.Write(d.wo, wb)
+	if err == nil {
+		glog.Infof("rocksdb: blocks %d-%d disconnected", lower, higher)
+	}
+	return err
+}
+
+// disconnectBlocksBitcoinType reverts the address data of the blocks in range lower-higher given by their rollback data
+// and removes their transactions, the data of the blocks themselves (height, header etc.) are left to the caller
+// txAddressesToUpdate and balances may contain data already modified by the caller
+func (d *RocksDB) disconnectBlocksBitcoinType(wb *gorocksdb.WriteBatch, lower uint32, higher uint32, blocks [][]blockTxs,
+	txAddressesToUpdate map[string]*TxAddresses, balances map[string]*AddrBalance) error {
 	txsToDelete := make(map[string]struct{})
-	balances := make(map[string]*AddrBalance)
 	for height := higher; height >= lower; height-- {
 		blockTxs := blocks[height-lower]
 		glog.Info("Disconnecting block ", height, " containing ", len(blockTxs), " transactions")
@@ -1308,6 +1722,9 @@ func (d *RocksDB) DisconnectBlockRangeBitcoinType(lower uint32, higher uint32) e
 			btxID := blockTxs[i].btxID
 			s := string(btxID)
 			txsToDelete[s] = struct{}{}
+			for _, input := range blockTxs[i].inputs {
+				wb.DeleteCF(d.cfh[cfSpending], packSpendingKey(input.btxID, input.index))
+			}
 			txa, err := d.getTxAddresses(btxID)
 			if err != nil {
 				return err
@@ -1321,9 +1738,9 @@ func (d *RocksDB) DisconnectBlockRangeBitcoinType(lower uint32, higher uint32) e
 				return err
 			}
 		}
-		key := packUint(height)
-		wb.DeleteCF(d.cfh[cfBlockTxs], key)
-		wb.DeleteCF(d.cfh[cfHeight], key)
+		if height == 0 {
+			break
+		}
 	}
 	d.storeTxAddresses(wb, txAddressesToUpdate)
 	d.storeBalancesDisconnect(wb, balances)
@@ -1332,11 +1749,7 @@ func (d *RocksDB) DisconnectBlockRangeBitcoinType(lower uint32, higher uint32) e
 		wb.DeleteCF(d.cfh[cfTransactions], b)
 		wb.DeleteCF(d.cfh[cfTxAddresses], b)
 	}
-	err := d.db.Write(d.wo, wb)
-	if err == nil {
-		glog.Infof("rocksdb: blocks %d-%d disconnected", lower, higher)
-	}
-	return err
+	return nil
 }
 
 func (d *RocksDB) storeBalancesDisconnect(wb *gorocksdb.WriteBatch, balances map[string]*AddrBalance) {
@@ -1478,11 +1891,17 @@ func (d *RocksDB) LoadInternalState(rpcCoin string) (*common.InternalState, erro
 	for i := 0; i < len(nc); i++ {
 		nc[i].Name = cfNames[i]
 		nc[i].Version = dbVersion
+		found := false
 		for j := 0; j < len(sc); j++ {
 			if sc[j].Name == nc[i].Name {
+				found = true
 				// check the version of the column, if it does not match, the db is not compatible
+				// unless the column can be converted by the registered migrations, see Migrate
 				if sc[j].Version != dbVersion {
-					return nil, errors.Errorf("DB version %v of column '%v' does not match the required version %v. DB is not compatible.", sc[j].Version, sc[j].Name, dbVersion)
+					if !canMigrate(sc[j].Version) {
+						return nil, errors.Errorf("DB version %v of column '%v' does not match the required version %v. DB is not compatible.", sc[j].Version, sc[j].Name, dbVersion)
+					}
+					nc[i].Version = sc[j].Version
 				}
 				nc[i].Rows = sc[j].Rows
 				nc[i].KeyBytes = sc[j].KeyBytes
@@ -1491,6 +1910,23 @@ func (d *RocksDB) LoadInternalState(rpcCoin string) (*common.InternalState, erro
 				break
 			}
 		}
+		// the spending column is added to an existing db, it is filled only from the next block
+		// the older spends are found using the address index until the column is built by -buildspendingindex
+		// the scripthash column is added to an existing db, the addresses indexed before are added by BuildScripthashIndex
+		if !found && i == cfScripthash && len(sc) > 0 && d.chainParser.GetChainType() == bchain.ChainBitcoinType {
+			is.ScripthashIndexIncomplete = true
+			glog.Info("rocksdb: scripthash index is created, it must be filled by the addresses indexed before")
+		}
+		if !found && i == cfSpending && len(sc) > 0 && d.chainParser.GetChainType() == bchain.ChainBitcoinType {
+			height, hash, err := d.GetBestBlock()
+			if err != nil {
+				return nil, err
+			}
+			if hash != "" {
+				is.SpendingIndexFromHeight = height + 1
+				glog.Info("rocksdb: spending index is created, it is complete from height ", is.SpendingIndexFromHeight)
+			}
+		}
 	}
 	is.DbColumns = nc
 	// after load, reset the synchronization data
@@ -451,6 +451,7 @@ func (d *RocksDB) DisconnectBlockRangeEthereumType(lower uint32, higher uint32)
 		key := packUint(height)
 		wb.DeleteCF(d.cfh[cfBlockTxs], key)
 		wb.DeleteCF(d.cfh[cfHeight], key)
+		wb.DeleteCF(d.cfh[cfBlockHeaders], key)
 	}
 	d.storeAddressContracts(wb, contracts)
 	err := d.db.Write(d.wo, wb)
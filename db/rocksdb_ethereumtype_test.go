@@ -237,6 +237,11 @@ func TestRocksDB_Index_EthereumType(t *testing.T) {
 		t.Errorf("GetBlockInfo() = %+v, want %+v", info, iw)
 	}
 
+	// the spending index does not exist for ethereum type coins
+	if stx, err := d.GetSpendingTx("0x"+dbtestdata.EthTxidB1T1, 0); err == nil || stx != nil {
+		t.Errorf("GetSpendingTx() = %+v, %v, want error", stx, err)
+	}
+
 	// Test tx caching functionality, leave one tx in db to test cleanup in DisconnectBlock
 	testTxCache(t, d, block1, &block1.Txs[0])
 	testTxCache(t, d, block2, &block2.Txs[0])
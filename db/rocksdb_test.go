@@ -7,6 +7,7 @@ import (
 	"blockbook/bchain/coins/btc"
 	"blockbook/common"
 	"blockbook/tests/dbtestdata"
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"io/ioutil"
@@ -16,6 +17,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	vlq "github.com/bsm/go-vlq"
 	"github.com/juju/errors"
@@ -108,6 +110,17 @@ func addressKeyHex(a string, height uint32, d *RocksDB) string {
 	return dbtestdata.AddressToPubKeyHex(a, d.chainParser) + uintToHex(^height)
 }
 
+// scripthashKeyPairs returns the expected rows of the scripthash column for the addresses
+func scripthashKeyPairs(d *RocksDB, addrs ...string) []keyPair {
+	kp := make([]keyPair, len(addrs))
+	for i, a := range addrs {
+		h := dbtestdata.AddressToPubKeyHex(a, d.chainParser)
+		b, _ := hex.DecodeString(h)
+		kp[i] = keyPair{hex.EncodeToString(Scripthash(b)), h, nil}
+	}
+	return kp
+}
+
 func txIndexesHex(tx string, indexes []int32) string {
 	buf := make([]byte, vlq.MaxLen32)
 	for i, index := range indexes {
@@ -280,6 +293,19 @@ func verifyAfterBitcoinTypeBlock1(t *testing.T, d *RocksDB, afterDisconnect bool
 			t.Fatal(err)
 		}
 	}
+	// block 1 does not spend any indexed outputs
+	if err := checkColumn(d, cfSpending, []keyPair{}); err != nil {
+		{
+			t.Fatal(err)
+		}
+	}
+	if err := checkColumn(d, cfScripthash, scripthashKeyPairs(d,
+		dbtestdata.Addr1, dbtestdata.Addr2, dbtestdata.Addr3, dbtestdata.Addr4, dbtestdata.Addr5,
+	)); err != nil {
+		{
+			t.Fatal(err)
+		}
+	}
 }
 
 func verifyAfterBitcoinTypeBlock2(t *testing.T, d *RocksDB) {
@@ -461,6 +487,26 @@ func verifyAfterBitcoinTypeBlock2(t *testing.T, d *RocksDB) {
 			t.Fatal(err)
 		}
 	}
+	// the vout and input index are encoded as signed varint, i.e. value * 2 for non negative values
+	if err := checkColumn(d, cfSpending, []keyPair{
+		{dbtestdata.TxidB1T1 + "02", dbtestdata.TxidB2T1 + "02" + varuintToHex(225494), nil},
+		{dbtestdata.TxidB2T1 + "00", dbtestdata.TxidB2T2 + "00" + varuintToHex(225494), nil},
+		{dbtestdata.TxidB1T2 + "00", dbtestdata.TxidB2T1 + "00" + varuintToHex(225494), nil},
+		{dbtestdata.TxidB1T2 + "02", dbtestdata.TxidB2T2 + "02" + varuintToHex(225494), nil},
+		{dbtestdata.TxidB1T2 + "04", dbtestdata.TxidB2T3 + "00" + varuintToHex(225494), nil},
+	}); err != nil {
+		{
+			t.Fatal(err)
+		}
+	}
+	if err := checkColumn(d, cfScripthash, scripthashKeyPairs(d,
+		dbtestdata.Addr1, dbtestdata.Addr2, dbtestdata.Addr3, dbtestdata.Addr4, dbtestdata.Addr5,
+		dbtestdata.Addr6, dbtestdata.Addr7, dbtestdata.Addr8, dbtestdata.Addr9, dbtestdata.AddrA,
+	)); err != nil {
+		{
+			t.Fatal(err)
+		}
+	}
 }
 
 type txidIndex struct {
@@ -468,7 +514,7 @@ type txidIndex struct {
 	index int32
 }
 
-func verifyGetTransactions(t *testing.T, d *RocksDB, addr string, low, high uint32, wantTxids []txidIndex, wantErr error) {
+func verifyGetTransactions(t *testing.T, d Index, addr string, low, high uint32, wantTxids []txidIndex, wantErr error) {
 	gotTxids := make([]txidIndex, 0)
 	addToTxids := func(txid string, height uint32, indexes []int32) error {
 		for _, index := range indexes {
@@ -496,7 +542,7 @@ func (p *testBitcoinParser) UnpackTx(buf []byte) (*bchain.Tx, uint32, error) {
 	return p.BaseParser.UnpackTx(buf)
 }
 
-func testTxCache(t *testing.T, d *RocksDB, b *bchain.Block, tx *bchain.Tx) {
+func testTxCache(t *testing.T, d Index, b *bchain.Block, tx *bchain.Tx) {
 	if err := d.PutTx(tx, b.Height, tx.Blocktime); err != nil {
 		t.Fatal(err)
 	}
@@ -526,246 +572,436 @@ func testTxCache(t *testing.T, d *RocksDB, b *bchain.Block, tx *bchain.Tx) {
 // 5) Disconnect the block 2 using BlockTxs column
 // 6) Reconnect block 2 and check
 // After each step, the content of DB is examined and any difference against expected state is regarded as failure
+// The test is run for all implementations of Index, the content of the columns is examined only for RocksDB
 func TestRocksDB_Index_BitcoinType(t *testing.T) {
-	d := setupRocksDB(t, &testBitcoinParser{
-		BitcoinParser: bitcoinTestnetParser(),
-	})
-	defer closeAndDestroyRocksDB(t, d)
+	for _, ti := range testIndexes {
+		t.Run(ti.name, func(t *testing.T) {
+			parser := &testBitcoinParser{
+				BitcoinParser: bitcoinTestnetParser(),
+			}
+			d, cleanup := ti.setup(t, parser)
+			defer cleanup()
+			// the content of the columns is checked only for RocksDB, the other implementations through the Index methods
+			rd, _ := d.(*RocksDB)
 
-	// connect 1st block - will log warnings about missing UTXO transactions in txAddresses column
-	block1 := dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)
-	if err := d.ConnectBlock(block1); err != nil {
-		t.Fatal(err)
-	}
-	verifyAfterBitcoinTypeBlock1(t, d, false)
+			// connect 1st block - will log warnings about missing UTXO transactions in txAddresses column
+			block1 := dbtestdata.GetTestBitcoinTypeBlock1(parser)
+			if err := d.ConnectBlock(block1); err != nil {
+				t.Fatal(err)
+			}
+			if rd != nil {
+				verifyAfterBitcoinTypeBlock1(t, rd, false)
+			}
 
-	// connect 2nd block - use some outputs from the 1st block as the inputs and 1 input uses tx from the same block
-	block2 := dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)
-	if err := d.ConnectBlock(block2); err != nil {
-		t.Fatal(err)
-	}
-	verifyAfterBitcoinTypeBlock2(t, d)
+			// connect 2nd block - use some outputs from the 1st block as the inputs and 1 input uses tx from the same block
+			block2 := dbtestdata.GetTestBitcoinTypeBlock2(parser)
+			if err := d.ConnectBlock(block2); err != nil {
+				t.Fatal(err)
+			}
+			if rd != nil {
+				verifyAfterBitcoinTypeBlock2(t, rd)
+			}
 
-	// get transactions for various addresses / low-high ranges
-	verifyGetTransactions(t, d, dbtestdata.Addr2, 0, 1000000, []txidIndex{
-		{dbtestdata.TxidB2T1, ^1},
-		{dbtestdata.TxidB1T1, 1},
-	}, nil)
-	verifyGetTransactions(t, d, dbtestdata.Addr2, 225493, 225493, []txidIndex{
-		{dbtestdata.TxidB1T1, 1},
-	}, nil)
-	verifyGetTransactions(t, d, dbtestdata.Addr2, 225494, 1000000, []txidIndex{
-		{dbtestdata.TxidB2T1, ^1},
-	}, nil)
-	verifyGetTransactions(t, d, dbtestdata.Addr2, 500000, 1000000, []txidIndex{}, nil)
-	verifyGetTransactions(t, d, dbtestdata.Addr8, 0, 1000000, []txidIndex{
-		{dbtestdata.TxidB2T2, 0},
-	}, nil)
-	verifyGetTransactions(t, d, dbtestdata.Addr6, 0, 1000000, []txidIndex{
-		{dbtestdata.TxidB2T2, ^0},
-		{dbtestdata.TxidB2T1, 0},
-	}, nil)
-	verifyGetTransactions(t, d, "mtGXQvBowMkBpnhLckhxhbwYK44Gs9eBad", 500000, 1000000, []txidIndex{}, errors.New("checksum mismatch"))
-
-	// GetBestBlock
-	height, hash, err := d.GetBestBlock()
-	if err != nil {
-		t.Fatal(err)
-	}
-	if height != 225494 {
-		t.Fatalf("GetBestBlock: got height %v, expected %v", height, 225494)
-	}
-	if hash != "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6" {
-		t.Fatalf("GetBestBlock: got hash %v, expected %v", hash, "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6")
-	}
+			// get transactions for various addresses / low-high ranges
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 0, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T1, ^1},
+				{dbtestdata.TxidB1T1, 1},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 225493, 225493, []txidIndex{
+				{dbtestdata.TxidB1T1, 1},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 225494, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T1, ^1},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr2, 500000, 1000000, []txidIndex{}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr8, 0, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T2, 0},
+			}, nil)
+			verifyGetTransactions(t, d, dbtestdata.Addr6, 0, 1000000, []txidIndex{
+				{dbtestdata.TxidB2T2, ^0},
+				{dbtestdata.TxidB2T1, 0},
+			}, nil)
+			verifyGetTransactions(t, d, "mtGXQvBowMkBpnhLckhxhbwYK44Gs9eBad", 500000, 1000000, []txidIndex{}, errors.New("checksum mismatch"))
 
-	// GetBlockHash
-	hash, err = d.GetBlockHash(225493)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if hash != "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997" {
-		t.Fatalf("GetBlockHash: got hash %v, expected %v", hash, "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997")
-	}
+			// GetBestBlock
+			height, hash, err := d.GetBestBlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if height != 225494 {
+				t.Fatalf("GetBestBlock: got height %v, expected %v", height, 225494)
+			}
+			if hash != "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6" {
+				t.Fatalf("GetBestBlock: got hash %v, expected %v", hash, "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6")
+			}
 
-	// Not connected block
-	hash, err = d.GetBlockHash(225495)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if hash != "" {
-		t.Fatalf("GetBlockHash: got hash '%v', expected ''", hash)
+			// GetBlockHash
+			hash, err = d.GetBlockHash(225493)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997" {
+				t.Fatalf("GetBlockHash: got hash %v, expected %v", hash, "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997")
+			}
+
+			// Not connected block
+			hash, err = d.GetBlockHash(225495)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != "" {
+				t.Fatalf("GetBlockHash: got hash '%v', expected ''", hash)
+			}
+
+			// GetBlockHash
+			info, err := d.GetBlockInfo(225494)
+			if err != nil {
+				t.Fatal(err)
+			}
+			iw := &BlockInfo{
+				Hash:   "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6",
+				Txs:    4,
+				Size:   2345678,
+				Time:   1534859123,
+				Height: 225494,
+			}
+			if !reflect.DeepEqual(info, iw) {
+				t.Errorf("GetBlockInfo() = %+v, want %+v", info, iw)
+			}
+
+			// Test tx caching functionality, leave one tx in db to test cleanup in DisconnectBlock
+			testTxCache(t, d, block1, &block1.Txs[0])
+			testTxCache(t, d, block2, &block2.Txs[0])
+			if err = d.PutTx(&block2.Txs[1], block2.Height, block2.Txs[1].Blocktime); err != nil {
+				t.Fatal(err)
+			}
+			if rd != nil {
+				// check that there is only the last tx in the cache
+				packedTx, err := parser.PackTx(&block2.Txs[1], block2.Height, block2.Txs[1].Blocktime)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := checkColumn(rd, cfTransactions, []keyPair{
+					{block2.Txs[1].Txid, hex.EncodeToString(packedTx), nil},
+				}); err != nil {
+					{
+						t.Fatal(err)
+					}
+				}
+
+				// try to disconnect both blocks, however only the last one is kept, it is not possible
+				// MemoryIndex keeps the rollback data of all blocks
+				err = rd.DisconnectBlockRangeBitcoinType(225493, 225494)
+				if err == nil || err.Error() != "Cannot disconnect blocks with height 225493 and lower. It is necessary to rebuild index." {
+					t.Fatal(err)
+				}
+				verifyAfterBitcoinTypeBlock2(t, rd)
+			}
+
+			// disconnect the 2nd block, verify that the db contains only data from the 1st block with restored unspentTxs
+			// and that the cached tx is removed
+			err = d.DisconnectBlockRangeBitcoinType(225494, 225494)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rd != nil {
+				verifyAfterBitcoinTypeBlock1(t, rd, true)
+				if err := checkColumn(rd, cfTransactions, []keyPair{}); err != nil {
+					{
+						t.Fatal(err)
+					}
+				}
+			}
+			verifyIndexBestBlock(t, d, 225493, "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997")
+			if tx, _, err := d.GetTx(block2.Txs[1].Txid); err != nil || tx != nil {
+				t.Errorf("GetTx() of the cached tx of the disconnected block = %+v, %v, want nil", tx, err)
+			}
+
+			// connect block again and verify the state of db
+			if err := d.ConnectBlock(block2); err != nil {
+				t.Fatal(err)
+			}
+			if rd != nil {
+				verifyAfterBitcoinTypeBlock2(t, rd)
+			}
+			verifyIndexBestBlock(t, d, 225494, "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6")
+
+			// test public methods for address balance and tx addresses
+			ab, err := d.GetAddressBalance(dbtestdata.Addr5, AddressBalanceDetailUTXO)
+			if err != nil {
+				t.Fatal(err)
+			}
+			abw := &AddrBalance{
+				Txs:        2,
+				SentSat:    *dbtestdata.SatB1T2A5,
+				BalanceSat: *dbtestdata.SatB2T3A5,
+				Utxos: []Utxo{
+					{
+						BtxID:    hexToBytes(dbtestdata.TxidB2T3),
+						Vout:     0,
+						Height:   225494,
+						ValueSat: *dbtestdata.SatB2T3A5,
+					},
+				},
+			}
+			if !reflect.DeepEqual(ab, abw) {
+				t.Errorf("GetAddressBalance() = %+v, want %+v", ab, abw)
+			}
+			rs := ab.ReceivedSat()
+			rsw := dbtestdata.SatB1T2A5.Add(dbtestdata.SatB1T2A5, dbtestdata.SatB2T3A5)
+			if rs.Cmp(rsw) != 0 {
+				t.Errorf("GetAddressBalance().ReceivedSat() = %v, want %v", rs, rsw)
+			}
+
+			ta, err := d.GetTxAddresses(dbtestdata.TxidB2T1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			taw := &TxAddresses{
+				Height: 225494,
+				Inputs: []TxInput{
+					{
+						AddrDesc: addressToAddrDesc(dbtestdata.Addr3, parser),
+						ValueSat: *dbtestdata.SatB1T2A3,
+					},
+					{
+						AddrDesc: addressToAddrDesc(dbtestdata.Addr2, parser),
+						ValueSat: *dbtestdata.SatB1T1A2,
+					},
+				},
+				Outputs: []TxOutput{
+					{
+						AddrDesc: addressToAddrDesc(dbtestdata.Addr6, parser),
+						Spent:    true,
+						ValueSat: *dbtestdata.SatB2T1A6,
+					},
+					{
+						AddrDesc: addressToAddrDesc(dbtestdata.Addr7, parser),
+						Spent:    false,
+						ValueSat: *dbtestdata.SatB2T1A7,
+					},
+					{
+						AddrDesc: hexToBytes(dbtestdata.TxidB2T1Output3OpReturn),
+						Spent:    false,
+						ValueSat: *dbtestdata.SatZero,
+					},
+				},
+			}
+			if !reflect.DeepEqual(ta, taw) {
+				t.Errorf("GetTxAddresses() = %+v, want %+v", ta, taw)
+			}
+			ia, _, err := ta.Inputs[0].Addresses(parser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ia, []string{dbtestdata.Addr3}) {
+				t.Errorf("GetTxAddresses().Inputs[0].Addresses() = %v, want %v", ia, []string{dbtestdata.Addr3})
+			}
+
+			stx, err := d.GetSpendingTx(dbtestdata.TxidB1T2, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stxw := &SpendingTx{
+				BtxID:  hexToBytes(dbtestdata.TxidB2T2),
+				Index:  1,
+				Height: 225494,
+			}
+			if !reflect.DeepEqual(stx, stxw) {
+				t.Errorf("GetSpendingTx() = %+v, want %+v", stx, stxw)
+			}
+			stx, err = d.GetSpendingTx(dbtestdata.TxidB2T1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stx != nil {
+				t.Errorf("GetSpendingTx() = %+v, want nil", stx)
+			}
+
+			addrDesc, err := parser.GetAddrDescFromAddress(dbtestdata.Addr8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sad, err := d.GetAddrDescForScripthash(Scripthash(addrDesc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sad, addrDesc) {
+				t.Errorf("GetAddrDescForScripthash() = %v, want %v", sad, addrDesc)
+			}
+			sad, err = d.GetAddrDescForScripthash(hexToBytes(dbtestdata.TxidB2T1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sad != nil {
+				t.Errorf("GetAddrDescForScripthash() = %v, want nil", sad)
+			}
+		})
 	}
+}
 
-	// GetBlockHash
-	info, err := d.GetBlockInfo(225494)
+func Test_BulkConnect_BitcoinType(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	bc, err := d.InitBulkConnect()
 	if err != nil {
 		t.Fatal(err)
 	}
-	iw := &BlockInfo{
-		Hash:   "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6",
-		Txs:    4,
-		Size:   2345678,
-		Time:   1534859123,
-		Height: 225494,
-	}
-	if !reflect.DeepEqual(info, iw) {
-		t.Errorf("GetBlockInfo() = %+v, want %+v", info, iw)
+
+	if d.is.DbState != common.DbStateInconsistent {
+		t.Fatal("DB not in DbStateInconsistent")
 	}
 
-	// Test tx caching functionality, leave one tx in db to test cleanup in DisconnectBlock
-	testTxCache(t, d, block1, &block1.Txs[0])
-	testTxCache(t, d, block2, &block2.Txs[0])
-	if err = d.PutTx(&block2.Txs[1], block2.Height, block2.Txs[1].Blocktime); err != nil {
+	if err := bc.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser), false); err != nil {
 		t.Fatal(err)
 	}
-	// check that there is only the last tx in the cache
-	packedTx, err := d.chainParser.PackTx(&block2.Txs[1], block2.Height, block2.Txs[1].Blocktime)
-	if err := checkColumn(d, cfTransactions, []keyPair{
-		{block2.Txs[1].Txid, hex.EncodeToString(packedTx), nil},
-	}); err != nil {
+	if err := checkColumn(d, cfBlockTxs, []keyPair{}); err != nil {
 		{
 			t.Fatal(err)
 		}
 	}
 
-	// try to disconnect both blocks, however only the last one is kept, it is not possible
-	err = d.DisconnectBlockRangeBitcoinType(225493, 225494)
-	if err == nil || err.Error() != "Cannot disconnect blocks with height 225493 and lower. It is necessary to rebuild index." {
+	if err := bc.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser), true); err != nil {
 		t.Fatal(err)
 	}
-	verifyAfterBitcoinTypeBlock2(t, d)
 
-	// disconnect the 2nd block, verify that the db contains only data from the 1st block with restored unspentTxs
-	// and that the cached tx is removed
-	err = d.DisconnectBlockRangeBitcoinType(225494, 225494)
-	if err != nil {
+	if err := bc.Close(); err != nil {
 		t.Fatal(err)
 	}
-	verifyAfterBitcoinTypeBlock1(t, d, true)
-	if err := checkColumn(d, cfTransactions, []keyPair{}); err != nil {
-		{
-			t.Fatal(err)
-		}
-	}
 
-	// connect block again and verify the state of db
-	if err := d.ConnectBlock(block2); err != nil {
-		t.Fatal(err)
+	if d.is.DbState != common.DbStateOpen {
+		t.Fatal("DB not in DbStateOpen")
 	}
+
 	verifyAfterBitcoinTypeBlock2(t, d)
+}
 
-	// test public methods for address balance and tx addresses
-	ab, err := d.GetAddressBalance(dbtestdata.Addr5, AddressBalanceDetailUTXO)
-	if err != nil {
+func TestRocksDB_BlockHeaders(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	// the header is stored only if it was provided by the backend
+	header := hexToBytes("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c")
+	block1 := dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)
+	block1.HeaderRaw = header
+	if err := d.ConnectBlock(block1); err != nil {
 		t.Fatal(err)
 	}
-	abw := &AddrBalance{
-		Txs:        2,
-		SentSat:    *dbtestdata.SatB1T2A5,
-		BalanceSat: *dbtestdata.SatB2T3A5,
-		Utxos: []Utxo{
-			{
-				BtxID:    hexToBytes(dbtestdata.TxidB2T3),
-				Vout:     0,
-				Height:   225494,
-				ValueSat: *dbtestdata.SatB2T3A5,
-			},
-		},
-	}
-	if !reflect.DeepEqual(ab, abw) {
-		t.Errorf("GetAddressBalance() = %+v, want %+v", ab, abw)
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
 	}
-	rs := ab.ReceivedSat()
-	rsw := dbtestdata.SatB1T2A5.Add(dbtestdata.SatB1T2A5, dbtestdata.SatB2T3A5)
-	if rs.Cmp(rsw) != 0 {
-		t.Errorf("GetAddressBalance().ReceivedSat() = %v, want %v", rs, rsw)
+	if err := checkColumn(d, cfBlockHeaders, []keyPair{
+		{"000370d5", hex.EncodeToString(header), nil},
+	}); err != nil {
+		t.Fatal(err)
 	}
-
-	ta, err := d.GetTxAddresses(dbtestdata.TxidB2T1)
+	h, err := d.GetBlockHeaderRaw(225493)
 	if err != nil {
 		t.Fatal(err)
 	}
-	taw := &TxAddresses{
-		Height: 225494,
-		Inputs: []TxInput{
-			{
-				AddrDesc: addressToAddrDesc(dbtestdata.Addr3, d.chainParser),
-				ValueSat: *dbtestdata.SatB1T2A3,
-			},
-			{
-				AddrDesc: addressToAddrDesc(dbtestdata.Addr2, d.chainParser),
-				ValueSat: *dbtestdata.SatB1T1A2,
-			},
-		},
-		Outputs: []TxOutput{
-			{
-				AddrDesc: addressToAddrDesc(dbtestdata.Addr6, d.chainParser),
-				Spent:    true,
-				ValueSat: *dbtestdata.SatB2T1A6,
-			},
-			{
-				AddrDesc: addressToAddrDesc(dbtestdata.Addr7, d.chainParser),
-				Spent:    false,
-				ValueSat: *dbtestdata.SatB2T1A7,
-			},
-			{
-				AddrDesc: hexToBytes(dbtestdata.TxidB2T1Output3OpReturn),
-				Spent:    false,
-				ValueSat: *dbtestdata.SatZero,
-			},
-		},
-	}
-	if !reflect.DeepEqual(ta, taw) {
-		t.Errorf("GetTxAddresses() = %+v, want %+v", ta, taw)
+	if !bytes.Equal(h, header) {
+		t.Errorf("GetBlockHeaderRaw() = %x, want %x", h, header)
 	}
-	ia, _, err := ta.Inputs[0].Addresses(d.chainParser)
+	h, err = d.GetBlockHeaderRaw(225494)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(ia, []string{dbtestdata.Addr3}) {
-		t.Errorf("GetTxAddresses().Inputs[0].Addresses() = %v, want %v", ia, []string{dbtestdata.Addr3})
+	if h != nil {
+		t.Errorf("GetBlockHeaderRaw() = %x, want nil", h)
 	}
 
+	if err := d.DisconnectBlockRangeBitcoinType(225493, 225494); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkColumn(d, cfBlockHeaders, []keyPair{}); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func Test_BulkConnect_BitcoinType(t *testing.T) {
+func Test_FiatRates(t *testing.T) {
 	d := setupRocksDB(t, &testBitcoinParser{
 		BitcoinParser: bitcoinTestnetParser(),
 	})
 	defer closeAndDestroyRocksDB(t, d)
 
-	bc, err := d.InitBulkConnect()
+	ticker, err := d.FiatRatesFindLastTicker()
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if d.is.DbState != common.DbStateInconsistent {
-		t.Fatal("DB not in DbStateInconsistent")
+	if ticker != nil {
+		t.Errorf("FiatRatesFindLastTicker() = %+v, want nil in empty db", ticker)
 	}
 
-	if err := bc.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser), false); err != nil {
-		t.Fatal(err)
-	}
-	if err := checkColumn(d, cfBlockTxs, []keyPair{}); err != nil {
-		{
+	for _, tr := range []struct {
+		ts    string
+		rates map[string]float64
+	}{
+		{"20190627000000", map[string]float64{"usd": 10000.5, "eur": 8800.1}},
+		{"20190628000000", map[string]float64{"usd": 11000.5}},
+	} {
+		ts, err := time.Parse(FiatRatesTimeFormat, tr.ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.FiatRatesStoreTicker(&CurrencyRatesTicker{Timestamp: &ts, Rates: tr.rates}); err != nil {
 			t.Fatal(err)
 		}
 	}
+	if err := d.FiatRatesStoreTicker(&CurrencyRatesTicker{}); err == nil {
+		t.Error("FiatRatesStoreTicker() of empty ticker must fail")
+	}
 
-	if err := bc.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser), true); err != nil {
-		t.Fatal(err)
+	tests := []struct {
+		name  string
+		ts    string
+		want  string
+		rates map[string]float64
+	}{
+		{"exact", "20190627000000", "20190627000000", map[string]float64{"usd": 10000.5, "eur": 8800.1}},
+		{"before all", "20190101000000", "20190627000000", map[string]float64{"usd": 10000.5, "eur": 8800.1}},
+		{"between", "20190627120000", "20190628000000", map[string]float64{"usd": 11000.5}},
+		{"after all", "20190629000000", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := time.Parse(FiatRatesTimeFormat, tt.ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := d.FiatRatesFindTicker(&ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("FiatRatesFindTicker() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("FiatRatesFindTicker() = nil")
+			}
+			if f := got.Timestamp.Format(FiatRatesTimeFormat); f != tt.want {
+				t.Errorf("FiatRatesFindTicker() timestamp = %v, want %v", f, tt.want)
+			}
+			if !reflect.DeepEqual(got.Rates, tt.rates) {
+				t.Errorf("FiatRatesFindTicker() rates = %v, want %v", got.Rates, tt.rates)
+			}
+		})
 	}
 
-	if err := bc.Close(); err != nil {
+	ticker, err = d.FiatRatesFindLastTicker()
+	if err != nil {
 		t.Fatal(err)
 	}
-
-	if d.is.DbState != common.DbStateOpen {
-		t.Fatal("DB not in DbStateOpen")
+	if ticker == nil || ticker.Timestamp.Format(FiatRatesTimeFormat) != "20190628000000" {
+		t.Errorf("FiatRatesFindLastTicker() = %+v, want ticker 20190628000000", ticker)
 	}
-
-	verifyAfterBitcoinTypeBlock2(t, d)
 }
 
 func Test_packBigint_unpackBigint(t *testing.T) {
@@ -3,6 +3,7 @@ package db
 import (
 	"blockbook/bchain"
 	"blockbook/common"
+	"encoding/hex"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -14,7 +15,7 @@ import (
 
 // SyncWorker is handle to SyncWorker
 type SyncWorker struct {
-	db                     *RocksDB
+	db                     Index
 	chain                  bchain.BlockChain
 	syncWorkers, syncChunk int
 	dryRun                 bool
@@ -23,10 +24,16 @@ type SyncWorker struct {
 	chanOsSignal           chan os.Signal
 	metrics                *common.Metrics
 	is                     *common.InternalState
+	events                 *eventPublisher
+	// set to 1 if the backend does not provide the raw block headers
+	noHeadersRaw int32
+	// optional source of the blocks for the parallel sync, read directly from the files of the backend
+	blockFiles                       *bchain.BlockFiles
+	blockFilesHits, blockFilesMisses uint64
 }
 
 // NewSyncWorker creates new SyncWorker and returns its handle
-func NewSyncWorker(db *RocksDB, chain bchain.BlockChain, syncWorkers, syncChunk int, minStartHeight int, dryRun bool, chanOsSignal chan os.Signal, metrics *common.Metrics, is *common.InternalState) (*SyncWorker, error) {
+func NewSyncWorker(db Index, chain bchain.BlockChain, syncWorkers, syncChunk int, minStartHeight int, dryRun bool, chanOsSignal chan os.Signal, metrics *common.Metrics, is *common.InternalState) (*SyncWorker, error) {
 	if minStartHeight < 0 {
 		minStartHeight = 0
 	}
@@ -43,6 +50,12 @@ func NewSyncWorker(db *RocksDB, chain bchain.BlockChain, syncWorkers, syncChunk
 	}, nil
 }
 
+// SetBlockFiles sets the block files of the backend used as the source of the blocks in ConnectBlocksParallel
+// the blocks not found in the files are fetched from the backend
+func (w *SyncWorker) SetBlockFiles(bf *bchain.BlockFiles) {
+	w.blockFiles = bf
+}
+
 var errSynced = errors.New("synced")
 
 // ErrOperationInterrupted is returned when operation is interrupted by OS signal
@@ -86,8 +99,14 @@ func (w *SyncWorker) ResyncIndex(onNewBlock bchain.OnNewBlockFunc, initialSync b
 func (w *SyncWorker) resyncIndex(onNewBlock bchain.OnNewBlockFunc, initialSync bool) error {
 	remoteBestHash, err := w.chain.GetBestBlockHash()
 	if err != nil {
+		if w.is.SetBackendAvailable(false) {
+			glog.Warning("sync: backend is unavailable, serving only the data stored in db")
+		}
 		return err
 	}
+	if w.is.SetBackendAvailable(true) {
+		glog.Info("sync: backend is available again")
+	}
 	localBestHeight, localBestHash, err := w.db.GetBestBlock()
 	if err != nil {
 		return err
@@ -121,7 +140,8 @@ func (w *SyncWorker) resyncIndex(onNewBlock bchain.OnNewBlockFunc, initialSync b
 	// if parallel operation is enabled and the number of blocks to be connected is large,
 	// use parallel routine to load majority of blocks
 	// use parallel sync only in case of initial sync because it puts the db to inconsistent state
-	if w.syncWorkers > 1 && initialSync {
+	// the bulk connect is implemented only by RocksDB
+	if _, isRocksDB := w.db.(*RocksDB); isRocksDB && w.syncWorkers > 1 && initialSync {
 		remoteBestHeight, err := w.chain.GetBestBlockHeight()
 		if err != nil {
 			return err
@@ -190,6 +210,9 @@ func (w *SyncWorker) connectBlocks(onNewBlock bchain.OnNewBlockFunc, initialSync
 		if err != nil {
 			return err
 		}
+		if w.events != nil {
+			w.events.blockConnected(res.block)
+		}
 		if onNewBlock != nil {
 			onNewBlock(res.block.Hash, res.block.Height)
 		}
@@ -236,6 +259,10 @@ func (w *SyncWorker) connectBlocks(onNewBlock bchain.OnNewBlockFunc, initialSync
 
 // ConnectBlocksParallel uses parallel goroutines to get data from blockchain daemon
 func (w *SyncWorker) ConnectBlocksParallel(lower, higher uint32) error {
+	rdb, ok := w.db.(*RocksDB)
+	if !ok {
+		return errors.New("ConnectBlocksParallel is supported only by RocksDB")
+	}
 	type hashHeight struct {
 		hash   string
 		height uint32
@@ -253,12 +280,12 @@ func (w *SyncWorker) ConnectBlocksParallel(lower, higher uint32) error {
 	terminating := make(chan struct{})
 	writeBlockWorker := func() {
 		defer close(writeBlockDone)
-		bc, err := w.db.InitBulkConnect()
+		bc, err := rdb.InitBulkConnect()
 		if err != nil {
 			glog.Error("sync: InitBulkConnect error ", err)
 		}
 		lastBlock := lower - 1
-		keep := uint32(w.chain.GetChainParser().KeepBlockAddresses())
+		keep := rdb.BlockTxsToKeep()
 	WriteBlockLoop:
 		for {
 			select {
@@ -292,7 +319,7 @@ func (w *SyncWorker) ConnectBlocksParallel(lower, higher uint32) error {
 	GetBlockLoop:
 		for hh := range hch {
 			for {
-				block, err = w.chain.GetBlock(hh.hash, hh.height)
+				block, err = w.getBlockParallel(hh.hash, hh.height)
 				if err != nil {
 					// signal came while looping in the error loop
 					if hchClosed.Load() == true {
@@ -344,11 +371,11 @@ ConnectLoop:
 			}
 			hch <- hashHeight{hash, h}
 			if h > 0 && h%1000 == 0 {
-				glog.Info("connecting block ", h, " ", hash, ", elapsed ", time.Since(start), " ", w.db.GetAndResetConnectBlockStats())
+				glog.Info("connecting block ", h, " ", hash, ", elapsed ", time.Since(start), " ", rdb.GetAndResetConnectBlockStats())
 				start = time.Now()
 			}
 			if msTime.Before(time.Now()) {
-				glog.Info(w.db.GetMemoryStats())
+				glog.Info(rdb.GetMemoryStats())
 				w.metrics.IndexDBSize.Set(float64(w.db.DatabaseSizeOnDisk()))
 				msTime = time.Now().Add(10 * time.Minute)
 			}
@@ -364,9 +391,40 @@ ConnectLoop:
 		close(bch[i])
 	}
 	<-writeBlockDone
+	if w.blockFiles != nil {
+		glog.Info("connectBlocksParallel: ", atomic.LoadUint64(&w.blockFilesHits), " blocks read from the block files, ",
+			atomic.LoadUint64(&w.blockFilesMisses), " blocks fetched from the backend")
+	}
 	return err
 }
 
+// getBlockParallel gets the block from the block files of the backend if they are set and contain the block,
+// otherwise from the backend
+func (w *SyncWorker) getBlockParallel(hash string, height uint32) (*bchain.Block, error) {
+	if w.blockFiles == nil {
+		return w.getBlock(hash, height)
+	}
+	data, err := w.blockFiles.GetBlockRaw(hash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		atomic.AddUint64(&w.blockFilesMisses, 1)
+		return w.getBlock(hash, height)
+	}
+	block, err := w.chain.GetChainParser().ParseBlock(data)
+	if err != nil {
+		return nil, errors.Annotatef(err, "ParseBlock %v %v", height, hash)
+	}
+	block.Hash = hash
+	block.Height = height
+	if err = w.setBlockHeaderRaw(block); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&w.blockFilesHits, 1)
+	return block, nil
+}
+
 type blockResult struct {
 	block *bchain.Block
 	err   error
@@ -386,7 +444,7 @@ func (w *SyncWorker) getBlockChain(out chan blockResult, done chan struct{}) {
 			return
 		default:
 		}
-		block, err := w.chain.GetBlock(hash, height)
+		block, err := w.getBlock(hash, height)
 		if err != nil {
 			if err == bchain.ErrBlockNotFound {
 				break
@@ -400,14 +458,106 @@ func (w *SyncWorker) getBlockChain(out chan blockResult, done chan struct{}) {
 	}
 }
 
+// getBlock gets the block from the backend together with its raw header, which is stored in the blockHeaders column
+func (w *SyncWorker) getBlock(hash string, height uint32) (*bchain.Block, error) {
+	block, err := w.chain.GetBlock(hash, height)
+	if err != nil {
+		return nil, err
+	}
+	if err = w.setBlockHeaderRaw(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// setBlockHeaderRaw requests the raw header of the block from the backend, only if the parser did not take it from the raw block
+func (w *SyncWorker) setBlockHeaderRaw(block *bchain.Block) error {
+	if block.HeaderRaw != nil || atomic.LoadInt32(&w.noHeadersRaw) != 0 {
+		return nil
+	}
+	h, err := w.chain.GetBlockHeaderRaw(block.Hash)
+	if err != nil {
+		if err != bchain.ErrNotSupported {
+			return errors.Annotatef(err, "GetBlockHeaderRaw %v", block.Hash)
+		}
+		if atomic.CompareAndSwapInt32(&w.noHeadersRaw, 0, 1) {
+			glog.Info("sync: the backend does not provide raw block headers, the headers are not stored")
+		}
+		return nil
+	}
+	if block.HeaderRaw, err = hex.DecodeString(h); err != nil {
+		return errors.Annotatef(err, "GetBlockHeaderRaw %v", block.Hash)
+	}
+	return nil
+}
+
 // DisconnectBlocks removes all data belonging to blocks in range lower-higher,
 func (w *SyncWorker) DisconnectBlocks(lower uint32, higher uint32, hashes []string) error {
 	glog.Infof("sync: disconnecting blocks %d-%d", lower, higher)
+	// the events must be created before the data of the blocks are removed
+	var events []*SinkEvent
+	if w.events != nil {
+		for height := higher; height >= lower && height <= higher; height-- {
+			e, err := w.events.blockDisconnecting(height)
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+	}
+	var err error
 	ct := w.chain.GetChainParser().GetChainType()
 	if ct == bchain.ChainBitcoinType {
-		return w.db.DisconnectBlockRangeBitcoinType(lower, higher)
+		err = w.db.DisconnectBlockRangeBitcoinType(lower, higher)
 	} else if ct == bchain.ChainEthereumType {
-		return w.db.DisconnectBlockRangeEthereumType(lower, higher)
+		err = w.db.DisconnectBlockRangeEthereumType(lower, higher)
+	} else {
+		err = errors.New("Unknown chain type")
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		w.events.publish(e)
+	}
+	return nil
+}
+
+// BuildSpendingIndex fills the spending index of blocks below the height from which the index is complete
+// the blocks are processed from the top so that the operation can be interrupted and resumed
+func (w *SyncWorker) BuildSpendingIndex() error {
+	if w.chain.GetChainParser().GetChainType() != bchain.ChainBitcoinType {
+		return errors.New("Spending index is supported only for bitcoin type coins")
+	}
+	from := w.is.GetSpendingIndexFromHeight()
+	if from == 0 {
+		glog.Info("sync: spending index is complete")
+		return nil
+	}
+	glog.Info("sync: building spending index for blocks 0-", from-1)
+	start := time.Now()
+	for height := int64(from) - 1; height >= 0; height-- {
+		select {
+		case <-w.chanOsSignal:
+			glog.Info("sync: building of spending index interrupted at height ", height)
+			return w.db.StoreInternalState(w.is)
+		default:
+		}
+		block, err := w.chain.GetBlock("", uint32(height))
+		if err != nil {
+			return err
+		}
+		if err := w.db.ConnectBlockSpending(block); err != nil {
+			return err
+		}
+		w.is.SetSpendingIndexFromHeight(uint32(height))
+		if height%1000 == 0 {
+			glog.Info("sync: spending index built down to height ", height, ", elapsed ", time.Since(start))
+			if err := w.db.StoreInternalState(w.is); err != nil {
+				return err
+			}
+		}
 	}
-	return errors.New("Unknown chain type")
+	glog.Info("sync: spending index built in ", time.Since(start))
+	return w.db.StoreInternalState(w.is)
 }
@@ -11,7 +11,7 @@ import (
 
 // TxCache is handle to TxCacheServer
 type TxCache struct {
-	db        *RocksDB
+	db        Index
 	chain     bchain.BlockChain
 	metrics   *common.Metrics
 	is        *common.InternalState
@@ -20,7 +20,7 @@ type TxCache struct {
 }
 
 // NewTxCache creates new TxCache interface and returns its handle
-func NewTxCache(db *RocksDB, chain bchain.BlockChain, metrics *common.Metrics, is *common.InternalState, enabled bool) (*TxCache, error) {
+func NewTxCache(db Index, chain bchain.BlockChain, metrics *common.Metrics, is *common.InternalState, enabled bool) (*TxCache, error) {
 	if !enabled {
 		glog.Info("txcache: disabled")
 	}
@@ -37,21 +37,13 @@ func NewTxCache(db *RocksDB, chain bchain.BlockChain, metrics *common.Metrics, i
 // GetTransaction returns transaction either from RocksDB or if not present from blockchain
 // it the transaction is confirmed, it is stored in the RocksDB
 func (c *TxCache) GetTransaction(txid string) (*bchain.Tx, uint32, error) {
-	var tx *bchain.Tx
-	var h uint32
-	var err error
-	if c.enabled {
-		tx, h, err = c.db.GetTx(txid)
-		if err != nil {
-			return nil, 0, err
-		}
-		if tx != nil {
-			// number of confirmations is not stored in cache, they change all the time
-			_, bestheight, _ := c.is.GetSyncState()
-			tx.Confirmations = bestheight - h + 1
-			c.metrics.TxCacheEfficiency.With(common.Labels{"status": "hit"}).Inc()
-			return tx, h, nil
-		}
+	tx, h, err := c.GetCachedTransaction(txid)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tx != nil {
+		c.metrics.TxCacheEfficiency.With(common.Labels{"status": "hit"}).Inc()
+		return tx, h, nil
 	}
 	tx, err = c.chain.GetTransaction(txid)
 	if err != nil {
@@ -82,7 +74,8 @@ func (c *TxCache) GetTransaction(txid string) (*bchain.Tx, uint32, error) {
 		} else {
 			return nil, 0, errors.New("Unknown chain type")
 		}
-		if c.enabled {
+		// the replica cannot write to the db, the transactions are cached by the primary
+		if c.enabled && !c.db.IsReplica() {
 			err = c.db.PutTx(tx, h, tx.Blocktime)
 			// do not return caching error, only log it
 			if err != nil {
@@ -94,3 +87,19 @@ func (c *TxCache) GetTransaction(txid string) (*bchain.Tx, uint32, error) {
 	}
 	return tx, h, nil
 }
+
+// GetCachedTransaction returns transaction stored in RocksDB without contacting the backend
+// it returns nil if the cache is disabled or the transaction is not stored
+func (c *TxCache) GetCachedTransaction(txid string) (*bchain.Tx, uint32, error) {
+	if !c.enabled {
+		return nil, 0, nil
+	}
+	tx, h, err := c.db.GetTx(txid)
+	if err != nil || tx == nil {
+		return nil, 0, err
+	}
+	// number of confirmations is not stored in cache, they change all the time
+	_, bestheight, _ := c.is.GetSyncState()
+	tx.Confirmations = bestheight - h + 1
+	return tx, h, nil
+}
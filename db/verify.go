@@ -0,0 +1,674 @@
+package db
+
+import (
+	"blockbook/bchain"
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+// Verification of the index
+// the balances of the addresses are recomputed from the address history and txAddresses, the utxos of the balances are checked
+// against txAddresses and the rollback data in blockTxs are checked against txAddresses and the blocks
+// the blocks in a height range can be verified against the blocks of the backend, then only the addresses of these blocks are checked
+// in the pruned mode, the balances cannot be recomputed from the history, only the utxos are checked
+
+// kinds of mismatches found by the verification
+const (
+	mismatchBalanceMissing     = "balanceMissing"     // address has history but no balance
+	mismatchBalanceTxs         = "balanceTxs"         // number of txs in balance differs from history
+	mismatchBalance            = "balance"            // balance differs from history or from the sum of utxos
+	mismatchSent               = "sent"               // sent amount differs from history
+	mismatchUtxoMissing        = "utxoMissing"        // unspent output in history is not in the utxos of the balance
+	mismatchUtxoSpent          = "utxoSpent"          // utxo of the balance is spent or does not exist in txAddresses
+	mismatchUtxo               = "utxo"               // utxo of the balance differs from the output in txAddresses
+	mismatchHistory            = "history"            // history row does not match txAddresses
+	mismatchHistoryMissing     = "historyMissing"     // transaction of the address is missing in its history
+	mismatchTxAddressesMissing = "txAddressesMissing" // transaction is not in txAddresses
+	mismatchTxAddresses        = "txAddresses"        // txAddresses differ from the transaction in the block
+	mismatchBlockHash          = "blockHash"          // hash of the block in db differs from the backend
+	mismatchBlockInfo          = "blockInfo"          // block info is missing or differs from the block
+	mismatchBlockTxs           = "blockTxs"           // rollback data of the block do not match txAddresses
+)
+
+// the mismatches of these kinds are fixed by the rebuild of the address balance
+var fixableMismatches = map[string]struct{}{
+	mismatchBalanceMissing: {},
+	mismatchBalanceTxs:     {},
+	mismatchBalance:        {},
+	mismatchSent:           {},
+	mismatchUtxoMissing:    {},
+	mismatchUtxoSpent:      {},
+	mismatchUtxo:           {},
+}
+
+// at most this number of mismatches is listed in the report, all are counted
+const maxReportedMismatches = 100000
+
+// number of addresses of the verified blocks collected before they are verified
+const verifyAddressesBatch = 10000
+
+// VerifyMismatch is an inconsistency found by the verification of the db
+type VerifyMismatch struct {
+	Kind     string `json:"kind"`
+	AddrDesc string `json:"addrDesc,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Txid     string `json:"txid,omitempty"`
+	Height   uint32 `json:"height,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Found    string `json:"found,omitempty"`
+	Fixed    bool   `json:"fixed,omitempty"`
+}
+
+// VerifyReport is the result of the verification of the db
+type VerifyReport struct {
+	From             uint32           `json:"from,omitempty"`
+	To               uint32           `json:"to,omitempty"`
+	Blocks           int              `json:"blocks"`
+	Addresses        int              `json:"addresses"`
+	Mismatches       int              `json:"mismatches"`
+	Fixed            int              `json:"fixed"`
+	RebuiltAddresses int              `json:"rebuiltAddresses"`
+	Interrupted      bool             `json:"interrupted,omitempty"`
+	Items            []VerifyMismatch `json:"items"`
+}
+
+// addrMismatch is a mismatch of the currently verified address, index is the position in the report or -1 if not listed
+type addrMismatch struct {
+	kind  string
+	index int
+}
+
+type verifier struct {
+	d           *RocksDB
+	fix         bool
+	pruned      bool
+	historyFrom uint32
+	report      *VerifyReport
+	// mismatches of the currently verified address, they are marked as fixed after the rebuild
+	addrMismatches []addrMismatch
+}
+
+func (d *RocksDB) newVerifier(fix bool) (*verifier, error) {
+	if d.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return nil, errors.New("Verification of the db is supported only for bitcoin type coins")
+	}
+	if fix && d.replica != nil {
+		return nil, errors.New("The database must be fixed by the primary instance")
+	}
+	historyFrom, err := d.AddressHistoryFromHeight()
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{
+		d:           d,
+		fix:         fix,
+		pruned:      d.IsPruned(),
+		historyFrom: historyFrom,
+		report:      &VerifyReport{Items: []VerifyMismatch{}},
+	}, nil
+}
+
+func (v *verifier) address(addrDesc bchain.AddressDescriptor) string {
+	a, _, err := v.d.chainParser.GetAddressesFromAddrDesc(addrDesc)
+	if err == nil && len(a) == 1 {
+		return a[0]
+	}
+	return ""
+}
+
+func (v *verifier) txid(btxID []byte) string {
+	txid, err := v.d.chainParser.UnpackTxid(btxID)
+	if err != nil {
+		return hex.EncodeToString(btxID)
+	}
+	return txid
+}
+
+func (v *verifier) mismatch(m VerifyMismatch, addrDesc bchain.AddressDescriptor) {
+	if addrDesc != nil {
+		m.AddrDesc = hex.EncodeToString(addrDesc)
+		m.Address = v.address(addrDesc)
+	}
+	glog.Warningf("verify: %+v", m)
+	v.report.Mismatches++
+	index := -1
+	if len(v.report.Items) < maxReportedMismatches {
+		index = len(v.report.Items)
+		v.report.Items = append(v.report.Items, m)
+	}
+	v.addrMismatches = append(v.addrMismatches, addrMismatch{kind: m.Kind, index: index})
+}
+
+func (v *verifier) interrupted(stop chan os.Signal) bool {
+	select {
+	case <-stop:
+		v.report.Interrupted = true
+		return true
+	default:
+		return false
+	}
+}
+
+func utxoKey(btxID []byte, vout int32) string {
+	return string(btxID) + strconv.Itoa(int(vout))
+}
+
+// balanceFromHistory computes the balance of the address from its history and txAddresses
+func (v *verifier) balanceFromHistory(addrDesc bchain.AddressDescriptor) (*AddrBalance, error) {
+	ab := &AddrBalance{}
+	err := v.d.GetAddrDescTransactions(addrDesc, 0, ^uint32(0), func(txid string, height uint32, indexes []int32) error {
+		btxID, err := v.d.chainParser.PackTxid(txid)
+		if err != nil {
+			return err
+		}
+		ta, err := v.d.getTxAddresses(btxID)
+		if err != nil {
+			return err
+		}
+		if ta == nil {
+			v.mismatch(VerifyMismatch{Kind: mismatchTxAddressesMissing, Txid: txid, Height: height}, addrDesc)
+			return nil
+		}
+		if ta.Height != height {
+			v.mismatch(VerifyMismatch{Kind: mismatchHistory, Txid: txid, Height: height, Expected: "height " + strconv.Itoa(int(ta.Height))}, addrDesc)
+		}
+		ab.Txs++
+		for _, index := range indexes {
+			if index >= 0 {
+				if int(index) >= len(ta.Outputs) || !bytes.Equal(ta.Outputs[index].AddrDesc, addrDesc) {
+					v.mismatch(VerifyMismatch{Kind: mismatchHistory, Txid: txid, Height: height, Found: "output " + strconv.Itoa(int(index))}, addrDesc)
+					continue
+				}
+				o := &ta.Outputs[index]
+				ab.BalanceSat.Add(&ab.BalanceSat, &o.ValueSat)
+				if !o.Spent {
+					ab.Utxos = append(ab.Utxos, Utxo{BtxID: btxID, Vout: index, Height: ta.Height, ValueSat: o.ValueSat})
+				}
+			} else {
+				i := ^index
+				if int(i) >= len(ta.Inputs) || !bytes.Equal(ta.Inputs[i].AddrDesc, addrDesc) {
+					v.mismatch(VerifyMismatch{Kind: mismatchHistory, Txid: txid, Height: height, Found: "input " + strconv.Itoa(int(i))}, addrDesc)
+					continue
+				}
+				ab.BalanceSat.Sub(&ab.BalanceSat, &ta.Inputs[i].ValueSat)
+				ab.SentSat.Add(&ab.SentSat, &ta.Inputs[i].ValueSat)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the history is from the newest to the oldest, the utxos are stored from the oldest
+	sort.SliceStable(ab.Utxos, func(i, j int) bool {
+		return ab.Utxos[i].Height < ab.Utxos[j].Height
+	})
+	return ab, nil
+}
+
+// checkUtxos checks the utxos of the balance against txAddresses, returns the valid utxos
+func (v *verifier) checkUtxos(addrDesc bchain.AddressDescriptor, ab *AddrBalance) ([]Utxo, error) {
+	valid := make([]Utxo, 0, len(ab.Utxos))
+	for i := range ab.Utxos {
+		u := &ab.Utxos[i]
+		ta, err := v.d.getTxAddresses(u.BtxID)
+		if err != nil {
+			return nil, err
+		}
+		if ta == nil || int(u.Vout) >= len(ta.Outputs) || u.Vout < 0 {
+			v.mismatch(VerifyMismatch{Kind: mismatchUtxoSpent, Txid: v.txid(u.BtxID), Height: u.Height, Found: "vout " + strconv.Itoa(int(u.Vout)) + " not in txAddresses"}, addrDesc)
+			continue
+		}
+		o := &ta.Outputs[u.Vout]
+		if o.Spent {
+			v.mismatch(VerifyMismatch{Kind: mismatchUtxoSpent, Txid: v.txid(u.BtxID), Height: u.Height, Found: "vout " + strconv.Itoa(int(u.Vout)) + " spent"}, addrDesc)
+			continue
+		}
+		if !bytes.Equal(o.AddrDesc, addrDesc) || o.ValueSat.Cmp(&u.ValueSat) != 0 || ta.Height != u.Height {
+			v.mismatch(VerifyMismatch{
+				Kind:     mismatchUtxo,
+				Txid:     v.txid(u.BtxID),
+				Height:   u.Height,
+				Expected: "vout " + strconv.Itoa(int(u.Vout)) + " value " + o.ValueSat.String() + " height " + strconv.Itoa(int(ta.Height)) + " addrDesc " + hex.EncodeToString(o.AddrDesc),
+				Found:    "value " + u.ValueSat.String(),
+			}, addrDesc)
+			continue
+		}
+		valid = append(valid, *u)
+	}
+	return valid, nil
+}
+
+// verifyAddress verifies the balance of the address, ab is nil if the address has no balance
+func (v *verifier) verifyAddress(addrDesc bchain.AddressDescriptor, ab *AddrBalance) error {
+	v.report.Addresses++
+	v.addrMismatches = v.addrMismatches[:0]
+	var hb *AddrBalance
+	var err error
+	if !v.pruned {
+		if hb, err = v.balanceFromHistory(addrDesc); err != nil {
+			return err
+		}
+	}
+	if ab == nil {
+		if hb != nil && hb.Txs > 0 {
+			v.mismatch(VerifyMismatch{Kind: mismatchBalanceMissing, Expected: "txs " + strconv.Itoa(int(hb.Txs))}, addrDesc)
+			return v.rebuildAddress(addrDesc, hb)
+		}
+		return nil
+	}
+	valid, err := v.checkUtxos(addrDesc, ab)
+	if err != nil {
+		return err
+	}
+	if hb == nil {
+		// pruned mode, the balance must be the sum of the utxos
+		var sum big.Int
+		for i := range valid {
+			sum.Add(&sum, &valid[i].ValueSat)
+		}
+		if sum.Cmp(&ab.BalanceSat) != 0 {
+			v.mismatch(VerifyMismatch{Kind: mismatchBalance, Expected: sum.String(), Found: ab.BalanceSat.String()}, addrDesc)
+		}
+		if len(v.addrMismatches) > 0 {
+			ab.Utxos = valid
+			ab.BalanceSat = sum
+			return v.rebuildAddress(addrDesc, ab)
+		}
+		return nil
+	}
+	if hb.Txs != ab.Txs {
+		v.mismatch(VerifyMismatch{Kind: mismatchBalanceTxs, Expected: strconv.Itoa(int(hb.Txs)), Found: strconv.Itoa(int(ab.Txs))}, addrDesc)
+	}
+	if hb.BalanceSat.Cmp(&ab.BalanceSat) != 0 {
+		v.mismatch(VerifyMismatch{Kind: mismatchBalance, Expected: hb.BalanceSat.String(), Found: ab.BalanceSat.String()}, addrDesc)
+	}
+	if hb.SentSat.Cmp(&ab.SentSat) != 0 {
+		v.mismatch(VerifyMismatch{Kind: mismatchSent, Expected: hb.SentSat.String(), Found: ab.SentSat.String()}, addrDesc)
+	}
+	balanceUtxos := make(map[string]struct{}, len(valid))
+	for i := range valid {
+		balanceUtxos[utxoKey(valid[i].BtxID, valid[i].Vout)] = struct{}{}
+	}
+	historyUtxos := make(map[string]struct{}, len(hb.Utxos))
+	for i := range hb.Utxos {
+		u := &hb.Utxos[i]
+		k := utxoKey(u.BtxID, u.Vout)
+		historyUtxos[k] = struct{}{}
+		if _, found := balanceUtxos[k]; !found {
+			v.mismatch(VerifyMismatch{Kind: mismatchUtxoMissing, Txid: v.txid(u.BtxID), Height: u.Height, Expected: "vout " + strconv.Itoa(int(u.Vout))}, addrDesc)
+		}
+	}
+	for i := range valid {
+		u := &valid[i]
+		if _, found := historyUtxos[utxoKey(u.BtxID, u.Vout)]; !found {
+			v.mismatch(VerifyMismatch{Kind: mismatchHistoryMissing, Txid: v.txid(u.BtxID), Height: u.Height, Found: "utxo vout " + strconv.Itoa(int(u.Vout))}, addrDesc)
+		}
+	}
+	if len(v.addrMismatches) > 0 {
+		return v.rebuildAddress(addrDesc, hb)
+	}
+	return nil
+}
+
+// rebuildAddress stores the balance of the address computed by the verification if the fix is requested
+// the balance is not rebuilt if the history of the address does not match txAddresses, the computed balance would be wrong
+func (v *verifier) rebuildAddress(addrDesc bchain.AddressDescriptor, ab *AddrBalance) error {
+	if !v.fix {
+		return nil
+	}
+	fixable := false
+	for _, m := range v.addrMismatches {
+		if m.kind == mismatchHistory || m.kind == mismatchTxAddressesMissing {
+			glog.Warning("verify: balance of address ", hex.EncodeToString(addrDesc), " not rebuilt, its history does not match txAddresses")
+			return nil
+		}
+		if _, f := fixableMismatches[m.kind]; f {
+			fixable = true
+		}
+	}
+	if !fixable {
+		return nil
+	}
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	if err := v.d.storeBalances(wb, map[string]*AddrBalance{string(addrDesc): ab}); err != nil {
+		return err
+	}
+	if err := v.d.db.Write(v.d.wo, wb); err != nil {
+		return err
+	}
+	v.report.RebuiltAddresses++
+	for _, m := range v.addrMismatches {
+		if _, f := fixableMismatches[m.kind]; f {
+			v.report.Fixed++
+			if m.index >= 0 {
+				v.report.Items[m.index].Fixed = true
+			}
+		}
+	}
+	glog.Info("verify: rebuilt balance of address ", hex.EncodeToString(addrDesc))
+	return nil
+}
+
+// verifyBlockTxs checks the rollback data of the block against txAddresses
+func (v *verifier) verifyBlockTxs(height uint32) error {
+	bt, err := v.d.getBlockTxs(height)
+	if err != nil {
+		v.mismatch(VerifyMismatch{Kind: mismatchBlockTxs, Height: height, Found: err.Error()}, nil)
+		return nil
+	}
+	bi, err := v.d.GetBlockInfo(height)
+	if err != nil {
+		return err
+	}
+	if bi == nil || int(bi.Txs) != len(bt) {
+		found := "missing"
+		if bi != nil {
+			found = "txs " + strconv.Itoa(int(bi.Txs))
+		}
+		v.mismatch(VerifyMismatch{Kind: mismatchBlockInfo, Height: height, Expected: "txs " + strconv.Itoa(len(bt)), Found: found}, nil)
+	}
+	zeroTx := make([]byte, v.d.chainParser.PackedTxidLen())
+	for i := range bt {
+		ta, err := v.d.getTxAddresses(bt[i].btxID)
+		if err != nil {
+			return err
+		}
+		if ta == nil {
+			v.mismatch(VerifyMismatch{Kind: mismatchTxAddressesMissing, Txid: v.txid(bt[i].btxID), Height: height}, nil)
+			continue
+		}
+		if ta.Height != height {
+			v.mismatch(VerifyMismatch{Kind: mismatchBlockTxs, Txid: v.txid(bt[i].btxID), Height: height, Found: "txAddresses height " + strconv.Itoa(int(ta.Height))}, nil)
+		}
+		for _, o := range bt[i].inputs {
+			if bytes.Equal(o.btxID, zeroTx) {
+				continue
+			}
+			ita, err := v.d.getTxAddresses(o.btxID)
+			if err != nil {
+				return err
+			}
+			if ita == nil || o.index < 0 || int(o.index) >= len(ita.Outputs) || !ita.Outputs[o.index].Spent {
+				v.mismatch(VerifyMismatch{Kind: mismatchBlockTxs, Txid: v.txid(bt[i].btxID), Height: height, Found: "input " + v.txid(o.btxID) + ":" + strconv.Itoa(int(o.index)) + " not spent in txAddresses"}, nil)
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyDB verifies the balances of all addresses, the addresses with history and the rollback data
+// if fix is set, the balances of the addresses with mismatches are rebuilt
+func (d *RocksDB) VerifyDB(fix bool, stop chan os.Signal) (*VerifyReport, error) {
+	v, err := d.newVerifier(fix)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	glog.Info("verify: start of verification of the whole db")
+	ro := gorocksdb.NewDefaultReadOptions()
+	ro.SetFillCache(false)
+	defer ro.Destroy()
+	pl := d.chainParser.PackedTxidLen()
+	it := d.db.NewIteratorCF(ro, d.cfh[cfAddressBalance])
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if v.interrupted(stop) {
+			it.Close()
+			return v.report, nil
+		}
+		addrDesc := append(bchain.AddressDescriptor(nil), it.Key().Data()...)
+		ab, err := unpackAddrBalance(it.Value().Data(), pl, AddressBalanceDetailUTXO)
+		if err != nil {
+			it.Close()
+			return nil, errors.Annotatef(err, "addrDesc %v", hex.EncodeToString(addrDesc))
+		}
+		if err = v.verifyAddress(addrDesc, ab); err != nil {
+			it.Close()
+			return nil, err
+		}
+		if v.report.Addresses%100000 == 0 {
+			glog.Info("verify: ", v.report.Addresses, " addresses, ", v.report.Mismatches, " mismatches, in progress...")
+		}
+	}
+	it.Close()
+	// the addresses with history must have balance
+	var last bchain.AddressDescriptor
+	it = d.db.NewIteratorCF(ro, d.cfh[cfAddresses])
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if v.interrupted(stop) {
+			it.Close()
+			return v.report, nil
+		}
+		addrDesc, _, err := unpackAddressKey(it.Key().Data())
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if bytes.Equal(addrDesc, last) {
+			continue
+		}
+		last = append(last[:0], addrDesc...)
+		ab, err := d.GetAddrDescBalance(last, AddressBalanceDetailNoUTXO)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if ab == nil {
+			if err = v.verifyAddress(append(bchain.AddressDescriptor(nil), last...), nil); err != nil {
+				it.Close()
+				return nil, err
+			}
+		}
+	}
+	it.Close()
+	it = d.db.NewIteratorCF(ro, d.cfh[cfBlockTxs])
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if v.interrupted(stop) {
+			return v.report, nil
+		}
+		if err = v.verifyBlockTxs(unpackUint(it.Key().Data())); err != nil {
+			return nil, err
+		}
+		v.report.Blocks++
+	}
+	glog.Info("verify: finished in ", time.Since(start), ", ", v.report.Addresses, " addresses, ", v.report.Blocks, " blocks, ",
+		v.report.Mismatches, " mismatches, ", v.report.Fixed, " fixed")
+	return v.report, nil
+}
+
+// verifyBlock verifies txAddresses and the address history of the block from the backend, the addresses of the block are added to addrDescs
+func (v *verifier) verifyBlock(block *bchain.Block, addrDescs map[string]struct{}) error {
+	d := v.d
+	v.addrMismatches = v.addrMismatches[:0]
+	hash, err := d.GetBlockHash(block.Height)
+	if err != nil {
+		return err
+	}
+	if hash != block.Hash {
+		v.mismatch(VerifyMismatch{Kind: mismatchBlockHash, Height: block.Height, Expected: block.Hash, Found: hash}, nil)
+		return nil
+	}
+	bi, err := d.GetBlockInfo(block.Height)
+	if err != nil {
+		return err
+	}
+	if bi == nil || int(bi.Txs) != len(block.Txs) {
+		found := "missing"
+		if bi != nil {
+			found = "txs " + strconv.Itoa(int(bi.Txs))
+		}
+		v.mismatch(VerifyMismatch{Kind: mismatchBlockInfo, Height: block.Height, Expected: "txs " + strconv.Itoa(len(block.Txs)), Found: found}, nil)
+	}
+	// transactions of the addresses in the block, to be found in the address history
+	history := make(map[string]map[string]struct{})
+	addToHistory := func(addrDesc bchain.AddressDescriptor, txid string) {
+		if !d.isAddrDescIndexable(addrDesc) {
+			return
+		}
+		s := string(addrDesc)
+		addrDescs[s] = struct{}{}
+		h, found := history[s]
+		if !found {
+			h = make(map[string]struct{})
+			history[s] = h
+		}
+		h[txid] = struct{}{}
+	}
+	for i := range block.Txs {
+		tx := &block.Txs[i]
+		btxID, err := d.chainParser.PackTxid(tx.Txid)
+		if err != nil {
+			return err
+		}
+		ta, err := d.getTxAddresses(btxID)
+		if err != nil {
+			return err
+		}
+		if ta == nil {
+			// in the pruned mode, the completely spent transactions are removed
+			if !v.pruned {
+				v.mismatch(VerifyMismatch{Kind: mismatchTxAddressesMissing, Txid: tx.Txid, Height: block.Height}, nil)
+			}
+			continue
+		}
+		if ta.Height != block.Height || len(ta.Inputs) != len(tx.Vin) || len(ta.Outputs) != len(tx.Vout) {
+			v.mismatch(VerifyMismatch{
+				Kind:     mismatchTxAddresses,
+				Txid:     tx.Txid,
+				Height:   block.Height,
+				Expected: "height " + strconv.Itoa(int(block.Height)) + " inputs " + strconv.Itoa(len(tx.Vin)) + " outputs " + strconv.Itoa(len(tx.Vout)),
+				Found:    "height " + strconv.Itoa(int(ta.Height)) + " inputs " + strconv.Itoa(len(ta.Inputs)) + " outputs " + strconv.Itoa(len(ta.Outputs)),
+			}, nil)
+			continue
+		}
+		for j := range tx.Vout {
+			addrDesc, err := d.chainParser.GetAddrDescFromVout(&tx.Vout[j])
+			// the long scripts are stored only with the block filter index
+			if err != nil || len(addrDesc) > maxAddrDescLen && !d.IsBlockFilterIndexEnabled() {
+				addrDesc = nil
+			}
+			o := &ta.Outputs[j]
+			if !bytes.Equal(addrDesc, o.AddrDesc) || tx.Vout[j].ValueSat.Cmp(&o.ValueSat) != 0 {
+				v.mismatch(VerifyMismatch{
+					Kind:     mismatchTxAddresses,
+					Txid:     tx.Txid,
+					Height:   block.Height,
+					Expected: "vout " + strconv.Itoa(j) + " value " + tx.Vout[j].ValueSat.String() + " addrDesc " + hex.EncodeToString(addrDesc),
+					Found:    "value " + o.ValueSat.String() + " addrDesc " + hex.EncodeToString(o.AddrDesc),
+				}, nil)
+			}
+			addToHistory(o.AddrDesc, tx.Txid)
+		}
+		for j := range ta.Inputs {
+			addToHistory(ta.Inputs[j].AddrDesc, tx.Txid)
+		}
+	}
+	// the history of the pruned blocks is not available
+	if block.Height < v.historyFrom {
+		return nil
+	}
+	for s, txids := range history {
+		addrDesc := bchain.AddressDescriptor(s)
+		err := d.GetAddrDescTransactions(addrDesc, block.Height, block.Height, func(txid string, height uint32, indexes []int32) error {
+			delete(txids, txid)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for txid := range txids {
+			v.mismatch(VerifyMismatch{Kind: mismatchHistoryMissing, Txid: txid, Height: block.Height}, addrDesc)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verifyAddresses(addrDescs map[string]struct{}) error {
+	for s := range addrDescs {
+		addrDesc := bchain.AddressDescriptor(s)
+		ab, err := v.d.GetAddrDescBalance(addrDesc, AddressBalanceDetailUTXO)
+		if err != nil {
+			return err
+		}
+		if err = v.verifyAddress(addrDesc, ab); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyBlocks verifies the blocks in the height range against the blocks from the backend and then the addresses of these blocks
+// if fix is set, the balances of the addresses with mismatches are rebuilt
+func (d *RocksDB) VerifyBlocks(from, to uint32, chain bchain.BlockChain, fix bool, stop chan os.Signal) (*VerifyReport, error) {
+	v, err := d.newVerifier(fix)
+	if err != nil {
+		return nil, err
+	}
+	bestHeight, _, err := d.GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+	if to > bestHeight {
+		to = bestHeight
+	}
+	if from > to {
+		return nil, errors.Errorf("Invalid range %v-%v, the best indexed block is %v", from, to, bestHeight)
+	}
+	v.report.From = from
+	v.report.To = to
+	start := time.Now()
+	glog.Info("verify: start of verification of blocks ", from, "-", to)
+	addrDescs := make(map[string]struct{})
+	for height := from; height <= to; height++ {
+		if v.interrupted(stop) {
+			return v.report, nil
+		}
+		hash, err := chain.GetBlockHash(height)
+		if err != nil {
+			return nil, errors.Annotatef(err, "GetBlockHash %v", height)
+		}
+		block, err := chain.GetBlock(hash, height)
+		if err != nil {
+			return nil, errors.Annotatef(err, "GetBlock %v", height)
+		}
+		if err = v.verifyBlock(block, addrDescs); err != nil {
+			return nil, err
+		}
+		// the rollback data are kept only for the last blocks
+		val, err := d.db.GetCF(d.ro, d.cfh[cfBlockTxs], packUint(height))
+		if err != nil {
+			return nil, err
+		}
+		hasBlockTxs := val.Size() > 0
+		val.Free()
+		if hasBlockTxs {
+			if err = v.verifyBlockTxs(height); err != nil {
+				return nil, err
+			}
+		}
+		v.report.Blocks++
+		if len(addrDescs) >= verifyAddressesBatch || height == to {
+			if err = v.verifyAddresses(addrDescs); err != nil {
+				return nil, err
+			}
+			addrDescs = make(map[string]struct{})
+		}
+		if height%1000 == 0 {
+			glog.Info("verify: height ", height, ", ", v.report.Mismatches, " mismatches, in progress...")
+		}
+	}
+	glog.Info("verify: finished in ", time.Since(start), ", ", v.report.Addresses, " addresses, ", v.report.Blocks, " blocks, ",
+		v.report.Mismatches, " mismatches, ", v.report.Fixed, " fixed")
+	return v.report, nil
+}
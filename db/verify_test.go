@@ -0,0 +1,133 @@
+// +build unittest
+
+package db
+
+import (
+	"blockbook/bchain"
+	"blockbook/tests/dbtestdata"
+	"math/big"
+	"testing"
+
+	"github.com/tecbot/gorocksdb"
+)
+
+func verifyNoMismatches(t *testing.T, name string, r *VerifyReport) {
+	if r.Mismatches != 0 || len(r.Items) != 0 || r.Interrupted {
+		t.Errorf("%v: expected no mismatches, got %+v", name, r)
+	}
+}
+
+func TestRocksDB_VerifyDB(t *testing.T) {
+	d := setupRocksDB(t, &testBitcoinParser{
+		BitcoinParser: bitcoinTestnetParser(),
+	})
+	defer closeAndDestroyRocksDB(t, d)
+
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock2(d.chainParser)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.VerifyDB(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyNoMismatches(t, "VerifyDB", r)
+	if r.Addresses != 10 || r.Blocks != 1 {
+		t.Errorf("VerifyDB() verified %v addresses and %v blocks, want 10 and 1", r.Addresses, r.Blocks)
+	}
+	chain, err := dbtestdata.NewFakeBlockChain(d.chainParser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = d.VerifyBlocks(225493, 225494, chain, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyNoMismatches(t, "VerifyBlocks", r)
+	if r.Blocks != 2 || r.Addresses != 10 {
+		t.Errorf("VerifyBlocks() verified %v addresses and %v blocks, want 10 and 2", r.Addresses, r.Blocks)
+	}
+
+	// corrupt the balance of Addr5, which has one utxo
+	addrDesc, err := d.chainParser.GetAddrDescFromAddress(dbtestdata.Addr5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab, err := d.GetAddrDescBalance(addrDesc, AddressBalanceDetailUTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab.BalanceSat.Add(&ab.BalanceSat, big.NewInt(1))
+	ab.Utxos = nil
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	if err := d.storeBalances(wb, map[string]*AddrBalance{string(addrDesc): ab}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.db.Write(d.wo, wb); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, verify := range []func(fix bool) (*VerifyReport, error){
+		func(fix bool) (*VerifyReport, error) { return d.VerifyDB(fix, nil) },
+		func(fix bool) (*VerifyReport, error) { return d.VerifyBlocks(225494, 225494, chain, fix, nil) },
+	} {
+		r, err = verify(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds := make(map[string]bool)
+		for _, m := range r.Items {
+			kinds[m.Kind] = true
+			if m.Address != dbtestdata.Addr5 || m.Fixed {
+				t.Errorf("unexpected mismatch %+v", m)
+			}
+		}
+		if r.Mismatches != 2 || !kinds[mismatchBalance] || !kinds[mismatchUtxoMissing] || r.Fixed != 0 {
+			t.Errorf("expected balance and utxoMissing mismatches, got %+v", r)
+		}
+	}
+
+	r, err = d.VerifyDB(true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Mismatches != 2 || r.Fixed != 2 || r.RebuiltAddresses != 1 {
+		t.Errorf("VerifyDB(fix) = %+v, expected 2 fixed mismatches", r)
+	}
+	r, err = d.VerifyDB(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyNoMismatches(t, "VerifyDB after fix", r)
+	verifyAfterBitcoinTypeBlock2(t, d)
+
+	// the block in db differs from the backend
+	if err := d.db.DeleteCF(d.wo, d.cfh[cfTxAddresses], mustPackTxid(t, d.chainParser, dbtestdata.TxidB2T4)); err != nil {
+		t.Fatal(err)
+	}
+	r, err = d.VerifyBlocks(225494, 225494, chain, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, m := range r.Items {
+		if m.Kind == mismatchTxAddressesMissing && m.Txid == dbtestdata.TxidB2T4 {
+			found = true
+		}
+	}
+	if !found || r.Fixed != 0 || r.RebuiltAddresses != 0 {
+		t.Errorf("VerifyBlocks() = %+v, expected unfixed txAddressesMissing of %v", r, dbtestdata.TxidB2T4)
+	}
+}
+
+func mustPackTxid(t *testing.T, p bchain.BlockChainParser, txid string) []byte {
+	btxID, err := p.PackTxid(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return btxID
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+)
+
+// key prefixes in the webhooks column
+const (
+	webhookKeyRegistration = 'w'
+	webhookKeyTx           = 't'
+)
+
+// Webhook is a registration of an url notified about the events of the addresses, xpub or blocks
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Addresses []string `json:"addresses,omitempty"`
+	Xpub      string   `json:"xpub,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+	Blocks    bool     `json:"blocks,omitempty"`
+	// Confirmations is the number of confirmations up to which the confirmation updates are sent
+	Confirmations uint32 `json:"confirmations"`
+	Created       int64  `json:"created"`
+}
+
+// WebhookTx is a transaction tracked for confirmation updates
+type WebhookTx struct {
+	WebhookID     string `json:"-"`
+	Txid          string `json:"-"`
+	Address       string `json:"address"`
+	Confirmations uint32 `json:"confirmations"`
+	FirstSeen     int64  `json:"firstSeen"`
+}
+
+// WebhookDelivery is an event waiting in the outbox for delivery
+type WebhookDelivery struct {
+	ID          uint64          `json:"-"`
+	NextAttempt int64           `json:"-"`
+	WebhookID   string          `json:"webhookId"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	Created     int64           `json:"created"`
+}
+
+// webhookDeliverySeq makes the keys of the deliveries unique, it is initialized by the current time to be unique across restarts
+var webhookDeliverySeq = uint64(time.Now().UnixNano())
+
+func packWebhookTxKey(webhookID, txid string) []byte {
+	key := make([]byte, 0, len(webhookID)+len(txid)+2)
+	key = append(key, webhookKeyTx)
+	key = append(key, webhookID...)
+	key = append(key, 0)
+	key = append(key, txid...)
+	return key
+}
+
+// packWebhookDeliveryKey packs the key so that the deliveries are sorted by the time of the next attempt
+func packWebhookDeliveryKey(nextAttempt int64, id uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(nextAttempt))
+	binary.BigEndian.PutUint64(key[8:], id)
+	return key
+}
+
+// StoreWebhook stores the webhook registration
+func (d *RocksDB) StoreWebhook(w *Webhook) error {
+	if w.ID == "" || w.URL == "" {
+		return errors.New("Webhook id and url must be set")
+	}
+	buf, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return d.db.PutCF(d.wo, d.cfh[cfWebhooks], append([]byte{webhookKeyRegistration}, w.ID...), buf)
+}
+
+// GetWebhooks returns all webhook registrations
+func (d *RocksDB) GetWebhooks() ([]*Webhook, error) {
+	r := make([]*Webhook, 0)
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfWebhooks])
+	defer it.Close()
+	prefix := []byte{webhookKeyRegistration}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		var w Webhook
+		if err := json.Unmarshal(it.Value().Data(), &w); err != nil {
+			return nil, errors.Annotatef(err, "webhook %s", string(it.Key().Data()[1:]))
+		}
+		r = append(r, &w)
+	}
+	return r, nil
+}
+
+// DeleteWebhook deletes the webhook registration together with its tracked transactions
+// the deliveries in the outbox are discarded when their time comes
+func (d *RocksDB) DeleteWebhook(id string) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	wb.DeleteCF(d.cfh[cfWebhooks], append([]byte{webhookKeyRegistration}, id...))
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfWebhooks])
+	defer it.Close()
+	prefix := packWebhookTxKey(id, "")
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		wb.DeleteCF(d.cfh[cfWebhooks], append([]byte(nil), it.Key().Data()...))
+	}
+	return d.db.Write(d.wo, wb)
+}
+
+// StoreWebhookTx stores the transaction tracked for confirmation updates
+func (d *RocksDB) StoreWebhookTx(t *WebhookTx) error {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return d.db.PutCF(d.wo, d.cfh[cfWebhooks], packWebhookTxKey(t.WebhookID, t.Txid), buf)
+}
+
+// GetWebhookTx returns the tracked transaction or nil if the transaction is not tracked by the webhook
+func (d *RocksDB) GetWebhookTx(webhookID, txid string) (*WebhookTx, error) {
+	val, err := d.db.GetCF(d.ro, d.cfh[cfWebhooks], packWebhookTxKey(webhookID, txid))
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	if len(val.Data()) == 0 {
+		return nil, nil
+	}
+	t := &WebhookTx{WebhookID: webhookID, Txid: txid}
+	if err := json.Unmarshal(val.Data(), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetWebhookTxs returns all transactions tracked for confirmation updates
+func (d *RocksDB) GetWebhookTxs() ([]*WebhookTx, error) {
+	r := make([]*WebhookTx, 0)
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfWebhooks])
+	defer it.Close()
+	prefix := []byte{webhookKeyTx}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Key().Data()
+		var i int
+		for i = 1; i < len(key) && key[i] != 0; i++ {
+		}
+		if i >= len(key) {
+			return nil, errors.Errorf("Invalid webhook tx key %q", key)
+		}
+		t := &WebhookTx{WebhookID: string(key[1:i]), Txid: string(key[i+1:])}
+		if err := json.Unmarshal(it.Value().Data(), t); err != nil {
+			return nil, errors.Annotatef(err, "webhook tx %q", key)
+		}
+		r = append(r, t)
+	}
+	return r, nil
+}
+
+// DeleteWebhookTx stops tracking of the transaction
+func (d *RocksDB) DeleteWebhookTx(webhookID, txid string) error {
+	return d.db.DeleteCF(d.wo, d.cfh[cfWebhooks], packWebhookTxKey(webhookID, txid))
+}
+
+// StoreWebhookDelivery stores a new delivery to the outbox, NextAttempt is the time of the first attempt in unix milliseconds
+func (d *RocksDB) StoreWebhookDelivery(dl *WebhookDelivery) error {
+	dl.ID = atomic.AddUint64(&webhookDeliverySeq, 1)
+	buf, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return d.db.PutCF(d.wo, d.cfh[cfWebhookOutbox], packWebhookDeliveryKey(dl.NextAttempt, dl.ID), buf)
+}
+
+// GetDueWebhookDeliveries returns up to max deliveries with the next attempt before or at the time now
+func (d *RocksDB) GetDueWebhookDeliveries(now time.Time, max int) ([]*WebhookDelivery, error) {
+	r := make([]*WebhookDelivery, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	it := d.db.NewIteratorCF(d.ro, d.cfh[cfWebhookOutbox])
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid() && len(r) < max; it.Next() {
+		key := it.Key().Data()
+		if len(key) != 16 {
+			return nil, errors.Errorf("Invalid webhook delivery key %q", key)
+		}
+		nextAttempt := int64(binary.BigEndian.Uint64(key))
+		if nextAttempt > nowMs {
+			break
+		}
+		dl := &WebhookDelivery{NextAttempt: nextAttempt, ID: binary.BigEndian.Uint64(key[8:])}
+		if err := json.Unmarshal(it.Value().Data(), dl); err != nil {
+			return nil, errors.Annotatef(err, "webhook delivery %q", key)
+		}
+		r = append(r, dl)
+	}
+	return r, nil
+}
+
+// RescheduleWebhookDelivery moves the delivery in the outbox to the time of the next attempt nextAttempt
+func (d *RocksDB) RescheduleWebhookDelivery(dl *WebhookDelivery, nextAttempt int64) error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	wb.DeleteCF(d.cfh[cfWebhookOutbox], packWebhookDeliveryKey(dl.NextAttempt, dl.ID))
+	dl.NextAttempt = nextAttempt
+	buf, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	wb.PutCF(d.cfh[cfWebhookOutbox], packWebhookDeliveryKey(dl.NextAttempt, dl.ID), buf)
+	return d.db.Write(d.wo, wb)
+}
+
+// DeleteWebhookDelivery removes the delivery from the outbox
+func (d *RocksDB) DeleteWebhookDelivery(dl *WebhookDelivery) error {
+	return d.db.DeleteCF(d.wo, d.cfh[cfWebhookOutbox], packWebhookDeliveryKey(dl.NextAttempt, dl.ID))
+}
@@ -0,0 +1,24 @@
+package eventsink
+
+import (
+	"blockbook/db"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// New creates the event sink specified by the url, zmq:<endpoint> creates ZeroMQ PUB socket bound to the endpoint
+// (for example zmq:tcp://127.0.0.1:28400), file:<path> creates NDJSON file to which the events are appended
+func New(url string) (db.EventSink, error) {
+	i := strings.Index(url, ":")
+	if i < 0 {
+		return nil, errors.Errorf("Invalid event sink %v, expected zmq:<endpoint> or file:<path>", url)
+	}
+	switch url[:i] {
+	case "zmq":
+		return NewZMQSink(url[i+1:])
+	case "file":
+		return NewFileSink(url[i+1:])
+	}
+	return nil, errors.Errorf("Unknown event sink type %v", url[:i])
+}
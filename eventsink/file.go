@@ -0,0 +1,50 @@
+package eventsink
+
+import (
+	"blockbook/db"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// FileSink appends the events to a file in the NDJSON format, one json encoded event per line
+type FileSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file for appending, the file is created if it does not exist
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	glog.Info("EventSink: appending to file ", path)
+	return &FileSink{file: f}, nil
+}
+
+// Publish appends the event as one line to the file
+func (s *FileSink) Publish(e *db.SinkEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(data)
+	return err
+}
+
+// Close syncs and closes the file
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,67 @@
+package eventsink
+
+import (
+	"blockbook/db"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+
+	"github.com/golang/glog"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// ZMQSink publishes the events to a ZeroMQ PUB socket
+// each message has three frames: the event type as topic, the json of the event and the 4 byte little endian sequence number,
+// the same layout as the notifications of bitcoind
+type ZMQSink struct {
+	lock     sync.Mutex
+	context  *zmq.Context
+	socket   *zmq.Socket
+	binding  string
+	sequence uint32
+}
+
+// NewZMQSink creates the PUB socket bound to the binding
+func NewZMQSink(binding string) (*ZMQSink, error) {
+	context, err := zmq.NewContext()
+	if err != nil {
+		return nil, err
+	}
+	socket, err := context.NewSocket(zmq.PUB)
+	if err != nil {
+		context.Term()
+		return nil, err
+	}
+	if err = socket.Bind(binding); err != nil {
+		socket.Close()
+		context.Term()
+		return nil, err
+	}
+	glog.Info("EventSink: publishing to ZeroMQ ", binding)
+	return &ZMQSink{context: context, socket: socket, binding: binding}, nil
+}
+
+// Publish sends the event to the subscribers, the event is dropped if there are no subscribers
+func (s *ZMQSink) Publish(e *db.SinkEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, s.sequence)
+	s.sequence++
+	_, err = s.socket.SendMessage(e.Type, data, seq)
+	return err
+}
+
+// Close closes the socket
+func (s *ZMQSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.socket.Close(); err != nil {
+		return err
+	}
+	return s.context.Term()
+}
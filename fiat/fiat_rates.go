@@ -0,0 +1,122 @@
+package fiat
+
+import (
+	"blockbook/db"
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// OnNewFiatRatesTicker is used to send notification about a new FiatRates ticker
+type OnNewFiatRatesTicker func(ticker *db.CurrencyRatesTicker)
+
+// RatesDownloaderInterface provides method signatures for specific fiat rates downloaders
+type RatesDownloaderInterface interface {
+	// getTicker returns rates at given timestamp or nil if the rates are not available
+	getTicker(timestamp *time.Time) (*db.CurrencyRatesTicker, error)
+}
+
+// RatesDownloader stores FiatRates API parameters
+type RatesDownloader struct {
+	period              time.Duration
+	db                  db.Index
+	startTime           time.Time
+	callbackOnNewTicker OnNewFiatRatesTicker
+	downloader          RatesDownloaderInterface
+}
+
+type ratesDownloaderParams struct {
+	URL            string `json:"url"`
+	PeriodSeconds  int    `json:"periodSeconds"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+	StartDate      string `json:"startDate"`
+}
+
+// NewFiatRatesDownloader initializes the downloader for FiatRates API of given type.
+// If the params do not specify startDate and startTime is nil, the downloader starts at the current time.
+func NewFiatRatesDownloader(d db.Index, apiType string, params string, startTime *time.Time, callback OnNewFiatRatesTicker) (*RatesDownloader, error) {
+	var p ratesDownloaderParams
+	if err := json.Unmarshal([]byte(params), &p); err != nil {
+		return nil, errors.Annotatef(err, "Invalid fiat rates params")
+	}
+	if p.URL == "" || p.PeriodSeconds <= 0 {
+		return nil, errors.New("Missing fiat rates parameters url or periodSeconds")
+	}
+	if p.TimeoutSeconds <= 0 {
+		p.TimeoutSeconds = 15
+	}
+	rd := &RatesDownloader{
+		period:              time.Duration(p.PeriodSeconds) * time.Second,
+		db:                  d,
+		callbackOnNewTicker: callback,
+	}
+	if startTime != nil {
+		rd.startTime = startTime.UTC()
+	} else if p.StartDate != "" {
+		t, err := time.Parse("2006-01-02", p.StartDate)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Invalid fiat rates startDate")
+		}
+		rd.startTime = t
+	} else {
+		rd.startTime = time.Now().UTC().Truncate(rd.period)
+	}
+	switch apiType {
+	case "json":
+		rd.downloader = NewJSONRatesDownloader(p.URL, time.Duration(p.TimeoutSeconds)*time.Second)
+	default:
+		return nil, errors.Errorf("NewFiatRatesDownloader: incorrect API type %q", apiType)
+	}
+	return rd, nil
+}
+
+// Run periodically downloads the tickers, starting from the last stored one (or startTime) up to the current time
+func (rd *RatesDownloader) Run() error {
+	glog.Info("FiatRatesDownloader: starting, period ", rd.period)
+	timer := time.NewTimer(rd.period)
+	for {
+		if err := rd.syncTickers(time.Now().UTC()); err != nil {
+			glog.Error("FiatRatesDownloader: ", err)
+		}
+		<-timer.C
+		timer.Reset(rd.period)
+	}
+}
+
+// syncTickers downloads the tickers missing in db up to the time now, it notifies only about the newest ticker
+func (rd *RatesDownloader) syncTickers(now time.Time) error {
+	last, err := rd.db.FiatRatesFindLastTicker()
+	if err != nil {
+		return err
+	}
+	next := rd.startTime
+	if last != nil {
+		next = last.Timestamp.UTC().Add(rd.period)
+	}
+	var newest *db.CurrencyRatesTicker
+	for !next.After(now) {
+		t := next
+		ticker, err := rd.downloader.getTicker(&t)
+		if err != nil {
+			return err
+		}
+		if ticker == nil {
+			glog.Warning("FiatRatesDownloader: no rates for ", t)
+		} else {
+			if err = rd.db.FiatRatesStoreTicker(ticker); err != nil {
+				return err
+			}
+			newest = ticker
+		}
+		next = next.Add(rd.period)
+	}
+	if newest != nil {
+		glog.Info("FiatRatesDownloader: stored tickers up to ", newest.Timestamp)
+		if rd.callbackOnNewTicker != nil {
+			rd.callbackOnNewTicker(newest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// +build unittest
+
+package fiat
+
+import (
+	"blockbook/bchain/coins/btc"
+	"blockbook/db"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/martinboehm/btcutil/chaincfg"
+)
+
+func TestMain(m *testing.M) {
+	c := m.Run()
+	chaincfg.ResetParams()
+	os.Exit(c)
+}
+
+func setupRocksDB(t *testing.T) (*db.RocksDB, string) {
+	tmp, err := ioutil.TempDir("", "testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := btc.NewBitcoinParser(btc.GetChainParams("test"), &btc.Configuration{BlockAddressesToKeep: 1})
+	d, err := db.NewRocksDB(tmp, 100000, -1, parser, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := d.LoadInternalState("fakecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetInternalState(is)
+	return d, tmp
+}
+
+func closeAndDestroyRocksDB(t *testing.T, d *db.RocksDB, dbpath string) {
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dbpath)
+}
+
+func TestFiatRates_JSONOverHTTP(t *testing.T) {
+	d, dbpath := setupRocksDB(t)
+	defer closeAndDestroyRocksDB(t, d, dbpath)
+
+	start := time.Date(2019, 11, 20, 0, 0, 0, 0, time.UTC)
+	// the stand-in service returns rates derived from the timestamp, the third ticker is missing
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, err := strconv.ParseInt(r.URL.Query().Get("ts"), 10, 64)
+		if err != nil || timestamp == start.Add(2*time.Hour).Unix() {
+			http.NotFound(w, r)
+			return
+		}
+		h := (timestamp - start.Unix()) / 3600
+		fmt.Fprintf(w, `{"rates":{"USD":%d.5,"eur":%d}}`, 7000+h, 6000+h)
+	}))
+	defer ts.Close()
+
+	var notified []*db.CurrencyRatesTicker
+	rd, err := NewFiatRatesDownloader(d, "json", `{"url":"`+ts.URL+`/rates?ts={timestamp}","periodSeconds":3600}`, &start, func(ticker *db.CurrencyRatesTicker) {
+		notified = append(notified, ticker)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rd.syncTickers(start.Add(3*time.Hour + 30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := d.FiatRatesFindLastTicker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt := start.Add(3 * time.Hour)
+	want := &db.CurrencyRatesTicker{Timestamp: &lt, Rates: map[string]float64{"usd": 7003.5, "eur": 6003}}
+	if !reflect.DeepEqual(last, want) {
+		t.Errorf("FiatRatesFindLastTicker() = %+v, want %+v", last, want)
+	}
+	if len(notified) != 1 || !reflect.DeepEqual(notified[0].Rates, want.Rates) {
+		t.Errorf("callbackOnNewTicker got %+v, want only %+v", notified, want)
+	}
+
+	// the ticker at 2:00 is missing, the closest following one is returned
+	tt := start.Add(time.Hour + time.Minute)
+	ticker, err := d.FiatRatesFindTicker(&tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticker == nil || !ticker.Timestamp.Equal(lt) {
+		t.Errorf("FiatRatesFindTicker(%v) = %+v, want ticker at %v", tt, ticker, lt)
+	}
+	tt = start.Add(4 * time.Hour)
+	ticker, err = d.FiatRatesFindTicker(&tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticker != nil {
+		t.Errorf("FiatRatesFindTicker(%v) = %+v, want nil", tt, ticker)
+	}
+
+	// next sync continues after the last stored ticker
+	if err = rd.syncTickers(start.Add(4 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	last, err = d.FiatRatesFindLastTicker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Timestamp.Equal(start.Add(4*time.Hour)) || last.Rates["usd"] != 7004.5 {
+		t.Errorf("FiatRatesFindLastTicker() = %+v, want ticker at %v", last, start.Add(4*time.Hour))
+	}
+}
+
+func TestFiatRates_JSONFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fiatrates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	start := time.Date(2019, 11, 20, 0, 0, 0, 0, time.UTC)
+	name := filepath.Join(dir, strconv.FormatInt(start.Unix(), 10)+".json")
+	if err = ioutil.WriteFile(name, []byte(`{"rates":{"usd":7100.25}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := NewJSONRatesDownloader("file://"+dir+"/{timestamp}.json", time.Second)
+	ticker, err := j.getTicker(&start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &db.CurrencyRatesTicker{Timestamp: &start, Rates: map[string]float64{"usd": 7100.25}}
+	if !reflect.DeepEqual(ticker, want) {
+		t.Errorf("getTicker() = %+v, want %+v", ticker, want)
+	}
+	next := start.Add(time.Hour)
+	ticker, err = j.getTicker(&next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticker != nil {
+		t.Errorf("getTicker() = %+v, want nil", ticker)
+	}
+}
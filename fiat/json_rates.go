@@ -0,0 +1,72 @@
+package fiat
+
+import (
+	"blockbook/db"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// JSONRatesDownloader downloads the rates from a service returning json in the form {"rates": {"usd": 7134.1, "eur": 6555.2}}.
+// The url can use http(s) or file scheme, the placeholder {timestamp} in the url
+// is replaced by the unix timestamp of the requested ticker.
+// Not found response (or missing file) means that there are no rates for the timestamp.
+type JSONRatesDownloader struct {
+	url        string
+	httpClient *http.Client
+}
+
+type jsonRatesResult struct {
+	Rates map[string]float64 `json:"rates"`
+}
+
+// NewJSONRatesDownloader creates JSONRatesDownloader for given url
+func NewJSONRatesDownloader(url string, timeout time.Duration) *JSONRatesDownloader {
+	transport := &http.Transport{}
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return &JSONRatesDownloader{
+		url: url,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+	}
+}
+
+func (j *JSONRatesDownloader) getTicker(timestamp *time.Time) (*db.CurrencyRatesTicker, error) {
+	url := strings.Replace(j.url, "{timestamp}", strconv.FormatInt(timestamp.Unix(), 10), -1)
+	glog.V(1).Info("JSONRatesDownloader: get ", url)
+	res, err := j.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%v returned status %v", url, res.StatusCode)
+	}
+	var data jsonRatesResult
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, errors.Annotatef(err, "%v", url)
+	}
+	if len(data.Rates) == 0 {
+		return nil, nil
+	}
+	// currency codes are stored in lower case
+	rates := make(map[string]float64, len(data.Rates))
+	for c, r := range data.Rates {
+		rates[strings.ToLower(c)] = r
+	}
+	t := timestamp.UTC()
+	return &db.CurrencyRatesTicker{
+		Timestamp: &t,
+		Rates:     rates,
+	}, nil
+}
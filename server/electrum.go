@@ -0,0 +1,1013 @@
+package server
+
+import (
+	"blockbook/api"
+	"blockbook/bchain"
+	"blockbook/common"
+	"blockbook/db"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+const electrumProtocolVersion = "1.4"
+
+// maximum length of a request line, must accommodate broadcast of big transactions
+const electrumMaxLineLength = 4 * 1024 * 1024
+
+// the statuses of the subscribed scripthashes are checked for changes in this interval
+const electrumNotifyPeriod = 1 * time.Second
+
+// maximum number of requests of one connection processed concurrently, a batch request counts as one request
+const electrumMaxRequestsInFlight = 16
+
+// maximum number of headers returned by blockchain.block.headers
+const electrumMaxHeaders = 2016
+
+// electrum protocol error codes
+const (
+	electrumErrorParse          = -32700
+	electrumErrorMethodNotFound = -32601
+	electrumErrorBadRequest     = 1
+	electrumErrorDaemon         = 2
+)
+
+var electrumConnectionCounter uint64
+
+type electrumReq struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type electrumRes struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type electrumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type electrumErrorRes struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   electrumError   `json:"error"`
+}
+
+type electrumNotification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type electrumChannel struct {
+	id        uint64
+	conn      net.Conn
+	out       chan interface{}
+	requests  chan struct{}
+	ip        string
+	alive     bool
+	aliveLock sync.Mutex
+}
+
+type electrumHeader struct {
+	Hex    string `json:"hex"`
+	Height uint32 `json:"height"`
+}
+
+type electrumHeaders struct {
+	Count int    `json:"count"`
+	Hex   string `json:"hex"`
+	Max   int    `json:"max"`
+}
+
+type electrumFeatures struct {
+	GenesisHash   string                 `json:"genesis_hash"`
+	Hosts         map[string]interface{} `json:"hosts"`
+	ProtocolMax   string                 `json:"protocol_max"`
+	ProtocolMin   string                 `json:"protocol_min"`
+	Pruning       interface{}            `json:"pruning"`
+	ServerVersion string                 `json:"server_version"`
+	HashFunction  string                 `json:"hash_function"`
+}
+
+type electrumHistoryItem struct {
+	TxHash string   `json:"tx_hash"`
+	Height int      `json:"height"`
+	Fee    *big.Int `json:"fee,omitempty"`
+}
+
+type electrumBalance struct {
+	Confirmed   *big.Int `json:"confirmed"`
+	Unconfirmed *big.Int `json:"unconfirmed"`
+}
+
+type electrumUnspent struct {
+	TxHash string   `json:"tx_hash"`
+	TxPos  int32    `json:"tx_pos"`
+	Height int      `json:"height"`
+	Value  *big.Int `json:"value"`
+}
+
+// ElectrumServer is a handle to the server speaking the electrum protocol
+type ElectrumServer struct {
+	binding                 string
+	certFiles               string
+	listener                net.Listener
+	listenerLock            sync.Mutex
+	db                      db.Index
+	txCache                 *db.TxCache
+	chain                   bchain.BlockChain
+	chainParser             bchain.BlockChainParser
+	mempool                 bchain.Mempool
+	metrics                 *common.Metrics
+	is                      *common.InternalState
+	api                     *api.Worker
+	channels                map[*electrumChannel]struct{}
+	channelsLock            sync.Mutex
+	headersSubscriptions    map[*electrumChannel]struct{}
+	scripthashSubscriptions map[string]map[*electrumChannel]string
+	dirtyScripthashes       map[string]struct{}
+	newBlock                bool
+	subscriptionsLock       sync.Mutex
+	mempoolAddrDescs        map[string]bchain.AddressDescriptor
+	mempoolAddrDescsLock    sync.Mutex
+	chanClose               chan struct{}
+}
+
+// NewElectrumServer creates new electrum protocol interface to blockbook and returns its handle
+func NewElectrumServer(binding string, certFiles string, db db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, metrics *common.Metrics, is *common.InternalState) (*ElectrumServer, error) {
+	if chain.GetChainParser().GetChainType() != bchain.ChainBitcoinType {
+		return nil, errors.New("Electrum protocol is supported only for bitcoin type coins")
+	}
+	api, err := api.NewWorker(db, chain, mempool, txCache, is)
+	if err != nil {
+		return nil, err
+	}
+	s := &ElectrumServer{
+		binding:                 binding,
+		certFiles:               certFiles,
+		db:                      db,
+		txCache:                 txCache,
+		chain:                   chain,
+		chainParser:             chain.GetChainParser(),
+		mempool:                 mempool,
+		metrics:                 metrics,
+		is:                      is,
+		api:                     api,
+		channels:                make(map[*electrumChannel]struct{}),
+		headersSubscriptions:    make(map[*electrumChannel]struct{}),
+		scripthashSubscriptions: make(map[string]map[*electrumChannel]string),
+		dirtyScripthashes:       make(map[string]struct{}),
+		mempoolAddrDescs:        make(map[string]bchain.AddressDescriptor),
+		chanClose:               make(chan struct{}),
+	}
+	return s, nil
+}
+
+// Run starts the server, it does not return until the server is closed
+func (s *ElectrumServer) Run() error {
+	var listener net.Listener
+	var err error
+	if s.certFiles == "" {
+		glog.Info("electrum server: starting to listen on tcp://", s.binding)
+		listener, err = net.Listen("tcp", s.binding)
+	} else {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(fmt.Sprint(s.certFiles, ".crt"), fmt.Sprint(s.certFiles, ".key"))
+		if err != nil {
+			return err
+		}
+		glog.Info("electrum server: starting to listen on ssl://", s.binding)
+		listener, err = tls.Listen("tcp", s.binding, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+	if err != nil {
+		return err
+	}
+	return s.serve(listener)
+}
+
+func (s *ElectrumServer) serve(listener net.Listener) error {
+	s.listenerLock.Lock()
+	select {
+	case <-s.chanClose:
+		// the server was closed before it started to serve
+		s.listenerLock.Unlock()
+		return listener.Close()
+	default:
+	}
+	s.listener = listener
+	s.listenerLock.Unlock()
+	go s.notifyLoop()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.chanClose:
+				return nil
+			default:
+			}
+			return err
+		}
+		c := &electrumChannel{
+			id:       atomic.AddUint64(&electrumConnectionCounter, 1),
+			conn:     conn,
+			out:      make(chan interface{}, outChannelSize),
+			requests: make(chan struct{}, electrumMaxRequestsInFlight),
+			ip:       conn.RemoteAddr().String(),
+			alive:    true,
+		}
+		s.onConnect(c)
+		go s.inputLoop(c)
+		go s.outputLoop(c)
+	}
+}
+
+// Close stops listening and closes all client connections
+func (s *ElectrumServer) Close() error {
+	glog.Infof("electrum server: closing")
+	s.listenerLock.Lock()
+	close(s.chanClose)
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.listenerLock.Unlock()
+	s.channelsLock.Lock()
+	channels := make([]*electrumChannel, 0, len(s.channels))
+	for c := range s.channels {
+		channels = append(channels, c)
+	}
+	s.channelsLock.Unlock()
+	for _, c := range channels {
+		s.closeChannel(c)
+	}
+	return err
+}
+
+func (s *ElectrumServer) closeChannel(c *electrumChannel) {
+	c.aliveLock.Lock()
+	wasAlive := c.alive
+	if c.alive {
+		c.conn.Close()
+		c.alive = false
+		//clean out
+		close(c.out)
+		for len(c.out) > 0 {
+			<-c.out
+		}
+	}
+	c.aliveLock.Unlock()
+	// onDisconnect takes subscriptionsLock, which must not be acquired while holding aliveLock
+	if wasAlive {
+		s.onDisconnect(c)
+	}
+}
+
+func (c *electrumChannel) IsAlive() bool {
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+	return c.alive
+}
+
+// send sends the message to the channel, the message is dropped if the channel is closed or the client does not read the messages
+func (c *electrumChannel) send(m interface{}) {
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+	if c.alive {
+		select {
+		case c.out <- m:
+		default:
+			glog.Error("electrum: output channel full, dropping message to ", c.id)
+		}
+	}
+}
+
+func (s *ElectrumServer) inputLoop(c *electrumChannel) {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Error("electrum: recovered from panic: ", r, ", ", c.id)
+			debug.PrintStack()
+			s.closeChannel(c)
+		}
+	}()
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), electrumMaxLineLength)
+	for scanner.Scan() {
+		d := bytes.TrimSpace(scanner.Bytes())
+		if len(d) == 0 {
+			continue
+		}
+		if d[0] == '[' {
+			var reqs []electrumReq
+			if err := json.Unmarshal(d, &reqs); err != nil {
+				glog.Error("electrum: error parsing message from ", c.id, ", ", err)
+				c.send(&electrumErrorRes{JSONRPC: "2.0", Error: electrumError{electrumErrorParse, "invalid JSON"}})
+				continue
+			}
+			// batch requests are processed serially and the results are returned together
+			// the reading of the connection waits if there are too many requests in flight
+			c.requests <- struct{}{}
+			go func() {
+				defer func() { <-c.requests }()
+				res := make([]interface{}, len(reqs))
+				for i := range reqs {
+					res[i] = s.onRequest(c, &reqs[i])
+				}
+				c.send(res)
+			}()
+		} else {
+			var req electrumReq
+			if err := json.Unmarshal(d, &req); err != nil {
+				glog.Error("electrum: error parsing message from ", c.id, ", ", err)
+				c.send(&electrumErrorRes{JSONRPC: "2.0", Error: electrumError{electrumErrorParse, "invalid JSON"}})
+				continue
+			}
+			c.requests <- struct{}{}
+			go func() {
+				defer func() { <-c.requests }()
+				c.send(s.onRequest(c, &req))
+			}()
+		}
+	}
+	s.closeChannel(c)
+}
+
+func (s *ElectrumServer) outputLoop(c *electrumChannel) {
+	enc := json.NewEncoder(c.conn)
+	for m := range c.out {
+		// Encode terminates each message by a newline, as required by the protocol
+		if err := enc.Encode(m); err != nil {
+			glog.Error("electrum: error sending message to ", c.id, ", ", err)
+			s.closeChannel(c)
+		}
+	}
+}
+
+func (s *ElectrumServer) onConnect(c *electrumChannel) {
+	glog.Info("electrum: client connected ", c.id, ", ", c.ip)
+	s.channelsLock.Lock()
+	s.channels[c] = struct{}{}
+	s.channelsLock.Unlock()
+	s.metrics.ElectrumClients.Inc()
+}
+
+func (s *ElectrumServer) onDisconnect(c *electrumChannel) {
+	s.unsubscribeAll(c)
+	s.channelsLock.Lock()
+	delete(s.channels, c)
+	s.channelsLock.Unlock()
+	glog.Info("electrum: client disconnected ", c.id, ", ", c.ip)
+	s.metrics.ElectrumClients.Dec()
+}
+
+var electrumHandlers = map[string]func(*ElectrumServer, *electrumChannel, []json.RawMessage) (interface{}, error){
+	"server.version": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		return []string{"Blockbook " + common.GetVersionInfo().Version, electrumProtocolVersion}, nil
+	},
+	"server.ping": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		return nil, nil
+	},
+	"server.features": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		return s.getFeatures()
+	},
+	"blockchain.block.header": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		height, err := electrumParamUint(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err = electrumNoCheckpoint(params, 1); err != nil {
+			return nil, err
+		}
+		return s.getHeaderAt(height)
+	},
+	"blockchain.block.headers": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		start, err := electrumParamUint(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		count, err := electrumParamUint(params, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err = electrumNoCheckpoint(params, 2); err != nil {
+			return nil, err
+		}
+		return s.getHeaders(start, count)
+	},
+	"blockchain.headers.subscribe": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		return s.subscribeHeaders(c)
+	},
+	"blockchain.scripthash.get_balance": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		sh, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.getBalance(sh)
+	},
+	"blockchain.scripthash.get_history": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		sh, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.getHistory(sh)
+	},
+	"blockchain.scripthash.listunspent": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		sh, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.listUnspent(sh)
+	},
+	"blockchain.scripthash.subscribe": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		sh, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.subscribeScripthash(c, sh)
+	},
+	"blockchain.scripthash.unsubscribe": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		sh, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return s.unsubscribeScripthash(c, sh), nil
+	},
+	"blockchain.transaction.get": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		txid, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		var verbose bool
+		if len(params) > 1 {
+			if err = json.Unmarshal(params[1], &verbose); err != nil {
+				return nil, electrumBadRequest("invalid parameter verbose")
+			}
+		}
+		return s.getTransaction(txid, verbose)
+	},
+	"blockchain.transaction.broadcast": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		hex, err := electrumParamString(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		txid, err := s.chain.SendRawTransaction(hex)
+		if err != nil {
+			return nil, &electrumError{electrumErrorDaemon, err.Error()}
+		}
+		return txid, nil
+	},
+	"blockchain.estimatefee": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		var blocks int
+		if len(params) < 1 || json.Unmarshal(params[0], &blocks) != nil {
+			return nil, electrumBadRequest("invalid parameter number")
+		}
+		return s.estimateFee(blocks)
+	},
+	"blockchain.relayfee": func(s *ElectrumServer, c *electrumChannel, params []json.RawMessage) (interface{}, error) {
+		ci, err := s.chain.GetChainInfo()
+		if err != nil {
+			return nil, &electrumError{electrumErrorDaemon, err.Error()}
+		}
+		return ci.RelayFee, nil
+	},
+}
+
+func (e *electrumError) Error() string {
+	return e.Message
+}
+
+func electrumBadRequest(message string) *electrumError {
+	return &electrumError{electrumErrorBadRequest, message}
+}
+
+func electrumParamString(params []json.RawMessage, i int) (string, error) {
+	var s string
+	if len(params) <= i || json.Unmarshal(params[i], &s) != nil {
+		return "", electrumBadRequest(fmt.Sprintf("missing or invalid parameter %d", i))
+	}
+	return s, nil
+}
+
+func electrumParamUint(params []json.RawMessage, i int) (uint32, error) {
+	var u uint32
+	if len(params) <= i || json.Unmarshal(params[i], &u) != nil {
+		return 0, electrumBadRequest(fmt.Sprintf("missing or invalid parameter %d", i))
+	}
+	return u, nil
+}
+
+// electrumNoCheckpoint checks the optional checkpoint height parameter, the merkle proofs to a checkpoint are not supported
+func electrumNoCheckpoint(params []json.RawMessage, i int) error {
+	if len(params) <= i {
+		return nil
+	}
+	var cp uint32
+	if json.Unmarshal(params[i], &cp) != nil {
+		return electrumBadRequest(fmt.Sprintf("invalid parameter %d", i))
+	}
+	if cp != 0 {
+		return electrumBadRequest("checkpoint merkle proofs are not supported")
+	}
+	return nil
+}
+
+func (s *ElectrumServer) onRequest(c *electrumChannel, req *electrumReq) (res interface{}) {
+	var err error
+	var data interface{}
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Error("electrum: client ", c.id, ", onRequest ", req.Method, " recovered from panic: ", r)
+			debug.PrintStack()
+			res = &electrumErrorRes{JSONRPC: "2.0", ID: req.ID, Error: electrumError{electrumErrorDaemon, "Internal error"}}
+		}
+	}()
+	f, ok := electrumHandlers[req.Method]
+	if ok {
+		data, err = f(s, c, req.Params)
+	} else {
+		err = &electrumError{electrumErrorMethodNotFound, fmt.Sprintf("unknown method %q", req.Method)}
+	}
+	if err == nil {
+		glog.V(1).Info("electrum: client ", c.id, " onRequest ", req.Method, " success")
+		s.metrics.ElectrumRequests.With(common.Labels{"method": req.Method, "status": "success"}).Inc()
+		return &electrumRes{JSONRPC: "2.0", ID: req.ID, Result: data}
+	}
+	glog.Error("electrum: client ", c.id, " onRequest ", req.Method, ": ", errors.ErrorStack(err))
+	s.metrics.ElectrumRequests.With(common.Labels{"method": req.Method, "status": "failure"}).Inc()
+	e, ok := err.(*electrumError)
+	if !ok {
+		if apiErr, isAPIErr := err.(*api.APIError); isAPIErr && apiErr.Public {
+			e = electrumBadRequest(apiErr.Error())
+		} else {
+			e = &electrumError{electrumErrorDaemon, "Internal error"}
+		}
+	}
+	return &electrumErrorRes{JSONRPC: "2.0", ID: req.ID, Error: *e}
+}
+
+// electrumScripthash returns the scripthash of the address descriptor in the electrum format
+func electrumScripthash(addrDesc bchain.AddressDescriptor) string {
+	h := db.Scripthash(addrDesc)
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+	return hex.EncodeToString(h)
+}
+
+// getAddrDesc returns the address descriptor of the scripthash or nil if the scripthash is unknown
+func (s *ElectrumServer) getAddrDesc(scripthash string) (bchain.AddressDescriptor, error) {
+	h, err := hex.DecodeString(scripthash)
+	if err != nil || len(h) != sha256.Size {
+		return nil, electrumBadRequest(fmt.Sprintf("invalid scripthash %v", scripthash))
+	}
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+	addrDesc, err := s.db.GetAddrDescForScripthash(h)
+	if err != nil {
+		return nil, err
+	}
+	if addrDesc == nil {
+		// the address may be only in mempool
+		s.mempoolAddrDescsLock.Lock()
+		addrDesc = s.mempoolAddrDescs[scripthash]
+		s.mempoolAddrDescsLock.Unlock()
+		if addrDesc == nil && !s.is.IsScripthashIndexComplete() {
+			return nil, &electrumError{electrumErrorDaemon, "Scripthash index is being built, try again later"}
+		}
+	}
+	return addrDesc, nil
+}
+
+func (s *ElectrumServer) getHeader(hash string, height uint32) (*electrumHeader, error) {
+	// the header is stored in db, older databases do not contain the headers and they are loaded from the backend
+	hr, err := s.db.GetBlockHeaderRaw(height)
+	if err != nil {
+		return nil, err
+	}
+	if hr != nil {
+		return &electrumHeader{Hex: hex.EncodeToString(hr), Height: height}, nil
+	}
+	h, err := s.chain.GetBlockHeaderRaw(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &electrumHeader{Hex: h, Height: height}, nil
+}
+
+// getHeaderAt returns the hex encoded header of the block at given height
+func (s *ElectrumServer) getHeaderAt(height uint32) (string, error) {
+	hash, err := s.db.GetBlockHash(height)
+	if err != nil {
+		return "", err
+	}
+	if hash == "" {
+		return "", electrumBadRequest(fmt.Sprintf("height %d out of range", height))
+	}
+	h, err := s.getHeader(hash, height)
+	if err != nil {
+		return "", err
+	}
+	return h.Hex, nil
+}
+
+// getHeaders returns concatenated headers of at most count blocks starting at the height start, the range is limited by the best block
+func (s *ElectrumServer) getHeaders(start, count uint32) (*electrumHeaders, error) {
+	if count > electrumMaxHeaders {
+		count = electrumMaxHeaders
+	}
+	bestHeight, _, err := s.db.GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+	r := &electrumHeaders{Max: electrumMaxHeaders}
+	var b bytes.Buffer
+	for height := start; height < start+count && height <= bestHeight; height++ {
+		h, err := s.getHeaderAt(height)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(h)
+		r.Count++
+	}
+	r.Hex = b.String()
+	return r, nil
+}
+
+func (s *ElectrumServer) getFeatures() (*electrumFeatures, error) {
+	genesis, err := s.db.GetBlockHash(0)
+	if err != nil {
+		return nil, err
+	}
+	if genesis == "" {
+		if genesis, err = s.chain.GetBlockHash(0); err != nil {
+			return nil, &electrumError{electrumErrorDaemon, err.Error()}
+		}
+	}
+	return &electrumFeatures{
+		GenesisHash:   genesis,
+		Hosts:         map[string]interface{}{},
+		ProtocolMax:   electrumProtocolVersion,
+		ProtocolMin:   electrumProtocolVersion,
+		ServerVersion: "Blockbook " + common.GetVersionInfo().Version,
+		HashFunction:  "sha256",
+	}, nil
+}
+
+func (s *ElectrumServer) getHistory(scripthash string) ([]electrumHistoryItem, error) {
+	addrDesc, err := s.getAddrDesc(scripthash)
+	if err != nil {
+		return nil, err
+	}
+	return s.getAddrDescHistory(addrDesc)
+}
+
+// getAddrDescHistory returns confirmed transactions of the address ordered by height (oldest first), followed by mempool transactions
+func (s *ElectrumServer) getAddrDescHistory(addrDesc bchain.AddressDescriptor) ([]electrumHistoryItem, error) {
+	history := make([]electrumHistoryItem, 0)
+	if addrDesc == nil {
+		return history, nil
+	}
+	err := s.db.GetAddrDescTransactions(addrDesc, 0, ^uint32(0), func(txid string, height uint32, indexes []int32) error {
+		history = append(history, electrumHistoryItem{TxHash: txid, Height: int(height)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the index returns the newest blocks first, keep the order of transactions in the block
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Height < history[j].Height
+	})
+	outpoints, err := s.mempool.GetAddrDescTransactions(addrDesc)
+	if err != nil {
+		return nil, err
+	}
+	unique := make(map[string]struct{}, len(outpoints))
+	for _, o := range outpoints {
+		if _, found := unique[o.Txid]; found {
+			continue
+		}
+		unique[o.Txid] = struct{}{}
+		tx, err := s.api.GetTransaction(o.Txid, false, false)
+		if err != nil {
+			// the transaction may have been removed from mempool in the meantime
+			glog.Warning("electrum: GetTransaction ", o.Txid, ": ", err)
+			continue
+		}
+		// height is -1 if the transaction has an unconfirmed input, 0 otherwise
+		hi := electrumHistoryItem{TxHash: o.Txid}
+		for i := range tx.Vin {
+			if tx.Vin[i].Txid != "" && s.mempool.GetTransactionTime(tx.Vin[i].Txid) != 0 {
+				hi.Height = -1
+				break
+			}
+		}
+		if tx.FeesSat != nil {
+			hi.Fee = (*big.Int)(tx.FeesSat)
+		} else {
+			hi.Fee = new(big.Int)
+		}
+		history = append(history, hi)
+	}
+	return history, nil
+}
+
+// electrumStatus computes the status of the history as defined by the electrum protocol, empty history has empty status
+func electrumStatus(history []electrumHistoryItem) string {
+	if len(history) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	for i := range history {
+		b.WriteString(history[i].TxHash)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(history[i].Height))
+		b.WriteByte(':')
+	}
+	h := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(h[:])
+}
+
+// electrumStatusResult returns the status as json null if empty
+func electrumStatusResult(status string) interface{} {
+	if status == "" {
+		return nil
+	}
+	return status
+}
+
+func (s *ElectrumServer) getBalance(scripthash string) (*electrumBalance, error) {
+	addrDesc, err := s.getAddrDesc(scripthash)
+	if err != nil {
+		return nil, err
+	}
+	r := &electrumBalance{Confirmed: new(big.Int), Unconfirmed: new(big.Int)}
+	if addrDesc == nil {
+		return r, nil
+	}
+	ba, err := s.db.GetAddrDescBalance(addrDesc, db.AddressBalanceDetailNoUTXO)
+	if err != nil {
+		return nil, err
+	}
+	if ba != nil {
+		r.Confirmed.Set(&ba.BalanceSat)
+	}
+	// unconfirmed balance is the difference of the utxos including mempool and the confirmed balance
+	utxos, err := s.api.GetAddrDescUtxo(addrDesc, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range utxos {
+		r.Unconfirmed.Add(r.Unconfirmed, (*big.Int)(utxos[i].AmountSat))
+	}
+	r.Unconfirmed.Sub(r.Unconfirmed, r.Confirmed)
+	return r, nil
+}
+
+func (s *ElectrumServer) listUnspent(scripthash string) ([]electrumUnspent, error) {
+	addrDesc, err := s.getAddrDesc(scripthash)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]electrumUnspent, 0)
+	if addrDesc == nil {
+		return r, nil
+	}
+	utxos, err := s.api.GetAddrDescUtxo(addrDesc, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range utxos {
+		u := &utxos[i]
+		r = append(r, electrumUnspent{
+			TxHash: u.Txid,
+			TxPos:  u.Vout,
+			Height: u.Height,
+			Value:  (*big.Int)(u.AmountSat),
+		})
+	}
+	// electrum clients expect the oldest outputs first, unconfirmed last
+	sort.SliceStable(r, func(i, j int) bool {
+		hi, hj := r[i].Height, r[j].Height
+		if hi == 0 {
+			hi = int(^uint32(0) >> 1)
+		}
+		if hj == 0 {
+			hj = int(^uint32(0) >> 1)
+		}
+		return hi < hj
+	})
+	return r, nil
+}
+
+func (s *ElectrumServer) getTransaction(txid string, verbose bool) (interface{}, error) {
+	tx, err := s.chain.GetTransaction(txid)
+	if err != nil {
+		if err == bchain.ErrTxNotFound {
+			return nil, electrumBadRequest(fmt.Sprintf("Transaction '%v' not found", txid))
+		}
+		return nil, &electrumError{electrumErrorDaemon, err.Error()}
+	}
+	if verbose {
+		return s.chain.GetTransactionSpecific(tx)
+	}
+	return tx.Hex, nil
+}
+
+// estimateFee returns the fee rate in coins per kilobyte or -1 if the fee cannot be estimated
+func (s *ElectrumServer) estimateFee(blocks int) (float64, error) {
+	fee, err := s.chain.EstimateSmartFee(blocks, true)
+	if err != nil || fee.Sign() <= 0 {
+		return -1, nil
+	}
+	return strconv.ParseFloat(s.chainParser.AmountToDecimalString(&fee), 64)
+}
+
+func (s *ElectrumServer) subscribeHeaders(c *electrumChannel) (interface{}, error) {
+	height, hash, err := s.db.GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.getHeader(hash, height)
+	if err != nil {
+		return nil, err
+	}
+	s.subscriptionsLock.Lock()
+	s.headersSubscriptions[c] = struct{}{}
+	s.subscriptionsLock.Unlock()
+	return h, nil
+}
+
+func (s *ElectrumServer) subscribeScripthash(c *electrumChannel, scripthash string) (interface{}, error) {
+	history, err := s.getHistory(scripthash)
+	if err != nil {
+		return nil, err
+	}
+	status := electrumStatus(history)
+	s.subscriptionsLock.Lock()
+	defer s.subscriptionsLock.Unlock()
+	as, ok := s.scripthashSubscriptions[scripthash]
+	if !ok {
+		as = make(map[*electrumChannel]string)
+		s.scripthashSubscriptions[scripthash] = as
+	}
+	as[c] = status
+	return electrumStatusResult(status), nil
+}
+
+func (s *ElectrumServer) unsubscribeScripthash(c *electrumChannel, scripthash string) bool {
+	s.subscriptionsLock.Lock()
+	defer s.subscriptionsLock.Unlock()
+	as, ok := s.scripthashSubscriptions[scripthash]
+	if !ok {
+		return false
+	}
+	_, ok = as[c]
+	delete(as, c)
+	if len(as) == 0 {
+		delete(s.scripthashSubscriptions, scripthash)
+	}
+	return ok
+}
+
+func (s *ElectrumServer) unsubscribeAll(c *electrumChannel) {
+	s.subscriptionsLock.Lock()
+	defer s.subscriptionsLock.Unlock()
+	delete(s.headersSubscriptions, c)
+	for sh, as := range s.scripthashSubscriptions {
+		delete(as, c)
+		if len(as) == 0 {
+			delete(s.scripthashSubscriptions, sh)
+		}
+	}
+}
+
+// OnNewBlock is a callback that notifies the subscribed clients about a new block
+func (s *ElectrumServer) OnNewBlock(hash string, height uint32) {
+	s.subscriptionsLock.Lock()
+	subscribed := len(s.headersSubscriptions) > 0
+	// the statuses of all subscribed scripthashes may change, they are checked in notifyLoop
+	s.newBlock = true
+	s.subscriptionsLock.Unlock()
+	if !subscribed {
+		return
+	}
+	h, err := s.getHeader(hash, height)
+	if err != nil {
+		glog.Error("electrum: getHeader error ", err, " for ", hash)
+		return
+	}
+	n := &electrumNotification{JSONRPC: "2.0", Method: "blockchain.headers.subscribe", Params: []interface{}{h}}
+	s.subscriptionsLock.Lock()
+	defer s.subscriptionsLock.Unlock()
+	for c := range s.headersSubscriptions {
+		c.send(n)
+	}
+	glog.Info("electrum: broadcasting new block ", height, " ", hash, " to ", len(s.headersSubscriptions), " channels")
+}
+
+// OnNewTxAddr is a callback that marks the scripthash of the address in a new mempool transaction to be checked for change of status
+// the status is not computed immediately as the transaction is not yet stored in the mempool when the callback is called
+func (s *ElectrumServer) OnNewTxAddr(tx *bchain.Tx, addrDesc bchain.AddressDescriptor) {
+	sh := electrumScripthash(addrDesc)
+	s.mempoolAddrDescsLock.Lock()
+	s.mempoolAddrDescs[sh] = addrDesc
+	s.mempoolAddrDescsLock.Unlock()
+	s.subscriptionsLock.Lock()
+	if _, ok := s.scripthashSubscriptions[sh]; ok {
+		s.dirtyScripthashes[sh] = struct{}{}
+	}
+	s.subscriptionsLock.Unlock()
+}
+
+// notifyLoop periodically checks the subscribed scripthashes affected by new transactions or blocks and notifies the clients about changed statuses
+func (s *ElectrumServer) notifyLoop() {
+	ticker := time.NewTicker(electrumNotifyPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.chanClose:
+			return
+		case <-ticker.C:
+			s.notifyChangedStatuses()
+		}
+	}
+}
+
+func (s *ElectrumServer) notifyChangedStatuses() {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Error("electrum: notifyChangedStatuses recovered from panic: ", r)
+			debug.PrintStack()
+		}
+	}()
+	s.subscriptionsLock.Lock()
+	newBlock := s.newBlock
+	var scripthashes []string
+	if newBlock {
+		scripthashes = make([]string, 0, len(s.scripthashSubscriptions))
+		for sh := range s.scripthashSubscriptions {
+			scripthashes = append(scripthashes, sh)
+		}
+	} else {
+		scripthashes = make([]string, 0, len(s.dirtyScripthashes))
+		for sh := range s.dirtyScripthashes {
+			scripthashes = append(scripthashes, sh)
+		}
+	}
+	s.newBlock = false
+	s.dirtyScripthashes = make(map[string]struct{})
+	s.subscriptionsLock.Unlock()
+	if newBlock {
+		s.cleanupMempoolAddrDescs()
+	}
+	for _, sh := range scripthashes {
+		history, err := s.getHistory(sh)
+		if err != nil {
+			glog.Error("electrum: getHistory error ", err, " for ", sh)
+			continue
+		}
+		status := electrumStatus(history)
+		n := &electrumNotification{JSONRPC: "2.0", Method: "blockchain.scripthash.subscribe", Params: []interface{}{sh, electrumStatusResult(status)}}
+		s.subscriptionsLock.Lock()
+		for c, old := range s.scripthashSubscriptions[sh] {
+			if old != status {
+				s.scripthashSubscriptions[sh][c] = status
+				c.send(n)
+			}
+		}
+		s.subscriptionsLock.Unlock()
+	}
+}
+
+// cleanupMempoolAddrDescs removes addresses which are no longer in mempool
+func (s *ElectrumServer) cleanupMempoolAddrDescs() {
+	s.mempoolAddrDescsLock.Lock()
+	defer s.mempoolAddrDescsLock.Unlock()
+	for sh, addrDesc := range s.mempoolAddrDescs {
+		o, err := s.mempool.GetAddrDescTransactions(addrDesc)
+		if err == nil && len(o) == 0 {
+			delete(s.mempoolAddrDescs, sh)
+		}
+	}
+}
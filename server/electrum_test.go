@@ -0,0 +1,142 @@
+// +build unittest
+
+package server
+
+import (
+	"blockbook/tests/dbtestdata"
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ElectrumServer_BitcoinType(t *testing.T) {
+	ps, dbpath := setupPublicHTTPServer(t)
+	defer closeAndDestroyPublicServer(t, ps, dbpath)
+
+	s, err := NewElectrumServer("127.0.0.1:0", "", ps.db, ps.chain, ps.mempool, ps.txCache, ps.metrics, ps.is)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(listener)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// scripthash of Addr5 (sha256 of the output script, reversed)
+	sh5 := "18789beff0b083eec158e6e5384035b33e34d0bd08aa3cbda50b96f4eac380bb"
+	// scripthash of an address which is not in the index
+	shUnknown := "0000000000000000000000000000000000000000000000000000000000000001"
+
+	tests := []struct {
+		name string
+		req  string
+		want string
+	}{
+		{
+			name: "server.version",
+			req:  `{"id":0,"method":"server.version","params":["test","1.4"]}`,
+			want: `{"jsonrpc":"2.0","id":0,"result":["Blockbook unknown","1.4"]}`,
+		},
+		{
+			name: "server.ping",
+			req:  `{"id":1,"method":"server.ping","params":[]}`,
+			want: `{"jsonrpc":"2.0","id":1,"result":null}`,
+		},
+		{
+			name: "blockchain.scripthash.get_balance",
+			req:  `{"id":2,"method":"blockchain.scripthash.get_balance","params":["` + sh5 + `"]}`,
+			want: `{"jsonrpc":"2.0","id":2,"result":{"confirmed":9000,"unconfirmed":0}}`,
+		},
+		{
+			name: "blockchain.scripthash.get_history",
+			req:  `{"id":3,"method":"blockchain.scripthash.get_history","params":["` + sh5 + `"]}`,
+			want: `{"jsonrpc":"2.0","id":3,"result":[{"tx_hash":"` + dbtestdata.TxidB1T2 + `","height":225493},{"tx_hash":"` + dbtestdata.TxidB2T3 + `","height":225494}]}`,
+		},
+		{
+			name: "blockchain.scripthash.listunspent",
+			req:  `{"id":4,"method":"blockchain.scripthash.listunspent","params":["` + sh5 + `"]}`,
+			want: `{"jsonrpc":"2.0","id":4,"result":[{"tx_hash":"` + dbtestdata.TxidB2T3 + `","tx_pos":0,"height":225494,"value":9000}]}`,
+		},
+		{
+			name: "blockchain.scripthash.subscribe",
+			req:  `{"id":5,"method":"blockchain.scripthash.subscribe","params":["` + sh5 + `"]}`,
+			want: `{"jsonrpc":"2.0","id":5,"result":"b71c44c1f97a75fd783dfc9c70d539af5ec79b939348f005170bf0add509e9c4"}`,
+		},
+		{
+			name: "blockchain.scripthash.subscribe unknown",
+			req:  `{"id":6,"method":"blockchain.scripthash.subscribe","params":["` + shUnknown + `"]}`,
+			want: `{"jsonrpc":"2.0","id":6,"result":null}`,
+		},
+		{
+			name: "blockchain.scripthash.unsubscribe",
+			req:  `{"id":7,"method":"blockchain.scripthash.unsubscribe","params":["` + sh5 + `"]}`,
+			want: `{"jsonrpc":"2.0","id":7,"result":true}`,
+		},
+		{
+			name: "blockchain.scripthash.get_balance invalid scripthash",
+			req:  `{"id":8,"method":"blockchain.scripthash.get_balance","params":["1234"]}`,
+			want: `{"jsonrpc":"2.0","id":8,"error":{"code":1,"message":"invalid scripthash 1234"}}`,
+		},
+		{
+			name: "unknown method",
+			req:  `{"id":9,"method":"blockchain.unknown","params":[]}`,
+			want: `{"jsonrpc":"2.0","id":9,"error":{"code":-32601,"message":"unknown method \"blockchain.unknown\""}}`,
+		},
+		{
+			name: "batch",
+			req:  `[{"id":10,"method":"server.ping","params":[]},{"id":11,"method":"blockchain.scripthash.get_balance","params":["` + shUnknown + `"]}]`,
+			want: `[{"jsonrpc":"2.0","id":10,"result":null},{"jsonrpc":"2.0","id":11,"result":{"confirmed":0,"unconfirmed":0}}]`,
+		},
+		{
+			name: "blockchain.block.header",
+			req:  `{"id":12,"method":"blockchain.block.header","params":[225494]}`,
+			want: `{"jsonrpc":"2.0","id":12,"result":"` + testBlock2Header + `"}`,
+		},
+		{
+			name: "blockchain.block.header checkpoint",
+			req:  `{"id":13,"method":"blockchain.block.header","params":[225494,225494]}`,
+			want: `{"jsonrpc":"2.0","id":13,"error":{"code":1,"message":"checkpoint merkle proofs are not supported"}}`,
+		},
+		{
+			name: "blockchain.block.header out of range",
+			req:  `{"id":14,"method":"blockchain.block.header","params":[225495]}`,
+			want: `{"jsonrpc":"2.0","id":14,"error":{"code":1,"message":"height 225495 out of range"}}`,
+		},
+		{
+			name: "blockchain.block.headers",
+			req:  `{"id":15,"method":"blockchain.block.headers","params":[225494,10]}`,
+			want: `{"jsonrpc":"2.0","id":15,"result":{"count":1,"hex":"` + testBlock2Header + `","max":2016}}`,
+		},
+		{
+			name: "blockchain.relayfee",
+			req:  `{"id":16,"method":"blockchain.relayfee","params":[]}`,
+			want: `{"jsonrpc":"2.0","id":16,"result":0.00001}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write([]byte(tt.req + "\n")); err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(got) != tt.want {
+				t.Errorf("got %v, want %v", strings.TrimSpace(got), tt.want)
+			}
+		})
+	}
+}
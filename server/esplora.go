@@ -0,0 +1,201 @@
+package server
+
+import (
+	"blockbook/api"
+	"blockbook/bchain"
+	"blockbook/common"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// esploraPath is the path of the Esplora compatible REST API relative to the path of the public server
+const esploraPath = "esplora/api/"
+
+// esploraText is a result of the Esplora API returned as plain text
+type esploraText string
+
+// ConnectEsploraInterface enables the Esplora compatible REST API, supported only for bitcoin type coins
+func (s *PublicServer) ConnectEsploraInterface() {
+	if s.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		glog.Error("public server: Esplora API is supported only for bitcoin type coins")
+		return
+	}
+	serveMux := s.https.Handler.(*http.ServeMux)
+	_, path := splitBinding(s.binding)
+	serveMux.HandleFunc(path+esploraPath, s.esploraHandler(path+esploraPath))
+}
+
+// esploraHandler dispatches the Esplora requests, the results are returned as json or plain text
+// and the errors as plain text with http status code, as Esplora does
+func (s *PublicServer) esploraHandler(prefix string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		var err error
+		status := http.StatusOK
+		defer func() {
+			if e := recover(); e != nil {
+				glog.Error("esploraHandler recovered from panic: ", e)
+				debug.PrintStack()
+				data = esploraText("Internal server error")
+				status = http.StatusInternalServerError
+			}
+			if t, isText := data.(esploraText); isText {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(status)
+				w.Write([]byte(t))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(status)
+			if err := json.NewEncoder(w).Encode(data); err != nil {
+				glog.Warning("json encode ", err)
+			}
+		}()
+		data, err = s.esploraRoute(r, strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/"))
+		if err != nil {
+			if apiErr, ok := err.(*api.APIError); ok && apiErr.Public {
+				data = esploraText(apiErr.Error())
+				status = http.StatusBadRequest
+			} else {
+				glog.Error("esploraHandler ", r.URL.Path, " error: ", err)
+				if s.debug {
+					data = esploraText(fmt.Sprintf("Internal server error: %v", err))
+				} else {
+					data = esploraText("Internal server error")
+				}
+				status = http.StatusInternalServerError
+			}
+		} else if data == nil {
+			data = esploraText("Not found")
+			status = http.StatusNotFound
+		}
+	}
+}
+
+func (s *PublicServer) esploraRoute(r *http.Request, p []string) (interface{}, error) {
+	if r.Method == http.MethodPost {
+		if len(p) == 1 && p[0] == "tx" {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-sendtx"}).Inc()
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil || len(b) == 0 {
+				return nil, api.NewAPIError("Missing tx blob", true)
+			}
+			txid, err := s.chain.SendRawTransaction(strings.TrimSpace(string(b)))
+			if err != nil {
+				return nil, api.NewAPIError(err.Error(), true)
+			}
+			return esploraText(txid), nil
+		}
+		return nil, nil
+	}
+	switch p[0] {
+	case "tx":
+		if len(p) < 2 {
+			return nil, nil
+		}
+		txid := p[1]
+		if len(p) == 2 {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-tx"}).Inc()
+			return s.api.GetEsploraTx(txid)
+		}
+		if len(p) == 3 {
+			switch p[2] {
+			case "status":
+				s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-tx-status"}).Inc()
+				return s.api.GetEsploraTxStatus(txid)
+			case "outspends":
+				s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-tx-outspends"}).Inc()
+				return s.api.GetEsploraOutspends(txid)
+			case "hex":
+				s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-tx-hex"}).Inc()
+				tx, err := s.chain.GetTransaction(txid)
+				if err != nil {
+					if err == bchain.ErrTxNotFound {
+						return nil, api.NewAPIError(fmt.Sprintf("Transaction '%v' not found", txid), true)
+					}
+					return nil, err
+				}
+				return esploraText(tx.Hex), nil
+			}
+		}
+	case "address":
+		if len(p) < 2 {
+			return nil, nil
+		}
+		address := p[1]
+		if len(p) == 2 {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-address"}).Inc()
+			return s.api.GetEsploraAddress(address)
+		}
+		switch p[2] {
+		case "utxo":
+			if len(p) == 3 {
+				s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-address-utxo"}).Inc()
+				return s.api.GetEsploraAddressUtxo(address)
+			}
+		case "txs":
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-address-txs"}).Inc()
+			if len(p) == 3 {
+				return s.api.GetEsploraAddressTxs(address, true, true, "")
+			}
+			if p[3] == "mempool" && len(p) == 4 {
+				return s.api.GetEsploraAddressTxs(address, true, false, "")
+			}
+			if p[3] == "chain" && len(p) <= 5 {
+				var lastSeenTxid string
+				if len(p) == 5 {
+					lastSeenTxid = p[4]
+				}
+				return s.api.GetEsploraAddressTxs(address, false, true, lastSeenTxid)
+			}
+		}
+	case "blocks":
+		if len(p) == 3 && p[1] == "tip" {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-blocks-tip"}).Inc()
+			height, hash, err := s.db.GetBestBlock()
+			if err != nil {
+				return nil, err
+			}
+			switch p[2] {
+			case "height":
+				return esploraText(strconv.FormatUint(uint64(height), 10)), nil
+			case "hash":
+				return esploraText(hash), nil
+			}
+		}
+	case "block-height":
+		if len(p) == 2 {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-block-height"}).Inc()
+			height, err := strconv.ParseUint(p[1], 10, 32)
+			if err != nil {
+				return nil, api.NewAPIError("Invalid block height", true)
+			}
+			hash, err := s.db.GetBlockHash(uint32(height))
+			if err != nil {
+				return nil, err
+			}
+			if hash == "" {
+				return nil, nil
+			}
+			return esploraText(hash), nil
+		}
+	case "fee-estimates":
+		if len(p) == 1 {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-fee-estimates"}).Inc()
+			return s.api.GetEsploraFeeEstimates()
+		}
+	case "mempool":
+		if len(p) == 2 && p[1] == "recent" {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "esplora-mempool-recent"}).Inc()
+			return s.api.GetEsploraMempoolRecent()
+		}
+	}
+	return nil, nil
+}
@@ -5,10 +5,12 @@ import (
 	"blockbook/bchain"
 	"blockbook/common"
 	"blockbook/db"
+	"blockbook/webhook"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/golang/glog"
 
@@ -17,19 +19,21 @@ import (
 
 // InternalServer is handle to internal http server
 type InternalServer struct {
+	binding     string
 	https       *http.Server
 	certFiles   string
-	db          *db.RocksDB
+	db          db.Index
 	txCache     *db.TxCache
 	chain       bchain.BlockChain
 	chainParser bchain.BlockChainParser
 	mempool     bchain.Mempool
 	is          *common.InternalState
 	api         *api.Worker
+	webhooks    *webhook.Manager
 }
 
 // NewInternalServer creates new internal http interface to blockbook and returns its handle
-func NewInternalServer(binding, certFiles string, db *db.RocksDB, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, is *common.InternalState) (*InternalServer, error) {
+func NewInternalServer(binding, certFiles string, db db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, is *common.InternalState) (*InternalServer, error) {
 	api, err := api.NewWorker(db, chain, mempool, txCache, is)
 	if err != nil {
 		return nil, err
@@ -42,6 +46,7 @@ func NewInternalServer(binding, certFiles string, db *db.RocksDB, chain bchain.B
 		Handler: serveMux,
 	}
 	s := &InternalServer{
+		binding:     binding,
 		https:       https,
 		certFiles:   certFiles,
 		db:          db,
@@ -55,6 +60,7 @@ func NewInternalServer(binding, certFiles string, db *db.RocksDB, chain bchain.B
 
 	serveMux.Handle(path+"favicon.ico", http.FileServer(http.Dir("./static/")))
 	serveMux.HandleFunc(path+"metrics", promhttp.Handler().ServeHTTP)
+	serveMux.HandleFunc(path+"api/backup", s.backupHandler)
 	serveMux.HandleFunc(path, s.index)
 
 	return s, nil
@@ -98,3 +104,95 @@ func (s *InternalServer) index(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(buf)
 }
+
+// ConnectWebhooks enables management of webhooks at the path api/webhooks
+func (s *InternalServer) ConnectWebhooks(m *webhook.Manager) {
+	s.webhooks = m
+	serveMux := s.https.Handler.(*http.ServeMux)
+	_, path := splitBinding(s.binding)
+	serveMux.HandleFunc(path+"api/webhooks", s.webhooksHandler(path+"api/webhooks"))
+	serveMux.HandleFunc(path+"api/webhooks/", s.webhooksHandler(path+"api/webhooks"))
+}
+
+// webhooksHandler lists the webhooks (GET), registers a new webhook (POST) and removes a webhook (DELETE api/webhooks/<id>)
+func (s *InternalServer) webhooksHandler(prefix string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		var err error
+		status := http.StatusOK
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			data = s.webhooks.Webhooks()
+		case r.Method == http.MethodPost && id == "":
+			var wh db.Webhook
+			if err = json.NewDecoder(r.Body).Decode(&wh); err != nil {
+				err = api.NewAPIError("Invalid webhook json, "+err.Error(), true)
+			} else {
+				data, err = s.webhooks.Register(&wh)
+			}
+		case r.Method == http.MethodDelete && id != "":
+			var found bool
+			found, err = s.webhooks.Unregister(id)
+			if err == nil && !found {
+				err = api.NewAPIError("Webhook not found", true)
+				status = http.StatusNotFound
+			}
+			data = struct {
+				Result bool `json:"result"`
+			}{found}
+		default:
+			err = api.NewAPIError("Unsupported request", true)
+			status = http.StatusMethodNotAllowed
+		}
+		writeInternalJSON(w, "webhooksHandler", status, data, err)
+	}
+}
+
+// backupHandler creates a checkpoint of the database (POST api/backup with json {"dir":"<directory>"}), the sync is not interrupted
+func (s *InternalServer) backupHandler(w http.ResponseWriter, r *http.Request) {
+	var data interface{}
+	var err error
+	status := http.StatusOK
+	if r.Method != http.MethodPost {
+		err = api.NewAPIError("Unsupported request", true)
+		status = http.StatusMethodNotAllowed
+	} else {
+		var req struct {
+			Dir string `json:"dir"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			err = api.NewAPIError("Invalid backup json, "+err.Error(), true)
+		} else if req.Dir == "" {
+			err = api.NewAPIError("Missing dir", true)
+		} else if data, err = s.db.CreateCheckpoint(req.Dir); err != nil {
+			err = api.NewAPIError("Backup failed, "+err.Error(), true)
+		}
+	}
+	writeInternalJSON(w, "backupHandler", status, data, err)
+}
+
+// writeInternalJSON writes the data or the error as json, the public api errors are returned with the status
+// (bad request if the status is OK), other errors are logged and returned as internal server error
+func writeInternalJSON(w http.ResponseWriter, handler string, status int, data interface{}, err error) {
+	type jsonError struct {
+		Text string `json:"error"`
+	}
+	if err != nil {
+		if apiErr, ok := err.(*api.APIError); ok && apiErr.Public {
+			if status == http.StatusOK {
+				status = http.StatusBadRequest
+			}
+			data = jsonError{apiErr.Error()}
+		} else {
+			glog.Error(handler, " ", err)
+			status = http.StatusInternalServerError
+			data = jsonError{"Internal server error"}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err = json.NewEncoder(w).Encode(data); err != nil {
+		glog.Warning("json encode ", err)
+	}
+}
@@ -42,7 +42,7 @@ type PublicServer struct {
 	socketio         *SocketIoServer
 	websocket        *WebsocketServer
 	https            *http.Server
-	db               *db.RocksDB
+	db               db.Index
 	txCache          *db.TxCache
 	chain            bchain.BlockChain
 	chainParser      bchain.BlockChainParser
@@ -58,7 +58,7 @@ type PublicServer struct {
 
 // NewPublicServer creates new public server http interface to blockbook and returns its handle
 // only basic functionality is mapped, to map all functions, call
-func NewPublicServer(binding string, certFiles string, db *db.RocksDB, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, explorerURL string, metrics *common.Metrics, is *common.InternalState, debugMode bool) (*PublicServer, error) {
+func NewPublicServer(binding string, certFiles string, db db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, explorerURL string, metrics *common.Metrics, is *common.InternalState, debugMode bool) (*PublicServer, error) {
 
 	api, err := api.NewWorker(db, chain, mempool, txCache, is)
 	if err != nil {
@@ -179,6 +179,7 @@ func (s *PublicServer) ConnectFullPublicInterface() {
 	serveMux.HandleFunc(path+"api/v2/block-index/", s.jsonHandler(s.apiBlockIndex, apiV2))
 	serveMux.HandleFunc(path+"api/v2/tx-specific/", s.jsonHandler(s.apiTxSpecific, apiV2))
 	serveMux.HandleFunc(path+"api/v2/tx/", s.jsonHandler(s.apiTx, apiV2))
+	serveMux.HandleFunc(path+"api/v2/tx-proof/", s.jsonHandler(s.apiTxProof, apiV2))
 	serveMux.HandleFunc(path+"api/v2/address/", s.jsonHandler(s.apiAddress, apiV2))
 	serveMux.HandleFunc(path+"api/v2/xpub/", s.jsonHandler(s.apiXpub, apiV2))
 	serveMux.HandleFunc(path+"api/v2/utxo/", s.jsonHandler(s.apiUtxo, apiV2))
@@ -186,6 +187,11 @@ func (s *PublicServer) ConnectFullPublicInterface() {
 	serveMux.HandleFunc(path+"api/v2/sendtx/", s.jsonHandler(s.apiSendTx, apiV2))
 	serveMux.HandleFunc(path+"api/v2/estimatefee/", s.jsonHandler(s.apiEstimateFee, apiV2))
 	serveMux.HandleFunc(path+"api/v2/feestats/", s.jsonHandler(s.apiFeeStats, apiV2))
+	serveMux.HandleFunc(path+"api/v2/tickers-list/", s.jsonHandler(s.apiTickersList, apiV2))
+	serveMux.HandleFunc(path+"api/v2/tickers/", s.jsonHandler(s.apiTickers, apiV2))
+	serveMux.HandleFunc(path+"api/v2/balancehistory/", s.jsonHandler(s.apiBalanceHistory, apiV2))
+	serveMux.HandleFunc(path+"api/v2/block-filters/", s.jsonHandler(s.apiBlockFilters, apiV2))
+	serveMux.HandleFunc(path+"api/v2/headers", s.jsonHandler(s.apiBlockHeaders, apiV2))
 	// socket.io interface
 	serveMux.Handle(path+"socket.io/", s.socketio.GetHandler())
 	// websocket interface
@@ -198,6 +204,11 @@ func (s *PublicServer) Close() error {
 	return s.https.Close()
 }
 
+// OnNewFiatRatesTicker notifies users subscribed to fiat rates about a new ticker
+func (s *PublicServer) OnNewFiatRatesTicker(ticker *db.CurrencyRatesTicker) {
+	s.websocket.OnNewFiatRatesTicker(ticker)
+}
+
 // Shutdown shuts down the server
 func (s *PublicServer) Shutdown(ctx context.Context) error {
 	glog.Infof("public server: shutdown")
@@ -216,6 +227,11 @@ func (s *PublicServer) OnNewTxAddr(tx *bchain.Tx, desc bchain.AddressDescriptor)
 	s.websocket.OnNewTxAddr(tx, desc)
 }
 
+// OnMempoolResync notifies users subscribed to websocket about the changes of the status of transactions after the mempool resync
+func (s *PublicServer) OnMempoolResync() {
+	s.websocket.OnMempoolResync()
+}
+
 func (s *PublicServer) txRedirect(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, joinURL(s.explorerURL, r.URL.Path), 302)
 	s.metrics.ExplorerViews.With(common.Labels{"action": "tx-redirect"}).Inc()
@@ -637,6 +653,7 @@ func (s *PublicServer) getAddressQueryParams(r *http.Request, accountDetails api
 		TokensToReturn: tokensToReturn,
 		FromHeight:     uint32(from),
 		ToHeight:       uint32(to),
+		FiatCurrency:   r.URL.Query().Get("currency"),
 	}, filterParam, gap
 }
 
@@ -955,6 +972,20 @@ func (s *PublicServer) apiTxSpecific(r *http.Request, apiVersion int) (interface
 	return tx, err
 }
 
+// apiTxProof returns the merkle proof of inclusion of a confirmed transaction in its block
+func (s *PublicServer) apiTxProof(r *http.Request, apiVersion int) (interface{}, error) {
+	var txid string
+	i := strings.LastIndexByte(r.URL.Path, '/')
+	if i > 0 {
+		txid = r.URL.Path[i+1:]
+	}
+	if len(txid) == 0 {
+		return nil, api.NewAPIError("Missing txid", true)
+	}
+	s.metrics.ExplorerViews.With(common.Labels{"action": "api-tx-proof"}).Inc()
+	return s.api.GetTxMerkleProof(txid)
+}
+
 func (s *PublicServer) apiAddress(r *http.Request, apiVersion int) (interface{}, error) {
 	var addressParam string
 	i := strings.LastIndexByte(r.URL.Path, '/')
@@ -1118,3 +1149,113 @@ func (s *PublicServer) apiEstimateFee(r *http.Request, apiVersion int) (interfac
 	}
 	return nil, api.NewAPIError("Missing parameter 'number of blocks'", true)
 }
+
+// apiBalanceHistory returns amounts received and sent by an address or xpub, aggregated to groups of groupBy seconds (default one hour)
+func (s *PublicServer) apiBalanceHistory(r *http.Request, apiVersion int) (interface{}, error) {
+	var history []api.BalanceHistory
+	var fromTime, toTime int64
+	var err error
+	if i := strings.LastIndexByte(r.URL.Path, '/'); i > 0 {
+		gap, ec := strconv.Atoi(r.URL.Query().Get("gap"))
+		if ec != nil {
+			gap = 0
+		}
+		if t := r.URL.Query().Get("from"); t != "" {
+			if fromTime, err = strconv.ParseInt(t, 10, 64); err != nil {
+				return nil, api.NewAPIError("Parameter 'from' is not a valid Unix timestamp", true)
+			}
+		}
+		if t := r.URL.Query().Get("to"); t != "" {
+			if toTime, err = strconv.ParseInt(t, 10, 64); err != nil {
+				return nil, api.NewAPIError("Parameter 'to' is not a valid Unix timestamp", true)
+			}
+		}
+		groupBy := uint64(3600)
+		if g := r.URL.Query().Get("groupBy"); g != "" {
+			if groupBy, err = strconv.ParseUint(g, 10, 32); err != nil || groupBy == 0 {
+				return nil, api.NewAPIError("Parameter 'groupBy' must be a positive number of seconds", true)
+			}
+		}
+		history, err = s.api.GetXpubBalanceHistory(r.URL.Path[i+1:], fromTime, toTime, uint32(groupBy), gap)
+		if err == nil {
+			s.metrics.ExplorerViews.With(common.Labels{"action": "api-xpub-balancehistory"}).Inc()
+		} else {
+			history, err = s.api.GetBalanceHistory(r.URL.Path[i+1:], fromTime, toTime, uint32(groupBy))
+			s.metrics.ExplorerViews.With(common.Labels{"action": "api-address-balancehistory"}).Inc()
+		}
+	}
+	return history, err
+}
+
+// apiBlockFilters returns BIP158 filters of the blocks from the height in the path to the height in the parameter 'to' (default the same block)
+func (s *PublicServer) apiBlockFilters(r *http.Request, apiVersion int) (interface{}, error) {
+	s.metrics.ExplorerViews.With(common.Labels{"action": "api-block-filters"}).Inc()
+	var from, to int
+	var err error
+	if i := strings.LastIndexByte(r.URL.Path, '/'); i > 0 {
+		if from, err = strconv.Atoi(r.URL.Path[i+1:]); err != nil {
+			return nil, api.NewAPIError("Missing or invalid block height", true)
+		}
+	}
+	to = from
+	if t := r.URL.Query().Get("to"); t != "" {
+		if to, err = strconv.Atoi(t); err != nil {
+			return nil, api.NewAPIError("Parameter 'to' is not a valid block height", true)
+		}
+	}
+	return s.api.GetBlockFilters(from, to)
+}
+
+// apiBlockHeaders returns raw headers of 'count' blocks (default and maximum 2000) starting from the height 'from'
+func (s *PublicServer) apiBlockHeaders(r *http.Request, apiVersion int) (interface{}, error) {
+	s.metrics.ExplorerViews.With(common.Labels{"action": "api-headers"}).Inc()
+	var from, count int
+	var err error
+	if f := r.URL.Query().Get("from"); f != "" {
+		if from, err = strconv.Atoi(f); err != nil {
+			return nil, api.NewAPIError("Parameter 'from' is not a valid block height", true)
+		}
+	}
+	if c := r.URL.Query().Get("count"); c != "" {
+		if count, err = strconv.Atoi(c); err != nil {
+			return nil, api.NewAPIError("Parameter 'count' is not a valid number", true)
+		}
+	}
+	return s.api.GetBlockHeaders(from, count)
+}
+
+// apiTickersList returns a list of available currency tickers at the timestamp (default the current time)
+func (s *PublicServer) apiTickersList(r *http.Request, apiVersion int) (interface{}, error) {
+	s.metrics.ExplorerViews.With(common.Labels{"action": "api-tickers-list"}).Inc()
+	// without the timestamp the currencies of the last ticker are returned
+	var timestamp int64
+	if t := r.URL.Query().Get("timestamp"); t != "" {
+		var err error
+		timestamp, err = strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, api.NewAPIError("Parameter 'timestamp' is not a valid Unix timestamp", true)
+		}
+	}
+	return s.api.GetFiatRatesTickersList(timestamp)
+}
+
+// apiTickers returns fiat rates at the timestamp (default the last available rates) for the currency (default all currencies)
+func (s *PublicServer) apiTickers(r *http.Request, apiVersion int) (interface{}, error) {
+	s.metrics.ExplorerViews.With(common.Labels{"action": "api-tickers"}).Inc()
+	var currencies []string
+	if c := r.URL.Query().Get("currency"); c != "" {
+		currencies = []string{c}
+	}
+	if t := r.URL.Query().Get("timestamp"); t != "" {
+		timestamp, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, api.NewAPIError("Parameter 'timestamp' is not a valid Unix timestamp", true)
+		}
+		result, err := s.api.GetFiatRatesForTimestamps([]int64{timestamp}, currencies)
+		if err != nil {
+			return nil, err
+		}
+		return result.Tickers[0], nil
+	}
+	return s.api.GetCurrentFiatRates(currencies)
+}
@@ -8,6 +8,7 @@ import (
 	"blockbook/common"
 	"blockbook/db"
 	"blockbook/tests/dbtestdata"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -54,14 +55,43 @@ func setupRocksDB(t *testing.T, parser bchain.BlockChainParser) (*db.RocksDB, *c
 	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(parser)); err != nil {
 		t.Fatal(err)
 	}
+	// only the header of the second block is stored, the first one is served by the backend
 	block2 := dbtestdata.GetTestBitcoinTypeBlock2(parser)
+	block2.HeaderRaw, _ = hex.DecodeString(testBlock2Header)
 	if err := d.ConnectBlock(block2); err != nil {
 		t.Fatal(err)
 	}
 	is.FinishedSync(block2.Height)
+	if err := insertFiatRates(d); err != nil {
+		t.Fatal(err)
+	}
 	return d, is, tmp
 }
 
+// testBlock2Header is the raw header of the test block 225494 with the previous block 225493,
+// version 0x20000000, merkle root fdd824a7..., bits 1d00ffff and nonce 12345
+const testBlock2Header = "0000002097294ee985ad46f74c449d9c4e98aa5ba36a85180e5bd70fd9befb7600000000dbf78c79696d857fe6d52c08273a79fcde3fd805eb66b7ee18b7cb80a724d8fd73177c5bffff001d39300000"
+
+// insertFiatRates stores two test tickers, 2019-11-21 14:00:00 UTC and 2019-11-22 14:00:00 UTC
+func insertFiatRates(d *db.RocksDB) error {
+	for _, tr := range []struct {
+		ts    string
+		rates map[string]float64
+	}{
+		{"20191121140000", map[string]float64{"usd": 7814.5, "eur": 7100.0}},
+		{"20191122140000", map[string]float64{"usd": 7914.5, "eur": 7134.1}},
+	} {
+		t, err := time.Parse(db.FiatRatesTimeFormat, tr.ts)
+		if err != nil {
+			return err
+		}
+		if err := d.FiatRatesStoreTicker(&db.CurrencyRatesTicker{Timestamp: &t, Rates: tr.rates}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func setupPublicHTTPServer(t *testing.T) (*PublicServer, string) {
 	parser := btc.NewBitcoinParser(
 		btc.GetChainParams("test"),
@@ -649,6 +679,412 @@ func httpTestsBitcoinType(t *testing.T, ts *httptest.Server) {
 				`{"page":1,"totalPages":1,"itemsOnPage":1000,"hash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","nextBlockHash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","height":225493,"confirmations":2,"size":1234567,"time":1534858021,"version":0,"merkleRoot":"","nonce":"","bits":"","difficulty":"","txCount":2,"txs":[{"txid":"00b2c06055e5e90e9c82bd4181fde310104391a7fa4f289b1704e5d90caa3840","vin":[],"vout":[{"value":"100000000","n":0,"addresses":["mfcWp7DB6NuaZsExybTTXpVgWz559Np4Ti"],"isAddress":true},{"value":"12345","n":1,"spent":true,"addresses":["mtGXQvBowMkBpnhLckhxhbwYK44Gs9eEtz"],"isAddress":true}],"blockHash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","blockHeight":225493,"confirmations":2,"blockTime":1534858021,"value":"100012345","valueIn":"0","fees":"0"},{"txid":"effd9ef509383d536b1c8af5bf434c8efbf521a4f2befd4022bbd68694b4ac75","vin":[],"vout":[{"value":"1234567890123","n":0,"spent":true,"addresses":["mv9uLThosiEnGRbVPS7Vhyw6VssbVRsiAw"],"isAddress":true},{"value":"1","n":1,"spent":true,"addresses":["2MzmAKayJmja784jyHvRUW1bXPget1csRRG"],"isAddress":true},{"value":"9876","n":2,"spent":true,"addresses":["2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1"],"isAddress":true}],"blockHash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","blockHeight":225493,"confirmations":2,"blockTime":1534858021,"value":"1234567900000","valueIn":"0","fees":"0"}]}`,
 			},
 		},
+		{
+			name:        "apiGetBlock from index",
+			r:           newGetRequest(ts.URL + "/api/v2/block/225494"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"page":1,"totalPages":1,"itemsOnPage":1000,"hash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","previousBlockHash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","height":225494,"confirmations":1,"size":2345678,"time":1534859123,"version":536870912,"merkleRoot":"fdd824a780cbb718eeb766eb05d83fdefc793a27082cd5e67f856d69798cf7db","nonce":"12345","bits":"1d00ffff","difficulty":"1","txCount":4,"txs":[`,
+			},
+		},
+		{
+			name:        "apiBlockHeaders",
+			r:           newGetRequest(ts.URL + "/api/v2/headers?from=225494&count=10"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"from":225494,"count":1,"headers":["` + testBlock2Header + `"]}`,
+			},
+		},
+		{
+			name:        "apiBlockHeaders not stored",
+			r:           newGetRequest(ts.URL + "/api/v2/headers?from=225493"),
+			status:      http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"error":"Not supported"}`,
+			},
+		},
+		{
+			name:        "apiBalanceHistory Addr5",
+			r:           newGetRequest(ts.URL + "/api/v2/balancehistory/" + dbtestdata.Addr5 + "?groupBy=1"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[{"time":1534858021,"txs":1,"received":"9876","sent":"0"},{"time":1534859123,"txs":1,"received":"9000","sent":"9876"}]`,
+			},
+		},
+		{
+			name:        "apiBalanceHistory Addr5 from",
+			r:           newGetRequest(ts.URL + "/api/v2/balancehistory/" + dbtestdata.Addr5 + "?from=1534859000"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[{"time":1534856400,"txs":1,"received":"9000","sent":"9876"}]`,
+			},
+		},
+		{
+			name:        "apiBalanceHistory xpub",
+			r:           newGetRequest(ts.URL + "/api/v2/balancehistory/" + dbtestdata.Xpub),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[{"time":1534856400,"txs":2,"received":"118641975501","sent":"1"}]`,
+			},
+		},
+		{
+			name:        "apiBalanceHistory xpub to",
+			r:           newGetRequest(ts.URL + "/api/v2/balancehistory/" + dbtestdata.Xpub + "?to=1534859000&groupBy=1"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[{"time":1534858021,"txs":1,"received":"1","sent":"0"}]`,
+			},
+		},
+		{
+			name:        "apiBalanceHistory invalid groupBy",
+			r:           newGetRequest(ts.URL + "/api/v2/balancehistory/" + dbtestdata.Addr5 + "?groupBy=0"),
+			status:      http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"error":"Parameter 'groupBy' must be a positive number of seconds"}`,
+			},
+		},
+		{
+			name:        "apiTxProof unknown tx",
+			r:           newGetRequest(ts.URL + "/api/v2/tx-proof/0000000000000000000000000000000000000000000000000000000000000001"),
+			status:      http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"error":"Transaction 0000000000000000000000000000000000000000000000000000000000000001 not found or not confirmed"}`,
+			},
+		},
+		{
+			name:        "apiTickersList",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers-list/?timestamp=1574344800"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"ts":1574344800,"available_currencies":["eur","usd"]}`,
+			},
+		},
+		{
+			name:        "apiTickersList last",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers-list/"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"ts":1574431200,"available_currencies":["eur","usd"]}`,
+			},
+		},
+		{
+			name:        "apiTickers last",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers/"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"ts":1574431200,"rates":{"eur":7134.1,"usd":7914.5}}`,
+			},
+		},
+		{
+			name:        "apiTickers timestamp currency",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers/?timestamp=1574340000&currency=usd"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"ts":1574344800,"rates":{"usd":7814.5}}`,
+			},
+		},
+		{
+			name:        "apiTickers missing currency",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers/?timestamp=1574344800&currency=xyz"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"ts":1574344800,"rates":{"xyz":-1}}`,
+			},
+		},
+		{
+			name:        "apiTickers no ticker",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers/?timestamp=7980386400"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"ts":7980386400,"rates":null,"error":"No tickers available for 2222-11-20 00:00:00 +0000 UTC"}`,
+			},
+		},
+		{
+			name:        "apiTickers invalid timestamp",
+			r:           newGetRequest(ts.URL + "/api/v2/tickers/?timestamp=yesterday"),
+			status:      http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"error":"Parameter 'timestamp' is not a valid Unix timestamp"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.DefaultClient.Do(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("StatusCode = %v, want %v", resp.StatusCode, tt.status)
+			}
+			if resp.Header["Content-Type"][0] != tt.contentType {
+				t.Errorf("Content-Type = %v, want %v", resp.Header["Content-Type"][0], tt.contentType)
+			}
+			bb, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := string(bb)
+			for _, c := range tt.body {
+				if !strings.Contains(b, c) {
+					t.Errorf("got %v, want to contain %v", b, c)
+					break
+				}
+			}
+		})
+	}
+}
+
+func httpTestsEsplora(t *testing.T, ts *httptest.Server) {
+	tests := []struct {
+		name        string
+		r           *http.Request
+		status      int
+		contentType string
+		body        []string
+	}{
+		{
+			name:        "esploraBlocksTipHeight",
+			r:           newGetRequest(ts.URL + "/esplora/api/blocks/tip/height"),
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`225494`,
+			},
+		},
+		{
+			name:        "esploraBlocksTipHash",
+			r:           newGetRequest(ts.URL + "/esplora/api/blocks/tip/hash"),
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6`,
+			},
+		},
+		{
+			name:        "esploraBlockHeight",
+			r:           newGetRequest(ts.URL + "/esplora/api/block-height/225493"),
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997`,
+			},
+		},
+		{
+			name:        "esploraTxStatus",
+			r:           newGetRequest(ts.URL + "/esplora/api/tx/05e2e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07/status"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"confirmed":true,"block_height":225494,"block_hash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","block_time":22549400002}`,
+			},
+		},
+		{
+			name:        "esploraTxOutspends",
+			r:           newGetRequest(ts.URL + "/esplora/api/tx/effd9ef509383d536b1c8af5bf434c8efbf521a4f2befd4022bbd68694b4ac75/outspends"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"spent":true,"txid":"05e2e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07","vin":0,"status":{"confirmed":true,"block_height":225494,"block_hash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","block_time":1534859123}}]`,
+			},
+		},
+		{
+			name:        "esploraTxHex not found",
+			r:           newGetRequest(ts.URL + "/esplora/api/tx/1234/hex"),
+			status:      http.StatusBadRequest,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`Transaction '1234' not found`,
+			},
+		},
+		{
+			name:        "esploraAddress",
+			r:           newGetRequest(ts.URL + "/esplora/api/address/2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"address":"2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1","chain_stats":{"funded_txo_count":2,"funded_txo_sum":18876,"spent_txo_count":1,"spent_txo_sum":9876,"tx_count":2},"mempool_stats":{"funded_txo_count":0,"funded_txo_sum":0,"spent_txo_count":0,"spent_txo_sum":0,"tx_count":0}}`,
+			},
+		},
+		{
+			name:        "esploraAddressUtxo",
+			r:           newGetRequest(ts.URL + "/esplora/api/address/2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1/utxo"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[{"txid":"05e2e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07","vout":0,"status":{"confirmed":true,"block_height":225494,"block_hash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","block_time":1534859123},"value":9000}]`,
+			},
+		},
+		{
+			name:        "esploraAddressTxsChain",
+			r:           newGetRequest(ts.URL + "/esplora/api/address/2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1/txs/chain/05e2e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[{"txid":"effd9ef509383d536b1c8af5bf434c8efbf521a4f2befd4022bbd68694b4ac75","version":0,"locktime":0,"vin":[],"vout":[{"scriptpubkey":"`,
+				`{"scriptpubkey":"a914e921fc4912a315078f370d959f2c4f7b6d2a683c87","scriptpubkey_type":"p2sh","scriptpubkey_address":"2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1","value":9876}]`,
+				`"status":{"confirmed":true,"block_height":225493,"block_hash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","block_time":22549300001}}]`,
+			},
+		},
+		{
+			name:        "esploraAddressTxsChain unknown last seen txid",
+			r:           newGetRequest(ts.URL + "/esplora/api/address/2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1/txs/chain/1234"),
+			status:      http.StatusBadRequest,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`Transaction 1234 not found in the history of the address`,
+			},
+		},
+		{
+			name:        "esploraFeeEstimates",
+			r:           newGetRequest(ts.URL + "/esplora/api/fee-estimates"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"1":0.1,"10":1,"1008":100.8,`,
+			},
+		},
+		{
+			name:        "esploraMempoolRecent",
+			r:           newGetRequest(ts.URL + "/esplora/api/mempool/recent"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`[]`,
+			},
+		},
+		{
+			name:        "esploraSendTx",
+			r:           newPostRequest(ts.URL+"/esplora/api/tx", "123456"),
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`9876`,
+			},
+		},
+		{
+			name:        "esplora unknown route",
+			r:           newGetRequest(ts.URL + "/esplora/api/unknown"),
+			status:      http.StatusNotFound,
+			contentType: "text/plain; charset=utf-8",
+			body: []string{
+				`Not found`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.DefaultClient.Do(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("StatusCode = %v, want %v", resp.StatusCode, tt.status)
+			}
+			if resp.Header["Content-Type"][0] != tt.contentType {
+				t.Errorf("Content-Type = %v, want %v", resp.Header["Content-Type"][0], tt.contentType)
+			}
+			bb, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := string(bb)
+			for _, c := range tt.body {
+				if !strings.Contains(b, c) {
+					t.Errorf("got %v, want to contain %v", b, c)
+					break
+				}
+			}
+		})
+	}
+}
+
+func httpTestsDegradedMode(t *testing.T, ts *httptest.Server, is *common.InternalState) {
+	// simulate unreachable backend, only the data stored in db are served
+	is.SetBackendAvailable(false)
+	defer is.SetBackendAvailable(true)
+	tests := []struct {
+		name        string
+		r           *http.Request
+		status      int
+		contentType string
+		body        []string
+	}{
+		{
+			name:        "apiIndex backend unavailable",
+			r:           newGetRequest(ts.URL + "/api"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`"backendUnavailable":true,"backendUnavailableSince":`,
+			},
+		},
+		{
+			name:        "apiTx backend unavailable",
+			r:           newGetRequest(ts.URL + "/api/v2/tx/05e2e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"txid":"05e2e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07","vin":[{"n":0,"addresses":["2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1"],"isAddress":true,"value":"9876"}],"vout":[{"value":"9000","n":0,"addresses":["2NEVv9LJmAnY99W1pFoc5UJjVdypBqdnvu1"],"isAddress":true}],"blockHash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","blockHeight":225494,"confirmations":1,`,
+				`"value":"9000","valueIn":"9876","fees":"876","backendUnavailable":true}`,
+			},
+		},
+		{
+			name:        "apiTx backend unavailable - not found",
+			r:           newGetRequest(ts.URL + "/api/v2/tx/1232e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07"),
+			status:      http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"error":"Transaction '1232e48aeabdd9b75def7b48d756ba304713c2aba7b522bf9dbc893fc4231b07' not found, backend unavailable"}`,
+			},
+		},
+		{
+			name:        "apiAddress backend unavailable",
+			r:           newGetRequest(ts.URL + "/api/v2/address/mv9uLThosiEnGRbVPS7Vhyw6VssbVRsiAw?details=basic"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"address":"mv9uLThosiEnGRbVPS7Vhyw6VssbVRsiAw","balance":"0","totalReceived":"1234567890123","totalSent":"1234567890123","unconfirmedBalance":"0","unconfirmedTxs":0,"txs":2,"backendUnavailable":true}`,
+			},
+		},
+		{
+			name:        "apiGetBlock backend unavailable",
+			r:           newGetRequest(ts.URL + "/api/v2/block/225493"),
+			status:      http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"page":1,"totalPages":1,"itemsOnPage":1000,"hash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","nextBlockHash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","height":225493,"confirmations":2,"size":1234567,"time":1534858021,"version":0,"merkleRoot":"","nonce":"","bits":"","difficulty":"","txCount":2,"backendUnavailable":true}`,
+			},
+		},
+		{
+			name:        "apiGetBlock backend unavailable - hash",
+			r:           newGetRequest(ts.URL + "/api/v2/block/0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997"),
+			status:      http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			body: []string{
+				`{"error":"Block not found, backend unavailable, only the block height can be used"}`,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -755,6 +1191,11 @@ func socketioTestsBitcoinType(t *testing.T, ts *httptest.Server) {
 			req:  socketioReq{"getBlockHeader", []interface{}{225493}},
 			want: `{"result":{"hash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","version":0,"confirmations":0,"height":0,"chainWork":"","nextHash":"","merkleRoot":"","time":0,"medianTime":0,"nonce":0,"bits":"","difficulty":0}}`,
 		},
+		{
+			name: "socketio getBlockHeader hash",
+			req:  socketioReq{"getBlockHeader", []interface{}{"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997"}},
+			want: `{"result":{"hash":"0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997","version":0,"confirmations":2,"height":225493,"chainWork":"","nextHash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6","merkleRoot":"","time":0,"medianTime":0,"nonce":0,"bits":"","difficulty":0}}`,
+		},
 		{
 			name: "socketio getDetailedTransaction",
 			req:  socketioReq{"getDetailedTransaction", []interface{}{"3d90d15ed026dc45e19ffb52875ed18fa9e8012ad123d7f7212176e2b0ebdb71"}},
@@ -965,6 +1406,107 @@ func websocketTestsBitcoinType(t *testing.T, ts *httptest.Server) {
 			},
 			want: `{"id":"16","data":{}}`,
 		},
+		{
+			name: "websocket getCurrentFiatRates",
+			req: websocketReq{
+				Method: "getCurrentFiatRates",
+				Params: map[string]interface{}{
+					"currencies": []string{"usd"},
+				},
+			},
+			want: `{"id":"17","data":{"ts":1574431200,"rates":{"usd":7914.5}}}`,
+		},
+		{
+			name: "websocket getFiatRatesForTimestamps",
+			req: websocketReq{
+				Method: "getFiatRatesForTimestamps",
+				Params: map[string]interface{}{
+					"timestamps": []int64{1574344800, 1574431000, 7980386400},
+					"currencies": []string{"eur"},
+				},
+			},
+			want: `{"id":"18","data":{"tickers":[{"ts":1574344800,"rates":{"eur":7100}},{"ts":1574431200,"rates":{"eur":7134.1}},{"ts":7980386400,"rates":null,"error":"No tickers available for 2222-11-20 00:00:00 +0000 UTC"}]}}`,
+		},
+		{
+			name: "websocket getFiatRatesTickersList",
+			req: websocketReq{
+				Method: "getFiatRatesTickersList",
+				Params: map[string]interface{}{
+					"timestamp": 1574344800,
+				},
+			},
+			want: `{"id":"19","data":{"ts":1574344800,"available_currencies":["eur","usd"]}}`,
+		},
+		{
+			name: "websocket getBalanceHistory xpub",
+			req: websocketReq{
+				Method: "getBalanceHistory",
+				Params: map[string]interface{}{
+					"descriptor": dbtestdata.Xpub,
+					"groupBy":    1,
+				},
+			},
+			want: `{"id":"20","data":[{"time":1534858021,"txs":1,"received":"1","sent":"0"},{"time":1534859123,"txs":1,"received":"118641975500","sent":"1"}]}`,
+		},
+		{
+			name: "websocket getBalanceHistory Addr5",
+			req: websocketReq{
+				Method: "getBalanceHistory",
+				Params: map[string]interface{}{
+					"descriptor": dbtestdata.Addr5,
+				},
+			},
+			want: `{"id":"21","data":[{"time":1534856400,"txs":2,"received":"18876","sent":"9876"}]}`,
+		},
+		{
+			name: "websocket subscribeXpub",
+			req: websocketReq{
+				Method: "subscribeXpub",
+				Params: map[string]interface{}{
+					"xpub": dbtestdata.Xpub,
+				},
+			},
+			want: `{"id":"22","data":{"subscribed":true}}`,
+		},
+		{
+			name: "websocket unsubscribeXpub",
+			req: websocketReq{
+				Method: "unsubscribeXpub",
+				Params: map[string]interface{}{
+					"xpub": dbtestdata.Xpub,
+				},
+			},
+			want: `{"id":"23","data":{"subscribed":false}}`,
+		},
+		{
+			name: "websocket subscribeTransaction",
+			req: websocketReq{
+				Method: "subscribeTransaction",
+				Params: map[string]interface{}{
+					"txid":          "0000000000000000000000000000000000000000000000000000000000000001",
+					"confirmations": 6,
+				},
+			},
+			want: `{"id":"24","data":{"subscribed":true}}`,
+		},
+		{
+			name: "websocket unsubscribeTransaction",
+			req: websocketReq{
+				Method: "unsubscribeTransaction",
+				Params: map[string]interface{}{
+					"txid": "0000000000000000000000000000000000000000000000000000000000000001",
+				},
+			},
+			want: `{"id":"25","data":{"subscribed":false}}`,
+		},
+		{
+			name: "websocket getFiatRatesTickersList last",
+			req: websocketReq{
+				Method: "getFiatRatesTickersList",
+				Params: map[string]interface{}{},
+			},
+			want: `{"id":"26","data":{"ts":1574431200,"available_currencies":["eur","usd"]}}`,
+		},
 	}
 
 	// send all requests at once
@@ -1018,6 +1560,7 @@ func Test_PublicServer_BitcoinType(t *testing.T) {
 	s, dbpath := setupPublicHTTPServer(t)
 	defer closeAndDestroyPublicServer(t, s, dbpath)
 	s.ConnectFullPublicInterface()
+	s.ConnectEsploraInterface()
 	// take the handler of the public server and pass it to the test server
 	ts := httptest.NewServer(s.https.Handler)
 	defer ts.Close()
@@ -1025,4 +1568,6 @@ func Test_PublicServer_BitcoinType(t *testing.T) {
 	httpTestsBitcoinType(t, ts)
 	socketioTestsBitcoinType(t, ts)
 	websocketTestsBitcoinType(t, ts)
+	httpTestsEsplora(t, ts)
+	httpTestsDegradedMode(t, ts, s.is)
 }
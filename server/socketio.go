@@ -22,7 +22,7 @@ import (
 // SocketIoServer is handle to SocketIoServer
 type SocketIoServer struct {
 	server      *gosocketio.Server
-	db          *db.RocksDB
+	db          db.Index
 	txCache     *db.TxCache
 	chain       bchain.BlockChain
 	chainParser bchain.BlockChainParser
@@ -33,7 +33,7 @@ type SocketIoServer struct {
 }
 
 // NewSocketIoServer creates new SocketIo interface to blockbook and returns its handle
-func NewSocketIoServer(db *db.RocksDB, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, metrics *common.Metrics, is *common.InternalState) (*SocketIoServer, error) {
+func NewSocketIoServer(db db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, metrics *common.Metrics, is *common.InternalState) (*SocketIoServer, error) {
 	api, err := api.NewWorker(db, chain, mempool, txCache, is)
 	if err != nil {
 		return nil, err
@@ -489,14 +489,32 @@ func (s *SocketIoServer) getBlockHeader(height uint32, hash string) (res resultG
 		res.Result.Hash = hash
 		return
 	}
-	bh, err := s.chain.GetBlockHeader(hash)
+	height, found, err := s.api.FindBlockHeight(hash)
 	if err != nil {
 		return
 	}
-	res.Result.Hash = bh.Hash
-	res.Result.Confirmations = bh.Confirmations
-	res.Result.Height = int(bh.Height)
-	res.Result.NextHash = bh.Next
+	if !found {
+		// the block is not one of the last blocks of the index, which does not map older block hashes to heights
+		bh, err := s.chain.GetBlockHeader(hash)
+		if err != nil {
+			return res, err
+		}
+		res.Result.Hash = bh.Hash
+		res.Result.Confirmations = bh.Confirmations
+		res.Result.Height = int(bh.Height)
+		res.Result.NextHash = bh.Next
+		return res, nil
+	}
+	bestHeight, _, err := s.db.GetBestBlock()
+	if err != nil {
+		return
+	}
+	res.Result.Hash = hash
+	res.Result.Confirmations = int(bestHeight-height) + 1
+	res.Result.Height = int(height)
+	if height < bestHeight {
+		res.Result.NextHash, err = s.db.GetBlockHash(height + 1)
+	}
 	return
 }
 
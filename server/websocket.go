@@ -9,7 +9,9 @@ import (
 	"math/big"
 	"net/http"
 	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +25,20 @@ const upgradeFailed = "Upgrade failed: "
 const outChannelSize = 500
 const defaultTimeout = 60 * time.Second
 
+// maxXpubSubscriptions is the maximum number of xpubs subscribed by one channel
+const maxXpubSubscriptions = 10
+
+// maximum number of blocks and transactions replayed to a subscription resumed from a cursor
+const maxReplayBlocks = 1000
+const maxReplayTxs = 1000
+
+// replaySendTimeout is the maximum time to wait for a free place in the output buffer of the channel during the replay,
+// the channel which does not read the replayed notifications is closed
+const replaySendTimeout = 10 * time.Second
+
+// maxTxSubscriptions is the maximum number of transactions subscribed by one channel
+const maxTxSubscriptions = 1000
+
 var (
 	// ErrorMethodNotAllowed is returned when client tries to upgrade method other than GET
 	ErrorMethodNotAllowed = errors.New("Method not allowed")
@@ -53,25 +69,35 @@ type websocketChannel struct {
 
 // WebsocketServer is a handle to websocket server
 type WebsocketServer struct {
-	socket                    *websocket.Conn
-	upgrader                  *websocket.Upgrader
-	db                        *db.RocksDB
-	txCache                   *db.TxCache
-	chain                     bchain.BlockChain
-	chainParser               bchain.BlockChainParser
-	mempool                   bchain.Mempool
-	metrics                   *common.Metrics
-	is                        *common.InternalState
-	api                       *api.Worker
-	block0hash                string
-	newBlockSubscriptions     map[*websocketChannel]string
-	newBlockSubscriptionsLock sync.Mutex
-	addressSubscriptions      map[string]map[*websocketChannel]string
-	addressSubscriptionsLock  sync.Mutex
+	socket                     *websocket.Conn
+	upgrader                   *websocket.Upgrader
+	db                         db.Index
+	txCache                    *db.TxCache
+	chain                      bchain.BlockChain
+	chainParser                bchain.BlockChainParser
+	mempool                    bchain.Mempool
+	metrics                    *common.Metrics
+	is                         *common.InternalState
+	api                        *api.Worker
+	block0hash                 string
+	newBlockSubscriptions      map[*websocketChannel]string
+	newBlockReplays            map[*websocketChannel][]*websocketRes
+	newBlockSubscriptionsLock  sync.Mutex
+	addressSubscriptions       map[string]map[*websocketChannel]string
+	addressReplays             map[*websocketChannel][]*websocketRes
+	addressSubscriptionsLock   sync.Mutex
+	fiatRatesSubscriptions     map[string]map[*websocketChannel]string
+	fiatRatesSubscriptionsLock sync.Mutex
+	xpubSubscriptions          map[*websocketChannel]map[string]*xpubSubscription
+	xpubAddrSubscriptions      map[string]map[*xpubSubscription]xpubAddressPath
+	xpubSubscriptionsLock      sync.Mutex
+	txSubscriptions            map[*websocketChannel]map[string]*txSubscription
+	txSubscriptionsLock        sync.Mutex
+	txSubscriptionsUpdate      chan struct{}
 }
 
 // NewWebsocketServer creates new websocket interface to blockbook and returns its handle
-func NewWebsocketServer(db *db.RocksDB, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, metrics *common.Metrics, is *common.InternalState) (*WebsocketServer, error) {
+func NewWebsocketServer(db db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, metrics *common.Metrics, is *common.InternalState) (*WebsocketServer, error) {
 	api, err := api.NewWorker(db, chain, mempool, txCache, is)
 	if err != nil {
 		return nil, err
@@ -86,18 +112,26 @@ func NewWebsocketServer(db *db.RocksDB, chain bchain.BlockChain, mempool bchain.
 			WriteBufferSize: 1024 * 32,
 			CheckOrigin:     checkOrigin,
 		},
-		db:                    db,
-		txCache:               txCache,
-		chain:                 chain,
-		chainParser:           chain.GetChainParser(),
-		mempool:               mempool,
-		metrics:               metrics,
-		is:                    is,
-		api:                   api,
-		block0hash:            b0,
-		newBlockSubscriptions: make(map[*websocketChannel]string),
-		addressSubscriptions:  make(map[string]map[*websocketChannel]string),
+		db:                     db,
+		txCache:                txCache,
+		chain:                  chain,
+		chainParser:            chain.GetChainParser(),
+		mempool:                mempool,
+		metrics:                metrics,
+		is:                     is,
+		api:                    api,
+		block0hash:             b0,
+		newBlockSubscriptions:  make(map[*websocketChannel]string),
+		newBlockReplays:        make(map[*websocketChannel][]*websocketRes),
+		addressSubscriptions:   make(map[string]map[*websocketChannel]string),
+		addressReplays:         make(map[*websocketChannel][]*websocketRes),
+		fiatRatesSubscriptions: make(map[string]map[*websocketChannel]string),
+		xpubSubscriptions:      make(map[*websocketChannel]map[string]*xpubSubscription),
+		xpubAddrSubscriptions:  make(map[string]map[*xpubSubscription]xpubAddressPath),
+		txSubscriptions:        make(map[*websocketChannel]map[string]*txSubscription),
+		txSubscriptionsUpdate:  make(chan struct{}, 1),
 	}
+	go s.txSubscriptionsUpdater()
 	return s, nil
 }
 
@@ -214,6 +248,9 @@ func (s *WebsocketServer) onConnect(c *websocketChannel) {
 func (s *WebsocketServer) onDisconnect(c *websocketChannel) {
 	s.unsubscribeNewBlock(c)
 	s.unsubscribeAddresses(c)
+	s.unsubscribeFiatRates(c)
+	s.unsubscribeXpub(c, "")
+	s.unsubscribeTransaction(c, "")
 	glog.Info("Client disconnected ", c.id, ", ", c.ip)
 	s.metrics.WebsocketClients.Dec()
 }
@@ -283,21 +320,152 @@ var requestHandlers = map[string]func(*WebsocketServer, *websocketChannel, *webs
 		return
 	},
 	"subscribeNewBlock": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
-		return s.subscribeNewBlock(c, req)
+		cursor, err := unmarshalCursor(req.Params)
+		if err == nil {
+			rv, err = s.subscribeNewBlock(c, req, cursor)
+		}
+		return
 	},
 	"unsubscribeNewBlock": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
 		return s.unsubscribeNewBlock(c)
 	},
 	"subscribeAddresses": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
 		ad, err := s.unmarshalAddresses(req.Params)
+		if err != nil {
+			return
+		}
+		cursor, err := unmarshalCursor(req.Params)
 		if err == nil {
-			rv, err = s.subscribeAddresses(c, ad, req)
+			rv, err = s.subscribeAddresses(c, ad, req, cursor)
 		}
 		return
 	},
 	"unsubscribeAddresses": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
 		return s.unsubscribeAddresses(c)
 	},
+	"subscribeXpub": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Xpub string `json:"xpub"`
+			Gap  int    `json:"gap"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			rv, err = s.subscribeXpub(c, r.Xpub, r.Gap, req)
+		}
+		return
+	},
+	"unsubscribeXpub": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Xpub string `json:"xpub"`
+		}{}
+		if len(req.Params) > 0 {
+			if err = json.Unmarshal(req.Params, &r); err != nil {
+				return
+			}
+		}
+		return s.unsubscribeXpub(c, r.Xpub)
+	},
+	"subscribeTransaction": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Txid          string `json:"txid"`
+			Confirmations uint32 `json:"confirmations"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			rv, err = s.subscribeTransaction(c, r.Txid, r.Confirmations, req)
+		}
+		return
+	},
+	"unsubscribeTransaction": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Txid string `json:"txid"`
+		}{}
+		if len(req.Params) > 0 {
+			if err = json.Unmarshal(req.Params, &r); err != nil {
+				return
+			}
+		}
+		return s.unsubscribeTransaction(c, r.Txid)
+	},
+	"getBalanceHistory": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Descriptor string `json:"descriptor"`
+			From       int64  `json:"from"`
+			To         int64  `json:"to"`
+			GroupBy    uint32 `json:"groupBy"`
+			Gap        int    `json:"gap"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			if r.GroupBy == 0 {
+				r.GroupBy = 3600
+			}
+			rv, err = s.api.GetXpubBalanceHistory(r.Descriptor, r.From, r.To, r.GroupBy, r.Gap)
+			if err != nil {
+				rv, err = s.api.GetBalanceHistory(r.Descriptor, r.From, r.To, r.GroupBy)
+			}
+		}
+		return
+	},
+	"getBlockFilters": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			From int `json:"from"`
+			To   int `json:"to"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			if r.To == 0 {
+				r.To = r.From
+			}
+			rv, err = s.api.GetBlockFilters(r.From, r.To)
+		}
+		return
+	},
+	"subscribeFiatRates": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Currency string `json:"currency"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			rv, err = s.subscribeFiatRates(c, r.Currency, req)
+		}
+		return
+	},
+	"unsubscribeFiatRates": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		return s.unsubscribeFiatRates(c)
+	},
+	"getCurrentFiatRates": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Currencies []string `json:"currencies"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			rv, err = s.api.GetCurrentFiatRates(r.Currencies)
+		}
+		return
+	},
+	"getFiatRatesForTimestamps": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Timestamps []int64  `json:"timestamps"`
+			Currencies []string `json:"currencies"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			rv, err = s.api.GetFiatRatesForTimestamps(r.Timestamps, r.Currencies)
+		}
+		return
+	},
+	"getFiatRatesTickersList": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
+		r := struct {
+			Timestamp int64 `json:"timestamp"`
+		}{}
+		err = json.Unmarshal(req.Params, &r)
+		if err == nil {
+			// without the timestamp the currencies of the last ticker are returned
+			rv, err = s.api.GetFiatRatesTickersList(r.Timestamp)
+		}
+		return
+	},
 	"ping": func(s *WebsocketServer, c *websocketChannel, req *websocketReq) (rv interface{}, err error) {
 		r := struct{}{}
 		return r, nil
@@ -362,6 +530,7 @@ type accountInfoReq struct {
 	ToHeight       int    `json:"to"`
 	ContractFilter string `json:"contractFilter"`
 	Gap            int    `json:"gap"`
+	Currency       string `json:"currency"`
 }
 
 func unmarshalGetAccountInfoRequest(params []byte) (*accountInfoReq, error) {
@@ -402,6 +571,7 @@ func (s *WebsocketServer) getAccountInfo(req *accountInfoReq) (res *api.Address,
 		Contract:       req.ContractFilter,
 		Vout:           api.AddressFilterVoutOff,
 		TokensToReturn: tokensToReturn,
+		FiatCurrency:   req.Currency,
 	}
 	if req.PageSize == 0 {
 		req.PageSize = txsOnPage
@@ -549,17 +719,173 @@ type subscriptionResponse struct {
 	Subscribed bool `json:"subscribed"`
 }
 
-func (s *WebsocketServer) subscribeNewBlock(c *websocketChannel, req *websocketReq) (res interface{}, err error) {
+// subscriptionCursor is the last block seen by the client, the subscription resumed from the cursor replays the missed events
+type subscriptionCursor struct {
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// reorgNotification is sent to a resumed subscription if the block of the cursor is no longer in the main chain,
+// the client must then synchronize its state using the other requests
+type reorgNotification struct {
+	Reorg  bool   `json:"reorg"`
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+type newBlockNotification struct {
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+type addressTxNotification struct {
+	Address string  `json:"address"`
+	Tx      *api.Tx `json:"tx"`
+}
+
+// unmarshalCursor returns the optional cursor from the params of a subscription, nil if the cursor is not set
+func unmarshalCursor(params []byte) (*subscriptionCursor, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	r := struct {
+		Cursor *subscriptionCursor `json:"cursor"`
+	}{}
+	if err := json.Unmarshal(params, &r); err != nil {
+		return nil, err
+	}
+	return r.Cursor, nil
+}
+
+// checkCursor returns the best height or reorg notification if the block of the cursor is not in the main chain
+func (s *WebsocketServer) checkCursor(cursor *subscriptionCursor) (uint32, *reorgNotification, error) {
+	bestHeight, _, err := s.db.GetBestBlock()
+	if err != nil {
+		return 0, nil, err
+	}
+	hash, err := s.db.GetBlockHash(cursor.Height)
+	if err != nil {
+		return 0, nil, err
+	}
+	if hash == "" || hash != cursor.Hash {
+		return bestHeight, &reorgNotification{Reorg: true, Height: cursor.Height, Hash: cursor.Hash}, nil
+	}
+	if bestHeight-cursor.Height > maxReplayBlocks {
+		return 0, nil, errors.Errorf("Cursor is older than %d blocks, cannot replay", maxReplayBlocks)
+	}
+	return bestHeight, nil, nil
+}
+
+// sendReplay sends the subscription response and the replayed notifications to the channel
+// it must be called without any subscription lock held, it waits for a free place in the output buffer
+// at most replaySendTimeout, the channel which does not read the notifications is closed
+func (s *WebsocketServer) sendReplay(c *websocketChannel, req *websocketReq, replay []interface{}) bool {
+	for _, r := range replay {
+		if !trySendResponse(c, &websocketRes{ID: req.ID, Data: r}, replaySendTimeout) {
+			glog.Warning("Client ", c.id, " does not read the replayed notifications, closing")
+			s.closeChannel(c)
+			return false
+		}
+	}
+	return true
+}
+
+// trySendResponse sends the response to the channel, waiting at most timeout for a free place in the output buffer
+// with zero timeout it does not wait; returns false if the response was not sent
+func trySendResponse(c *websocketChannel, res *websocketRes, timeout time.Duration) (sent bool) {
+	defer func() {
+		// the output channel was closed meanwhile
+		if r := recover(); r != nil {
+			sent = false
+		}
+	}()
+	if timeout == 0 {
+		select {
+		case c.out <- res:
+			return true
+		default:
+			return false
+		}
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case c.out <- res:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// flushQueued sends the notifications queued during the replay without blocking, skip filters out already replayed ones
+// the subscription lock must be held so that no new notification overtakes the queued ones
+func flushQueued(c *websocketChannel, queued []*websocketRes, skip func(*websocketRes) bool) bool {
+	for _, r := range queued {
+		if skip(r) {
+			continue
+		}
+		if !trySendResponse(c, r, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *WebsocketServer) subscribeNewBlock(c *websocketChannel, req *websocketReq, cursor *subscriptionCursor) (res interface{}, err error) {
 	s.newBlockSubscriptionsLock.Lock()
-	defer s.newBlockSubscriptionsLock.Unlock()
+	if cursor == nil {
+		s.newBlockSubscriptions[c] = req.ID
+		s.newBlockSubscriptionsLock.Unlock()
+		return &subscriptionResponse{true}, nil
+	}
+	// take the snapshot of the best height under the lock, the blocks broadcasted after it are queued until the replay is sent
+	bestHeight, reorg, err := s.checkCursor(cursor)
+	if err != nil {
+		s.newBlockSubscriptionsLock.Unlock()
+		return nil, err
+	}
 	s.newBlockSubscriptions[c] = req.ID
-	return &subscriptionResponse{true}, nil
+	s.newBlockReplays[c] = nil
+	s.newBlockSubscriptionsLock.Unlock()
+
+	replay := []interface{}{&subscriptionResponse{true}}
+	if reorg != nil {
+		replay = append(replay, reorg)
+	} else {
+		for height := cursor.Height + 1; height <= bestHeight; height++ {
+			hash, err := s.db.GetBlockHash(height)
+			if err != nil {
+				s.unsubscribeNewBlock(c)
+				return nil, err
+			}
+			replay = append(replay, &newBlockNotification{Height: height, Hash: hash})
+		}
+	}
+	if !s.sendReplay(c, req, replay) {
+		return nil, nil
+	}
+	s.newBlockSubscriptionsLock.Lock()
+	queued, replaying := s.newBlockReplays[c]
+	delete(s.newBlockReplays, c)
+	sent := !replaying || flushQueued(c, queued, func(r *websocketRes) bool {
+		// the blocks up to the snapshot were already replayed
+		n, ok := r.Data.(*newBlockNotification)
+		return ok && reorg == nil && n.Height <= bestHeight
+	})
+	s.newBlockSubscriptionsLock.Unlock()
+	if !sent {
+		glog.Warning("Client ", c.id, " does not read the notifications, closing")
+		s.closeChannel(c)
+	}
+	// the response was already sent
+	return nil, nil
 }
 
 func (s *WebsocketServer) unsubscribeNewBlock(c *websocketChannel) (res interface{}, err error) {
 	s.newBlockSubscriptionsLock.Lock()
 	defer s.newBlockSubscriptionsLock.Unlock()
 	delete(s.newBlockSubscriptions, c)
+	delete(s.newBlockReplays, c)
 	return &subscriptionResponse{false}, nil
 }
 
@@ -582,11 +908,91 @@ func (s *WebsocketServer) unmarshalAddresses(params []byte) ([]bchain.AddressDes
 	return rv, nil
 }
 
-func (s *WebsocketServer) subscribeAddresses(c *websocketChannel, addrDesc []bchain.AddressDescriptor, req *websocketReq) (res interface{}, err error) {
+type replayTx struct {
+	txid     string
+	height   uint32
+	addrDesc bchain.AddressDescriptor
+}
+
+// addressTxsSince returns the transactions of the addresses confirmed after the height and the transactions in mempool
+// not contained in seen, the transactions are sorted from the oldest
+func (s *WebsocketServer) addressTxsSince(addrDesc []bchain.AddressDescriptor, height, bestHeight uint32, seen map[string]struct{}) ([]replayTx, error) {
+	var txs []replayTx
+	add := func(txid string, h uint32, ad bchain.AddressDescriptor) error {
+		if _, found := seen[txid]; found {
+			return nil
+		}
+		if len(seen) >= maxReplayTxs {
+			return errors.Errorf("More than %d transactions to replay", maxReplayTxs)
+		}
+		seen[txid] = struct{}{}
+		txs = append(txs, replayTx{txid: txid, height: h, addrDesc: ad})
+		return nil
+	}
+	for _, ad := range addrDesc {
+		if height < bestHeight {
+			err := s.db.GetAddrDescTransactions(ad, height+1, bestHeight, func(txid string, h uint32, indexes []int32) error {
+				return add(txid, h, ad)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		o, err := s.mempool.GetAddrDescTransactions(ad)
+		if err != nil {
+			return nil, err
+		}
+		// mempool returns the transactions in reverse order
+		for i := len(o) - 1; i >= 0; i-- {
+			if err := add(o[i].Txid, 0, ad); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		// mempool transactions with height 0 go last
+		hi, hj := txs[i].height, txs[j].height
+		if hi == 0 || hj == 0 {
+			return hi != 0 && hj == 0
+		}
+		return hi < hj
+	})
+	return txs, nil
+}
+
+func (s *WebsocketServer) addressTxNotifications(txs []replayTx) ([]interface{}, error) {
+	r := make([]interface{}, 0, len(txs))
+	for i := range txs {
+		addr, _, err := s.chainParser.GetAddressesFromAddrDesc(txs[i].addrDesc)
+		if err != nil {
+			return nil, err
+		}
+		if len(addr) != 1 {
+			continue
+		}
+		tx, err := s.api.GetTransaction(txs[i].txid, false, false)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, &addressTxNotification{Address: addr[0], Tx: tx})
+	}
+	return r, nil
+}
+
+func (s *WebsocketServer) subscribeAddresses(c *websocketChannel, addrDesc []bchain.AddressDescriptor, req *websocketReq, cursor *subscriptionCursor) (res interface{}, err error) {
 	// unsubscribe all previous subscriptions
 	s.unsubscribeAddresses(c)
 	s.addressSubscriptionsLock.Lock()
-	defer s.addressSubscriptionsLock.Unlock()
+	var bestHeight uint32
+	var reorg *reorgNotification
+	if cursor != nil {
+		// take the snapshot of the best height under the lock, the transactions broadcasted after it are queued until the replay is sent
+		if bestHeight, reorg, err = s.checkCursor(cursor); err != nil {
+			s.addressSubscriptionsLock.Unlock()
+			return nil, err
+		}
+		s.addressReplays[c] = nil
+	}
 	for i := range addrDesc {
 		ads := string(addrDesc[i])
 		as, ok := s.addressSubscriptions[ads]
@@ -596,13 +1002,58 @@ func (s *WebsocketServer) subscribeAddresses(c *websocketChannel, addrDesc []bch
 		}
 		as[c] = req.ID
 	}
-	return &subscriptionResponse{true}, nil
+	s.addressSubscriptionsLock.Unlock()
+	if cursor == nil {
+		return &subscriptionResponse{true}, nil
+	}
+
+	// load the replayed transactions without the lock, it may take some time
+	replay := []interface{}{&subscriptionResponse{true}}
+	seen := make(map[string]struct{})
+	if reorg != nil {
+		replay = append(replay, reorg)
+	} else {
+		txs, err := s.addressTxsSince(addrDesc, cursor.Height, bestHeight, seen)
+		if err == nil {
+			var r []interface{}
+			if r, err = s.addressTxNotifications(txs); err == nil {
+				replay = append(replay, r...)
+			}
+		}
+		if err != nil {
+			s.unsubscribeAddresses(c)
+			return nil, err
+		}
+	}
+	if !s.sendReplay(c, req, replay) {
+		return nil, nil
+	}
+	s.addressSubscriptionsLock.Lock()
+	queued, replaying := s.addressReplays[c]
+	delete(s.addressReplays, c)
+	sent := !replaying || flushQueued(c, queued, func(r *websocketRes) bool {
+		// the mempool transactions may be already replayed
+		n, ok := r.Data.(*addressTxNotification)
+		if !ok || n.Tx == nil {
+			return false
+		}
+		_, found := seen[n.Tx.Txid]
+		return found
+	})
+	s.addressSubscriptionsLock.Unlock()
+	if !sent {
+		glog.Warning("Client ", c.id, " does not read the notifications, closing")
+		s.closeChannel(c)
+	}
+	// the response was already sent
+	return nil, nil
 }
 
 // unsubscribeAddresses unsubscribes all address subscriptions by this channel
 func (s *WebsocketServer) unsubscribeAddresses(c *websocketChannel) (res interface{}, err error) {
 	s.addressSubscriptionsLock.Lock()
 	defer s.addressSubscriptionsLock.Unlock()
+	delete(s.addressReplays, c)
 	for _, sa := range s.addressSubscriptions {
 		for sc := range sa {
 			if sc == c {
@@ -613,68 +1064,557 @@ func (s *WebsocketServer) unsubscribeAddresses(c *websocketChannel) (res interfa
 	return &subscriptionResponse{false}, nil
 }
 
+// xpubSubscription is a subscription of a channel to the addresses derived from xpub
+type xpubSubscription struct {
+	c        *websocketChannel
+	xpub     string
+	id       string
+	basePath string
+	gap      int
+	// addrDescs are the derived address descriptors of receiving (index 0) and change (index 1) addresses
+	addrDescs [2][]string
+	// lastUsed is the index of the last used address of the chain or -1 if no address was used
+	lastUsed [2]int
+}
+
+type xpubAddressPath struct {
+	change int
+	index  int
+}
+
+// addXpubAddressesLocked adds the derived address descriptors to the subscription, s.xpubSubscriptionsLock must be held
+func (s *WebsocketServer) addXpubAddressesLocked(xs *xpubSubscription, change int, addrDescs []bchain.AddressDescriptor) {
+	for _, ad := range addrDescs {
+		ads := string(ad)
+		as, ok := s.xpubAddrSubscriptions[ads]
+		if !ok {
+			as = make(map[*xpubSubscription]xpubAddressPath)
+			s.xpubAddrSubscriptions[ads] = as
+		}
+		as[xs] = xpubAddressPath{change, len(xs.addrDescs[change])}
+		xs.addrDescs[change] = append(xs.addrDescs[change], ads)
+	}
+}
+
+// extendXpubSubscriptionLocked marks the address as used and derives new addresses to keep the gap after the last used address,
+// s.xpubSubscriptionsLock must be held
+func (s *WebsocketServer) extendXpubSubscriptionLocked(xs *xpubSubscription, change int, index int) error {
+	if index <= xs.lastUsed[change] {
+		return nil
+	}
+	xs.lastUsed[change] = index
+	from := len(xs.addrDescs[change])
+	to := index + xs.gap
+	if to <= from {
+		return nil
+	}
+	addrDescs, err := s.chainParser.DeriveAddressDescriptorsFromTo(xs.xpub, uint32(change), uint32(from), uint32(to))
+	if err != nil {
+		return err
+	}
+	s.addXpubAddressesLocked(xs, change, addrDescs)
+	return nil
+}
+
+func (s *WebsocketServer) removeXpubSubscriptionLocked(xs *xpubSubscription) {
+	for change := range xs.addrDescs {
+		for _, ads := range xs.addrDescs[change] {
+			if as, ok := s.xpubAddrSubscriptions[ads]; ok {
+				delete(as, xs)
+				if len(as) == 0 {
+					delete(s.xpubAddrSubscriptions, ads)
+				}
+			}
+		}
+	}
+	if subs, ok := s.xpubSubscriptions[xs.c]; ok {
+		delete(subs, xs.xpub)
+		if len(subs) == 0 {
+			delete(s.xpubSubscriptions, xs.c)
+		}
+	}
+}
+
+// subscribeXpub subscribes to the addresses derived from the xpub, the set of addresses is extended when a new address is used
+// subscription of an already subscribed xpub replaces the previous subscription
+func (s *WebsocketServer) subscribeXpub(c *websocketChannel, xpub string, gap int, req *websocketReq) (res interface{}, err error) {
+	// derive the addresses before taking the lock, it may take some time
+	xd, err := s.api.GetXpubAddressDescriptors(xpub, gap)
+	if err != nil {
+		return nil, err
+	}
+	s.xpubSubscriptionsLock.Lock()
+	defer s.xpubSubscriptionsLock.Unlock()
+	subs := s.xpubSubscriptions[c]
+	if xs, ok := subs[xpub]; ok {
+		s.removeXpubSubscriptionLocked(xs)
+	} else if len(subs) >= maxXpubSubscriptions {
+		return nil, errors.New("Too many xpub subscriptions")
+	}
+	xs := &xpubSubscription{
+		c:        c,
+		xpub:     xpub,
+		id:       req.ID,
+		basePath: xd.BasePath,
+		gap:      xd.Gap,
+		lastUsed: xd.LastUsed,
+	}
+	for change := range xd.AddrDescs {
+		s.addXpubAddressesLocked(xs, change, xd.AddrDescs[change])
+	}
+	subs = s.xpubSubscriptions[c]
+	if subs == nil {
+		subs = make(map[string]*xpubSubscription)
+		s.xpubSubscriptions[c] = subs
+	}
+	subs[xpub] = xs
+	return &subscriptionResponse{true}, nil
+}
+
+// unsubscribeXpub unsubscribes the xpub subscription by this channel, empty xpub unsubscribes all xpub subscriptions
+func (s *WebsocketServer) unsubscribeXpub(c *websocketChannel, xpub string) (res interface{}, err error) {
+	s.xpubSubscriptionsLock.Lock()
+	defer s.xpubSubscriptionsLock.Unlock()
+	for x, xs := range s.xpubSubscriptions[c] {
+		if xpub == "" || x == xpub {
+			s.removeXpubSubscriptionLocked(xs)
+		}
+	}
+	return &subscriptionResponse{false}, nil
+}
+
+// refreshXpubSubscriptions extends the subscribed xpubs by the addresses used in the new block
+func (s *WebsocketServer) refreshXpubSubscriptions() {
+	s.xpubSubscriptionsLock.Lock()
+	var subs []*xpubSubscription
+	for _, xss := range s.xpubSubscriptions {
+		for _, xs := range xss {
+			subs = append(subs, xs)
+		}
+	}
+	s.xpubSubscriptionsLock.Unlock()
+	for _, xs := range subs {
+		// the gap of the subscription is already increased by one
+		xd, err := s.api.GetXpubAddressDescriptors(xs.xpub, xs.gap-1)
+		if err != nil {
+			glog.Error("GetXpubAddressDescriptors error ", err, " for client ", xs.c.id)
+			continue
+		}
+		s.xpubSubscriptionsLock.Lock()
+		// the subscription could have been removed in the meantime
+		if s.xpubSubscriptions[xs.c][xs.xpub] != xs {
+			s.xpubSubscriptionsLock.Unlock()
+			continue
+		}
+		for change := range xd.LastUsed {
+			if err := s.extendXpubSubscriptionLocked(xs, change, xd.LastUsed[change]); err != nil {
+				glog.Error("DeriveAddressDescriptorsFromTo error ", err, " for client ", xs.c.id)
+			}
+		}
+		s.xpubSubscriptionsLock.Unlock()
+	}
+}
+
+// status of the subscribed transaction sent in txStatusNotification
+const (
+	txStatusMempool   = "mempool"
+	txStatusMined     = "mined"
+	txStatusConfirmed = "confirmed"
+	txStatusRemoved   = "removed"
+	txStatusReorged   = "reorged"
+)
+
+// txSubscription tracks the status of a transaction until it reaches the required number of confirmations
+type txSubscription struct {
+	c             *websocketChannel
+	txid          string
+	id            string
+	confirmations uint32
+	inMempool     bool
+	// missing is set when the transaction was not found in mempool, it is reported as removed when it is missing twice
+	// to avoid false reports when the mempool is resynchronized before the block with the transaction is indexed
+	missing bool
+	height  uint32
+	hash    string
+}
+
+type txStatusNotification struct {
+	Txid          string `json:"txid"`
+	Status        string `json:"status"`
+	Height        uint32 `json:"height,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	Confirmations uint32 `json:"confirmations"`
+}
+
+// updateTxSubscriptionLocked compares the stored status of the transaction with the index and mempool
+// and returns the notifications of the changes, done is true if the required number of confirmations was reached
+func (s *WebsocketServer) updateTxSubscriptionLocked(ts *txSubscription, checkMissing bool) (notifications []*txStatusNotification, done bool, err error) {
+	ta, err := s.db.GetTxAddresses(ts.txid)
+	if err != nil {
+		return nil, false, err
+	}
+	var height uint32
+	var hash string
+	if ta != nil {
+		height = ta.Height
+		if hash, err = s.db.GetBlockHash(height); err != nil {
+			return nil, false, err
+		}
+	}
+	if ts.height != 0 && (ts.height != height || ts.hash != hash) {
+		notifications = append(notifications, &txStatusNotification{Txid: ts.txid, Status: txStatusReorged, Height: ts.height, Hash: ts.hash})
+		ts.height = 0
+		ts.hash = ""
+		ts.inMempool = false
+	}
+	if ta != nil {
+		bestHeight, _, err := s.db.GetBestBlock()
+		if err != nil {
+			return nil, false, err
+		}
+		confirmations := uint32(0)
+		if bestHeight >= height {
+			confirmations = bestHeight - height + 1
+		}
+		if ts.height == 0 {
+			ts.height = height
+			ts.hash = hash
+			ts.inMempool = false
+			ts.missing = false
+			notifications = append(notifications, &txStatusNotification{Txid: ts.txid, Status: txStatusMined, Height: height, Hash: hash, Confirmations: confirmations})
+		}
+		if confirmations >= ts.confirmations {
+			notifications = append(notifications, &txStatusNotification{Txid: ts.txid, Status: txStatusConfirmed, Height: height, Hash: hash, Confirmations: confirmations})
+			return notifications, true, nil
+		}
+		return notifications, false, nil
+	}
+	if s.mempool.GetTransactionTime(ts.txid) != 0 {
+		ts.missing = false
+		if !ts.inMempool {
+			ts.inMempool = true
+			notifications = append(notifications, &txStatusNotification{Txid: ts.txid, Status: txStatusMempool})
+		}
+	} else if ts.inMempool && checkMissing {
+		if ts.missing {
+			ts.inMempool = false
+			ts.missing = false
+			notifications = append(notifications, &txStatusNotification{Txid: ts.txid, Status: txStatusRemoved})
+		} else {
+			ts.missing = true
+		}
+	}
+	return notifications, false, nil
+}
+
+func (s *WebsocketServer) removeTxSubscriptionLocked(ts *txSubscription) {
+	if subs, ok := s.txSubscriptions[ts.c]; ok {
+		delete(subs, ts.txid)
+		if len(subs) == 0 {
+			delete(s.txSubscriptions, ts.c)
+		}
+	}
+}
+
+// subscribeTransaction subscribes to the changes of the status of the transaction until it reaches the number of confirmations,
+// the current status is sent immediately after the response
+func (s *WebsocketServer) subscribeTransaction(c *websocketChannel, txid string, confirmations uint32, req *websocketReq) (res interface{}, err error) {
+	if s.chainParser.GetChainType() != bchain.ChainBitcoinType {
+		return nil, errors.New("Not supported")
+	}
+	if len(txid) == 0 {
+		return nil, errors.New("Missing txid")
+	}
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	s.txSubscriptionsLock.Lock()
+	defer s.txSubscriptionsLock.Unlock()
+	subs := s.txSubscriptions[c]
+	if _, ok := subs[txid]; !ok && len(subs) >= maxTxSubscriptions {
+		return nil, errors.New("Too many transaction subscriptions")
+	}
+	ts := &txSubscription{
+		c:             c,
+		txid:          txid,
+		id:            req.ID,
+		confirmations: confirmations,
+	}
+	notifications, done, err := s.updateTxSubscriptionLocked(ts, false)
+	if err != nil {
+		return nil, err
+	}
+	sendResponse(c, req, &subscriptionResponse{true})
+	for _, n := range notifications {
+		sendResponse(c, req, n)
+	}
+	if !done {
+		if subs == nil {
+			subs = make(map[string]*txSubscription)
+			s.txSubscriptions[c] = subs
+		}
+		subs[txid] = ts
+	}
+	// the response was already sent
+	return nil, nil
+}
+
+// unsubscribeTransaction unsubscribes the transaction subscription by this channel, empty txid unsubscribes all transactions
+func (s *WebsocketServer) unsubscribeTransaction(c *websocketChannel, txid string) (res interface{}, err error) {
+	s.txSubscriptionsLock.Lock()
+	defer s.txSubscriptionsLock.Unlock()
+	for t, ts := range s.txSubscriptions[c] {
+		if txid == "" || t == txid {
+			s.removeTxSubscriptionLocked(ts)
+		}
+	}
+	return &subscriptionResponse{false}, nil
+}
+
+// updateTxSubscriptions sends the changes of the status of the subscribed transactions
+func (s *WebsocketServer) updateTxSubscriptions(checkMissing bool) {
+	s.txSubscriptionsLock.Lock()
+	defer s.txSubscriptionsLock.Unlock()
+	for _, subs := range s.txSubscriptions {
+		for _, ts := range subs {
+			notifications, done, err := s.updateTxSubscriptionLocked(ts, checkMissing)
+			if err != nil {
+				glog.Error("updateTxSubscription error ", err, " for tx ", ts.txid)
+				continue
+			}
+			if ts.c.IsAlive() {
+				for _, n := range notifications {
+					ts.c.out <- &websocketRes{
+						ID:   ts.id,
+						Data: n,
+					}
+				}
+			}
+			if done {
+				s.removeTxSubscriptionLocked(ts)
+			}
+		}
+	}
+}
+
+// scheduleTxSubscriptionsUpdate requests the asynchronous update of the subscribed transactions,
+// the requests made while an update is pending are coalesced
+func (s *WebsocketServer) scheduleTxSubscriptionsUpdate() {
+	select {
+	case s.txSubscriptionsUpdate <- struct{}{}:
+	default:
+	}
+}
+
+// txSubscriptionsUpdater runs the scheduled updates of the subscribed transactions
+func (s *WebsocketServer) txSubscriptionsUpdater() {
+	for range s.txSubscriptionsUpdate {
+		s.updateTxSubscriptions(false)
+	}
+}
+
+// notifyTxSubscriptionMempool sends the mempool status of a new mempool transaction without waiting for the mempool resync
+func (s *WebsocketServer) notifyTxSubscriptionMempool(txid string) {
+	s.txSubscriptionsLock.Lock()
+	defer s.txSubscriptionsLock.Unlock()
+	for _, subs := range s.txSubscriptions {
+		ts, ok := subs[txid]
+		if !ok || ts.inMempool || ts.height != 0 {
+			continue
+		}
+		ts.inMempool = true
+		ts.missing = false
+		if ts.c.IsAlive() {
+			ts.c.out <- &websocketRes{
+				ID:   ts.id,
+				Data: &txStatusNotification{Txid: txid, Status: txStatusMempool},
+			}
+		}
+	}
+}
+
+// OnMempoolResync is a callback that sends the changes of the status of the subscribed transactions after the mempool resync
+func (s *WebsocketServer) OnMempoolResync() {
+	s.updateTxSubscriptions(true)
+}
+
+// allFiatRates is the key of subscriptions to all currencies
+const allFiatRates = "!ALL!"
+
+// subscribeFiatRates subscribes to the rates of the currency, empty currency means all currencies
+func (s *WebsocketServer) subscribeFiatRates(c *websocketChannel, currency string, req *websocketReq) (res interface{}, err error) {
+	// unsubscribe all previous subscriptions
+	s.unsubscribeFiatRates(c)
+	s.fiatRatesSubscriptionsLock.Lock()
+	defer s.fiatRatesSubscriptionsLock.Unlock()
+	if currency == "" {
+		currency = allFiatRates
+	} else {
+		currency = strings.ToLower(currency)
+	}
+	as, ok := s.fiatRatesSubscriptions[currency]
+	if !ok {
+		as = make(map[*websocketChannel]string)
+		s.fiatRatesSubscriptions[currency] = as
+	}
+	as[c] = req.ID
+	return &subscriptionResponse{true}, nil
+}
+
+// unsubscribeFiatRates unsubscribes all fiat rates subscriptions by this channel
+func (s *WebsocketServer) unsubscribeFiatRates(c *websocketChannel) (res interface{}, err error) {
+	s.fiatRatesSubscriptionsLock.Lock()
+	defer s.fiatRatesSubscriptionsLock.Unlock()
+	for _, sa := range s.fiatRatesSubscriptions {
+		delete(sa, c)
+	}
+	return &subscriptionResponse{false}, nil
+}
+
+// OnNewFiatRatesTicker is a callback that broadcasts info about fiat rates affecting subscribed currency
+func (s *WebsocketServer) OnNewFiatRatesTicker(ticker *db.CurrencyRatesTicker) {
+	s.fiatRatesSubscriptionsLock.Lock()
+	defer s.fiatRatesSubscriptionsLock.Unlock()
+	for currency, as := range s.fiatRatesSubscriptions {
+		var rates map[string]float64
+		if currency == allFiatRates {
+			rates = ticker.Rates
+		} else {
+			rate, found := ticker.Rates[currency]
+			if !found {
+				continue
+			}
+			rates = map[string]float64{currency: rate}
+		}
+		data := struct {
+			Rates interface{} `json:"rates"`
+		}{
+			Rates: rates,
+		}
+		for c, id := range as {
+			if c.IsAlive() {
+				c.out <- &websocketRes{
+					ID:   id,
+					Data: &data,
+				}
+			}
+		}
+		glog.Info("broadcasting new rates for currency ", currency, " to ", len(as), " channels")
+	}
+}
+
 // OnNewBlock is a callback that broadcasts info about new block to subscribed clients
 func (s *WebsocketServer) OnNewBlock(hash string, height uint32) {
 	s.newBlockSubscriptionsLock.Lock()
-	defer s.newBlockSubscriptionsLock.Unlock()
-	data := struct {
-		Height uint32 `json:"height"`
-		Hash   string `json:"hash"`
-	}{
+	data := newBlockNotification{
 		Height: height,
 		Hash:   hash,
 	}
 	for c, id := range s.newBlockSubscriptions {
-		if c.IsAlive() {
-			c.out <- &websocketRes{
-				ID:   id,
-				Data: &data,
-			}
+		res := &websocketRes{
+			ID:   id,
+			Data: &data,
+		}
+		// the channel is being replayed, the notification is sent after the replay
+		if q, replaying := s.newBlockReplays[c]; replaying {
+			s.newBlockReplays[c] = append(q, res)
+		} else if c.IsAlive() {
+			c.out <- res
 		}
 	}
 	glog.Info("broadcasting new block ", height, " ", hash, " to ", len(s.newBlockSubscriptions), " channels")
+	s.newBlockSubscriptionsLock.Unlock()
+	// the update of the transaction subscriptions reads the db and must not block the sync
+	s.scheduleTxSubscriptionsUpdate()
+	go s.refreshXpubSubscriptions()
 }
 
-// OnNewTxAddr is a callback that broadcasts info about a tx affecting subscribed address
+// OnNewTxAddr is a callback that broadcasts info about a tx affecting subscribed address or xpub
 func (s *WebsocketServer) OnNewTxAddr(tx *bchain.Tx, addrDesc bchain.AddressDescriptor) {
+	s.notifyTxSubscriptionMempool(tx.Txid)
 	// check if there is any subscription but release the lock immediately, GetTransactionFromBchainTx may take some time
 	s.addressSubscriptionsLock.Lock()
 	as, ok := s.addressSubscriptions[string(addrDesc)]
+	subscribed := ok && len(as) > 0
 	s.addressSubscriptionsLock.Unlock()
-	if ok && len(as) > 0 {
-		addr, _, err := s.chainParser.GetAddressesFromAddrDesc(addrDesc)
-		if err != nil {
-			glog.Error("GetAddressesFromAddrDesc error ", err, " for ", addrDesc)
-			return
-		}
-		if len(addr) == 1 {
-			atx, err := s.api.GetTransactionFromBchainTx(tx, 0, false, false)
-			if err != nil {
-				glog.Error("GetTransactionFromBchainTx error ", err, " for ", tx.Txid)
-				return
+	s.xpubSubscriptionsLock.Lock()
+	xpubSubscribed := len(s.xpubAddrSubscriptions[string(addrDesc)]) > 0
+	s.xpubSubscriptionsLock.Unlock()
+	if !subscribed && !xpubSubscribed {
+		return
+	}
+	addr, _, err := s.chainParser.GetAddressesFromAddrDesc(addrDesc)
+	if err != nil {
+		glog.Error("GetAddressesFromAddrDesc error ", err, " for ", addrDesc)
+		return
+	}
+	if len(addr) != 1 {
+		return
+	}
+	atx, err := s.api.GetTransactionFromBchainTx(tx, 0, false, false)
+	if err != nil {
+		glog.Error("GetTransactionFromBchainTx error ", err, " for ", tx.Txid)
+		return
+	}
+	if subscribed {
+		s.broadcastAddressTx(addrDesc, addr[0], atx)
+	}
+	if xpubSubscribed {
+		s.broadcastXpubTx(addrDesc, addr[0], atx)
+	}
+}
+
+func (s *WebsocketServer) broadcastAddressTx(addrDesc bchain.AddressDescriptor, address string, atx *api.Tx) {
+	data := addressTxNotification{
+		Address: address,
+		Tx:      atx,
+	}
+	// get the list of subscriptions again, this time keep the lock
+	s.addressSubscriptionsLock.Lock()
+	defer s.addressSubscriptionsLock.Unlock()
+	as, ok := s.addressSubscriptions[string(addrDesc)]
+	if ok {
+		for c, id := range as {
+			res := &websocketRes{
+				ID:   id,
+				Data: &data,
 			}
-			data := struct {
-				Address string  `json:"address"`
-				Tx      *api.Tx `json:"tx"`
-			}{
-				Address: addr[0],
-				Tx:      atx,
+			// the channel is being replayed, the notification is sent after the replay
+			if q, replaying := s.addressReplays[c]; replaying {
+				s.addressReplays[c] = append(q, res)
+			} else if c.IsAlive() {
+				c.out <- res
 			}
-			// get the list of subscriptions again, this time keep the lock
-			s.addressSubscriptionsLock.Lock()
-			defer s.addressSubscriptionsLock.Unlock()
-			as, ok = s.addressSubscriptions[string(addrDesc)]
-			if ok {
-				for c, id := range as {
-					if c.IsAlive() {
-						c.out <- &websocketRes{
-							ID:   id,
-							Data: &data,
-						}
-					}
-				}
-				glog.Info("broadcasting new tx ", tx.Txid, " for addr ", addr[0], " to ", len(as), " channels")
+		}
+		glog.Info("broadcasting new tx ", atx.Txid, " for addr ", address, " to ", len(as), " channels")
+	}
+}
+
+func (s *WebsocketServer) broadcastXpubTx(addrDesc bchain.AddressDescriptor, address string, atx *api.Tx) {
+	s.xpubSubscriptionsLock.Lock()
+	defer s.xpubSubscriptionsLock.Unlock()
+	as := s.xpubAddrSubscriptions[string(addrDesc)]
+	for xs, p := range as {
+		if xs.c.IsAlive() {
+			xs.c.out <- &websocketRes{
+				ID: xs.id,
+				Data: &struct {
+					Xpub    string  `json:"xpub"`
+					Address string  `json:"address"`
+					Path    string  `json:"path"`
+					Tx      *api.Tx `json:"tx"`
+				}{
+					Xpub:    xs.xpub,
+					Address: address,
+					Path:    xs.basePath + "/" + strconv.Itoa(p.change) + "/" + strconv.Itoa(p.index),
+					Tx:      atx,
+				},
 			}
 		}
+		// the address is used, keep the gap of unused addresses after it
+		if err := s.extendXpubSubscriptionLocked(xs, p.change, p.index); err != nil {
+			glog.Error("DeriveAddressDescriptorsFromTo error ", err, " for client ", xs.c.id)
+		}
 	}
+	glog.Info("broadcasting new tx ", atx.Txid, " for xpub addr ", address, " to ", len(as), " subscriptions")
 }
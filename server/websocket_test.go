@@ -0,0 +1,405 @@
+// +build unittest
+
+package server
+
+import (
+	"blockbook/bchain"
+	"blockbook/tests/dbtestdata"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func Test_WebsocketServer_XpubSubscription(t *testing.T) {
+	ps, dbpath := setupPublicHTTPServer(t)
+	defer closeAndDestroyPublicServer(t, ps, dbpath)
+	s := ps.websocket
+
+	c := &websocketChannel{id: 1, out: make(chan *websocketRes, outChannelSize), alive: true}
+	if _, err := s.subscribeXpub(c, dbtestdata.Xpub, 0, &websocketReq{ID: "7"}); err != nil {
+		t.Fatal(err)
+	}
+	xs := s.xpubSubscriptions[c][dbtestdata.Xpub]
+	if xs == nil {
+		t.Fatal("subscription not found")
+	}
+	// the default gap is 20 unused addresses after the last used address m/49'/1'/33'/0/0 resp. m/49'/1'/33'/1/3
+	if len(xs.addrDescs[0]) != 21 || len(xs.addrDescs[1]) != 24 {
+		t.Fatalf("derived %d receiving and %d change addresses, want 21 and 24", len(xs.addrDescs[0]), len(xs.addrDescs[1]))
+	}
+
+	// a transaction to the last derived receiving address extends the set of subscribed addresses
+	ads, err := s.chainParser.DeriveAddressDescriptorsFromTo(dbtestdata.Xpub, 0, 20, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, _, err := s.chainParser.GetAddressesFromAddrDesc(ads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &bchain.Tx{
+		Txid: "2b0e2e1dfd4d30f1a6b2f8c5c2fd1bce4e8c1a9d3b5f7e0a1c2d3e4f5a6b7c8d",
+		Vout: []bchain.Vout{
+			{
+				N:            0,
+				ValueSat:     *big.NewInt(12345),
+				ScriptPubKey: bchain.ScriptPubKey{Hex: hex.EncodeToString(ads[0])},
+			},
+		},
+	}
+	s.OnNewTxAddr(tx, ads[0])
+	select {
+	case res := <-c.out:
+		if res.ID != "7" {
+			t.Errorf("notification id %v, want 7", res.ID)
+		}
+		b, err := json.Marshal(res.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data struct {
+			Xpub    string `json:"xpub"`
+			Address string `json:"address"`
+			Path    string `json:"path"`
+			Tx      struct {
+				Txid string `json:"txid"`
+			} `json:"tx"`
+		}
+		if err := json.Unmarshal(b, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Xpub != dbtestdata.Xpub || data.Address != addresses[0] || data.Path != "m/49'/1'/33'/0/20" || data.Tx.Txid != tx.Txid {
+			t.Errorf("notification %s", b)
+		}
+	default:
+		t.Fatal("no notification")
+	}
+	if len(xs.addrDescs[0]) != 41 || xs.lastUsed[0] != 20 {
+		t.Errorf("after use derived %d receiving addresses, last used %d, want 41 and 20", len(xs.addrDescs[0]), xs.lastUsed[0])
+	}
+	if _, found := s.xpubAddrSubscriptions[xs.addrDescs[0][40]]; !found {
+		t.Error("extended address not subscribed")
+	}
+
+	s.unsubscribeXpub(c, "")
+	if len(s.xpubSubscriptions) != 0 || len(s.xpubAddrSubscriptions) != 0 {
+		t.Errorf("subscriptions left after unsubscribe: %d xpubs, %d addresses", len(s.xpubSubscriptions), len(s.xpubAddrSubscriptions))
+	}
+}
+
+func Test_WebsocketServer_ExtendXpubSubscriptionUnused(t *testing.T) {
+	ps, dbpath := setupPublicHTTPServer(t)
+	defer closeAndDestroyPublicServer(t, ps, dbpath)
+	s := ps.websocket
+
+	// the subscription of an xpub without used addresses, with the gap of 20 unused addresses
+	c := &websocketChannel{id: 1, out: make(chan *websocketRes, outChannelSize), alive: true}
+	xs := &xpubSubscription{c: c, xpub: dbtestdata.Xpub, gap: 21, lastUsed: [2]int{-1, -1}}
+	ads, err := s.chainParser.DeriveAddressDescriptorsFromTo(dbtestdata.Xpub, 1, 0, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.xpubSubscriptionsLock.Lock()
+	defer s.xpubSubscriptionsLock.Unlock()
+	s.addXpubAddressesLocked(xs, 1, ads)
+
+	// the first used address is recorded like any other and keeps the gap after it
+	for _, tt := range []struct {
+		index    int
+		lastUsed int
+		derived  int
+	}{
+		{0, 0, 21},
+		{0, 0, 21},
+		{2, 2, 23},
+	} {
+		if err := s.extendXpubSubscriptionLocked(xs, 1, tt.index); err != nil {
+			t.Fatal(err)
+		}
+		if xs.lastUsed[1] != tt.lastUsed || len(xs.addrDescs[1]) != tt.derived {
+			t.Errorf("after use of index %d last used %d, derived %d, want %d and %d", tt.index, xs.lastUsed[1], len(xs.addrDescs[1]), tt.lastUsed, tt.derived)
+		}
+	}
+	if xs.lastUsed[0] != -1 {
+		t.Errorf("last used receiving address %d, want -1", xs.lastUsed[0])
+	}
+	s.removeXpubSubscriptionLocked(xs)
+}
+
+func readReplay(t *testing.T, c *websocketChannel) []string {
+	var r []string
+	for {
+		select {
+		case res := <-c.out:
+			b, err := json.Marshal(res.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = append(r, res.ID+" "+string(b))
+		default:
+			return r
+		}
+	}
+}
+
+func Test_WebsocketServer_ResumeSubscription(t *testing.T) {
+	ps, dbpath := setupPublicHTTPServer(t)
+	defer closeAndDestroyPublicServer(t, ps, dbpath)
+	s := ps.websocket
+
+	block1Hash := "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997"
+	block2Hash := "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6"
+	addr5, err := s.chainParser.GetAddrDescFromAddress(dbtestdata.Addr5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		subscribe func(c *websocketChannel, req *websocketReq) (interface{}, error)
+		wantRes   interface{}
+		want      []string
+	}{
+		{
+			name: "newBlock without cursor",
+			subscribe: func(c *websocketChannel, req *websocketReq) (interface{}, error) {
+				return s.subscribeNewBlock(c, req, nil)
+			},
+			wantRes: &subscriptionResponse{true},
+		},
+		{
+			name: "newBlock from cursor",
+			subscribe: func(c *websocketChannel, req *websocketReq) (interface{}, error) {
+				return s.subscribeNewBlock(c, req, &subscriptionCursor{Height: 225493, Hash: block1Hash})
+			},
+			want: []string{
+				`1 {"subscribed":true}`,
+				`1 {"height":225494,"hash":"` + block2Hash + `"}`,
+			},
+		},
+		{
+			name: "newBlock from cursor at tip",
+			subscribe: func(c *websocketChannel, req *websocketReq) (interface{}, error) {
+				return s.subscribeNewBlock(c, req, &subscriptionCursor{Height: 225494, Hash: block2Hash})
+			},
+			want: []string{
+				`1 {"subscribed":true}`,
+			},
+		},
+		{
+			name: "newBlock from cursor not in main chain",
+			subscribe: func(c *websocketChannel, req *websocketReq) (interface{}, error) {
+				return s.subscribeNewBlock(c, req, &subscriptionCursor{Height: 225494, Hash: block1Hash})
+			},
+			want: []string{
+				`1 {"subscribed":true}`,
+				`1 {"reorg":true,"height":225494,"hash":"` + block1Hash + `"}`,
+			},
+		},
+		{
+			name: "addresses from cursor",
+			subscribe: func(c *websocketChannel, req *websocketReq) (interface{}, error) {
+				return s.subscribeAddresses(c, []bchain.AddressDescriptor{addr5}, req, &subscriptionCursor{Height: 225493, Hash: block1Hash})
+			},
+			want: []string{
+				`1 {"subscribed":true}`,
+				`1 {"address":"` + dbtestdata.Addr5 + `","tx":{"txid":"` + dbtestdata.TxidB2T3 + `"}}`,
+			},
+		},
+		{
+			name: "addresses from cursor not in main chain",
+			subscribe: func(c *websocketChannel, req *websocketReq) (interface{}, error) {
+				return s.subscribeAddresses(c, []bchain.AddressDescriptor{addr5}, req, &subscriptionCursor{Height: 300000, Hash: block2Hash})
+			},
+			want: []string{
+				`1 {"subscribed":true}`,
+				`1 {"reorg":true,"height":300000,"hash":"` + block2Hash + `"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &websocketChannel{id: 1, out: make(chan *websocketRes, outChannelSize), alive: true}
+			defer s.onDisconnect(c)
+			res, err := tt.subscribe(c, &websocketReq{ID: "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res, tt.wantRes) {
+				t.Errorf("response %+v, want %+v", res, tt.wantRes)
+			}
+			got := readReplay(t, c)
+			// compare only the txid of the replayed transactions
+			for i := range got {
+				var n struct {
+					Address string `json:"address"`
+					Tx      *struct {
+						Txid string `json:"txid"`
+					} `json:"tx"`
+				}
+				if json.Unmarshal([]byte(got[i][2:]), &n) == nil && n.Tx != nil {
+					b, _ := json.Marshal(n)
+					got[i] = got[i][:2] + string(b)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replay %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_WebsocketServer_ReplayQueue(t *testing.T) {
+	ps, dbpath := setupPublicHTTPServer(t)
+	defer closeAndDestroyPublicServer(t, ps, dbpath)
+	s := ps.websocket
+
+	c := &websocketChannel{id: 1, out: make(chan *websocketRes, outChannelSize), alive: true}
+	defer s.onDisconnect(c)
+	// simulate the channel in the middle of the replay
+	s.newBlockSubscriptionsLock.Lock()
+	s.newBlockSubscriptions[c] = "1"
+	s.newBlockReplays[c] = nil
+	s.newBlockSubscriptionsLock.Unlock()
+
+	s.OnNewBlock("00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6", 225494)
+	if got := readReplay(t, c); len(got) != 0 {
+		t.Fatalf("notification sent during the replay: %v", got)
+	}
+	s.newBlockSubscriptionsLock.Lock()
+	queued := s.newBlockReplays[c]
+	delete(s.newBlockReplays, c)
+	ok := flushQueued(c, queued, func(r *websocketRes) bool { return false })
+	s.newBlockSubscriptionsLock.Unlock()
+	if !ok {
+		t.Fatal("flushQueued failed")
+	}
+	want := []string{`1 {"height":225494,"hash":"00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6"}`}
+	if got := readReplay(t, c); !reflect.DeepEqual(got, want) {
+		t.Errorf("queued notifications %v, want %v", got, want)
+	}
+
+	// the output buffer is full, the queued notification cannot be sent without blocking
+	full := &websocketChannel{id: 2, out: make(chan *websocketRes), alive: true}
+	if flushQueued(full, queued, func(r *websocketRes) bool { return false }) {
+		t.Error("flushQueued to a full channel succeeded")
+	}
+}
+
+func Test_WebsocketServer_TransactionSubscription(t *testing.T) {
+	ps, dbpath := setupPublicHTTPServer(t)
+	defer closeAndDestroyPublicServer(t, ps, dbpath)
+	s := ps.websocket
+
+	block1Hash := "0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997"
+	block2Hash := "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6"
+	unknownTxid := "0000000000000000000000000000000000000000000000000000000000000001"
+	c := &websocketChannel{id: 1, out: make(chan *websocketRes, outChannelSize), alive: true}
+	defer s.onDisconnect(c)
+
+	steps := []struct {
+		name string
+		do   func() error
+		want []string
+	}{
+		{
+			name: "subscribe transaction with enough confirmations",
+			do: func() error {
+				_, err := s.subscribeTransaction(c, dbtestdata.TxidB2T3, 1, &websocketReq{ID: "1"})
+				return err
+			},
+			want: []string{
+				`1 {"subscribed":true}`,
+				`1 {"txid":"` + dbtestdata.TxidB2T3 + `","status":"mined","height":225494,"hash":"` + block2Hash + `","confirmations":1}`,
+				`1 {"txid":"` + dbtestdata.TxidB2T3 + `","status":"confirmed","height":225494,"hash":"` + block2Hash + `","confirmations":1}`,
+			},
+		},
+		{
+			name: "subscribe mined transaction",
+			do: func() error {
+				_, err := s.subscribeTransaction(c, dbtestdata.TxidB1T2, 3, &websocketReq{ID: "2"})
+				return err
+			},
+			want: []string{
+				`2 {"subscribed":true}`,
+				`2 {"txid":"` + dbtestdata.TxidB1T2 + `","status":"mined","height":225493,"hash":"` + block1Hash + `","confirmations":2}`,
+			},
+		},
+		{
+			name: "subscribe unknown transaction",
+			do: func() error {
+				_, err := s.subscribeTransaction(c, unknownTxid, 0, &websocketReq{ID: "3"})
+				return err
+			},
+			want: []string{
+				`3 {"subscribed":true}`,
+			},
+		},
+		{
+			name: "new block without changes",
+			do: func() error {
+				s.updateTxSubscriptions(false)
+				return nil
+			},
+		},
+		{
+			name: "transaction in mempool",
+			do: func() error {
+				s.notifyTxSubscriptionMempool(unknownTxid)
+				s.notifyTxSubscriptionMempool(unknownTxid)
+				return nil
+			},
+			want: []string{
+				`3 {"txid":"` + unknownTxid + `","status":"mempool","confirmations":0}`,
+			},
+		},
+		{
+			name: "transaction missing in mempool once",
+			do: func() error {
+				s.OnMempoolResync()
+				return nil
+			},
+		},
+		{
+			name: "transaction removed from mempool",
+			do: func() error {
+				s.OnMempoolResync()
+				return nil
+			},
+			want: []string{
+				`3 {"txid":"` + unknownTxid + `","status":"removed","confirmations":0}`,
+			},
+		},
+		{
+			name: "mined transaction reorged",
+			do: func() error {
+				s.txSubscriptionsLock.Lock()
+				s.txSubscriptions[c][dbtestdata.TxidB1T2].hash = block2Hash
+				s.txSubscriptionsLock.Unlock()
+				s.updateTxSubscriptions(false)
+				return nil
+			},
+			want: []string{
+				`2 {"txid":"` + dbtestdata.TxidB1T2 + `","status":"reorged","height":225493,"hash":"` + block2Hash + `","confirmations":0}`,
+				`2 {"txid":"` + dbtestdata.TxidB1T2 + `","status":"mined","height":225493,"hash":"` + block1Hash + `","confirmations":2}`,
+			},
+		},
+	}
+	for _, st := range steps {
+		if err := st.do(); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		if got := readReplay(t, c); !reflect.DeepEqual(got, st.want) {
+			t.Errorf("%s: got %v, want %v", st.name, got, st.want)
+		}
+	}
+	if subs := s.txSubscriptions[c]; len(subs) != 2 || subs[dbtestdata.TxidB2T3] != nil {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+	s.unsubscribeTransaction(c, "")
+	if len(s.txSubscriptions) != 0 {
+		t.Errorf("subscriptions left after unsubscribe: %d", len(s.txSubscriptions))
+	}
+}
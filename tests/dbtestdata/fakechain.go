@@ -18,7 +18,7 @@ func NewFakeBlockChain(parser bchain.BlockChainParser) (bchain.BlockChain, error
 }
 
 func (c *fakeBlockChain) CreateMempool(chain bchain.BlockChain) (bchain.Mempool, error) {
-	return bchain.NewMempoolBitcoinType(chain, 1, 1), nil
+	return bchain.NewMempoolBitcoinType(chain, 1, 1, 0), nil
 }
 
 func (c *fakeBlockChain) Initialize() error {
@@ -57,6 +57,7 @@ func (c *fakeBlockChain) GetChainInfo() (v *bchain.ChainInfo, err error) {
 		Bestblockhash: GetTestBitcoinTypeBlock2(c.Parser).BlockHeader.Hash,
 		Version:       "001001",
 		Subversion:    c.GetSubversion(),
+		RelayFee:      0.00001,
 	}, nil
 }
 
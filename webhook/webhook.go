@@ -0,0 +1,783 @@
+package webhook
+
+import (
+	"blockbook/api"
+	"blockbook/bchain"
+	"blockbook/common"
+	"blockbook/db"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/juju/errors"
+)
+
+// event types
+const (
+	// EventTx is sent when a transaction of a registered address appears in mempool or in a block
+	EventTx = "tx"
+	// EventConfirmations is sent when the number of confirmations of a transaction notified by EventTx changes
+	EventConfirmations = "confirmations"
+	// EventBlock is sent when a new block is connected to the index
+	EventBlock = "block"
+)
+
+// http headers of the webhook request
+const (
+	HeaderEvent     = "X-Blockbook-Event"
+	HeaderDelivery  = "X-Blockbook-Delivery"
+	HeaderSignature = "X-Blockbook-Signature"
+)
+
+const (
+	deliveryTimeout   = 10 * time.Second
+	deliveryBatch     = 100
+	deliveryWorkers   = 8
+	dispatchPeriod    = time.Second
+	initialBackoff    = 10 * time.Second
+	maxBackoff        = time.Hour
+	maxAttempts       = 20
+	maxUnconfirmedAge = 72 * time.Hour
+)
+
+// Event is the payload of the webhook request
+type Event struct {
+	Event         string  `json:"event"`
+	WebhookID     string  `json:"webhookId"`
+	Address       string  `json:"address,omitempty"`
+	Txid          string  `json:"txid,omitempty"`
+	Confirmations uint32  `json:"confirmations"`
+	BlockHeight   uint32  `json:"blockHeight,omitempty"`
+	BlockHash     string  `json:"blockHash,omitempty"`
+	Tx            *api.Tx `json:"tx,omitempty"`
+}
+
+type newBlock struct {
+	hash   string
+	height uint32
+}
+
+type registration struct {
+	*db.Webhook
+	// addrDescs maps the address descriptors of the webhook to addresses
+	addrDescs map[string]string
+}
+
+// Manager keeps the webhook registrations, creates the events and delivers them from the outbox
+// the new blocks are processed and the events delivered by the goroutine started by Run
+type Manager struct {
+	db          db.Index
+	chainParser bchain.BlockChainParser
+	mempool     bchain.Mempool
+	api         *api.Worker
+	metrics     *common.Metrics
+	client      *http.Client
+	lock        sync.Mutex
+	webhooks    map[string]*registration
+	addrDescs   map[string][]*registration
+	newBlocks   []newBlock
+	chanWake    chan struct{}
+	chanStop    chan struct{}
+	chanDone    chan struct{}
+}
+
+// NewManager creates the webhook manager and loads the stored registrations
+func NewManager(d db.Index, chain bchain.BlockChain, mempool bchain.Mempool, txCache *db.TxCache, metrics *common.Metrics, is *common.InternalState) (*Manager, error) {
+	if chain.GetChainParser().GetChainType() != bchain.ChainBitcoinType {
+		return nil, errors.New("Webhooks are supported only for bitcoin type coins")
+	}
+	w, err := api.NewWorker(d, chain, mempool, txCache, is)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		db:          d,
+		chainParser: chain.GetChainParser(),
+		mempool:     mempool,
+		api:         w,
+		metrics:     metrics,
+		client:      &http.Client{Timeout: deliveryTimeout},
+		webhooks:    make(map[string]*registration),
+		addrDescs:   make(map[string][]*registration),
+		chanWake:    make(chan struct{}, 1),
+		chanStop:    make(chan struct{}),
+		chanDone:    make(chan struct{}),
+	}
+	webhooks, err := d.GetWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	for _, wh := range webhooks {
+		r, err := m.resolve(wh)
+		if err != nil {
+			// keep the registration, the addresses are resolved again on the next block
+			glog.Error("webhook ", wh.ID, ": ", err)
+		}
+		m.add(r)
+	}
+	glog.Info("webhook: loaded ", len(webhooks), " registrations")
+	return m, nil
+}
+
+// Signature returns the value of the signature header, hex encoded HMAC-SHA256 of the payload with the webhook secret
+func Signature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// resolve returns the registration with the address descriptors of the addresses and of the addresses derived from the xpub
+func (m *Manager) resolve(wh *db.Webhook) (*registration, error) {
+	r := &registration{Webhook: wh, addrDescs: make(map[string]string)}
+	for _, a := range wh.Addresses {
+		addrDesc, err := m.chainParser.GetAddrDescFromAddress(a)
+		if err != nil {
+			return r, api.NewAPIError("Invalid address "+a+", "+err.Error(), true)
+		}
+		r.addrDescs[string(addrDesc)] = a
+	}
+	if wh.Xpub != "" {
+		xa, err := m.api.GetXpubAddress(wh.Xpub, 0, 1, api.AccountDetailsTokens, &api.AddressFilter{Vout: api.AddressFilterVoutOff, TokensToReturn: api.TokensToReturnDerived}, 0)
+		if err != nil {
+			return r, err
+		}
+		for i := range xa.Tokens {
+			a := xa.Tokens[i].Name
+			addrDesc, err := m.chainParser.GetAddrDescFromAddress(a)
+			if err != nil {
+				return r, err
+			}
+			r.addrDescs[string(addrDesc)] = a
+		}
+	}
+	return r, nil
+}
+
+// add adds the registration to the maps, replacing a registration with the same id
+func (m *Manager) add(r *registration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.removeLocked(r.ID)
+	m.webhooks[r.ID] = r
+	for ad := range r.addrDescs {
+		m.addrDescs[ad] = append(m.addrDescs[ad], r)
+	}
+}
+
+func (m *Manager) removeLocked(id string) bool {
+	r, found := m.webhooks[id]
+	if !found {
+		return false
+	}
+	delete(m.webhooks, id)
+	for ad := range r.addrDescs {
+		rs := m.addrDescs[ad]
+		for i := range rs {
+			if rs[i] == r {
+				rs = append(rs[:i], rs[i+1:]...)
+				break
+			}
+		}
+		if len(rs) == 0 {
+			delete(m.addrDescs, ad)
+		} else {
+			m.addrDescs[ad] = rs
+		}
+	}
+	return true
+}
+
+// Register validates and stores a new webhook, the id and the secret are generated if not set
+func (m *Manager) Register(wh *db.Webhook) (*db.Webhook, error) {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, api.NewAPIError("Invalid url", true)
+	}
+	if len(wh.Addresses) == 0 && wh.Xpub == "" && !wh.Blocks {
+		return nil, api.NewAPIError("Missing addresses, xpub or blocks", true)
+	}
+	if wh.ID == "" {
+		if wh.ID, err = randomHex(16); err != nil {
+			return nil, err
+		}
+	}
+	if wh.Secret == "" {
+		if wh.Secret, err = randomHex(32); err != nil {
+			return nil, err
+		}
+	}
+	if wh.Confirmations == 0 {
+		wh.Confirmations = 1
+	}
+	wh.Created = time.Now().Unix()
+	r, err := m.resolve(wh)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.db.StoreWebhook(wh); err != nil {
+		return nil, err
+	}
+	m.add(r)
+	glog.Info("webhook: registered ", wh.ID, ", url ", wh.URL, ", ", len(r.addrDescs), " addresses")
+	return wh, nil
+}
+
+// Unregister removes the webhook, returns false if the webhook does not exist
+func (m *Manager) Unregister(id string) (bool, error) {
+	m.lock.Lock()
+	found := m.removeLocked(id)
+	m.lock.Unlock()
+	if !found {
+		return false, nil
+	}
+	if err := m.db.DeleteWebhook(id); err != nil {
+		return true, err
+	}
+	glog.Info("webhook: unregistered ", id)
+	return true, nil
+}
+
+// Webhooks returns the registered webhooks without their secrets
+func (m *Manager) Webhooks() []db.Webhook {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r := make([]db.Webhook, 0, len(m.webhooks))
+	for _, wh := range m.webhooks {
+		w := *wh.Webhook
+		w.Secret = ""
+		r = append(r, w)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].ID < r[j].ID
+	})
+	return r
+}
+
+// registrationsForAddrDesc returns the registrations of the address descriptor and the address
+func (m *Manager) registrationsForAddrDesc(addrDesc bchain.AddressDescriptor) ([]*registration, []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rs := m.addrDescs[string(addrDesc)]
+	if len(rs) == 0 {
+		return nil, nil
+	}
+	regs := make([]*registration, len(rs))
+	addresses := make([]string, len(rs))
+	for i, r := range rs {
+		regs[i] = r
+		addresses[i] = r.addrDescs[string(addrDesc)]
+	}
+	return regs, addresses
+}
+
+func (m *Manager) enqueue(ev *Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = m.db.StoreWebhookDelivery(&db.WebhookDelivery{
+		WebhookID:   ev.WebhookID,
+		Event:       ev.Event,
+		Payload:     payload,
+		NextAttempt: now.UnixNano() / int64(time.Millisecond),
+		Created:     now.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	m.wake()
+	return nil
+}
+
+// wake wakes the manager goroutine if it waits for the next dispatch period
+func (m *Manager) wake() {
+	select {
+	case m.chanWake <- struct{}{}:
+	default:
+	}
+}
+
+// notifyTx creates the tx event if the transaction is not yet tracked by the webhook and starts tracking of its confirmations
+func (m *Manager) notifyTx(r *registration, address string, txid string, confirmations uint32, getTx func() (*api.Tx, error)) error {
+	t, err := m.db.GetWebhookTx(r.ID, txid)
+	if err != nil || t != nil {
+		return err
+	}
+	tx, err := getTx()
+	if err != nil {
+		return err
+	}
+	ev := &Event{
+		Event:         EventTx,
+		WebhookID:     r.ID,
+		Address:       address,
+		Txid:          txid,
+		Confirmations: confirmations,
+		Tx:            tx,
+	}
+	if confirmations > 0 {
+		ev.BlockHeight = uint32(tx.Blockheight)
+		ev.BlockHash = tx.Blockhash
+	}
+	if err = m.enqueue(ev); err != nil {
+		return err
+	}
+	if confirmations >= r.Confirmations {
+		return nil
+	}
+	return m.db.StoreWebhookTx(&db.WebhookTx{
+		WebhookID:     r.ID,
+		Txid:          txid,
+		Address:       address,
+		Confirmations: confirmations,
+		FirstSeen:     time.Now().Unix(),
+	})
+}
+
+// OnNewTxAddr is a callback that creates the tx events for a new mempool transaction of a registered address
+func (m *Manager) OnNewTxAddr(tx *bchain.Tx, addrDesc bchain.AddressDescriptor) {
+	regs, addresses := m.registrationsForAddrDesc(addrDesc)
+	if len(regs) == 0 {
+		return
+	}
+	var atx *api.Tx
+	getTx := func() (*api.Tx, error) {
+		var err error
+		if atx == nil {
+			atx, err = m.api.GetTransactionFromBchainTx(tx, 0, false, false)
+		}
+		return atx, err
+	}
+	for i, r := range regs {
+		if err := m.notifyTx(r, addresses[i], tx.Txid, 0, getTx); err != nil {
+			glog.Error("webhook ", r.ID, ": tx ", tx.Txid, ": ", err)
+		}
+	}
+}
+
+// OnNewBlock is a callback that queues the block for the manager goroutine, so that the sync is not delayed by the webhooks
+func (m *Manager) OnNewBlock(hash string, height uint32) {
+	m.lock.Lock()
+	m.newBlocks = append(m.newBlocks, newBlock{hash: hash, height: height})
+	m.lock.Unlock()
+	m.wake()
+}
+
+// processBlocks processes the queued blocks in the order in which they were connected
+func (m *Manager) processBlocks() {
+	for !m.stopping() {
+		m.lock.Lock()
+		if len(m.newBlocks) == 0 {
+			m.lock.Unlock()
+			return
+		}
+		b := m.newBlocks[0]
+		m.newBlocks = m.newBlocks[1:]
+		m.lock.Unlock()
+		m.processBlock(b.hash, b.height)
+	}
+}
+
+// processBlock creates the block events, tx events for transactions of registered addresses in the block
+// and confirmation updates of the tracked transactions
+func (m *Manager) processBlock(hash string, height uint32) {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Error("webhook: processBlock recovered from panic: ", r)
+			debug.PrintStack()
+		}
+	}()
+	// the block could have been disconnected by a fork before it was processed
+	if h, err := m.db.GetBlockHash(height); err != nil || h != hash {
+		glog.Info("webhook: block ", height, " ", hash, " is not in the index, skipped")
+		return
+	}
+	m.refreshXpubs()
+	m.lock.Lock()
+	regs := make([]*registration, 0, len(m.webhooks))
+	for _, r := range m.webhooks {
+		regs = append(regs, r)
+	}
+	m.lock.Unlock()
+	if len(regs) == 0 {
+		return
+	}
+	for _, r := range regs {
+		if r.Blocks {
+			if err := m.enqueue(&Event{Event: EventBlock, WebhookID: r.ID, BlockHeight: height, BlockHash: hash}); err != nil {
+				glog.Error("webhook ", r.ID, ": block ", height, ": ", err)
+			}
+		}
+	}
+	if err := m.processBlockTxs(height); err != nil {
+		glog.Error("webhook: block ", height, ": ", err)
+	}
+	if err := m.updateConfirmations(height); err != nil {
+		glog.Error("webhook: block ", height, ": ", err)
+	}
+}
+
+// refreshXpubs derives again the addresses of xpubs, new addresses may have been used
+func (m *Manager) refreshXpubs() {
+	m.lock.Lock()
+	var xpubs []*db.Webhook
+	for _, r := range m.webhooks {
+		if r.Xpub != "" {
+			xpubs = append(xpubs, r.Webhook)
+		}
+	}
+	m.lock.Unlock()
+	for _, wh := range xpubs {
+		r, err := m.resolve(wh)
+		if err != nil {
+			glog.Error("webhook ", wh.ID, ": ", err)
+			continue
+		}
+		m.lock.Lock()
+		// the webhook could have been unregistered in the meantime
+		_, found := m.webhooks[wh.ID]
+		m.lock.Unlock()
+		if found {
+			m.add(r)
+		}
+	}
+}
+
+// processBlockTxs creates the tx events for the transactions of registered addresses in the block not seen before in mempool
+func (m *Manager) processBlockTxs(height uint32) error {
+	txids, err := m.db.GetBlockTxids(height)
+	if err != nil {
+		return err
+	}
+	for _, txid := range txids {
+		ta, err := m.db.GetTxAddresses(txid)
+		if err != nil {
+			return err
+		}
+		if ta == nil {
+			continue
+		}
+		type match struct {
+			r       *registration
+			address string
+		}
+		matches := make(map[string]match)
+		for _, ads := range [][]bchain.AddressDescriptor{taInputs(ta), taOutputs(ta)} {
+			for _, ad := range ads {
+				regs, addresses := m.registrationsForAddrDesc(ad)
+				for i, r := range regs {
+					if _, found := matches[r.ID]; !found {
+						matches[r.ID] = match{r, addresses[i]}
+					}
+				}
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		txid := txid
+		var atx *api.Tx
+		getTx := func() (*api.Tx, error) {
+			var err error
+			if atx == nil {
+				atx, err = m.api.GetTransaction(txid, false, false)
+			}
+			return atx, err
+		}
+		for _, mt := range matches {
+			if err := m.notifyTx(mt.r, mt.address, txid, 1, getTx); err != nil {
+				glog.Error("webhook ", mt.r.ID, ": tx ", txid, ": ", err)
+			}
+		}
+	}
+	return nil
+}
+
+func taInputs(ta *db.TxAddresses) []bchain.AddressDescriptor {
+	r := make([]bchain.AddressDescriptor, len(ta.Inputs))
+	for i := range ta.Inputs {
+		r[i] = ta.Inputs[i].AddrDesc
+	}
+	return r
+}
+
+func taOutputs(ta *db.TxAddresses) []bchain.AddressDescriptor {
+	r := make([]bchain.AddressDescriptor, len(ta.Outputs))
+	for i := range ta.Outputs {
+		r[i] = ta.Outputs[i].AddrDesc
+	}
+	return r
+}
+
+// updateConfirmations creates the confirmation updates of the tracked transactions
+// the tracking stops when the number of confirmations required by the webhook is reached
+// or when the transaction disappears from mempool without being confirmed
+func (m *Manager) updateConfirmations(height uint32) error {
+	tracked, err := m.db.GetWebhookTxs()
+	if err != nil {
+		return err
+	}
+	for _, t := range tracked {
+		m.lock.Lock()
+		r := m.webhooks[t.WebhookID]
+		m.lock.Unlock()
+		if r == nil {
+			if err := m.db.DeleteWebhookTx(t.WebhookID, t.Txid); err != nil {
+				return err
+			}
+			continue
+		}
+		ta, err := m.db.GetTxAddresses(t.Txid)
+		if err != nil {
+			return err
+		}
+		if ta == nil {
+			if m.mempool.GetTransactionTime(t.Txid) == 0 && time.Since(time.Unix(t.FirstSeen, 0)) > maxUnconfirmedAge {
+				glog.Info("webhook ", t.WebhookID, ": tx ", t.Txid, " not confirmed, tracking stopped")
+				if err := m.db.DeleteWebhookTx(t.WebhookID, t.Txid); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if ta.Height > height {
+			continue
+		}
+		confirmations := height - ta.Height + 1
+		if confirmations <= t.Confirmations {
+			continue
+		}
+		blockHash, err := m.db.GetBlockHash(ta.Height)
+		if err != nil {
+			return err
+		}
+		err = m.enqueue(&Event{
+			Event:         EventConfirmations,
+			WebhookID:     t.WebhookID,
+			Address:       t.Address,
+			Txid:          t.Txid,
+			Confirmations: confirmations,
+			BlockHeight:   ta.Height,
+			BlockHash:     blockHash,
+		})
+		if err != nil {
+			return err
+		}
+		if confirmations >= r.Confirmations {
+			err = m.db.DeleteWebhookTx(t.WebhookID, t.Txid)
+		} else {
+			t.Confirmations = confirmations
+			err = m.db.StoreWebhookTx(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run processes the new blocks and delivers the events from the outbox until Stop is called
+func (m *Manager) Run() {
+	defer close(m.chanDone)
+	glog.Info("webhook: starting delivery")
+	ticker := time.NewTicker(dispatchPeriod)
+	defer ticker.Stop()
+	for {
+		m.processBlocks()
+		m.deliverDue()
+		select {
+		case <-m.chanStop:
+			glog.Info("webhook: delivery stopped")
+			return
+		case <-m.chanWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops the delivery and waits until the running delivery finishes
+func (m *Manager) Stop() {
+	close(m.chanStop)
+	<-m.chanDone
+}
+
+func (m *Manager) stopping() bool {
+	select {
+	case <-m.chanStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliverDue delivers the due events from the outbox, the deliveries are grouped by the url of the webhook,
+// the groups are delivered concurrently by at most deliveryWorkers workers, the deliveries of one group in order
+func (m *Manager) deliverDue() {
+	for !m.stopping() {
+		dls, err := m.db.GetDueWebhookDeliveries(time.Now(), deliveryBatch)
+		if err != nil {
+			glog.Error("webhook: GetDueWebhookDeliveries ", err)
+			return
+		}
+		if len(dls) == 0 {
+			return
+		}
+		// the deliveries left in the outbox by an unavailable url are attempted in the next dispatch period
+		if !m.deliverGroups(m.groupByURL(dls)) || len(dls) < deliveryBatch {
+			return
+		}
+	}
+}
+
+// groupByURL splits the deliveries to groups by the url of the webhook, keeping their order
+// the deliveries of the removed webhooks form a separate group
+func (m *Manager) groupByURL(dls []*db.WebhookDelivery) [][]*db.WebhookDelivery {
+	var groups [][]*db.WebhookDelivery
+	index := make(map[string]int)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, dl := range dls {
+		var u string
+		if r := m.webhooks[dl.WebhookID]; r != nil {
+			u = r.URL
+		}
+		i, found := index[u]
+		if !found {
+			i = len(groups)
+			index[u] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], dl)
+	}
+	return groups
+}
+
+// deliverGroups delivers the groups of deliveries by the pool of workers, returns false if some deliveries were left in the outbox
+func (m *Manager) deliverGroups(groups [][]*db.WebhookDelivery) bool {
+	workers := deliveryWorkers
+	if len(groups) < workers {
+		workers = len(groups)
+	}
+	var incomplete int32
+	var wg sync.WaitGroup
+	chanGroups := make(chan []*db.WebhookDelivery)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range chanGroups {
+				if !m.deliverGroup(g) {
+					atomic.StoreInt32(&incomplete, 1)
+				}
+			}
+		}()
+	}
+	for _, g := range groups {
+		chanGroups <- g
+	}
+	close(chanGroups)
+	wg.Wait()
+	return atomic.LoadInt32(&incomplete) == 0
+}
+
+// deliverGroup delivers the deliveries of one url in order, it stops at the first failed delivery
+// not to wait for the unavailable url repeatedly, returns false if it did not finish all deliveries
+func (m *Manager) deliverGroup(dls []*db.WebhookDelivery) bool {
+	for _, dl := range dls {
+		if m.stopping() {
+			return false
+		}
+		delivered, err := m.deliver(dl)
+		if err != nil {
+			glog.Error("webhook ", dl.WebhookID, ": delivery ", dl.ID, ": ", err)
+			return false
+		}
+		if !delivered {
+			return false
+		}
+	}
+	return true
+}
+
+// backoff returns the delay after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	d := initialBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// deliver sends one delivery, the delivery is removed from the outbox on success or rescheduled on failure
+// returns false if the url did not accept the delivery
+func (m *Manager) deliver(dl *db.WebhookDelivery) (bool, error) {
+	m.lock.Lock()
+	r := m.webhooks[dl.WebhookID]
+	m.lock.Unlock()
+	if r == nil {
+		return true, m.db.DeleteWebhookDelivery(dl)
+	}
+	err := m.post(r, dl)
+	if err == nil {
+		m.metrics.WebhookDeliveries.With(common.Labels{"event": dl.Event, "status": "success"}).Inc()
+		return true, m.db.DeleteWebhookDelivery(dl)
+	}
+	dl.Attempts++
+	if dl.Attempts >= maxAttempts {
+		glog.Error("webhook ", r.ID, ": delivery ", dl.ID, " dropped after ", dl.Attempts, " attempts: ", err)
+		m.metrics.WebhookDeliveries.With(common.Labels{"event": dl.Event, "status": "dropped"}).Inc()
+		return false, m.db.DeleteWebhookDelivery(dl)
+	}
+	glog.Warning("webhook ", r.ID, ": delivery ", dl.ID, " attempt ", dl.Attempts, " failed: ", err)
+	m.metrics.WebhookDeliveries.With(common.Labels{"event": dl.Event, "status": "failure"}).Inc()
+	next := time.Now().Add(backoff(dl.Attempts))
+	return false, m.db.RescheduleWebhookDelivery(dl, next.UnixNano()/int64(time.Millisecond))
+}
+
+func (m *Manager) post(r *registration, dl *db.WebhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, r.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(dl.ID, 10))
+	req.Header.Set(HeaderSignature, Signature(r.Secret, dl.Payload))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// read the body to allow reuse of the connection
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("Unexpected status %v", resp.Status)
+	}
+	return nil
+}
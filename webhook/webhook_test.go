@@ -0,0 +1,404 @@
+// +build unittest
+
+package webhook
+
+import (
+	"blockbook/bchain/coins/btc"
+	"blockbook/common"
+	"blockbook/db"
+	"blockbook/tests/dbtestdata"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martinboehm/btcutil/chaincfg"
+)
+
+func TestMain(m *testing.M) {
+	c := m.Run()
+	chaincfg.ResetParams()
+	os.Exit(c)
+}
+
+type received struct {
+	event     string
+	signature string
+	body      []byte
+}
+
+type receiver struct {
+	sync.Mutex
+	status   int
+	requests []received
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.Lock()
+	defer rc.Unlock()
+	rc.requests = append(rc.requests, received{
+		event:     r.Header.Get(HeaderEvent),
+		signature: r.Header.Get(HeaderSignature),
+		body:      body,
+	})
+	w.WriteHeader(rc.status)
+}
+
+func setupManager(t *testing.T) (*Manager, *db.RocksDB, string) {
+	tmp, err := ioutil.TempDir("", "testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := btc.NewBitcoinParser(btc.GetChainParams("test"), &btc.Configuration{BlockAddressesToKeep: 1})
+	d, err := db.NewRocksDB(tmp, 100000, -1, parser, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newManager(t, d, parser), d, tmp
+}
+
+// newManager connects the test blocks to the index d and creates the manager using it
+func newManager(t *testing.T, d db.Index, parser *btc.BitcoinParser) *Manager {
+	is, err := d.LoadInternalState("fakecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetInternalState(is)
+	if err := d.ConnectBlock(dbtestdata.GetTestBitcoinTypeBlock1(parser)); err != nil {
+		t.Fatal(err)
+	}
+	block2 := dbtestdata.GetTestBitcoinTypeBlock2(parser)
+	if err := d.ConnectBlock(block2); err != nil {
+		t.Fatal(err)
+	}
+	is.FinishedSync(block2.Height)
+	metrics, err := common.GetMetrics("Fakecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := dbtestdata.NewFakeBlockChain(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mempool, err := chain.CreateMempool(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txCache, err := db.NewTxCache(d, chain, metrics, is, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(d, chain, mempool, txCache, metrics, is)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func closeAndDestroyRocksDB(t *testing.T, d *db.RocksDB, dbpath string) {
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dbpath)
+}
+
+func TestSignature(t *testing.T) {
+	got := Signature("secret", []byte(`{"event":"block"}`))
+	want := "sha256=ba88664f1209502fd5373ab48ff87d46d464f9f75a9ae51726d4862e9ce46a08"
+	if got != want {
+		t.Errorf("Signature() = %v, want %v", got, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{maxAttempts, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestManager_Register(t *testing.T) {
+	m, d, dbpath := setupManager(t)
+	defer closeAndDestroyRocksDB(t, d, dbpath)
+
+	for _, wh := range []db.Webhook{
+		{URL: "ftp://example.com", Blocks: true},
+		{URL: "http://", Blocks: true},
+		{URL: "http://example.com"},
+		{URL: "http://example.com", Addresses: []string{"invalid"}},
+	} {
+		if _, err := m.Register(&wh); err == nil {
+			t.Errorf("Register(%+v) did not fail", wh)
+		}
+	}
+	wh, err := m.Register(&db.Webhook{URL: "http://example.com/hook", Addresses: []string{dbtestdata.Addr5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wh.ID) != 32 || len(wh.Secret) != 64 || wh.Confirmations != 1 || wh.Created == 0 {
+		t.Errorf("Register() = %+v, missing defaults", wh)
+	}
+	whs := m.Webhooks()
+	if len(whs) != 1 || whs[0].ID != wh.ID || whs[0].Secret != "" {
+		t.Errorf("Webhooks() = %+v", whs)
+	}
+	// the registration survives restart of the manager
+	stored, err := d.GetWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].ID != wh.ID || stored[0].Secret != wh.Secret {
+		t.Errorf("GetWebhooks() = %+v", stored)
+	}
+	found, err := m.Unregister(wh.ID)
+	if err != nil || !found {
+		t.Errorf("Unregister() = %v, %v", found, err)
+	}
+	found, err = m.Unregister(wh.ID)
+	if err != nil || found {
+		t.Errorf("Unregister() of removed webhook = %v, %v", found, err)
+	}
+	if whs := m.Webhooks(); len(whs) != 0 {
+		t.Errorf("Webhooks() after Unregister = %+v", whs)
+	}
+}
+
+func TestManager_Deliver(t *testing.T) {
+	m, d, dbpath := setupManager(t)
+	defer closeAndDestroyRocksDB(t, d, dbpath)
+
+	rc := &receiver{status: http.StatusOK}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	wh, err := m.Register(&db.Webhook{URL: ts.URL, Addresses: []string{dbtestdata.Addr5}, Blocks: true, Confirmations: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.OnNewBlock("00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6", 225494)
+	m.processBlocks()
+	m.deliverDue()
+
+	rc.Lock()
+	requests := rc.requests
+	rc.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	for _, r := range requests {
+		if r.signature != Signature(wh.Secret, r.body) {
+			t.Errorf("invalid signature %v of %s", r.signature, r.body)
+		}
+	}
+	var block, tx Event
+	if err := json.Unmarshal(requests[0].body, &block); err != nil {
+		t.Fatal(err)
+	}
+	if requests[0].event != EventBlock || block.Event != EventBlock || block.WebhookID != wh.ID || block.BlockHeight != 225494 ||
+		block.BlockHash != "00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6" {
+		t.Errorf("block event %s", requests[0].body)
+	}
+	if err := json.Unmarshal(requests[1].body, &tx); err != nil {
+		t.Fatal(err)
+	}
+	if requests[1].event != EventTx || tx.Address != dbtestdata.Addr5 || tx.Txid != dbtestdata.TxidB2T3 ||
+		tx.Confirmations != 1 || tx.Tx == nil || tx.Tx.Txid != dbtestdata.TxidB2T3 {
+		t.Errorf("tx event %s", requests[1].body)
+	}
+	// the transaction is tracked until it reaches 2 confirmations
+	wt, err := d.GetWebhookTx(wh.ID, dbtestdata.TxidB2T3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wt == nil || wt.Address != dbtestdata.Addr5 || wt.Confirmations != 1 {
+		t.Errorf("GetWebhookTx() = %+v", wt)
+	}
+	// delivered events are removed from the outbox
+	dls, err := d.GetDueWebhookDeliveries(time.Now().Add(maxBackoff), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 0 {
+		t.Errorf("outbox contains %d deliveries, want 0", len(dls))
+	}
+}
+
+func TestManager_MemoryIndex(t *testing.T) {
+	parser := btc.NewBitcoinParser(btc.GetChainParams("test"), &btc.Configuration{BlockAddressesToKeep: 1})
+	d, err := db.NewMemoryIndex(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	m := newManager(t, d, parser)
+
+	rc := &receiver{status: http.StatusOK}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	wh, err := m.Register(&db.Webhook{URL: ts.URL, Addresses: []string{dbtestdata.Addr5}, Blocks: true, Confirmations: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := d.GetWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].ID != wh.ID {
+		t.Errorf("GetWebhooks() = %+v", stored)
+	}
+
+	m.OnNewBlock("00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6", 225494)
+	m.processBlocks()
+	m.deliverDue()
+
+	rc.Lock()
+	requests := rc.requests
+	rc.Unlock()
+	if len(requests) != 2 || requests[0].event != EventBlock || requests[1].event != EventTx {
+		t.Fatalf("got requests %+v, want block and tx event", requests)
+	}
+	wt, err := d.GetWebhookTx(wh.ID, dbtestdata.TxidB2T3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wt == nil || wt.Address != dbtestdata.Addr5 || wt.Confirmations != 1 {
+		t.Errorf("GetWebhookTx() = %+v", wt)
+	}
+	dls, err := d.GetDueWebhookDeliveries(time.Now().Add(maxBackoff), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 0 {
+		t.Errorf("outbox contains %d deliveries, want 0", len(dls))
+	}
+	found, err := m.Unregister(wh.ID)
+	if err != nil || !found {
+		t.Errorf("Unregister() = %v, %v", found, err)
+	}
+	if txs, err := d.GetWebhookTxs(); err != nil || len(txs) != 0 {
+		t.Errorf("GetWebhookTxs() after Unregister = %+v, %v", txs, err)
+	}
+}
+
+func TestManager_DeliverRetry(t *testing.T) {
+	m, d, dbpath := setupManager(t)
+	defer closeAndDestroyRocksDB(t, d, dbpath)
+
+	rc := &receiver{status: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	wh, err := m.Register(&db.Webhook{URL: ts.URL, Blocks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.OnNewBlock("00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6", 225494)
+	m.processBlocks()
+	m.deliverDue()
+
+	// the failed delivery is not due now but after the backoff
+	dls, err := d.GetDueWebhookDeliveries(time.Now(), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 0 {
+		t.Errorf("got %d due deliveries, want 0", len(dls))
+	}
+	dls, err = d.GetDueWebhookDeliveries(time.Now().Add(initialBackoff), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Attempts != 1 || dls[0].WebhookID != wh.ID || dls[0].Event != EventBlock {
+		t.Fatalf("GetDueWebhookDeliveries() = %+v", dls)
+	}
+
+	// deliveries of a removed webhook are discarded
+	if _, err := m.Unregister(wh.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.deliver(dls[0]); err != nil {
+		t.Fatal(err)
+	}
+	dls, err = d.GetDueWebhookDeliveries(time.Now().Add(maxBackoff), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 0 {
+		t.Errorf("outbox contains %d deliveries, want 0", len(dls))
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if len(rc.requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(rc.requests))
+	}
+}
+
+func TestManager_DeliverGroups(t *testing.T) {
+	m, d, dbpath := setupManager(t)
+	defer closeAndDestroyRocksDB(t, d, dbpath)
+
+	failing := &receiver{status: http.StatusServiceUnavailable}
+	tsFailing := httptest.NewServer(failing)
+	defer tsFailing.Close()
+	ok := &receiver{status: http.StatusOK}
+	tsOk := httptest.NewServer(ok)
+	defer tsOk.Close()
+
+	if _, err := m.Register(&db.Webhook{URL: tsFailing.URL, Blocks: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Register(&db.Webhook{URL: tsOk.URL, Blocks: true}); err != nil {
+		t.Fatal(err)
+	}
+	m.OnNewBlock("0000000076fbbed90fd75b0e18856aa35baa984e9c9d444cf746ad85e94e2997", 225493)
+	m.OnNewBlock("00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b6", 225494)
+	// the block which is not in the index is skipped
+	m.OnNewBlock("00000000eb0443fd7dc4a1ed5c686a8e995057805f9a161d9a5a77a95e72b7b7", 225495)
+	m.processBlocks()
+	m.deliverDue()
+
+	// the unavailable url does not delay the other url and gets only the first delivery
+	ok.Lock()
+	if len(ok.requests) != 2 {
+		t.Errorf("got %d requests to the available url, want 2", len(ok.requests))
+	}
+	ok.Unlock()
+	failing.Lock()
+	if len(failing.requests) != 1 {
+		t.Errorf("got %d requests to the unavailable url, want 1", len(failing.requests))
+	}
+	failing.Unlock()
+	dls, err := d.GetDueWebhookDeliveries(time.Now(), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Attempts != 0 {
+		t.Errorf("GetDueWebhookDeliveries() = %+v, want one delivery without attempts", dls)
+	}
+	dls, err = d.GetDueWebhookDeliveries(time.Now().Add(maxBackoff), deliveryBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 2 {
+		t.Errorf("outbox contains %d deliveries, want 2", len(dls))
+	}
+}